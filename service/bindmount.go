@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dell/csi-unity/service/utils"
+	"github.com/dell/gofsutil"
+)
+
+//defaultBindMountRetries is the default number of retries of a bind mount failing with a transient error
+const defaultBindMountRetries = 3
+
+//bindMountRetries is the number of retries of a bind mount failing with a transient error
+var bindMountRetries = defaultBindMountRetries
+
+//bindMountRetryDelay is the delay before the first retry of a bind mount, doubled at each retry
+var bindMountRetryDelay = 100 * time.Millisecond
+
+//bindMount bind mounts the source to the target. It is a variable so that tests can override it
+var bindMount = gofsutil.BindMount
+
+//isTransientMountError returns true when the mount failed because the target was busy, e.g. while the kubelet is
+//working on the same target path, rather than because of a permanent error such as a missing source
+func isTransientMountError(err error) bool {
+	if errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EAGAIN) {
+		return true
+	}
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "device or resource busy") || strings.Contains(message, "resource temporarily unavailable")
+}
+
+//bindMountWithRetry bind mounts the source to the target, retrying the transient errors a bounded number of times.
+//Permanent errors are returned at once
+func bindMountWithRetry(ctx context.Context, source, target string, options ...string) error {
+	log := utils.GetRunidLogger(ctx)
+	delay := bindMountRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := bindMount(ctx, source, target, options...)
+		if err == nil || attempt >= bindMountRetries || !isTransientMountError(err) {
+			return err
+		}
+		log.Warnf("Bind mount of %s to %s failed with a transient error, retrying in %v. Error: %v", source, target, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestBindMountRetry(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	origMount, origDelay := bindMount, bindMountRetryDelay
+	defer func() { bindMount, bindMountRetryDelay = origMount, origDelay }()
+	bindMountRetryDelay = time.Millisecond
+
+	var errs []error
+	calls := 0
+	bindMount = func(ctx context.Context, source, target string, options ...string) error {
+		calls++
+		if len(errs) == 0 {
+			return nil
+		}
+		err := errs[0]
+		errs = errs[1:]
+		return err
+	}
+
+	//EBUSY once then success
+	errs, calls = []error{fmt.Errorf("mount failed: %w", syscall.EBUSY)}, 0
+	assert.Nil(t, bindMountWithRetry(ctx, "/staging", "/target", "bind"))
+	assert.Equal(t, 2, calls)
+
+	//EAGAIN reported by the mount command output
+	errs, calls = []error{errors.New("mount failed: exit status 32: mount: /target: resource temporarily unavailable")}, 0
+	assert.Nil(t, bindMountWithRetry(ctx, "/staging", "/target", "bind"))
+	assert.Equal(t, 2, calls)
+
+	//Permanent errors are not retried
+	errs, calls = []error{fmt.Errorf("mount failed: %w", syscall.ENOENT)}, 0
+	assert.NotNil(t, bindMountWithRetry(ctx, "/staging", "/target", "bind"))
+	assert.Equal(t, 1, calls)
+
+	//Retries are bounded
+	errs, calls = []error{syscall.EBUSY, syscall.EBUSY, syscall.EBUSY, syscall.EBUSY, syscall.EBUSY}, 0
+	err := bindMountWithRetry(ctx, "/staging", "/target", "bind")
+	assert.True(t, errors.Is(err, syscall.EBUSY), "Unexpected error: %v", err)
+	assert.Equal(t, defaultBindMountRetries+1, calls)
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dell/csi-unity/service/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//Capabilities of an array that can be forced on or off in the capabilities of the array config
+const (
+	capabilityDataReduction = "dataReduction"
+	capabilityThinClone     = "thinClone"
+)
+
+//capabilityMinVersions maps the capabilities to the first Unity OE version supporting them
+var capabilityMinVersions = map[string][]int{
+	capabilityDataReduction: {4, 3},
+	capabilityThinClone:     {4, 2},
+}
+
+//validateCapabilities returns an error when the capabilities of the array config include an unknown capability
+func validateCapabilities(capabilities map[string]bool) error {
+	for capability := range capabilities {
+		if _, ok := capabilityMinVersions[capability]; !ok {
+			return fmt.Errorf("unknown capability %s. Supported capabilities are %s and %s", capability, capabilityDataReduction, capabilityThinClone)
+		}
+	}
+	return nil
+}
+
+//getArraySoftwareVersion returns the Unity OE version of the array from its basic system info. It is a variable so
+//that tests can override it
+var getArraySoftwareVersion = func(ctx context.Context, array *StorageArrayConfig) (string, error) {
+	client := newArrayHTTPClient(array, nil)
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(array.RestGateway, "/")+basicSystemInfoPath+"?fields=softwareVersion", nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-EMC-REST-CLIENT", "true")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", newRestStatusError(resp, "unexpected response status")
+	}
+	var result struct {
+		Entries []struct {
+			Content struct {
+				SoftwareVersion string `json:"softwareVersion"`
+			} `json:"content"`
+		} `json:"entries"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if len(result.Entries) == 0 || result.Entries[0].Content.SoftwareVersion == "" {
+		return "", fmt.Errorf("software version not reported by array %s", array.ArrayId)
+	}
+	return result.Entries[0].Content.SoftwareVersion, nil
+}
+
+//isVersionAtLeast returns true when the dotted version, e.g. 5.0.2.0.5.009, is at least the minimum version
+func isVersionAtLeast(version string, minVersion []int) bool {
+	tokens := strings.Split(strings.TrimSpace(version), ".")
+	for i, min := range minVersion {
+		if i >= len(tokens) {
+			return false
+		}
+		value, err := strconv.Atoi(tokens[i])
+		if err != nil {
+			return false
+		}
+		if value != min {
+			return value > min
+		}
+	}
+	return true
+}
+
+//detectCapabilities returns the capabilities supported by the array, detected from its Unity OE version. Detected
+//capabilities are kept for the lifetime of the driver
+func (s *service) detectCapabilities(ctx context.Context, array *StorageArrayConfig) (map[string]bool, error) {
+	if detected, ok := s.arrayCapabilities.Load(array.ArrayId); ok {
+		return detected.(map[string]bool), nil
+	}
+	version, err := getArraySoftwareVersion(ctx, array)
+	if err != nil {
+		return nil, err
+	}
+	detected := make(map[string]bool)
+	for capability, minVersion := range capabilityMinVersions {
+		detected[capability] = isVersionAtLeast(version, minVersion)
+	}
+	_, log, _ := GetRunidLog(ctx)
+	log.Infof("Capabilities detected on array %s with OE version %s: %v", array.ArrayId, version, detected)
+	s.arrayCapabilities.Store(array.ArrayId, detected)
+	return detected, nil
+}
+
+//isCapabilitySupported returns true when the array supports the capability. The capabilities of the array config take
+//precedence over the detected capabilities, as the detection may be wrong on some OE versions. A capability that cannot
+//be detected is assumed to be supported, leaving the array to reject the request
+func (s *service) isCapabilitySupported(ctx context.Context, arrayID, capability string) bool {
+	_, log, _ := GetRunidLog(ctx)
+	array := s.getStorageArray(arrayID)
+	if array == nil {
+		return true
+	}
+	supported := true
+	detected, err := s.detectCapabilities(ctx, array)
+	if err != nil {
+		log.Warnf("Unable to detect the capabilities of array %s. Error: %v", arrayID, utils.GetUnityError(err))
+	} else {
+		supported = detected[capability]
+	}
+	if forced, ok := array.Capabilities[capability]; ok {
+		if err != nil || forced != supported {
+			log.Warnf("Capability %s of array %s forced to %v by the array config, overriding the detected value", capability, arrayID, forced)
+		}
+		return forced
+	}
+	return supported
+}
+
+//requireCapability rejects the request when the array does not support the capability it needs
+func (s *service) requireCapability(ctx context.Context, arrayID, capability string) error {
+	rid, _ := utils.GetRunidAndLogger(ctx)
+	if !s.isCapabilitySupported(ctx, arrayID, capability) {
+		return status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "Capability %s is not supported by array %s", capability, arrayID))
+	}
+	return nil
+}
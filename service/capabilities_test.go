@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"testing"
+)
+
+func TestCapabilitiesOverride(t *testing.T) {
+	origVersion := getArraySoftwareVersion
+	defer func() { getArraySoftwareVersion = origVersion }()
+	versions := map[string]string{"old": "4.1.0.9058043", "new": "5.0.2.0.5.009"}
+	detections := 0
+	getArraySoftwareVersion = func(ctx context.Context, array *StorageArrayConfig) (string, error) {
+		detections++
+		if version, ok := versions[array.ArrayId]; ok {
+			return version, nil
+		}
+		return "", errors.New("connection refused")
+	}
+	ctx := context.Background()
+
+	//Capabilities are detected from the OE version when not overridden
+	s := &service{arrays: new(sync.Map)}
+	s.arrays.Store("old", &StorageArrayConfig{ArrayId: "old"})
+	s.arrays.Store("new", &StorageArrayConfig{ArrayId: "new"})
+	assert.False(t, s.isCapabilitySupported(ctx, "old", capabilityDataReduction))
+	assert.False(t, s.isCapabilitySupported(ctx, "old", capabilityThinClone))
+	assert.True(t, s.isCapabilitySupported(ctx, "new", capabilityDataReduction))
+	assert.True(t, s.isCapabilitySupported(ctx, "new", capabilityThinClone))
+	assert.Equal(t, 2, detections, "Detected capabilities are kept")
+
+	//An override forces a capability on or off regardless of the detection
+	s = &service{arrays: new(sync.Map)}
+	s.arrays.Store("old", &StorageArrayConfig{ArrayId: "old", Capabilities: map[string]bool{capabilityDataReduction: true}})
+	s.arrays.Store("new", &StorageArrayConfig{ArrayId: "new", Capabilities: map[string]bool{capabilityThinClone: false}})
+	s.arrays.Store("unreachable", &StorageArrayConfig{ArrayId: "unreachable", Capabilities: map[string]bool{capabilityDataReduction: false}})
+	assert.True(t, s.isCapabilitySupported(ctx, "old", capabilityDataReduction))
+	assert.False(t, s.isCapabilitySupported(ctx, "old", capabilityThinClone))
+	assert.True(t, s.isCapabilitySupported(ctx, "new", capabilityDataReduction))
+	assert.False(t, s.isCapabilitySupported(ctx, "new", capabilityThinClone))
+	assert.False(t, s.isCapabilitySupported(ctx, "unreachable", capabilityDataReduction))
+	assert.True(t, s.isCapabilitySupported(ctx, "unreachable", capabilityThinClone), "Undetected capabilities are assumed supported")
+
+	err := s.requireCapability(ctx, "new", capabilityThinClone)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Nil(t, s.requireCapability(ctx, "old", capabilityDataReduction))
+
+	//Unknown capabilities are rejected in the array config
+	assert.Nil(t, validateCapabilities(map[string]bool{capabilityDataReduction: true, capabilityThinClone: false}))
+	assert.NotNil(t, validateCapabilities(map[string]bool{"compression": true}))
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+
+	"github.com/dell/csi-unity/service/utils"
+	"github.com/dell/gounity"
+	"github.com/dell/gounity/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//createCloneFromVolume clones the volume through an intermediate snapshot of the source volume. It is a variable so
+//that tests can override it
+var createCloneFromVolume = func(ctx context.Context, unity *gounity.Client, volName, sourceVolID string) error {
+	_, err := gounity.NewVolume(unity).CreateCloneFromVolume(ctx, volName, sourceVolID)
+	return err
+}
+
+//listCloneSnapshots returns the intermediate snapshots of the clone of the source volume. It is a variable so that
+//tests can override it
+var listCloneSnapshots = func(ctx context.Context, unity *gounity.Client, volName, sourceVolID string) ([]types.Snapshot, error) {
+	snaps, _, err := gounity.NewSnapshot(unity).ListSnapshots(ctx, 0, 0, sourceVolID, "")
+	if err != nil {
+		return nil, err
+	}
+	return filterCloneSnapshots(snaps, volName), nil
+}
+
+//getCloneSnapshotName returns the name gounity gives to the intermediate snapshot of the clone
+func getCloneSnapshotName(volName string) string {
+	return gounity.SnapForClone + volName
+}
+
+//filterCloneSnapshots returns the intermediate snapshots of the clone among the snapshots. Only the exact name is
+//matched, so that the intermediate snapshots of clones whose name contains the name of the clone are kept
+func filterCloneSnapshots(snaps []types.Snapshot, volName string) []types.Snapshot {
+	cloneSnaps := make([]types.Snapshot, 0)
+	for _, snap := range snaps {
+		if snap.SnapshotContent.Name == getCloneSnapshotName(volName) {
+			cloneSnaps = append(cloneSnaps, snap)
+		}
+	}
+	return cloneSnaps
+}
+
+//deleteCloneSnapshot deletes an intermediate snapshot of a clone. It is a variable so that tests can override it
+var deleteCloneSnapshot = func(ctx context.Context, unity *gounity.Client, snapshotID string) error {
+	return gounity.NewSnapshot(unity).DeleteSnapshot(ctx, snapshotID)
+}
+
+//cloneVolume clones the source volume. When the clone fails the intermediate snapshots it left on the source volume
+//are deleted before the error is returned, so that retries start clean, unless they are kept for troubleshooting
+func (s *service) cloneVolume(ctx context.Context, unity *gounity.Client, volName, sourceVolID string) error {
+	ctx, log, rid := GetRunidLog(ctx)
+	err := createCloneFromVolume(ctx, unity, volName, sourceVolID)
+	if err != gounity.CreateSnapshotFailedError && err != gounity.CloningFailedError {
+		if err != nil {
+			log.Debugf("Volume cloning for source volume: %s returned error: %v", sourceVolID, err)
+		}
+		return nil
+	}
+	if s.opts.KeepCloneSnapshots {
+		log.Infof("Intermediate snapshots of the failed clone %s of volume %s are kept", volName, sourceVolID)
+	} else {
+		s.cleanupCloneSnapshots(ctx, unity, volName, sourceVolID)
+	}
+	if err == gounity.CreateSnapshotFailedError {
+		return status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Unable to Create Snapshot for Volume Cloning for source volume: %s", sourceVolID))
+	}
+	return status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Volume cloning for source volume: %s failed.", sourceVolID))
+}
+
+//cleanupCloneSnapshots deletes the intermediate snapshots left on the source volume by a failed clone
+func (s *service) cleanupCloneSnapshots(ctx context.Context, unity *gounity.Client, volName, sourceVolID string) {
+	log := utils.GetRunidLogger(ctx)
+	snaps, err := listCloneSnapshots(ctx, unity, volName, sourceVolID)
+	if err != nil {
+		log.Warnf("Unable to list the intermediate snapshots of the failed clone %s of volume %s. Error: %v", volName, sourceVolID, utils.GetUnityError(err))
+		return
+	}
+	for _, snap := range snaps {
+		if err := deleteCloneSnapshot(ctx, unity, snap.SnapshotContent.ResourceId); err != nil {
+			log.Warnf("Unable to delete the intermediate snapshot %s of the failed clone %s. Error: %v", snap.SnapshotContent.Name, volName, utils.GetUnityError(err))
+			continue
+		}
+		log.Infof("Deleted the intermediate snapshot %s of the failed clone %s of volume %s", snap.SnapshotContent.Name, volName, sourceVolID)
+	}
+}
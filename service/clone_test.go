@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"github.com/dell/gounity"
+	"github.com/dell/gounity/types"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCloneVolumeCleanup(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	origClone, origList, origDelete := createCloneFromVolume, listCloneSnapshots, deleteCloneSnapshot
+	defer func() {
+		createCloneFromVolume, listCloneSnapshots, deleteCloneSnapshot = origClone, origList, origDelete
+	}()
+
+	//Fake array on which the failed clone leaves its intermediate snapshot on the source volume
+	artifacts := make([]types.Snapshot, 0)
+	calls := make([]string, 0)
+	cloneErr := gounity.CloningFailedError
+	createCloneFromVolume = func(ctx context.Context, unity *gounity.Client, volName, sourceVolID string) error {
+		calls = append(calls, "clone "+volName)
+		if cloneErr == nil {
+			return nil
+		}
+		snap := types.Snapshot{}
+		snap.SnapshotContent.Name = getCloneSnapshotName(volName)
+		snap.SnapshotContent.ResourceId = "38654705681"
+		artifacts = append(artifacts, snap)
+		return cloneErr
+	}
+	listCloneSnapshots = func(ctx context.Context, unity *gounity.Client, volName, sourceVolID string) ([]types.Snapshot, error) {
+		return artifacts, nil
+	}
+	deleteCloneSnapshot = func(ctx context.Context, unity *gounity.Client, snapshotID string) error {
+		calls = append(calls, "delete "+snapshotID)
+		artifacts = make([]types.Snapshot, 0)
+		return nil
+	}
+	s := &service{arrays: new(sync.Map)}
+
+	//Clone failure deletes the intermediate snapshot before returning the error
+	err := s.cloneVolume(ctx, nil, "vol2", "sv_1")
+	assert.NotNil(t, err)
+	assert.Equal(t, codes.Unknown, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "Volume cloning for source volume: sv_1 failed"), "Unexpected error message: %v", err)
+	assert.Equal(t, []string{"clone vol2", "delete 38654705681"}, calls)
+	assert.Equal(t, 0, len(artifacts))
+
+	//Retry starts clean and succeeds
+	cloneErr = nil
+	calls = make([]string, 0)
+	assert.Nil(t, s.cloneVolume(ctx, nil, "vol2", "sv_1"))
+	assert.Equal(t, []string{"clone vol2"}, calls)
+
+	//Intermediate snapshots are kept when configured
+	cloneErr = gounity.CloningFailedError
+	calls = make([]string, 0)
+	s.opts.KeepCloneSnapshots = true
+	err = s.cloneVolume(ctx, nil, "vol3", "sv_1")
+	assert.Equal(t, codes.Unknown, status.Code(err))
+	assert.Equal(t, []string{"clone vol3"}, calls)
+	assert.Equal(t, 1, len(artifacts))
+}
+
+func TestFilterCloneSnapshots(t *testing.T) {
+	names := []string{getCloneSnapshotName("vol2"), getCloneSnapshotName("vol20"), getCloneSnapshotName("pvc-vol2"), "vol2", "snap-vol2"}
+	snaps := make([]types.Snapshot, 0)
+	for _, name := range names {
+		snap := types.Snapshot{}
+		snap.SnapshotContent.Name = name
+		snaps = append(snaps, snap)
+	}
+
+	//Only the intermediate snapshot of the clone itself matches
+	cloneSnaps := filterCloneSnapshots(snaps, "vol2")
+	assert.Equal(t, 1, len(cloneSnaps))
+	assert.Equal(t, getCloneSnapshotName("vol2"), cloneSnaps[0].SnapshotContent.Name)
+	assert.Equal(t, 0, len(filterCloneSnapshots(snaps, "vol")))
+}
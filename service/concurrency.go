@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"github.com/dell/csi-unity/service/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//concurrencyLimiter caps the number of concurrent operations. Excess operations wait for a slot until their context
+//is done
+type concurrencyLimiter struct {
+	once  sync.Once
+	slots chan struct{}
+}
+
+//acquire waits for a slot and returns the function releasing it. A size of 0 or less does not cap the operations
+func (l *concurrencyLimiter) acquire(ctx context.Context, size int, operation string) (func(), error) {
+	if size <= 0 {
+		return func() {}, nil
+	}
+	l.once.Do(func() {
+		l.slots = make(chan struct{}, size)
+	})
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	default:
+	}
+
+	rid, log := utils.GetRunidAndLogger(ctx)
+	log.Debugf("%s waiting for one of the %d concurrent slots", operation, cap(l.slots))
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		code := codes.DeadlineExceeded
+		if ctx.Err() == context.Canceled {
+			code = codes.Canceled
+		}
+		return nil, status.Error(code, utils.GetMessageWithRunID(rid, "%s aborted while waiting for one of the %d concurrent slots: %v", operation, cap(l.slots), ctx.Err()))
+	}
+}
+
+//runConcurrently calls fn for each index from 0 to n-1 with at most limit calls running at once, and returns once all
+//the calls have returned. A limit of 1 or less calls fn for each index one after the other
+func runConcurrently(n, limit int, fn func(i int)) {
+	if limit <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+	slots := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+//volumeLocks serializes the operations on the same volume, keyed by volume name or volume id, so that concurrent
+//retries of an operation do not race past the idempotency check of each other
+type volumeLocks struct {
+	mutex sync.Mutex
+	locks map[string]*volumeLock
+}
+
+//volumeLock is the lock of a volume, removed when no operation holds or waits for it
+type volumeLock struct {
+	slot  chan struct{}
+	users int
+}
+
+//acquire waits for the lock of the volume and returns the function releasing it. Operations waiting for the lock are
+//aborted when their context is done
+func (l *volumeLocks) acquire(ctx context.Context, key, operation string) (func(), error) {
+	l.mutex.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*volumeLock)
+	}
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &volumeLock{slot: make(chan struct{}, 1)}
+		l.locks[key] = lock
+	}
+	lock.users++
+	l.mutex.Unlock()
+
+	done := func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		lock.users--
+		if lock.users == 0 {
+			delete(l.locks, key)
+		}
+	}
+	select {
+	case lock.slot <- struct{}{}:
+		return func() {
+			<-lock.slot
+			done()
+		}, nil
+	default:
+	}
+
+	rid, log := utils.GetRunidAndLogger(ctx)
+	log.Debugf("%s waiting for the operation in progress on volume %s", operation, key)
+	select {
+	case lock.slot <- struct{}{}:
+		return func() {
+			<-lock.slot
+			done()
+		}, nil
+	case <-ctx.Done():
+		done()
+		code := codes.DeadlineExceeded
+		if ctx.Err() == context.Canceled {
+			code = codes.Canceled
+		}
+		return nil, status.Error(code, utils.GetMessageWithRunID(rid, "%s aborted while waiting for the operation in progress on volume %s: %v", operation, key, ctx.Err()))
+	}
+}
+
+//lockVolume locks the volume for the operation unless volume locking is disabled, and returns the function unlocking it
+func (s *service) lockVolume(ctx context.Context, key, operation string) (func(), error) {
+	if s.opts.DisableVolumeLocking {
+		return func() {}, nil
+	}
+	return s.volumeLocks.acquire(ctx, key, operation)
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCreateVolumeConcurrencyLimit(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+
+	//Concurrency is capped
+	limiter := &concurrencyLimiter{}
+	var mutex sync.Mutex
+	running, maxRunning := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := limiter.acquire(ctx, 3, "CreateVolume")
+			if !assert.Nil(t, err) {
+				return
+			}
+			defer release()
+			mutex.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mutex.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 3, maxRunning)
+
+	//The cap is global: requests for any array wait for the same slots
+	s := &service{arrays: new(sync.Map), opts: Opts{MaxConcurrentCreateVolume: 1}}
+	release, err := s.createVolumeLimiter.acquire(ctx, s.opts.MaxConcurrentCreateVolume, "CreateVolume")
+	assert.Nil(t, err)
+	timeoutCtx, cancelTimeout := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelTimeout()
+	_, err = s.CreateVolume(timeoutCtx, &csi.CreateVolumeRequest{Name: "vol1", Parameters: map[string]string{keyArrayId: "array2"}})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	//A cancelled queued request aborts cleanly without taking a slot
+	cancelCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		_, err := s.CreateVolume(cancelCtx, &csi.CreateVolumeRequest{Name: "vol2", Parameters: map[string]string{keyArrayId: "array3"}})
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	assert.Equal(t, codes.Canceled, status.Code(<-done))
+	release()
+
+	//The released slot is available again
+	release, err = s.createVolumeLimiter.acquire(ctx, s.opts.MaxConcurrentCreateVolume, "CreateVolume")
+	assert.Nil(t, err)
+	release()
+
+	//No cap by default
+	s = &service{arrays: new(sync.Map)}
+	for i := 0; i < 5; i++ {
+		_, err = s.createVolumeLimiter.acquire(ctx, s.opts.MaxConcurrentCreateVolume, "CreateVolume")
+		assert.Nil(t, err)
+	}
+}
+
+func TestVolumeLocks(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+
+	//Concurrent creations of the same name result in a single volume
+	s := &service{arrays: new(sync.Map)}
+	var mutex sync.Mutex
+	volumes := make(map[string]int)
+	created := 0
+	createVolume := func(name string) error {
+		unlock, err := s.lockVolume(ctx, name, "CreateVolume")
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		mutex.Lock()
+		_, found := volumes[name]
+		mutex.Unlock()
+		if found {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+		mutex.Lock()
+		defer mutex.Unlock()
+		volumes[name]++
+		created++
+		return nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, createVolume("vol1"))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, created)
+	assert.Equal(t, 1, volumes["vol1"])
+
+	//CreateVolume waits for the operation in progress with the same name
+	unlock, err := s.lockVolume(ctx, "vol1", "CreateVolume")
+	assert.Nil(t, err)
+	timeoutCtx, cancelTimeout := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelTimeout()
+	_, err = s.CreateVolume(timeoutCtx, &csi.CreateVolumeRequest{Name: "vol1", Parameters: map[string]string{keyArrayId: "array1"}})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "waiting for the operation in progress on volume vol1"), "Unexpected error message: %v", err)
+
+	//Other names are not blocked
+	_, err = s.CreateVolume(timeoutCtx, &csi.CreateVolumeRequest{Name: "vol2"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	//DeleteVolume waits for the operation in progress with the same volume id, and can be cancelled
+	volID := "vol3-fc-array1-sv_1"
+	unlockDelete, err := s.lockVolume(ctx, volID, "DeleteVolume")
+	assert.Nil(t, err)
+	cancelCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		_, err := s.DeleteVolume(cancelCtx, &csi.DeleteVolumeRequest{VolumeId: volID})
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	assert.Equal(t, codes.Canceled, status.Code(<-done))
+	unlockDelete()
+	unlock()
+
+	//Released locks are removed
+	assert.Equal(t, 0, len(s.volumeLocks.locks))
+
+	//Volume locking can be disabled
+	s.opts.DisableVolumeLocking = true
+	unlock, err = s.lockVolume(ctx, "vol1", "CreateVolume")
+	assert.Nil(t, err)
+	unlockAgain, err := s.lockVolume(ctx, "vol1", "CreateVolume")
+	assert.Nil(t, err)
+	unlockAgain()
+	unlock()
+}
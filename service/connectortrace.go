@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dell/csi-unity/service/utils"
+)
+
+//defaultConnectorTraceMaxSize is the size of the connector trace file at which it is rotated
+const defaultConnectorTraceMaxSize = 10 * 1024 * 1024
+
+//connectorTracer is the tracer of the gobrick connectors
+type connectorTracer interface {
+	Trace(ctx context.Context, format string, args ...interface{})
+}
+
+//fileTracer writes the traces of the gobrick connectors to a file, rotated to a single backup file with the .1 suffix
+//when it reaches its maximum size
+type fileTracer struct {
+	mutex   sync.Mutex
+	path    string
+	maxSize int64
+	file    *os.File
+	size    int64
+}
+
+//newFileTracer returns a tracer appending the traces to the file
+func newFileTracer(path string, maxSize int64) (*fileTracer, error) {
+	tracer := &fileTracer{path: path, maxSize: maxSize}
+	if err := tracer.open(); err != nil {
+		return nil, err
+	}
+	return tracer, nil
+}
+
+func (t *fileTracer) open() error {
+	file, err := os.OpenFile(t.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	t.file = file
+	t.size = info.Size()
+	return nil
+}
+
+//rotate moves the trace file to its backup file and opens a new trace file. The trace file is opened again when it
+//cannot be moved
+func (t *fileTracer) rotate() error {
+	t.file.Close()
+	t.file = nil
+	renameErr := os.Rename(t.path, t.path+".1")
+	if err := t.open(); err != nil {
+		return err
+	}
+	return renameErr
+}
+
+//Trace writes the trace with the run id, array id and volume id of the context, so that the traces of the volumes
+//staged concurrently can be told apart
+func (t *fileTracer) Trace(ctx context.Context, format string, args ...interface{}) {
+	fields := getLogFields(ctx)
+	var line strings.Builder
+	line.WriteString(time.Now().Format(time.RFC3339Nano))
+	for _, key := range []string{utils.RUNID, utils.ARRAYID, utils.VOLUMEID} {
+		if value, ok := fields[key]; ok {
+			line.WriteString(fmt.Sprintf(" %s=%v", key, value))
+		}
+	}
+	line.WriteString(" " + fmt.Sprintf(format, args...) + "\n")
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.file == nil {
+		return
+	}
+	if t.maxSize > 0 && t.size > 0 && t.size+int64(line.Len()) > t.maxSize {
+		if err := t.rotate(); err != nil {
+			utils.GetLogger().Errorf("Unable to rotate the connector trace file %s. Error: %v", t.path, err)
+			if t.file == nil {
+				return
+			}
+		}
+	}
+	n, err := t.file.WriteString(line.String())
+	t.size += int64(n)
+	if err != nil {
+		utils.GetLogger().Errorf("Unable to write to the connector trace file %s. Error: %v", t.path, err)
+	}
+}
+
+//getConnectorTracer returns the file tracer when a connector trace file is configured, and the tracer discarding the
+//traces otherwise or when the file cannot be opened
+func (s *service) getConnectorTracer() connectorTracer {
+	if s.opts.ConnectorTraceFile == "" {
+		return &emptyTracer{}
+	}
+	tracer, err := newFileTracer(s.opts.ConnectorTraceFile, defaultConnectorTraceMaxSize)
+	if err != nil {
+		utils.GetLogger().Warnf("Unable to open the connector trace file %s. Connector traces are discarded. Error: %v", s.opts.ConnectorTraceFile, err)
+		return &emptyTracer{}
+	}
+	utils.GetLogger().Infof("Writing the connector traces to %s", s.opts.ConnectorTraceFile)
+	return tracer
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConnectorTraceFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "connectortrace")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "connector.trace")
+
+	//Without a trace file the traces are discarded
+	s := &service{opts: Opts{}}
+	_, ok := s.getConnectorTracer().(*emptyTracer)
+	assert.True(t, ok)
+
+	s.opts.ConnectorTraceFile = path
+	tracer, ok := s.getConnectorTracer().(*fileTracer)
+	if !assert.True(t, ok) {
+		return
+	}
+	ctx, _ := setRunIdContext(context.Background(), "1234")
+	ctx, _ = setVolumeIdContext(ctx, "vol1-FC-array1-sv_1")
+	tracer.Trace(ctx, "connecting to %d targets", 2)
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(string(data), "runid=1234 volumeid=vol1-FC-array1-sv_1 connecting to 2 targets"), "Unexpected trace: %s", data)
+
+	//The trace file is rotated at its maximum size
+	tracer.maxSize = int64(len(data)) + 10
+	tracer.Trace(ctx, "multipath device %s found", "dm-1")
+	backup, err := ioutil.ReadFile(path + ".1")
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(string(backup), "connecting to 2 targets"), "Unexpected backup: %s", backup)
+	data, err = ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(string(data), "multipath device dm-1 found"), "Unexpected trace: %s", data)
+	assert.False(t, strings.Contains(string(data), "connecting to 2 targets"), "Unexpected trace: %s", data)
+
+	//A trace file that cannot be opened discards the traces
+	s.opts.ConnectorTraceFile = filepath.Join(dir, "missing", "connector.trace")
+	_, ok = s.getConnectorTracer().(*emptyTracer)
+	assert.True(t, ok)
+}
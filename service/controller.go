@@ -5,7 +5,9 @@ All Rights Reserved
 package service
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -19,7 +21,9 @@ import (
 	"github.com/dell/gounity"
 	"github.com/dell/gounity/types"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -36,6 +40,8 @@ const (
 	keyProtocol             = "protocol"
 	keyNasServer            = "nasServer"
 	keyHostIoSize           = "hostIoSize"
+	keyDisableMultipath     = "disableMultipath"
+	keyPlacementGroup       = "placementGroup"
 )
 
 const (
@@ -49,6 +55,18 @@ const (
 	NFSShareLocalPath        = "/"
 	NFSShareNamePrefix       = "csishare-"
 	AdditionalFilesystemSize = 1.5 * 1024 * 1024 * 1024
+
+	//Response header reporting whether ListVolumes results cover all the arrays
+	listVolumesStatusHeader = "csi-unity-list-status"
+	listStatusComplete      = "complete"
+	listStatusDegraded      = "degraded"
+
+	//Policies applied by ListVolumes to the volume records missing a required field
+	IncompleteVolumeSkip = "skip"
+	IncompleteVolumeFail = "fail"
+
+	//State of a Unity snapshot that is consistent and ready to be used
+	snapshotStateReady = 2
 )
 
 var (
@@ -58,6 +76,7 @@ var (
 	errNoMultiNodeWriter      = "multi-node with writer(s) only supported for block access type"
 	errBlockReadOnly          = "Read only not supported for Block Volume"
 	errBlockNFS               = "Block Volume Capability is not supported for NFS"
+	errNFSMountBlock          = "NFS file system type is not supported for block protocols"
 )
 
 //CRParams - defines placeholder for all create volume parameters
@@ -82,13 +101,76 @@ const snapshotType resourceType = "snapshot"
 func (s *service) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
 	ctx, log, rid := GetRunidLog(ctx)
 	log.Debugf("Executing CreateVolume with args: %+v", *req)
+	release, err := s.createVolumeLimiter.acquire(ctx, s.opts.MaxConcurrentCreateVolume, "CreateVolume")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	//Requests with the same name are serialized so that only one of them creates the volume
+	unlock, err := s.lockVolume(ctx, req.GetName(), "CreateVolume")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	defer s.trackVolumeCreation(req.GetName())()
 	params := req.GetParameters()
-	arrayID := strings.ToLower(strings.TrimSpace(params[keyArrayId]))
+	if s.opts.StrictParameters {
+		if err := validateParameterKeys(ctx, params); err != nil {
+			return nil, err
+		}
+	}
+	arrayID := normalizeArrayId(strings.TrimSpace(params[keyArrayId]), s.opts.ArrayIdCaseSensitive)
+	//Storage classes without arrayId select the array by its labels
+	selector := strings.TrimSpace(params[keyArraySelector])
+	//Storage classes without arrayId place the volumes of a namespace on the array it prefers
+	if namespace := getRequestNamespace(params); arrayID == "" && namespace != "" {
+		arrayID, err = s.selectArrayByNamespace(ctx, namespace, selector, req.GetAccessibilityRequirements())
+		if err != nil {
+			return nil, err
+		}
+	}
+	if arrayID == "" && selector != "" {
+		arrayID, err = s.selectArrayByLabels(ctx, selector, req.GetAccessibilityRequirements())
+		if err != nil {
+			return nil, err
+		}
+	}
 	if arrayID == "" {
 		return nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "ArrayId cannot be empty"))
 	}
 	ctx, log = setArrayIdContext(ctx, arrayID)
 
+	//Volumes are created with the default protocol when the storage class does not set one. The default is set on a
+	//copy of the parameters and of the request so that the request of the caller is left unchanged
+	if strings.TrimSpace(params[keyProtocol]) == "" {
+		withDefaults := make(map[string]string, len(params)+1)
+		for key, value := range params {
+			withDefaults[key] = value
+		}
+		withDefaults[keyProtocol] = s.getDefaultProtocol()
+		params = withDefaults
+		reqWithDefaults := *req
+		reqWithDefaults.Parameters = params
+		req = &reqWithDefaults
+		log.Debugf("Parameter %s is not set. Using default protocol %s", keyProtocol, params[keyProtocol])
+	}
+
+	if err := s.checkMixedProtocolParameters(ctx, params); err != nil {
+		return nil, err
+	}
+
+	//Reject incompatible access types before any call to the array
+	if err := validateCreateVolumeAccessType(ctx, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.requireNotInMaintenance(ctx, arrayID); err != nil {
+		return nil, err
+	}
+	if err := s.requireWritable(ctx, arrayID, "CreateVolume"); err != nil {
+		return nil, err
+	}
+
 	if err := s.requireProbe(ctx, arrayID); err != nil {
 		return nil, err
 	}
@@ -103,13 +185,89 @@ func (s *service) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest
 		return nil, err
 	}
 
+	if _, err := getNFSAccessPolicy(params[keyAccessPolicy]); err != nil {
+		return nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "%v", err))
+	}
+
+	//Non-fatal conditions of the request are reported in the volume context
+	warnings := make(volumeWarnings, 0)
+	tieringPolicy = warnings.checkTieringPolicy(ctx, params[keyTieringPolicy], tieringPolicy)
+
 	volName := req.GetName()
 	accessibility := req.GetAccessibilityRequirements()
 	preferredAccessibility := accessibility.GetPreferred()
+	if params[keyArrayId] == "" && preferredAccessibility != nil {
+		preferredAccessibility = getTopologiesForArray(preferredAccessibility, arrayID)
+	}
+
+	//Fresh volumes of a placement group are created on the array already hosting the group when it can host them
+	placementGroup := strings.TrimSpace(params[keyPlacementGroup])
+	if placementGroup != "" && req.GetVolumeContentSource() == nil {
+		if selected := s.selectPlacementArray(ctx, placementGroup, arrayID, protocol, storagePool, size, accessibility); selected != arrayID {
+			arrayID = selected
+			ctx, log = setArrayIdContext(ctx, arrayID)
+			unity, err = s.getUnityClient(ctx, arrayID)
+			if err != nil {
+				return nil, err
+			}
+			if preferredAccessibility != nil {
+				preferredAccessibility = getTopologiesForArray(preferredAccessibility, arrayID)
+			}
+		}
+	}
+
+	//Fresh block volumes fall back to another eligible array when the storage pool of the array lacks capacity
+	if fallback, _ := strconv.ParseBool(strings.TrimSpace(params[keyAllowArrayFallback])); fallback && protocol != NFS && req.GetVolumeContentSource() == nil {
+		selected, err := s.selectFallbackArray(ctx, arrayID, selector, protocol, storagePool, size, accessibility)
+		if err != nil {
+			return nil, err
+		}
+		if selected != arrayID {
+			arrayID = selected
+			ctx, log = setArrayIdContext(ctx, arrayID)
+			unity, err = s.getUnityClient(ctx, arrayID)
+			if err != nil {
+				return nil, err
+			}
+			if preferredAccessibility != nil {
+				preferredAccessibility = getTopologiesForArray(preferredAccessibility, arrayID)
+			}
+		}
+	}
 
 	log.Infof("PREFERRED-->%+v", preferredAccessibility)
 
-	desc := params[keyDescription]
+	//The placement and fallback may have moved the volume to another array
+	if err := s.requireWritable(ctx, arrayID, "CreateVolume"); err != nil {
+		return nil, err
+	}
+	if err := s.requirePoolAllowed(ctx, arrayID, storagePool); err != nil {
+		return nil, err
+	}
+	if err := s.requireProtocolSupported(ctx, arrayID, protocol); err != nil {
+		return nil, err
+	}
+	if dataReduction {
+		if err := s.requireCapability(ctx, arrayID, capabilityDataReduction); err != nil {
+			return nil, err
+		}
+	}
+	if protocol != NFS && req.GetVolumeContentSource() != nil {
+		if err := s.requireCapability(ctx, arrayID, capabilityThinClone); err != nil {
+			return nil, err
+		}
+	}
+
+	//The description is truncated to keep room for the placement group and ownership tags
+	tags := getOwnerTag()
+	if placementGroup != "" {
+		tags = fmt.Sprintf(placementGroupTagFormat, placementGroup) + " " + tags
+	}
+	desc := warnings.truncateDescription(ctx, params[keyDescription], tags)
+	if placementGroup != "" {
+		desc = addPlacementGroupTag(desc, placementGroup)
+	}
+	desc = addOwnerTag(desc)
 	hostIOLimitName := strings.TrimSpace(params[keyHostIOLimitName])
 
 	crParams := CRParams{
@@ -135,6 +293,9 @@ func (s *service) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest
 			sourceVolID := volumeSource.VolumeId
 			log.Debugf("Cloning Volume: %s", sourceVolID)
 			resp, err := s.createVolumeClone(ctx, &crParams, sourceVolID, arrayID, contentSource, unity, preferredAccessibility)
+			if err == nil {
+				warnings.setVolumeContext(ctx, resp)
+			}
 			return resp, err
 		}
 
@@ -145,10 +306,31 @@ func (s *service) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest
 			log.Debugf("Create Volume from Snapshot: %s", snapshotID)
 
 			resp, err := s.createVolumeFromSnap(ctx, &crParams, snapshotID, arrayID, contentSource, unity, preferredAccessibility)
+			if err == nil {
+				warnings.setVolumeContext(ctx, resp)
+			}
 			return resp, err
 		}
 	}
 
+	//completeResponse completes the response of a fresh volume with the volume context set from the parameters
+	//and the warnings of the request, and records its placement group
+	completeResponse := func(volumeResp *csi.CreateVolumeResponse) *csi.CreateVolumeResponse {
+		if protocol != NFS {
+			setDisableMultipathContext(volumeResp, params)
+		}
+		setMountPropagationContext(volumeResp, params)
+		setSELinuxContext(volumeResp, params)
+		if protocol == NFS {
+			setNFSAccessPolicyContext(volumeResp, params)
+		}
+		setFsTypeContext(volumeResp, req.GetVolumeCapabilities(), protocol)
+		warnings.checkCapacityRoundedUp(ctx, volumeResp, req.GetCapacityRange().GetRequiredBytes())
+		warnings.setVolumeContext(ctx, volumeResp)
+		s.recordPlacementGroup(ctx, placementGroup, arrayID)
+		return volumeResp
+	}
+
 	//Create Fresh Volume
 	if protocol == NFS {
 
@@ -179,10 +361,26 @@ func (s *service) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest
 		filesystem, _ := fileAPI.FindFilesystemByName(ctx, volName)
 		if filesystem != nil {
 			content := filesystem.FileContent
-			if int64(content.SizeTotal) == size && content.NASServer.Id == nasServer && content.Pool.Id == storagePool {
+			if content.NASServer.Id == nasServer && content.Pool.Id == storagePool {
+				expand, err := s.checkExistingVolumeSize(ctx, volName, int64(content.SizeTotal), size)
+				if err != nil {
+					return nil, err
+				}
+				if expand {
+					err = s.withReauth(ctx, arrayID, func() error {
+						return fileAPI.ExpandFilesystem(ctx, content.Id, uint64(size))
+					})
+					if err != nil {
+						return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Expand existing filesystem %s failed with error: %v", volName, utils.GetUnityError(err)))
+					}
+					filesystem, err = fileAPI.FindFilesystemByName(ctx, volName)
+					if err != nil {
+						return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Find filesystem %s failed with error: %v", volName, utils.GetUnityError(err)))
+					}
+				}
 				log.Info("Filesystem exists in the requested state with same size, NAS server and storage pool")
 				filesystem.FileContent.SizeTotal -= AdditionalFilesystemSize
-				return utils.GetVolumeResponseFromFilesystem(filesystem, arrayID, protocol), nil
+				return completeResponse(utils.GetVolumeResponseFromFilesystem(filesystem, arrayID, protocol)), nil
 			} else {
 				log.Info("'Filesystem name' already exists and size/NAS server/storage pool is different")
 				return nil, status.Error(codes.AlreadyExists, utils.GetMessageWithRunID(rid, "'Filesystem name' already exists and size/NAS server/storage pool is different."))
@@ -190,23 +388,33 @@ func (s *service) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest
 		}
 
 		log.Debug("Filesystem does not exist, proceeding to create new filesystem")
+		if err := s.checkPoolReservation(ctx, unity, storagePool, size); err != nil {
+			return nil, err
+		}
 		//Hardcoded ProtocolNFS to 0 in order to support only NFS
-		resp, err := fileAPI.CreateFilesystem(ctx, volName, storagePool, desc, nasServer, uint64(size), int(tieringPolicy), int(hostIoSize), ProtocolNFS, thin, dataReduction)
+		var resp *types.Filesystem
+		err = s.withReauth(ctx, arrayID, createOnce(func() error {
+			resp, err = fileAPI.CreateFilesystem(ctx, volName, storagePool, desc, nasServer, uint64(size), int(tieringPolicy), int(hostIoSize), ProtocolNFS, thin, dataReduction)
+			return err
+		}, func() bool {
+			resp, _ = fileAPI.FindFilesystemByName(ctx, volName)
+			return resp != nil
+		}))
 		//Add method to create filesystem
 		if err != nil {
-			log.Debugf("Filesystem create response:%v Error:%v", resp, err)
-			return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Create Filesystem %s failed with error: %v", volName, err))
+			log.Debugf("Filesystem create response:%v Error:%v", resp, utils.GetUnityError(err))
+			return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Create Filesystem %s failed with error: %v", volName, utils.GetUnityError(err)))
 		}
 
 		resp, err = fileAPI.FindFilesystemByName(ctx, volName)
 		if err != nil {
-			log.Debugf("Find Filesystem response: %v Error: %v", resp, err)
+			log.Debugf("Find Filesystem response: %v Error: %v", resp, utils.GetUnityError(err))
 		}
 
 		if resp != nil {
 			resp.FileContent.SizeTotal -= AdditionalFilesystemSize
 			filesystemResp := utils.GetVolumeResponseFromFilesystem(resp, arrayID, protocol)
-			return filesystemResp, nil
+			return completeResponse(filesystemResp), nil
 		}
 	} else {
 		// log all parameters used in CreateVolume call
@@ -228,7 +436,7 @@ func (s *service) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest
 		if hostIOLimitName != "" {
 			hostIOLimit, err = volumeAPI.FindHostIOLimitByName(ctx, hostIOLimitName)
 			if err != nil {
-				return nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "HostIOLimitName %s not found. Error: %v", hostIOLimitName, err))
+				return nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "HostIOLimitName %s not found. Error: %v", hostIOLimitName, utils.GetUnityError(err)))
 			}
 
 			hostIOLimitId = hostIOLimit.IoLimitPolicyContent.Id
@@ -238,30 +446,74 @@ func (s *service) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest
 		vol, _ := volumeAPI.FindVolumeByName(ctx, volName)
 		if vol != nil {
 			content := vol.VolumeContent
-			if int64(content.SizeTotal) == size {
-				log.Info("Volume exists in the requested state with same size")
-				return utils.GetVolumeResponseFromVolume(vol, arrayID, protocol, preferredAccessibility), nil
-			} else {
-				log.Info("'Volume name' already exists and size is different")
-				return nil, status.Error(codes.AlreadyExists, utils.GetMessageWithRunID(rid, "'Volume name' already exists and size is different."))
+			expand, err := s.checkExistingVolumeSize(ctx, volName, int64(content.SizeTotal), size)
+			if err != nil {
+				return nil, err
+			}
+			if expand {
+				err = s.withReauth(ctx, arrayID, func() error {
+					return volumeAPI.ExpandVolume(ctx, content.ResourceId, uint64(size))
+				})
+				if err != nil {
+					return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Expand existing volume %s failed with error: %v", volName, utils.GetUnityError(err)))
+				}
+				vol, err = volumeAPI.FindVolumeByName(ctx, volName)
+				if err != nil {
+					return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Find volume %s failed with error: %v", volName, utils.GetUnityError(err)))
+				}
 			}
+			log.Info("Volume exists in the requested state with same size")
+			return completeResponse(utils.GetVolumeResponseFromVolume(vol, arrayID, protocol, preferredAccessibility)), nil
 		}
 
 		log.Debug("Volume does not exist, proceeding to create new volume")
-		resp, err := volumeAPI.CreateLun(ctx, volName, storagePool, desc, uint64(size), int(tieringPolicy), hostIOLimitId, thin, dataReduction)
+		if err := s.checkPoolReservation(ctx, unity, storagePool, size); err != nil {
+			return nil, err
+		}
+		var resp *types.Volume
+		err = s.withReauth(ctx, arrayID, createOnce(func() error {
+			resp, err = volumeAPI.CreateLun(ctx, volName, storagePool, desc, uint64(size), int(tieringPolicy), hostIOLimitId, thin, dataReduction)
+			return err
+		}, func() bool {
+			resp, _ = volumeAPI.FindVolumeByName(ctx, volName)
+			return resp != nil
+		}))
 		if err != nil {
-			return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Create Volume %s failed with error: %v", volName, err))
+			return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Create Volume %s failed with error: %v", volName, utils.GetUnityError(err)))
 		}
 
 		resp, err = volumeAPI.FindVolumeByName(ctx, volName)
 		if resp != nil {
-			volumeResp := utils.GetVolumeResponseFromVolume(resp, arrayID, protocol, preferredAccessibility)
+			volumeResp := completeResponse(utils.GetVolumeResponseFromVolume(resp, arrayID, protocol, preferredAccessibility))
 			log.Debugf("CreateVolume successful for volid: [%s]", volumeResp.Volume.VolumeId)
 			return volumeResp, nil
 		}
 	}
 
-	return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Volume/Filesystem not found after create. %v", err))
+	return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Volume/Filesystem not found after create. %v", utils.GetUnityError(err)))
+}
+
+//setDisableMultipathContext passes the disableMultipath storage class parameter to the node in the volume context
+func setDisableMultipathContext(volumeResp *csi.CreateVolumeResponse, params map[string]string) {
+	if value := strings.TrimSpace(params[keyDisableMultipath]); value != "" {
+		volumeResp.Volume.VolumeContext[keyDisableMultipath] = value
+	}
+}
+
+//checkExistingVolumeSize applies the size mismatch policy to a volume that already exists with the requested name.
+//Returns true when the existing volume has to be expanded to the requested size. A volume larger than requested
+//always returns AlreadyExists
+func (s *service) checkExistingVolumeSize(ctx context.Context, volName string, existing, requested int64) (bool, error) {
+	rid, log := utils.GetRunidAndLogger(ctx)
+	if existing == requested {
+		return false, nil
+	}
+	if existing < requested && s.opts.SizeMismatchPolicy == SizeMismatchAutoExpand {
+		log.Infof("Volume %s exists with size %d smaller than the requested size %d. Expanding it as per the %s policy", volName, existing, requested, SizeMismatchAutoExpand)
+		return true, nil
+	}
+	log.Infof("'Volume name' %s already exists and size %d is different from the requested size %d", volName, existing, requested)
+	return false, status.Error(codes.AlreadyExists, utils.GetMessageWithRunID(rid, "'Volume name' already exists and size is different."))
 }
 
 func (s *service) DeleteVolume(
@@ -270,6 +522,11 @@ func (s *service) DeleteVolume(
 	*csi.DeleteVolumeResponse, error) {
 	ctx, log, rid := GetRunidLog(ctx)
 	log.Debugf("Executing DeleteVolume with args: %+v", *req)
+	unlock, err := s.lockVolume(ctx, req.GetVolumeId(), "DeleteVolume")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 	var snapErr error
 	volID, protocol, arrayId, unity, err := s.validateAndGetResourceDetails(ctx, req.GetVolumeId(), volumeType)
 	if err != nil {
@@ -280,43 +537,38 @@ func (s *service) DeleteVolume(
 		return nil, err
 	}
 	deleteVolumeResp := &csi.DeleteVolumeResponse{}
-	//Not validating protocol here to support deletion of pvcs from v1.0
-	if protocol != NFS {
-
-		//Delete logic for FC and iSCSI volumes
-		var throwErr error
-		err, throwErr = s.deleteBlockVolume(ctx, volID, unity)
-		if throwErr != nil {
-			return nil, throwErr
-		}
-
-	} else {
-
-		//Delete logic for Filesystem
-		var throwErr error
-		err, snapErr, throwErr = s.deleteFilesystem(ctx, volID, unity)
+	var throwErr error
+	reauthErr := s.withReauth(ctx, arrayId, func() error {
+		err, snapErr, throwErr = s.deleteVolumeWithGrace(ctx, req.GetVolumeId(), volID, protocol, unity)
 		if throwErr != nil {
-			return nil, throwErr
+			return throwErr
 		}
+		return err
+	})
+	if _, ok := reauthErr.(*reauthError); ok {
+		return nil, reauthErr
+	}
+	if throwErr != nil {
+		return nil, throwErr
 	}
 
 	//Idempotency check
 	if err == nil {
 		log.Debugf("DeleteVolume successful for volid: [%s]", req.VolumeId)
 		return deleteVolumeResp, nil
-	} else if err == gounity.FilesystemNotFoundError || err == gounity.VolumeNotFoundError || snapErr == gounity.SnapshotNotFoundError {
+	} else if isVolumeNotFound(err, snapErr) {
 		log.Debug("Volume not found on array")
 		log.Debugf("DeleteVolume successful for volid: [%s]", req.VolumeId)
 		return deleteVolumeResp, nil
 	}
-	return nil, status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "Delete Volume %s failed with error: %v", volID, err))
+	return nil, status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "Delete Volume %s failed with error: %v", volID, utils.GetUnityError(err)))
 }
 
 func (s *service) ControllerPublishVolume(
 	ctx context.Context,
 	req *csi.ControllerPublishVolumeRequest) (
 	*csi.ControllerPublishVolumeResponse, error) {
-	ctx, log, _ := GetRunidLog(ctx)
+	ctx, log, rid := GetRunidLog(ctx)
 	log.Debugf("Executing ControllerPublishVolume with args: %+v", *req)
 
 	volID, protocol, arrayID, unity, err := s.validateAndGetResourceDetails(ctx, req.GetVolumeId(), volumeType)
@@ -346,16 +598,28 @@ func (s *service) ControllerPublishVolume(
 	pinfo["arrayId"] = arrayID
 	pinfo["host"] = nodeID
 
+	var resp *csi.ControllerPublishVolumeResponse
 	if protocol == FC || protocol == ISCSI {
-		resp, err := s.exportVolume(ctx, protocol, volID, hostID, nodeID, arrayID, unity, pinfo, host)
+		err = s.withReauth(ctx, arrayID, func() error {
+			resp, err = s.exportVolume(ctx, protocol, volID, hostID, nodeID, arrayID, unity, pinfo, host)
+			return err
+		})
 		return resp, err
 	}
 
 	vc := req.GetVolumeCapability()
 	am := vc.GetAccessMode()
 
+	accessPolicy, err := getNFSAccessPolicy(req.GetVolumeContext()[keyAccessPolicy])
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "%v", err))
+	}
+
 	//Export for NFS
-	resp, err := s.exportFilesystem(ctx, volID, hostID, nodeID, arrayID, unity, pinfo, am)
+	err = s.withReauth(ctx, arrayID, func() error {
+		resp, err = s.exportFilesystem(ctx, volID, hostID, nodeID, arrayID, unity, pinfo, am, accessPolicy)
+		return err
+	})
 	return resp, err
 }
 
@@ -391,7 +655,11 @@ func (s *service) ControllerUnpublishVolume(
 	if protocol != NFS {
 
 		volumeAPI := gounity.NewVolume(unity)
-		vol, err := volumeAPI.FindVolumeById(ctx, volID)
+		var vol *types.Volume
+		err = s.withReauth(ctx, arrayID, func() error {
+			vol, err = volumeAPI.FindVolumeById(ctx, volID)
+			return err
+		})
 		if err != nil {
 			// If the volume isn't found, k8s will retry Controller Unpublish forever so...
 			// There is no way back if volume isn't found and so considering this scenario idempotent
@@ -399,16 +667,18 @@ func (s *service) ControllerUnpublishVolume(
 				log.Debugf("Volume %s not found on the array %s during Controller Unpublish. Hence considering the call to be idempotent", volID, arrayID)
 				return &csi.ControllerUnpublishVolumeResponse{}, nil
 			}
-			return nil, status.Error(codes.Internal, utils.GetMessageWithRunID(rid, "%v", err))
+			return nil, status.Error(codes.Internal, utils.GetMessageWithRunID(rid, "%v", utils.GetUnityError(err)))
 		}
 
 		//Idempotency check
 		content := vol.VolumeContent
 		if len(content.HostAccessResponse) > 0 {
 			log.Debug("Removing Host access on Volume ", volID)
-			err = volumeAPI.UnexportVolume(ctx, volID)
+			err = s.withReauth(ctx, arrayID, func() error {
+				return volumeAPI.UnexportVolume(ctx, volID)
+			})
 			if err != nil {
-				return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Unexport Volume Failed. %v", err))
+				return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Unexport Volume Failed. %v", utils.GetUnityError(err)))
 			}
 		} else {
 			log.Info(fmt.Sprintf("The given Node %s does not have access on the given volume %s. Already in Unpublished state.", hostID, volID))
@@ -418,7 +688,9 @@ func (s *service) ControllerUnpublishVolume(
 	}
 
 	//Unexport for NFS
-	err = s.unexportFilesystem(ctx, volID, hostID, nodeID, req.GetVolumeId(), arrayID, unity)
+	err = s.withReauth(ctx, arrayID, func() error {
+		return s.unexportFilesystem(ctx, volID, hostID, nodeID, req.GetVolumeId(), arrayID, unity)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -467,14 +739,190 @@ func (s *service) ValidateVolumeCapabilities(ctx context.Context, req *csi.Valid
 }
 
 func (s *service) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "Not implemented")
+	ctx, log, rid := GetRunidLog(ctx)
+	log.Debugf("Executing ListVolumes with args: %+v", *req)
+
+	//Limiting the number of volumes to 100 to avoid timeout issues
+	maxEntries := int(req.MaxEntries)
+	if maxEntries > MAX_ENTRIES_VOLUME || maxEntries == 0 {
+		maxEntries = MAX_ENTRIES_VOLUME
+	}
+
+	arrays := s.getStorageArrayList()
+	sort.Slice(arrays, func(i, j int) bool { return arrays[i].ArrayId < arrays[j].ArrayId })
+
+	arrayIndex, startToken, err := parseListVolumesToken(req.StartingToken)
+	if err != nil || arrayIndex > len(arrays) {
+		return nil, status.Error(codes.Aborted, utils.GetMessageWithRunID(rid, "Invalid StartingToken: %s", req.StartingToken))
+	}
+
+	entries := make([]*csi.ListVolumesResponse_Entry, 0)
+	listStatus := listStatusComplete
+	nextToken := ""
+	for ; arrayIndex < len(arrays) && len(entries) < maxEntries; arrayIndex++ {
+		array := arrays[arrayIndex]
+		arrayCtx, arrayLog := setArrayIdContext(ctx, array.ArrayId)
+		if err := singleArrayProbe(arrayCtx, "Controller", array); err != nil {
+			arrayLog.Warnf("Skipping unreachable array %s while listing volumes. Error: %v", array.ArrayId, err)
+			listStatus = listStatusDegraded
+			startToken = 0
+			continue
+		}
+
+		var volumes []types.Volume
+		var next int
+		err = s.withReauth(arrayCtx, array.ArrayId, func() error {
+			volumes, next, err = listArrayVolumes(arrayCtx, array.UnityClient, startToken, maxEntries-len(entries))
+			return err
+		})
+		if err != nil {
+			arrayLog.Warnf("Unable to list volumes of array %s. Error: %v", array.ArrayId, err)
+			listStatus = listStatusDegraded
+			startToken = 0
+			continue
+		}
+
+		arrayEntries, err := s.getCSIVolumes(arrayCtx, s.filterOwnedVolumes(volumes))
+		if err != nil {
+			return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, err.Error()))
+		}
+		entries = append(entries, arrayEntries...)
+		if next > 0 {
+			nextToken = fmt.Sprintf("%d:%d", arrayIndex, next)
+			break
+		}
+		startToken = 0
+	}
+	if nextToken == "" && arrayIndex < len(arrays) {
+		nextToken = fmt.Sprintf("%d:0", arrayIndex)
+	}
+
+	//Empty results from unreachable arrays are reported as degraded so that callers retry instead of
+	//treating them as no volumes
+	if err := grpc.SetHeader(ctx, metadata.Pairs(listVolumesStatusHeader, listStatus)); err != nil {
+		log.Debugf("Unable to set %s header. Error: %v", listVolumesStatusHeader, err)
+	}
+	log.Debugf("ListVolumes returned %d volumes. Status: %s", len(entries), listStatus)
+	return &csi.ListVolumesResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
+}
+
+//listArrayVolumes lists a page of volumes of an array. It is a variable so that tests can override it
+var listArrayVolumes = func(ctx context.Context, unity *gounity.Client, startToken, maxEntries int) ([]types.Volume, int, error) {
+	return gounity.NewVolume(unity).ListVolumes(ctx, startToken, maxEntries)
+}
+
+//parseListVolumesToken parses the ListVolumes token made of the index of the array and the token within the array
+func parseListVolumesToken(token string) (int, int, error) {
+	if token == "" {
+		return 0, 0, nil
+	}
+	tokens := strings.Split(token, ":")
+	if len(tokens) != 2 {
+		return 0, 0, errors.New(fmt.Sprintf("invalid token %s", token))
+	}
+	arrayIndex, err := strconv.Atoi(tokens[0])
+	if err != nil || arrayIndex < 0 {
+		return 0, 0, errors.New(fmt.Sprintf("invalid token %s", token))
+	}
+	startToken, err := strconv.Atoi(tokens[1])
+	if err != nil || startToken < 0 {
+		return 0, 0, errors.New(fmt.Sprintf("invalid token %s", token))
+	}
+	return arrayIndex, startToken, nil
 }
 
 func (s *service) GetCapacity(
 	ctx context.Context,
 	req *csi.GetCapacityRequest) (
 	*csi.GetCapacityResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "Not implemented")
+	ctx, log, rid := GetRunidLog(ctx)
+	log.Debugf("Executing GetCapacity with args: %+v", *req)
+	params := req.GetParameters()
+	storagePool := strings.TrimSpace(params[keyStoragePool])
+	if storagePool == "" {
+		return nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Storage Pool ID cannot be empty"))
+	}
+
+	//Capacity is computed only for the arrays of the requested topology segment and the array of the storage class
+	arrayIds := getTopologyArrayIds(req.GetAccessibleTopology())
+	arrayID := normalizeArrayId(strings.TrimSpace(params[keyArrayId]), s.opts.ArrayIdCaseSensitive)
+	if req.GetAccessibleTopology() == nil {
+		if arrayID == "" {
+			return nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "ArrayId cannot be empty"))
+		}
+		arrayIds = []string{arrayID}
+	}
+
+	var capacity int64
+	for _, id := range arrayIds {
+		id = normalizeArrayId(id, s.opts.ArrayIdCaseSensitive)
+		if arrayID != "" && id != arrayID {
+			continue
+		}
+		if s.getStorageArray(id) == nil {
+			log.Debugf("Array %s of the requested topology is not configured", id)
+			continue
+		}
+		if s.isArrayInMaintenance(id) {
+			log.Infof("Array %s is in maintenance mode. Reporting no capacity", id)
+			continue
+		}
+		arrayCtx, _ := setArrayIdContext(ctx, id)
+		if err := s.requireProbe(arrayCtx, id); err != nil {
+			return nil, err
+		}
+		unity, err := s.getUnityClient(arrayCtx, id)
+		if err != nil {
+			return nil, err
+		}
+		var free, total uint64
+		err = s.withReauth(arrayCtx, id, func() error {
+			free, total, err = getStoragePoolCapacity(arrayCtx, unity, storagePool)
+			return err
+		})
+		if err != nil {
+			return nil, status.Error(codes.Internal, utils.GetMessageWithRunID(rid, "Unable to get capacity of storage pool %s on array %s. Error: %v", storagePool, id, utils.GetUnityError(err)))
+		}
+		if s.opts.PoolFreeReservation != "" {
+			reserved, err := parsePoolReservation(s.opts.PoolFreeReservation, total)
+			if err != nil {
+				return nil, status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "%v", utils.GetUnityError(err)))
+			}
+			if free > reserved {
+				free -= reserved
+			} else {
+				free = 0
+			}
+		}
+		log.Debugf("Available capacity of storage pool %s on array %s: %d", storagePool, id, free)
+		capacity += int64(free)
+	}
+	return &csi.GetCapacityResponse{AvailableCapacity: capacity}, nil
+}
+
+//getTopologyArrayIds returns the ids of the arrays in the topology segment. Topology keys have the format
+//csi-unity.dellemc.com/<arrayId>-<protocol>
+func getTopologyArrayIds(topology *csi.Topology) []string {
+	arrayIds := make([]string, 0)
+	for key, value := range topology.GetSegments() {
+		if !strings.HasPrefix(key, Name+"/") || value != "true" {
+			continue
+		}
+		arrayProtocol := strings.TrimPrefix(key, Name+"/")
+		index := strings.LastIndex(arrayProtocol, "-")
+		if index <= 0 {
+			continue
+		}
+		arrayId := arrayProtocol[:index]
+		if !utils.ArrayContains(arrayIds, arrayId) {
+			arrayIds = append(arrayIds, arrayId)
+		}
+	}
+	sort.Strings(arrayIds)
+	return arrayIds
 }
 
 func (s *service) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
@@ -484,29 +932,82 @@ func (s *service) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotReq
 	if len(req.SourceVolumeId) == 0 {
 		return nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Storage Resource ID cannot be empty"))
 	}
-	var err error
-	req.Name, err = util.ValidateResourceName(req.Name, api.MaxResourceNameLength)
+	//Sanitize the name before validating it, as names over the Unity limit would otherwise fail on the array
+	sanitizedName, err := utils.SanitizeResourceName(req.Name, api.MaxResourceNameLength)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "invalid snapshot name [%v]", err))
+	}
+	if sanitizedName != strings.TrimSpace(req.Name) {
+		log.Infof("Snapshot name %s exceeds %d characters. Using sanitized name %s", req.Name, api.MaxResourceNameLength, sanitizedName)
+	}
+	req.Name, err = util.ValidateResourceName(sanitizedName, api.MaxResourceNameLength)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "invalid snapshot name [%v]", err))
 	}
 
 	//Source volume is for volume clone or snapshot clone
-	volId, protocol, arrayId, _, err := s.validateAndGetResourceDetails(ctx, req.SourceVolumeId, volumeType)
+	volId, protocol, arrayId, unity, err := s.validateAndGetResourceDetails(ctx, req.SourceVolumeId, volumeType)
 	if err != nil {
 		return nil, err
 	}
 
 	ctx, log = setArrayIdContext(ctx, arrayId)
+	if err := s.requireWritable(ctx, arrayId, "CreateSnapshot"); err != nil {
+		return nil, err
+	}
+	if err := s.checkSourceArrayReachable(ctx, arrayId); err != nil {
+		return nil, err
+	}
 	if err := s.requireProbe(ctx, arrayId); err != nil {
 		return nil, err
 	}
 
 	//Idempotency check
-	snap, err := s.createIdempotentSnapshot(ctx, req.Name, volId, req.Parameters["description"], req.Parameters["retentionDuration"], protocol, arrayId, false)
+	var snap *types.Snapshot
+	err = s.withReauth(ctx, arrayId, func() error {
+		snap, err = s.createIdempotentSnapshot(ctx, req.Name, volId, req.Parameters["description"], req.Parameters["retentionDuration"], protocol, arrayId, false)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	return utils.GetSnapshotResponseFromSnapshot(snap, protocol, arrayId), nil
+	sourceSize := s.getSnapshotSourceSize(ctx, unity, volId, protocol, snap)
+	snapResp := getCreateSnapshotResponse(snap, sourceSize, protocol, arrayId)
+	if !snapResp.Snapshot.ReadyToUse {
+		log.Infof("Snapshot %s is not yet ready to use. ListSnapshots reports it ready once consistent", snapResp.Snapshot.SnapshotId)
+	}
+	return snapResp, nil
+}
+
+//getCreateSnapshotResponse returns the CreateSnapshot response with the size of the source volume. The snapshot is
+//ready to use only once Unity reports it consistent
+func getCreateSnapshotResponse(snap *types.Snapshot, sourceSize int64, protocol, arrayId string) *csi.CreateSnapshotResponse {
+	snapResp := utils.GetSnapshotResponseFromSnapshot(snap, protocol, arrayId)
+	snapResp.Snapshot.ReadyToUse = snap.SnapshotContent.State == snapshotStateReady
+	if sourceSize > 0 {
+		snapResp.Snapshot.SizeBytes = sourceSize
+	}
+	return snapResp
+}
+
+//getSnapshotSourceSize returns the size of the source volume or filesystem of the snapshot. The size reported by the
+//snapshot is returned when the source cannot be found, as for a snapshot of a snapshot
+func (s *service) getSnapshotSourceSize(ctx context.Context, unity *gounity.Client, volId, protocol string, snap *types.Snapshot) int64 {
+	log := utils.GetRunidLogger(ctx)
+	if protocol == NFS {
+		filesystem, err := gounity.NewFilesystem(unity).FindFilesystemById(ctx, volId)
+		if err == nil {
+			return int64(filesystem.FileContent.SizeTotal) - AdditionalFilesystemSize
+		}
+		log.Debugf("Unable to find source filesystem %s of snapshot. Using snapshot size. Error: %v", volId, err)
+	} else {
+		volume, err := gounity.NewVolume(unity).FindVolumeById(ctx, volId)
+		if err == nil {
+			return int64(volume.VolumeContent.SizeTotal)
+		}
+		log.Debugf("Unable to find source volume %s of snapshot. Using snapshot size. Error: %v", volId, err)
+	}
+	return snap.SnapshotContent.Size
 }
 
 func (s *service) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
@@ -524,14 +1025,23 @@ func (s *service) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotReq
 
 	snapApi := gounity.NewSnapshot(unity)
 	//Idempotency check
-	snap, err := snapApi.FindSnapshotById(ctx, snapId)
+	snap, err := findSnapshotById(ctx, unity, snapId)
 	//snapshot exists, continue deleting the snapshot
 	if err != nil {
 		log.Info("Snapshot doesn't exists")
 	}
 
+	//a snapshot other than the one named in the id is never deleted
 	if snap != nil {
-		err := snapApi.DeleteSnapshot(ctx, snapId)
+		if err := s.verifySnapshotId(ctx, req.SnapshotId, arrayId, snap); err != nil {
+			return nil, err
+		}
+	}
+
+	if snap != nil {
+		err := s.withReauth(ctx, arrayId, func() error {
+			return snapApi.DeleteSnapshot(ctx, snapId)
+		})
 		if err != nil {
 			return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Delete Snapshot error: %v", err))
 		}
@@ -576,7 +1086,12 @@ func (s *service) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsReque
 		startToken = int(i)
 	}
 
-	snaps, nextToken, err := snapApi.ListSnapshots(ctx, startToken, maxEntries, "", snapId)
+	var snaps []types.Snapshot
+	var nextToken int
+	err = s.withReauth(ctx, arrayId, func() error {
+		snaps, nextToken, err = snapApi.ListSnapshots(ctx, startToken, maxEntries, "", snapId)
+		return err
+	})
 	if err != nil {
 		return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Unable to get the snapshots: %v", err))
 	}
@@ -685,6 +1200,9 @@ func (s *service) ControllerExpandVolume(ctx context.Context, req *csi.Controlle
 	}
 
 	ctx, log = setArrayIdContext(ctx, arrayId)
+	if err := s.requireWritable(ctx, arrayId, "ControllerExpandVolume"); err != nil {
+		return nil, err
+	}
 	if err := s.requireProbe(ctx, arrayId); err != nil {
 		return nil, err
 	}
@@ -711,7 +1229,11 @@ func (s *service) ControllerExpandVolume(ctx context.Context, req *csi.Controlle
 		capacity += AdditionalFilesystemSize
 		filesystemApi := gounity.NewFilesystem(unity)
 
-		filesystem, err := filesystemApi.FindFilesystemById(ctx, volId)
+		var filesystem *types.Filesystem
+		err = s.withReauth(ctx, arrayId, func() error {
+			filesystem, err = filesystemApi.FindFilesystemById(ctx, volId)
+			return err
+		})
 		if err != nil {
 			snapshotApi := gounity.NewSnapshot(unity)
 			_, err = snapshotApi.FindSnapshotById(ctx, volId)
@@ -731,7 +1253,9 @@ func (s *service) ControllerExpandVolume(ctx context.Context, req *csi.Controlle
 			return expandVolumeResp, nil
 		}
 
-		err = filesystemApi.ExpandFilesystem(ctx, volId, uint64(capacity))
+		err = s.withReauth(ctx, arrayId, func() error {
+			return filesystemApi.ExpandFilesystem(ctx, volId, uint64(capacity))
+		})
 		if err != nil {
 			return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Expand filesystem failed with error: %v", err))
 		}
@@ -746,7 +1270,11 @@ func (s *service) ControllerExpandVolume(ctx context.Context, req *csi.Controlle
 	} else {
 		volumeApi := gounity.NewVolume(unity)
 		//Idempotency check
-		volume, err := volumeApi.FindVolumeById(ctx, volId)
+		var volume *types.Volume
+		err = s.withReauth(ctx, arrayId, func() error {
+			volume, err = volumeApi.FindVolumeById(ctx, volId)
+			return err
+		})
 		if err != nil {
 			return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Find volume failed with error: %v", err))
 		}
@@ -766,9 +1294,12 @@ func (s *service) ControllerExpandVolume(ctx context.Context, req *csi.Controlle
 			return expandVolumeResp, nil
 		}
 
-		err = volumeApi.ExpandVolume(ctx, volId, uint64(capacity))
-		if err != nil {
-			return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Expand volume failed with error: %v", err))
+		//The expansion of large volumes runs in a job, waited for until the volume is usable
+		if err = s.expandLun(ctx, arrayId, unity, volId, uint64(capacity)); err != nil {
+			if _, ok := status.FromError(err); ok {
+				return nil, err
+			}
+			return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Expand volume failed with error: %v", utils.GetUnityError(err)))
 		}
 
 		volume, err = volumeApi.FindVolumeById(ctx, volId)
@@ -781,9 +1312,31 @@ func (s *service) ControllerExpandVolume(ctx context.Context, req *csi.Controlle
 	}
 }
 
-func (s *service) getCSIVolumes(volumes []types.Volume) ([]*csi.ListVolumesResponse_Entry, error) {
-	entries := make([]*csi.ListVolumesResponse_Entry, len(volumes))
-	for i, vol := range volumes {
+//getMissingVolumeFields returns the fields required in a csi.Volume that are missing from the volume record
+func getMissingVolumeFields(vol types.Volume) []string {
+	missing := make([]string, 0)
+	if vol.VolumeContent.ResourceId == "" {
+		missing = append(missing, "id")
+	}
+	if vol.VolumeContent.SizeTotal == 0 {
+		missing = append(missing, "size")
+	}
+	return missing
+}
+
+//getCSIVolumes maps the volume records to csi volumes. Incomplete records, e.g. without size, are skipped with a
+//warning, or fail the listing with the fail policy
+func (s *service) getCSIVolumes(ctx context.Context, volumes []types.Volume) ([]*csi.ListVolumesResponse_Entry, error) {
+	log := utils.GetRunidLogger(ctx)
+	entries := make([]*csi.ListVolumesResponse_Entry, 0, len(volumes))
+	for _, vol := range volumes {
+		if missing := getMissingVolumeFields(vol); len(missing) > 0 {
+			if s.opts.IncompleteVolumePolicy == IncompleteVolumeFail {
+				return nil, fmt.Errorf("volume record %s %s is missing %s", vol.VolumeContent.Name, vol.VolumeContent.ResourceId, strings.Join(missing, ", "))
+			}
+			log.Warnf("Skipping volume record %s %s missing %s", vol.VolumeContent.Name, vol.VolumeContent.ResourceId, strings.Join(missing, ", "))
+			continue
+		}
 		// Make the additional volume attributes
 		attributes := map[string]string{
 			"Name":          vol.VolumeContent.Name,
@@ -798,9 +1351,9 @@ func (s *service) getCSIVolumes(volumes []types.Volume) ([]*csi.ListVolumesRespo
 			VolumeContext: attributes,
 		}
 
-		entries[i] = &csi.ListVolumesResponse_Entry{
+		entries = append(entries, &csi.ListVolumesResponse_Entry{
 			Volume: vi,
-		}
+		})
 	}
 
 	return entries, nil
@@ -809,10 +1362,7 @@ func (s *service) getCSIVolumes(volumes []types.Volume) ([]*csi.ListVolumesRespo
 func (s *service) getCSISnapshots(snaps []types.Snapshot, volId, protocol, arrayId string) ([]*csi.ListSnapshotsResponse_Entry, error) {
 	entries := make([]*csi.ListSnapshotsResponse_Entry, len(snaps))
 	for i, snap := range snaps {
-		isReady := false
-		if snap.SnapshotContent.State == 2 {
-			isReady = true
-		}
+		isReady := snap.SnapshotContent.State == snapshotStateReady
 		var timestamp *timestamp.Timestamp
 		if !snap.SnapshotContent.CreationTime.IsZero() {
 			timestamp, _ = ptypes.TimestampProto(snap.SnapshotContent.CreationTime)
@@ -871,12 +1421,12 @@ func (s *service) createFilesystemFromSnapshot(ctx context.Context, snapID, volu
 
 	snapResp, err := snapshotAPI.CopySnapshot(ctx, snapID, volumeName)
 	if err != nil {
-		return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Create Filesystem from snapshot failed with error. Error: %v", err))
+		return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Create Filesystem from snapshot failed with error. Error: %v", utils.GetUnityError(err)))
 	}
 
 	snapResp, err = snapshotAPI.FindSnapshotByName(ctx, volumeName)
 	if err != nil {
-		return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Create Filesystem from snapshot failed with error. Error: %v", err))
+		return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Create Filesystem from snapshot failed with error. Error: %v", utils.GetUnityError(err)))
 	}
 
 	return snapResp, nil
@@ -1033,7 +1583,7 @@ func (s *service) createVolumeClone(ctx context.Context, crParams *CRParams, sou
 			snapResp, snapErr = snapAPI.FindSnapshotById(ctx, sourceVolID)
 			if snapErr != nil {
 				log.Debugf("Tried to check if PVC exists as a snapshot: %v", snapErr)
-				return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Find source filesystem: %s Failed. Error: %v ", sourceVolID, err))
+				return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Find source filesystem: %s Failed. Error: %v ", sourceVolID, utils.GetUnityError(err)))
 			}
 			isSnapshot = true
 			filesystem, err = s.getFilesystemByResourceID(ctx, snapResp.SnapshotContent.StorageResource.Id, arrayID)
@@ -1089,7 +1639,7 @@ func (s *service) createVolumeClone(ctx context.Context, crParams *CRParams, sou
 	volumeAPI := gounity.NewVolume(unity)
 	sourceVolResp, err := volumeAPI.FindVolumeById(ctx, sourceVolID)
 	if err != nil {
-		return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Source volume not found: %s. Error: %v", sourceVolID, err))
+		return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Source volume not found: %s. Error: %v", sourceVolID, utils.GetUnityError(err)))
 	}
 
 	err = validateCreateVolumeFromSource(ctx, sourceVolResp, storagePool, tieringPolicy, size, thin, dataReduction, false)
@@ -1111,13 +1661,8 @@ func (s *service) createVolumeClone(ctx context.Context, crParams *CRParams, sou
 	}
 
 	//Perform volume cloning
-	volResp, err = volumeAPI.CreateCloneFromVolume(ctx, volName, sourceVolID)
-	if err != nil {
-		if err == gounity.CreateSnapshotFailedError {
-			return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Unable to Create Snapshot for Volume Cloning for source volume: %s", sourceVolID))
-		} else if err == gounity.CloningFailedError {
-			return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Volume cloning for source volume: %s failed.", sourceVolID))
-		}
+	if err = s.cloneVolume(ctx, unity, volName, sourceVolID); err != nil {
+		return nil, err
 	}
 
 	volResp, err = volumeAPI.FindVolumeByName(ctx, volName)
@@ -1126,7 +1671,7 @@ func (s *service) createVolumeClone(ctx context.Context, crParams *CRParams, sou
 		csiVolResp.Volume.ContentSource = contentSource
 		return csiVolResp, nil
 	}
-	return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Volume not found after create. %v", err))
+	return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Volume not found after create. %v", utils.GetUnityError(err)))
 }
 
 //createVolumeFromSnap - Method to create a volume from snapshot with idempotency for all protocols
@@ -1137,6 +1682,7 @@ func (s *service) createVolumeFromSnap(ctx context.Context, crParams *CRParams,
 		return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Source snapshot ID cannot be empty"))
 	}
 
+	snapshotContextID := snapshotID
 	snapshotID, _, sourceArrayID, _, err := s.validateAndGetResourceDetails(ctx, snapshotID, snapshotType)
 	if err != nil {
 		return nil, err
@@ -1156,9 +1702,15 @@ func (s *service) createVolumeFromSnap(ctx context.Context, crParams *CRParams,
 	hostIoSize := crParams.HostIoSize
 
 	snapAPI := gounity.NewSnapshot(unity)
-	snapResp, err := snapAPI.FindSnapshotById(ctx, snapshotID)
+	snapResp, err := findSnapshotById(ctx, unity, snapshotID)
 	if err != nil {
-		return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Source snapshot not found: %s", snapshotID))
+		return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Source snapshot not found: %s. Error: %v", snapshotID, utils.GetUnityError(err)))
+	}
+	if err := s.verifySnapshotId(ctx, snapshotContextID, arrayID, snapResp); err != nil {
+		return nil, err
+	}
+	if err := s.verifySnapshotSource(ctx, snapshotContextID, protocol, snapResp); err != nil {
+		return nil, err
 	}
 
 	if protocol == NFS {
@@ -1203,7 +1755,7 @@ func (s *service) createVolumeFromSnap(ctx context.Context, crParams *CRParams,
 			csiVolResp.Volume.ContentSource = contentSource
 			return csiVolResp, nil
 		}
-		return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Filesystem: %s not found after create. Error: %v", volName, err))
+		return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Filesystem: %s not found after create. Error: %v", volName, utils.GetUnityError(err)))
 	}
 
 	//If protocol is FC or iSCSI
@@ -1211,14 +1763,25 @@ func (s *service) createVolumeFromSnap(ctx context.Context, crParams *CRParams,
 	volumeAPI := gounity.NewVolume(unity)
 	sourceVolResp, err := volumeAPI.FindVolumeById(ctx, volID)
 	if err != nil {
-		return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Source volume not found: %s", volID))
+		return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Source volume not found: %s. Error: %v", volID, utils.GetUnityError(err)))
 	}
 
-	err = validateCreateVolumeFromSource(ctx, sourceVolResp, storagePool, tieringPolicy, size, thin, dataReduction, true)
+	//Tiering policy and data reduction are re-applied to the restored volume, so only the storage pool and thin
+	//provisioning have to match the source volume
+	err = validateCreateVolumeFromSource(ctx, sourceVolResp, storagePool, int64(sourceVolResp.VolumeContent.TieringPolicy), size, thin, sourceVolResp.VolumeContent.IsDataReductionEnabled, true)
 	if err != nil {
 		return nil, err
 	}
 
+	var hostIOLimitId string
+	if crParams.HostIOLimitName != "" {
+		hostIOLimit, err := volumeAPI.FindHostIOLimitByName(ctx, crParams.HostIOLimitName)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "HostIOLimitName %s not found. Error: %v", crParams.HostIOLimitName, utils.GetUnityError(err)))
+		}
+		hostIOLimitId = hostIOLimit.IoLimitPolicyContent.Id
+	}
+
 	// Validate the size parameter
 	if snapResp.SnapshotContent.Size != size {
 		return nil, status.Errorf(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Requested size %d should be same as source snapshot size %d", size, snapResp.SnapshotContent.Size))
@@ -1229,6 +1792,9 @@ func (s *service) createVolumeFromSnap(ctx context.Context, crParams *CRParams,
 		//Idempotency Check
 		if volResp.VolumeContent.IsThinClone == true && len(volResp.VolumeContent.ParentSnap.Id) > 0 && volResp.VolumeContent.ParentSnap.Id == snapshotID {
 			log.Info("Volume exists in the requested state")
+			if err := s.reconcileRestoredVolume(ctx, volResp, crParams, hostIOLimitId, arrayID); err != nil {
+				return nil, err
+			}
 			csiVolResp := utils.GetVolumeResponseFromVolume(volResp, arrayID, protocol, preferredAccessibility)
 			csiVolResp.Volume.ContentSource = contentSource
 			return csiVolResp, nil
@@ -1239,25 +1805,31 @@ func (s *service) createVolumeFromSnap(ctx context.Context, crParams *CRParams,
 	if snapResp.SnapshotContent.IsAutoDelete == true {
 		err = snapAPI.ModifySnapshotAutoDeleteParameter(ctx, snapshotID)
 		if err != nil {
-			return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Unable to modify auto-delete parameter for snapshot %s", snapshotID))
+			return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Unable to modify auto-delete parameter for snapshot %s. Error: %v", snapshotID, utils.GetUnityError(err)))
 		}
 	}
 
-	volResp, err = volumeAPI.CreteLunThinClone(ctx, volName, snapshotID, volID)
-	if err != nil {
-		return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Create volume from snapshot failed with error %v", err))
+	//The thin clone is created in a job, waited for until the volume is usable
+	if err = s.createLunThinClone(ctx, arrayID, unity, volName, snapshotID, volID); err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Create volume from snapshot failed with error %v", utils.GetUnityError(err)))
 	}
 	volResp, err = volumeAPI.FindVolumeByName(ctx, volName)
 	if err != nil {
-		log.Debugf("Find Volume response: %v Error: %v", volResp, err)
+		log.Debugf("Find Volume response: %v Error: %v", volResp, utils.GetUnityError(err))
 	}
 
 	if volResp != nil {
+		if err := s.reconcileRestoredVolume(ctx, volResp, crParams, hostIOLimitId, arrayID); err != nil {
+			return nil, err
+		}
 		csiVolResp := utils.GetVolumeResponseFromVolume(volResp, arrayID, protocol, preferredAccessibility)
 		csiVolResp.Volume.ContentSource = contentSource
 		return csiVolResp, nil
 	}
-	return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Volume not found after create. %v", err))
+	return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Volume not found after create. %v", utils.GetUnityError(err)))
 }
 
 //deleteFilesystem - Method to handle delete filesystem logic
@@ -1275,7 +1847,7 @@ func (s *service) deleteFilesystem(ctx context.Context, volID string, unity *gou
 		snapshotAPI := gounity.NewSnapshot(unity)
 		snapsResp, _, snapshotErr := snapshotAPI.ListSnapshots(ctx, 0, 0, filesystemResp.FileContent.StorageResource.Id, "")
 		if snapshotErr != nil {
-			return nil, nil, status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "List snapshots for filesystem %s failed with error: %v", volID, snapshotErr))
+			return nil, nil, status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "List snapshots for filesystem %s failed with error: %v", volID, utils.GetUnityError(snapshotErr)))
 		}
 
 		for _, snapResp := range snapsResp {
@@ -1293,11 +1865,11 @@ func (s *service) deleteFilesystem(ctx context.Context, volID string, unity *gou
 			//Validate if snapshot has any NFS or SMB shares
 			sourceVolID, err := fileAPI.GetFilesystemIdFromResId(ctx, snapResp.SnapshotContent.StorageResource.Id)
 			if err != nil {
-				return nil, nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Source storage resource: %s filesystem Id not found. Error: %v", snapResp.SnapshotContent.StorageResource.Id, err))
+				return nil, nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Source storage resource: %s filesystem Id not found. Error: %v", snapResp.SnapshotContent.StorageResource.Id, utils.GetUnityError(err)))
 			}
 			filesystemResp, err = fileAPI.FindFilesystemById(ctx, sourceVolID)
 			if err != nil {
-				return nil, nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Find source filesystem: %s failed with error: %v", sourceVolID, err))
+				return nil, nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Find source filesystem: %s failed with error: %v", sourceVolID, utils.GetUnityError(err)))
 			}
 			for _, nfsShare := range filesystemResp.FileContent.NFSShare {
 				if nfsShare.ParentSnap.Id == volID {
@@ -1310,6 +1882,19 @@ func (s *service) deleteFilesystem(ctx context.Context, volID string, unity *gou
 	return err, snapErr, nil
 }
 
+//deleteVolumeResource deletes the block volume or the filesystem of the volume from the array. It is a variable so that
+//tests can override it
+var deleteVolumeResource = func(ctx context.Context, s *service, volID, protocol string, unity *gounity.Client) (error, error, error) {
+	//Not validating protocol here to support deletion of pvcs from v1.0
+	if protocol != NFS {
+		//Delete logic for FC and iSCSI volumes
+		err, throwErr := s.deleteBlockVolume(ctx, volID, unity)
+		return err, nil, throwErr
+	}
+	//Delete logic for Filesystem
+	return s.deleteFilesystem(ctx, volID, unity)
+}
+
 //deleteBlockVolume - Method to handle delete FC and iSCSI volumes
 func (s *service) deleteBlockVolume(ctx context.Context, volID string, unity *gounity.Client) (error, error) {
 
@@ -1318,7 +1903,7 @@ func (s *service) deleteBlockVolume(ctx context.Context, volID string, unity *go
 	snapshotAPI := gounity.NewSnapshot(unity)
 	snapsResp, _, snapshotErr := snapshotAPI.ListSnapshots(ctx, 0, 0, volID, "")
 	if snapshotErr != nil {
-		return nil, status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "List snapshots for volume %s failed with error: %v", volID, snapshotErr))
+		return nil, status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "List snapshots for volume %s failed with error: %v", volID, utils.GetUnityError(snapshotErr)))
 	}
 	totalSnaps := len(snapsResp)
 	for _, snapResp := range snapsResp {
@@ -1343,7 +1928,7 @@ func (s *service) deleteBlockVolume(ctx context.Context, volID string, unity *go
 }
 
 //exportFilesystem - Method to export filesystem with idempotency
-func (s *service) exportFilesystem(ctx context.Context, volID, hostID, nodeID, arrayID string, unity *gounity.Client, pinfo map[string]string, am *csi.VolumeCapability_AccessMode) (*csi.ControllerPublishVolumeResponse, error) {
+func (s *service) exportFilesystem(ctx context.Context, volID, hostID, nodeID, arrayID string, unity *gounity.Client, pinfo map[string]string, am *csi.VolumeCapability_AccessMode, accessPolicy string) (*csi.ControllerPublishVolumeResponse, error) {
 
 	ctx, log, rid := GetRunidLog(ctx)
 	pinfo["filesystem"] = volID
@@ -1356,7 +1941,7 @@ func (s *service) exportFilesystem(ctx context.Context, volID, hostID, nodeID, a
 		snapshotAPI := gounity.NewSnapshot(unity)
 		snapResp, err = snapshotAPI.FindSnapshotById(ctx, volID)
 		if err != nil {
-			return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Find filesystem: %s failed with error: %v", volID, err))
+			return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Find filesystem: %s failed with error: %v", volID, utils.GetUnityError(err)))
 		}
 		isSnapshot = true
 
@@ -1391,13 +1976,13 @@ func (s *service) exportFilesystem(ctx context.Context, volID, hostID, nodeID, a
 		if isSnapshot {
 			nfsShareResp, err := fileAPI.CreateNFSShareFromSnapshot(ctx, nfsShareName, NFSShareLocalPath, volID, gounity.NoneDefaultAccess)
 			if err != nil {
-				return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Create NFS Share failed. Error: %v", err))
+				return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Create NFS Share failed. Error: %v", utils.GetUnityError(err)))
 			}
 			nfsShareID = nfsShareResp.NFSShareContent.Id
 		} else {
 			filesystemResp, err = fileAPI.CreateNFSShare(ctx, nfsShareName, NFSShareLocalPath, volID, gounity.NoneDefaultAccess)
 			if err != nil {
-				return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Create NFS Share failed. Error: %v", err))
+				return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Create NFS Share failed. Error: %v", utils.GetUnityError(err)))
 			}
 		}
 		for _, nfsShare := range filesystemResp.FileContent.NFSShare {
@@ -1408,58 +1993,26 @@ func (s *service) exportFilesystem(ctx context.Context, volID, hostID, nodeID, a
 	}
 
 	//Allocate host access to NFS Share with appropriate access mode
+	unlock := lockNFSShare(nfsShareID)
+	defer unlock()
 	nfsShareResp, err := fileAPI.FindNFSShareById(ctx, nfsShareID)
 	if err != nil {
-		return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Find NFS Share: %s failed. Error: %v", nfsShareID, err))
+		return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Find NFS Share: %s failed. Error: %v", nfsShareID, utils.GetUnityError(err)))
 	}
-	readOnlyHosts := nfsShareResp.NFSShareContent.ReadOnlyHosts
-	readWriteHosts := nfsShareResp.NFSShareContent.ReadWriteHosts
-	readOnlyRootHosts := nfsShareResp.NFSShareContent.ReadOnlyRootAccessHosts
-	readWriteRootHosts := nfsShareResp.NFSShareContent.RootAccessHosts
-
+	//The host has to be granted the access type of the access policy and mode, and no other access type
+	accessType := getNFSHostAccessType(accessPolicy, am)
+	hostIDs := getNFSShareHostIDs(nfsShareResp)
 	foundIncompatible := false
 	foundIdempotent := false
-	otherHostsWithAccess := len(readOnlyHosts)
-	var readHostIDList, readWriteHostIDList []string
-	for _, host := range readOnlyHosts {
-		if host.ID == hostID {
-			foundIncompatible = true
-			break
-		}
-	}
-	otherHostsWithAccess += len(readWriteHosts)
-	if !foundIncompatible {
-		for _, host := range readWriteHosts {
-			if host.ID == hostID {
+	otherHostsWithAccess := 0
+	for hostAccessType, ids := range hostIDs {
+		for _, id := range ids {
+			if id != hostID {
+				otherHostsWithAccess++
+			} else if hostAccessType == accessType {
+				foundIdempotent = true
+			} else {
 				foundIncompatible = true
-				break
-			}
-		}
-	}
-	otherHostsWithAccess += len(readOnlyRootHosts)
-	if !foundIncompatible {
-		for _, host := range readOnlyRootHosts {
-			readHostIDList = append(readHostIDList, host.ID)
-			if host.ID == hostID {
-				if am.Mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY {
-					foundIdempotent = true
-				} else {
-					foundIncompatible = true
-				}
-			}
-		}
-	}
-	otherHostsWithAccess += len(readWriteRootHosts)
-	if !foundIncompatible && !foundIdempotent {
-		for _, host := range readWriteRootHosts {
-			readWriteHostIDList = append(readWriteHostIDList, host.ID)
-			if host.ID == hostID {
-				if am.Mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY {
-					foundIncompatible = true
-				} else {
-					foundIdempotent = true
-					otherHostsWithAccess--
-				}
 			}
 		}
 	}
@@ -1474,25 +2027,11 @@ func (s *service) exportFilesystem(ctx context.Context, volID, hostID, nodeID, a
 		log.Info("Host has access to the given host and exists in the required state.")
 		return &csi.ControllerPublishVolumeResponse{PublishContext: pinfo}, nil
 	}
-	if am.Mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY {
-		readHostIDList = append(readHostIDList, hostID)
-		if isSnapshot {
-			err = fileAPI.ModifyNFSShareCreatedFromSnapshotHostAccess(ctx, nfsShareID, readHostIDList, gounity.ReadOnlyRootAccessType)
-		} else {
-			err = fileAPI.ModifyNFSShareHostAccess(ctx, volID, nfsShareID, readHostIDList, gounity.ReadOnlyRootAccessType)
-		}
-	} else {
-		readWriteHostIDList = append(readWriteHostIDList, hostID)
-		if isSnapshot {
-			err = fileAPI.ModifyNFSShareCreatedFromSnapshotHostAccess(ctx, nfsShareID, readWriteHostIDList, gounity.ReadWriteRootAccessType)
-		} else {
-			err = fileAPI.ModifyNFSShareHostAccess(ctx, volID, nfsShareID, readWriteHostIDList, gounity.ReadWriteRootAccessType)
-		}
-	}
+	err = s.grantNFSShareHostAccess(ctx, unity, volID, nfsShareID, hostID, nodeID, isSnapshot, hostIDs[accessType], accessType)
 	if err != nil {
-		return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Allocating host %s access to NFS Share failed. Error: %v", nodeID, err))
+		return nil, err
 	}
-	log.Debugf("NFS Share: %s is accessible to host: %s with access mode: %s", nfsShareID, nodeID, am.Mode)
+	log.Debugf("NFS Share: %s is accessible to host: %s with access mode: %s and access type: %s", nfsShareID, nodeID, am.Mode, accessType)
 	log.Debugf("ControllerPublishVolume successful for volid: [%s]", pinfo["volumeContextId"])
 	return &csi.ControllerPublishVolumeResponse{PublishContext: pinfo}, nil
 
@@ -1513,7 +2052,7 @@ func (s *service) exportVolume(ctx context.Context, protocol, volID, hostID, nod
 	volumeAPI := gounity.NewVolume(unity)
 	vol, err := volumeAPI.FindVolumeById(ctx, volID)
 	if err != nil {
-		return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Find volume Failed %v", err))
+		return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Find volume Failed %v", utils.GetUnityError(err)))
 	}
 
 	//Idempotency check
@@ -1533,10 +2072,14 @@ func (s *service) exportVolume(ctx context.Context, protocol, volID, hostID, nod
 		}
 	}
 
+	if err := s.checkHostLunLimit(ctx, arrayID, hostID, nodeID); err != nil {
+		return nil, err
+	}
+
 	log.Debug("Adding host access to ", hostID, " on volume ", volID)
 	err = volumeAPI.ExportVolume(ctx, volID, hostID)
 	if err != nil {
-		return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Export Volume Failed %v", err))
+		return nil, status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Export Volume Failed %v", utils.GetUnityError(err)))
 	}
 	log.Debugf("ControllerPublishVolume successful for volid: [%s]", pinfo["volumeContextId"])
 	return &csi.ControllerPublishVolumeResponse{PublishContext: pinfo}, nil
@@ -1560,7 +2103,7 @@ func (s *service) unexportFilesystem(ctx context.Context, volID, hostID, nodeID,
 				log.Debugf("Filesystem %s not found on the array %s during Controller Unpublish. Hence considering the call to be idempotent", volID, arrayID)
 				return nil
 			}
-			return status.Error(codes.Internal, utils.GetMessageWithRunID(rid, "Find filesystem %s failed with error: %v", volID, err))
+			return status.Error(codes.Internal, utils.GetMessageWithRunID(rid, "Find filesystem %s failed with error: %v", volID, utils.GetUnityError(err)))
 		}
 		isSnapshot = true
 		filesystem, err = s.getFilesystemByResourceID(ctx, snapResp.SnapshotContent.StorageResource.Id, arrayID)
@@ -1604,78 +2147,41 @@ func (s *service) unexportFilesystem(ctx context.Context, volID, hostID, nodeID,
 		return nil
 	}
 
+	unlock := lockNFSShare(nfsShareID)
+	defer unlock()
 	nfsShareResp, err := fileAPI.FindNFSShareById(ctx, nfsShareID)
 	if err != nil {
-		return status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Find NFS Share: %s failed. Error: %v", nfsShareID, err))
-	}
-	readOnlyHosts := nfsShareResp.NFSShareContent.ReadOnlyHosts
-	readWriteHosts := nfsShareResp.NFSShareContent.ReadWriteHosts
-	readOnlyRootHosts := nfsShareResp.NFSShareContent.ReadOnlyRootAccessHosts
-	readWriteRootHosts := nfsShareResp.NFSShareContent.RootAccessHosts
-
-	foundIncompatible := false
-	foundReadOnly := false
-	foundReadWrite := false
-	otherHostsWithAccess := len(readOnlyHosts)
-	var readHostIDList, readWriteHostIDList []string
-	for _, host := range readOnlyHosts {
-		if host.ID == hostID {
-			foundIncompatible = true
-			break
-		}
-	}
-	otherHostsWithAccess += len(readWriteHosts)
-	if !foundIncompatible {
-		for _, host := range readWriteHosts {
-			if host.ID == hostID {
-				foundIncompatible = true
-				break
-			}
-		}
-	}
-	otherHostsWithAccess += len(readOnlyRootHosts)
-	if !foundIncompatible {
-		for _, host := range readOnlyRootHosts {
-			if host.ID == hostID {
-				foundReadOnly = true
-				otherHostsWithAccess--
-			} else {
-				readHostIDList = append(readHostIDList, host.ID)
-			}
-		}
-	}
-	otherHostsWithAccess += len(readWriteRootHosts)
-	if !foundIncompatible {
-		for _, host := range readWriteRootHosts {
-			if host.ID == hostID {
-				foundReadWrite = true
-				otherHostsWithAccess--
-			} else {
-				readWriteHostIDList = append(readWriteHostIDList, host.ID)
+		return status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Find NFS Share: %s failed. Error: %v", nfsShareID, utils.GetUnityError(err)))
+	}
+	//The host is removed from the hosts of the access type it was granted, whatever the access policy of the volume
+	var accessType gounity.AccessType
+	var remainingHostIDs []string
+	otherHostsWithAccess := 0
+	for hostAccessType, ids := range getNFSShareHostIDs(nfsShareResp) {
+		if utils.ArrayContains(ids, hostID) {
+			accessType = hostAccessType
+			for _, id := range ids {
+				if id != hostID {
+					remainingHostIDs = append(remainingHostIDs, id)
+				}
 			}
-		}
-	}
-	if foundIncompatible {
-		return status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Cannot remove host access. Host: %s has access on NFS Share: %s with incompatible access mode.", nodeID, nfsShareID))
-	}
-	if foundReadOnly {
-		if isSnapshot {
-			err = fileAPI.ModifyNFSShareCreatedFromSnapshotHostAccess(ctx, nfsShareID, readHostIDList, gounity.ReadOnlyRootAccessType)
+			otherHostsWithAccess += len(ids) - 1
 		} else {
-			err = fileAPI.ModifyNFSShareHostAccess(ctx, volID, nfsShareID, readHostIDList, gounity.ReadOnlyRootAccessType)
+			otherHostsWithAccess += len(ids)
 		}
-	} else if foundReadWrite {
+	}
+	if accessType != "" {
 		if isSnapshot {
-			err = fileAPI.ModifyNFSShareCreatedFromSnapshotHostAccess(ctx, nfsShareID, readWriteHostIDList, gounity.ReadWriteRootAccessType)
+			err = fileAPI.ModifyNFSShareCreatedFromSnapshotHostAccess(ctx, nfsShareID, remainingHostIDs, accessType)
 		} else {
-			err = fileAPI.ModifyNFSShareHostAccess(ctx, volID, nfsShareID, readWriteHostIDList, gounity.ReadWriteRootAccessType)
+			err = fileAPI.ModifyNFSShareHostAccess(ctx, volID, nfsShareID, remainingHostIDs, accessType)
 		}
 	} else {
 		//Idempotent case
 		log.Infof("Host: %s has no access on NFS Share: %s", nodeID, nfsShareID)
 	}
 	if err != nil {
-		return status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Removing host %s access to NFS Share failed. Error: %v", nodeID, err))
+		return status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Removing host %s access to NFS Share failed. Error: %v", nodeID, utils.GetUnityError(err)))
 	}
 	log.Debugf("Host: %s access is removed from NFS Share: %s", nodeID, nfsShareID)
 
@@ -1690,7 +2196,7 @@ func (s *service) unexportFilesystem(ctx context.Context, volID, hostID, nodeID,
 				err = fileAPI.DeleteNFSShare(ctx, filesystem.FileContent.Id, nfsShareID)
 			}
 			if err != nil {
-				return status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Delete NFS Share: %s Failed with error: %v", nfsShareID, err))
+				return status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Delete NFS Share: %s Failed with error: %v", nfsShareID, utils.GetUnityError(err)))
 			}
 			log.Debugf("NFS Share: %s deleted successfully.", nfsShareID)
 		}
@@ -1699,3 +2205,111 @@ func (s *service) unexportFilesystem(ctx context.Context, volID, hostID, nodeID,
 
 	return nil
 }
+
+//getStoragePoolCapacity returns the free and total capacity of the storage pool. It is a variable so that tests can override it
+var getStoragePoolCapacity = func(ctx context.Context, unity *gounity.Client, storagePool string) (uint64, uint64, error) {
+	pool, err := gounity.NewStoragePool(unity).FindStoragePoolById(ctx, storagePool)
+	if err != nil {
+		return 0, 0, err
+	}
+	return pool.StoragePoolContent.FreeCapacity, pool.StoragePoolContent.TotalCapacity, nil
+}
+
+//parsePoolReservation returns the reserved capacity in bytes of a pool of the given total capacity
+func parsePoolReservation(reservation string, total uint64) (uint64, error) {
+	if strings.HasSuffix(reservation, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(reservation, "%")), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return 0, errors.New(fmt.Sprintf("invalid pool free reservation percentage [%s]", reservation))
+		}
+		return uint64(float64(total) * percent / 100), nil
+	}
+	if bytes, err := strconv.ParseUint(reservation, 10, 64); err == nil {
+		return bytes, nil
+	}
+	bytes, err := utils.ParseSize(reservation)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("invalid pool free reservation [%s]", reservation))
+	}
+	return uint64(bytes), nil
+}
+
+//isPoolAllowed returns true when storage classes may target the storage pool on the array
+func (s *StorageArrayConfig) isPoolAllowed(storagePool string) bool {
+	return len(s.AllowedPools) == 0 || utils.ArrayContains(s.AllowedPools, storagePool)
+}
+
+//requirePoolAllowed rejects the storage pool when it is not in the allowed pools of the array
+func (s *service) requirePoolAllowed(ctx context.Context, arrayID, storagePool string) error {
+	rid, _ := utils.GetRunidAndLogger(ctx)
+	array := s.getStorageArray(arrayID)
+	if array != nil && !array.isPoolAllowed(storagePool) {
+		return status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Storage pool %s is not in the allowed pools %v of array %s", storagePool, array.AllowedPools, arrayID))
+	}
+	return nil
+}
+
+//getCanonicalProtocol returns the protocol matching the given value case-insensitively, or "" when it is not supported
+func getCanonicalProtocol(value string) string {
+	for _, protocol := range []string{FC, ISCSI, NFS} {
+		if strings.EqualFold(strings.TrimSpace(value), protocol) {
+			return protocol
+		}
+	}
+	return ""
+}
+
+//getDefaultProtocol returns the protocol of the volumes whose storage class does not set one
+func (s *service) getDefaultProtocol() string {
+	if s.opts.DefaultProtocol == "" {
+		return FC
+	}
+	return s.opts.DefaultProtocol
+}
+
+//isProtocolSupported returns true when the array supports the protocol
+func (s *StorageArrayConfig) isProtocolSupported(protocol string) bool {
+	if len(s.Protocols) == 0 {
+		return true
+	}
+	for _, supported := range s.Protocols {
+		if strings.EqualFold(strings.TrimSpace(supported), protocol) {
+			return true
+		}
+	}
+	return false
+}
+
+//requireProtocolSupported rejects the protocol when the array does not support it
+func (s *service) requireProtocolSupported(ctx context.Context, arrayID, protocol string) error {
+	rid, _ := utils.GetRunidAndLogger(ctx)
+	array := s.getStorageArray(arrayID)
+	if array != nil && !array.isProtocolSupported(protocol) {
+		return status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Protocol %s is not supported by array %s. Supported protocols are %v", protocol, arrayID, array.Protocols))
+	}
+	return nil
+}
+
+//checkPoolReservation makes sure that creating a volume of the given size keeps the configured free capacity in the storage pool
+func (s *service) checkPoolReservation(ctx context.Context, unity *gounity.Client, storagePool string, size int64) error {
+	rid, log := utils.GetRunidAndLogger(ctx)
+	if s.opts.PoolFreeReservation == "" {
+		return nil
+	}
+
+	free, total, err := getStoragePoolCapacity(ctx, unity, storagePool)
+	if err != nil {
+		return status.Error(codes.Internal, utils.GetMessageWithRunID(rid, "Unable to get capacity of storage pool %s. Error: %v", storagePool, utils.GetUnityError(err)))
+	}
+
+	reserved, err := parsePoolReservation(s.opts.PoolFreeReservation, total)
+	if err != nil {
+		return status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "%v", utils.GetUnityError(err)))
+	}
+
+	log.Debugf("Storage pool %s free: %d total: %d reserved: %d requested: %d", storagePool, free, total, reserved, size)
+	if uint64(size) > free || free-uint64(size) < reserved {
+		return status.Error(codes.ResourceExhausted, utils.GetMessageWithRunID(rid, "Creating volume of size %d bytes would leave less than the reserved %d bytes free in storage pool %s. Free capacity: %d bytes", size, reserved, storagePool, free))
+	}
+	return nil
+}
@@ -2,9 +2,23 @@ package service
 
 import (
 	"context"
+	"errors"
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/dell/csi-unity/service/utils"
+	"github.com/dell/gounity"
+	"github.com/dell/gounity/types"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestControllerProbe(t *testing.T) {
@@ -24,3 +38,783 @@ func TestControllerProbe(t *testing.T) {
 	err = testConf.service.probe(ctx, "controller", "")
 	assert.True(t, err != nil, "probe failed")
 }
+
+func TestCreateVolumeAccessTypeValidation(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	s := &service{arrays: new(sync.Map)}
+	accessMode := &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER}
+
+	//Block access type on NFS protocol
+	_, err := s.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:       "block-on-nfs",
+		Parameters: map[string]string{keyArrayId: "array1", keyProtocol: NFS},
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+			AccessMode: accessMode,
+		}},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), errBlockNFS), "Unexpected error message: %v", err)
+
+	//NFS mount on block protocol
+	_, err = s.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:       "nfs-on-block",
+		Parameters: map[string]string{keyArrayId: "array1", keyProtocol: ISCSI},
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "nfs"}},
+			AccessMode: accessMode,
+		}},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), errNFSMountBlock), "Unexpected error message: %v", err)
+
+	//Compatible access type passes the validation
+	err = validateCreateVolumeAccessType(ctx, &csi.CreateVolumeRequest{
+		Parameters: map[string]string{keyProtocol: FC},
+		VolumeCapabilities: []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}},
+			AccessMode: accessMode,
+		}},
+	})
+	assert.Nil(t, err)
+}
+
+func TestCheckPoolReservation(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	origCapacity := getStoragePoolCapacity
+	defer func() { getStoragePoolCapacity = origCapacity }()
+	//Pool of 100Gi with 30Gi free
+	getStoragePoolCapacity = func(ctx context.Context, unity *gounity.Client, storagePool string) (uint64, uint64, error) {
+		return 30 * 1024 * 1024 * 1024, 100 * 1024 * 1024 * 1024, nil
+	}
+	gib := int64(1024 * 1024 * 1024)
+	s := &service{}
+
+	//No reservation configured
+	assert.Nil(t, s.checkPoolReservation(ctx, nil, "pool_1", 30*gib))
+
+	//Within reservation
+	s.opts.PoolFreeReservation = "10%"
+	assert.Nil(t, s.checkPoolReservation(ctx, nil, "pool_1", 20*gib))
+	s.opts.PoolFreeReservation = "5Gi"
+	assert.Nil(t, s.checkPoolReservation(ctx, nil, "pool_1", 25*gib))
+
+	//Would violate reservation
+	s.opts.PoolFreeReservation = "10%"
+	err := s.checkPoolReservation(ctx, nil, "pool_1", 21*gib)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	s.opts.PoolFreeReservation = "5Gi"
+	err = s.checkPoolReservation(ctx, nil, "pool_1", 26*gib)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	//Invalid reservation
+	s.opts.PoolFreeReservation = "110%"
+	err = s.checkPoolReservation(ctx, nil, "pool_1", gib)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+//headerStream captures the headers set by the RPC handlers
+type headerStream struct {
+	header metadata.MD
+}
+
+func (h *headerStream) Method() string { return "/csi.v1.Controller/ListVolumes" }
+func (h *headerStream) SetHeader(md metadata.MD) error {
+	h.header = metadata.Join(h.header, md)
+	return nil
+}
+func (h *headerStream) SendHeader(md metadata.MD) error { return nil }
+func (h *headerStream) SetTrailer(md metadata.MD) error { return nil }
+
+func TestListVolumesStatus(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	client, err := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	if err != nil {
+		t.Fatalf("Unable to create unity client: %v", err)
+	}
+	s := &service{arrays: new(sync.Map)}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", UnityClient: client})
+	s.arrays.Store("array2", &StorageArrayConfig{ArrayId: "array2", UnityClient: client})
+
+	origAuth, origList := authenticateArray, listArrayVolumes
+	defer func() { authenticateArray, listArrayVolumes = origAuth, origList }()
+	listArrayVolumes = func(ctx context.Context, unity *gounity.Client, startToken, maxEntries int) ([]types.Volume, int, error) {
+		return []types.Volume{}, 0, nil
+	}
+
+	//No arrays reachable: empty and degraded, without error
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		return errors.New("connection refused")
+	}
+	stream := &headerStream{}
+	resp, err := s.ListVolumes(grpc.NewContextWithServerTransportStream(ctx, stream), &csi.ListVolumesRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(resp.Entries))
+	assert.Equal(t, []string{listStatusDegraded}, stream.header.Get(listVolumesStatusHeader))
+
+	//All arrays reachable without volumes: empty and complete
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		return nil
+	}
+	stream = &headerStream{}
+	resp, err = s.ListVolumes(grpc.NewContextWithServerTransportStream(ctx, stream), &csi.ListVolumesRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(resp.Entries))
+	assert.Equal(t, "", resp.NextToken)
+	assert.Equal(t, []string{listStatusComplete}, stream.header.Get(listVolumesStatusHeader))
+}
+
+func TestReconcileRestoredVolume(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	s := &service{arrays: new(sync.Map)}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", RestGateway: "https://127.0.0.1:1"})
+
+	origModify := modifyLunAttributes
+	defer func() { modifyLunAttributes = origModify }()
+	var applied *lunAttributes
+	modifyLunAttributes = func(ctx context.Context, array *StorageArrayConfig, lunId string, attrs lunAttributes) error {
+		assert.Equal(t, "sv_1", lunId)
+		applied = &attrs
+		return nil
+	}
+
+	//Restored volume inherits the source attributes
+	restored := &types.Volume{}
+	restored.VolumeContent.ResourceId = "sv_1"
+	restored.VolumeContent.TieringPolicy = 1
+	restored.VolumeContent.IsDataReductionEnabled = false
+	crParams := &CRParams{TieringPolicy: 2, DataReduction: true}
+
+	err := s.reconcileRestoredVolume(ctx, restored, crParams, "qos_1", "array1")
+	assert.Nil(t, err)
+	if assert.NotNil(t, applied) {
+		assert.Equal(t, int64(2), *applied.TieringPolicy)
+		assert.Equal(t, true, *applied.DataReduction)
+		assert.Equal(t, "qos_1", applied.HostIOLimitId)
+	}
+
+	//Only the differing attributes are applied
+	applied = nil
+	crParams = &CRParams{TieringPolicy: 1, DataReduction: true}
+	err = s.reconcileRestoredVolume(ctx, restored, crParams, "", "array1")
+	assert.Nil(t, err)
+	if assert.NotNil(t, applied) {
+		assert.Nil(t, applied.TieringPolicy)
+		assert.Equal(t, true, *applied.DataReduction)
+	}
+
+	//Nothing is applied when the restored volume matches the request
+	applied = nil
+	crParams = &CRParams{TieringPolicy: 1, DataReduction: false}
+	err = s.reconcileRestoredVolume(ctx, restored, crParams, "", "array1")
+	assert.Nil(t, err)
+	assert.Nil(t, applied)
+
+	//Failure to apply the requested parameters fails the request
+	modifyLunAttributes = func(ctx context.Context, array *StorageArrayConfig, lunId string, attrs lunAttributes) error {
+		return errors.New("modify LUN failed")
+	}
+	err = s.reconcileRestoredVolume(ctx, restored, &CRParams{TieringPolicy: 2}, "", "array1")
+	assert.Equal(t, codes.Unknown, status.Code(err))
+}
+
+func TestCreateSnapshotName(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	s := &service{arrays: new(sync.Map)}
+
+	//Degenerate names are rejected before any call to the array
+	for _, name := range []string{"   ", "snap shot#1"} {
+		_, err := s.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{Name: name, SourceVolumeId: "vol1-FC-array1-sv_1"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "Unexpected error for name [%s]: %v", name, err)
+		assert.True(t, strings.Contains(err.Error(), "invalid snapshot name"), "Unexpected error message: %v", err)
+	}
+
+	//Over-limit names are sanitized and pass the name validation. The request then fails on the unknown array
+	_, err := s.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{Name: "snapshot-" + strings.Repeat("a", 100), SourceVolumeId: "vol1-FC-array1-sv_1"})
+	assert.NotNil(t, err)
+	assert.False(t, strings.Contains(err.Error(), "invalid snapshot name"), "Unexpected error message: %v", err)
+}
+
+func TestCreateSnapshotReadyToUse(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	gib := int64(1024 * 1024 * 1024)
+	s := &service{arrays: new(sync.Map)}
+	snap := &types.Snapshot{}
+	snap.SnapshotContent.Name = "snap1"
+	snap.SnapshotContent.ResourceId = "38654705680"
+	snap.SnapshotContent.Size = 2 * gib
+
+	//Snapshot not yet consistent is not ready to use and reports the size of the source volume
+	snap.SnapshotContent.State = 1
+	resp := getCreateSnapshotResponse(snap, 5*gib, FC, "array1")
+	assert.False(t, resp.Snapshot.ReadyToUse)
+	assert.Equal(t, 5*gib, resp.Snapshot.SizeBytes)
+	assert.Equal(t, "snap1-FC-array1-38654705680", resp.Snapshot.SnapshotId)
+	entries, err := s.getCSISnapshots([]types.Snapshot{*snap}, "vol1-FC-array1-sv_1", FC, "array1")
+	assert.Nil(t, err)
+	assert.False(t, entries[0].Snapshot.ReadyToUse)
+
+	//Once consistent, the snapshot is ready to use and listed as such
+	snap.SnapshotContent.State = snapshotStateReady
+	resp = getCreateSnapshotResponse(snap, 5*gib, FC, "array1")
+	assert.True(t, resp.Snapshot.ReadyToUse)
+	entries, err = s.getCSISnapshots([]types.Snapshot{*snap}, "vol1-FC-array1-sv_1", FC, "array1")
+	assert.Nil(t, err)
+	assert.True(t, entries[0].Snapshot.ReadyToUse)
+
+	//The snapshot size is used when the source cannot be found
+	client, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	assert.Equal(t, 2*gib, s.getSnapshotSourceSize(ctx, client, "sv_1", FC, snap))
+	resp = getCreateSnapshotResponse(snap, 0, FC, "array1")
+	assert.Equal(t, 2*gib, resp.Snapshot.SizeBytes)
+}
+
+func TestGetCapacityTopology(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	gib := uint64(1024 * 1024 * 1024)
+	client1, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	client2, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:2", true)
+	s := &service{arrays: new(sync.Map), opts: Opts{AutoProbe: true}}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", UnityClient: client1})
+	s.arrays.Store("array2", &StorageArrayConfig{ArrayId: "array2", UnityClient: client2})
+
+	origAuth, origCapacity := authenticateArray, getStoragePoolCapacity
+	defer func() { authenticateArray, getStoragePoolCapacity = origAuth, origCapacity }()
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		return nil
+	}
+	getStoragePoolCapacity = func(ctx context.Context, unity *gounity.Client, storagePool string) (uint64, uint64, error) {
+		if unity == client1 {
+			return 10 * gib, 100 * gib, nil
+		}
+		return 20 * gib, 100 * gib, nil
+	}
+
+	//Only the array of the requested topology segment is counted
+	resp, err := s.GetCapacity(ctx, &csi.GetCapacityRequest{
+		Parameters:         map[string]string{keyStoragePool: "pool_1"},
+		AccessibleTopology: &csi.Topology{Segments: map[string]string{Name + "/array2-iscsi": "true"}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(20*gib), resp.AvailableCapacity)
+
+	//Topology segment not matching the array of the storage class has no capacity
+	resp, err = s.GetCapacity(ctx, &csi.GetCapacityRequest{
+		Parameters:         map[string]string{keyStoragePool: "pool_1", keyArrayId: "array1"},
+		AccessibleTopology: &csi.Topology{Segments: map[string]string{Name + "/array2-fc": "true"}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), resp.AvailableCapacity)
+
+	//Without topology the array of the storage class is used, keeping the pool free reservation
+	s.opts.PoolFreeReservation = "5%"
+	resp, err = s.GetCapacity(ctx, &csi.GetCapacityRequest{
+		Parameters: map[string]string{keyStoragePool: "pool_1", keyArrayId: "array1"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5*gib), resp.AvailableCapacity)
+
+	_, err = s.GetCapacity(ctx, &csi.GetCapacityRequest{Parameters: map[string]string{keyStoragePool: "pool_1"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestPoolAllowlist(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	s := &service{arrays: new(sync.Map)}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", AllowedPools: []string{"pool_1", "pool_2"}})
+	s.arrays.Store("array2", &StorageArrayConfig{ArrayId: "array2"})
+
+	//Pool in the allowlist
+	assert.Nil(t, s.requirePoolAllowed(ctx, "array1", "pool_2"))
+
+	//Pool not in the allowlist is rejected naming the pool
+	err := s.requirePoolAllowed(ctx, "array1", "pool_3")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "pool_3"), "Unexpected error message: %v", err)
+
+	//Empty allowlist permits all pools
+	assert.Nil(t, s.requirePoolAllowed(ctx, "array2", "pool_3"))
+}
+
+func TestDefaultProtocol(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	defer os.Unsetenv(EnvDefaultProtocol)
+	s := &service{arrays: new(sync.Map)}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", Protocols: []string{"FC", "NFS"}})
+	s.arrays.Store("array2", &StorageArrayConfig{ArrayId: "array2"})
+
+	//Each protocol can be the default of the volumes whose storage class does not set one. A parameter of another
+	//protocol makes the default visible in the conflict error, the parameters of the caller are left unchanged
+	for _, protocol := range []string{FC, ISCSI, NFS} {
+		os.Setenv(EnvDefaultProtocol, strings.ToLower(protocol))
+		s.opts = getOptsFromEnv(ctx)
+		assert.Equal(t, protocol, s.opts.DefaultProtocol)
+		conflicting := keyNasServer
+		if protocol == NFS {
+			conflicting = keyDisableMultipath
+		}
+		req := &csi.CreateVolumeRequest{
+			Name:               "vol1",
+			Parameters:         map[string]string{keyArrayId: "array2", keyStoragePool: "pool_1", conflicting: "true"},
+			VolumeCapabilities: []*csi.VolumeCapability{{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}}},
+		}
+		_, err := s.CreateVolume(ctx, req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.True(t, strings.Contains(err.Error(), keyProtocol+" is "+protocol), "Unexpected error message: %v", err)
+		assert.Equal(t, map[string]string{keyArrayId: "array2", keyStoragePool: "pool_1", conflicting: "true"}, req.Parameters)
+	}
+
+	//Invalid default falls back to FC
+	os.Setenv(EnvDefaultProtocol, "SMB")
+	assert.Equal(t, FC, getOptsFromEnv(ctx).DefaultProtocol)
+
+	//Protocol not supported by the array is rejected
+	assert.Nil(t, s.requireProtocolSupported(ctx, "array1", FC))
+	assert.Nil(t, s.requireProtocolSupported(ctx, "array1", NFS))
+	err := s.requireProtocolSupported(ctx, "array1", ISCSI)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), ISCSI), "Unexpected error message: %v", err)
+
+	//All protocols are supported when the array does not list them
+	assert.Nil(t, s.requireProtocolSupported(ctx, "array2", ISCSI))
+}
+
+func TestCheckExistingVolumeSize(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	gib := int64(1024 * 1024 * 1024)
+
+	//Strict policy
+	s := &service{opts: Opts{SizeMismatchPolicy: SizeMismatchStrict}}
+	expand, err := s.checkExistingVolumeSize(ctx, "vol1", 5*gib, 5*gib)
+	assert.Nil(t, err)
+	assert.False(t, expand)
+	_, err = s.checkExistingVolumeSize(ctx, "vol1", 5*gib, 8*gib)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = s.checkExistingVolumeSize(ctx, "vol1", 8*gib, 5*gib)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	//Auto expand policy expands smaller volumes only
+	s.opts.SizeMismatchPolicy = SizeMismatchAutoExpand
+	expand, err = s.checkExistingVolumeSize(ctx, "vol1", 5*gib, 8*gib)
+	assert.Nil(t, err)
+	assert.True(t, expand)
+	expand, err = s.checkExistingVolumeSize(ctx, "vol1", 5*gib, 5*gib)
+	assert.Nil(t, err)
+	assert.False(t, expand)
+	_, err = s.checkExistingVolumeSize(ctx, "vol1", 8*gib, 5*gib)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	//Strict is the default policy
+	s = &service{}
+	s.opts = getOptsFromEnv(ctx)
+	assert.Equal(t, SizeMismatchStrict, s.opts.SizeMismatchPolicy)
+	_, err = s.checkExistingVolumeSize(ctx, "vol1", 5*gib, 8*gib)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+}
+
+//unityTestError has the JSON layout of the error body returned by the Unity REST API
+type unityTestError struct {
+	ErrorContent struct {
+		ErrorCode      int    `json:"errorCode"`
+		HTTPStatusCode int    `json:"httpStatusCode"`
+		Message        string `json:"message"`
+	} `json:"error"`
+}
+
+func (e *unityTestError) Error() string {
+	return e.ErrorContent.Message
+}
+
+func newUnityTestError(code int, message string) error {
+	err := &unityTestError{}
+	err.ErrorContent.ErrorCode = code
+	err.ErrorContent.Message = message
+	return err
+}
+
+func newUnauthorizedTestError() error {
+	err := &unityTestError{}
+	err.ErrorContent.ErrorCode = 131149829
+	err.ErrorContent.HTTPStatusCode = http.StatusUnauthorized
+	err.ErrorContent.Message = "Unauthorized"
+	return err
+}
+
+func TestUnityErrorCodeInMappedErrors(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	client, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	origAuth, origCapacity, origModify := authenticateArray, getStoragePoolCapacity, modifyLunAttributes
+	defer func() {
+		authenticateArray, getStoragePoolCapacity, modifyLunAttributes = origAuth, origCapacity, origModify
+	}()
+
+	//Probe failure mapped to FailedPrecondition
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		return newUnityTestError(131149829, "Login failed")
+	}
+	s := &service{arrays: new(sync.Map), opts: Opts{AutoProbe: true}}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", UnityClient: client})
+	err := s.probe(ctx, "Controller", "array1")
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "131149829"), "Unity error code missing: %v", err)
+
+	//Storage pool failure mapped to Internal
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		return nil
+	}
+	getStoragePoolCapacity = func(ctx context.Context, unity *gounity.Client, storagePool string) (uint64, uint64, error) {
+		return 0, 0, newUnityTestError(131149830, "Pool not found")
+	}
+	s.opts.PoolFreeReservation = "10%"
+	err = s.checkPoolReservation(ctx, client, "pool_1", 1024)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "131149830"), "Unity error code missing: %v", err)
+
+	//Modify failure mapped to Unknown
+	modifyLunAttributes = func(ctx context.Context, array *StorageArrayConfig, lunId string, attrs lunAttributes) error {
+		return newUnityTestError(108007744, "Invalid tiering policy")
+	}
+	restored := &types.Volume{}
+	restored.VolumeContent.ResourceId = "sv_1"
+	err = s.reconcileRestoredVolume(ctx, restored, &CRParams{TieringPolicy: 2}, "", "array1")
+	assert.Equal(t, codes.Unknown, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "108007744"), "Unity error code missing: %v", err)
+}
+
+func TestDeleteVolumeCreationGrace(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	client, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	s := &service{arrays: new(sync.Map), opts: Opts{AutoProbe: true, DeleteGracePeriod: 30}}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", UnityClient: client})
+
+	origAuth, origDelete, origSleep := authenticateArray, deleteVolumeResource, retrySleep
+	defer func() {
+		authenticateArray, deleteVolumeResource, retrySleep = origAuth, origDelete, origSleep
+	}()
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		return nil
+	}
+	sleeps := 0
+	retrySleep = func(ctx context.Context, delay time.Duration) error {
+		sleeps++
+		return nil
+	}
+	deletes, foundAfter := 0, 0
+	deleteVolumeResource = func(ctx context.Context, s *service, volID, protocol string, unity *gounity.Client) (error, error, error) {
+		deletes++
+		if deletes <= foundAfter {
+			return gounity.VolumeNotFoundError, nil, nil
+		}
+		return nil, nil, nil
+	}
+
+	//Creation in progress: the volume is deleted once the array reports it
+	finish := s.trackVolumeCreation("csivol-1")
+	foundAfter = 2
+	_, err := s.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "csivol-1-FC-array1-sv_1"})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, deletes)
+	assert.Equal(t, 2, sleeps)
+	finish()
+
+	//Recently created volume still not found after the grace period succeeds
+	deletes, sleeps, foundAfter = 0, 0, 1000
+	retrySleep = func(ctx context.Context, delay time.Duration) error {
+		return errors.New("deadline")
+	}
+	_, err = s.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "csivol-1-FC-array1-sv_1"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, deletes)
+
+	//Truly absent volume succeeds without waiting
+	deletes = 0
+	retrySleep = func(ctx context.Context, delay time.Duration) error {
+		sleeps++
+		return nil
+	}
+	_, err = s.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "csivol-2-FC-array1-sv_2"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, deletes)
+	assert.Equal(t, 0, sleeps)
+
+	//No grace period configured
+	s.opts.DeleteGracePeriod = 0
+	deletes = 0
+	defer s.trackVolumeCreation("csivol-3")()
+	_, err = s.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "csivol-3-FC-array1-sv_3"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, deletes)
+	assert.Equal(t, "csivol-3", getVolumeNameFromVolumeContext("csivol-3-FC-array1-sv_3"))
+	assert.Equal(t, "", getVolumeNameFromVolumeContext("sv_3"))
+}
+
+func TestListVolumesOwnership(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	client, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	s := &service{arrays: new(sync.Map)}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", UnityClient: client})
+
+	origAuth, origList := authenticateArray, listArrayVolumes
+	defer func() { authenticateArray, listArrayVolumes = origAuth, origList }()
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		return nil
+	}
+	listArrayVolumes = func(ctx context.Context, unity *gounity.Client, startToken, maxEntries int) ([]types.Volume, int, error) {
+		owned, foreign, untagged, unprefixed := types.Volume{}, types.Volume{}, types.Volume{}, types.Volume{}
+		owned.VolumeContent.ResourceId = "sv_1"
+		owned.VolumeContent.Description = addOwnerTag("database volume")
+		foreign.VolumeContent.ResourceId = "sv_2"
+		foreign.VolumeContent.Description = "[csi-owner=other.csi.driver]"
+		untagged.VolumeContent.ResourceId = "sv_3"
+		untagged.VolumeContent.Name = "csivol-3"
+		unprefixed.VolumeContent.ResourceId = "sv_4"
+		unprefixed.VolumeContent.Name = "backup-4"
+		owned.VolumeContent.SizeTotal, foreign.VolumeContent.SizeTotal, untagged.VolumeContent.SizeTotal, unprefixed.VolumeContent.SizeTotal = 8192, 8192, 8192, 8192
+		return []types.Volume{owned, foreign, untagged, unprefixed}, 0, nil
+	}
+	listedIds := func() []string {
+		resp, err := s.ListVolumes(grpc.NewContextWithServerTransportStream(ctx, &headerStream{}), &csi.ListVolumesRequest{})
+		assert.Nil(t, err)
+		ids := make([]string, 0)
+		for _, entry := range resp.Entries {
+			ids = append(ids, entry.Volume.VolumeId)
+		}
+		return ids
+	}
+
+	//The volumes of another driver are not listed, the untagged volumes of earlier versions are
+	assert.Equal(t, []string{"sv_1", "sv_3", "sv_4"}, listedIds())
+	assert.True(t, isOwnedVolume(addOwnerTag(addPlacementGroupTag("", "db"))))
+
+	//Untagged volumes are listed only with the volume name prefix of the driver when it is configured
+	os.Setenv(EnvVolumeNamePrefix, "csivol")
+	defer os.Unsetenv(EnvVolumeNamePrefix)
+	s.opts = getOptsFromEnv(ctx)
+	assert.Equal(t, []string{"sv_1", "sv_3"}, listedIds())
+
+	//All volumes are listed for migration
+	os.Setenv(EnvListAllVolumes, "true")
+	defer os.Unsetenv(EnvListAllVolumes)
+	s.opts = getOptsFromEnv(ctx)
+	assert.Equal(t, []string{"sv_1", "sv_2", "sv_3", "sv_4"}, listedIds())
+}
+
+func TestGrantNFSShareHostAccess(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	origModify, origFind := modifyNFSShareHostAccess, findNFSShareHostIDs
+	defer func() { modifyNFSShareHostAccess, findNFSShareHostIDs = origModify, origFind }()
+
+	//Fake NFS share whose host access update replaces the whole host list and fails for the failing host
+	shareHosts := []string{"Host_1"}
+	failingHost := "Host_2"
+	failures := 0
+	modifyNFSShareHostAccess = func(ctx context.Context, unity *gounity.Client, volID, nfsShareID string, isSnapshot bool, hostIDs []string, accessType gounity.AccessType) error {
+		if utils.ArrayContains(hostIDs, failingHost) && failures > 0 {
+			failures--
+			return errors.New("Unable to resolve the host address")
+		}
+		shareHosts = hostIDs
+		return nil
+	}
+	findNFSShareHostIDs = func(ctx context.Context, unity *gounity.Client, nfsShareID string, accessType gounity.AccessType) ([]string, error) {
+		return append([]string{}, shareHosts...), nil
+	}
+	s := &service{arrays: new(sync.Map)}
+
+	//Partial failure returns a retriable error for the failed node and keeps the nodes that already have access
+	failures = 1
+	err := s.grantNFSShareHostAccess(ctx, nil, "fs_1", "NFSShare_1", "Host_2", "node2", false, []string{"Host_1"}, gounity.ReadWriteRootAccessType)
+	assert.NotNil(t, err)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "Allocating host node2 access to NFS Share NFSShare_1 failed"), "Unexpected error message: %v", err)
+	assert.Equal(t, []string{"Host_1"}, shareHosts)
+
+	//Retry of the request with the hosts read from the share converges
+	err = s.grantNFSShareHostAccess(ctx, nil, "fs_1", "NFSShare_1", "Host_2", "node2", false, shareHosts, gounity.ReadWriteRootAccessType)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Host_1", "Host_2"}, shareHosts)
+
+	//Retry with the same hosts is idempotent
+	err = s.grantNFSShareHostAccess(ctx, nil, "fs_1", "NFSShare_1", "Host_2", "node2", false, []string{"Host_1"}, gounity.ReadWriteRootAccessType)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Host_1", "Host_2"}, shareHosts)
+
+	//Configured retries re-read the hosts of the share and converge within the request
+	shareHosts = []string{"Host_1", "Host_3"}
+	failures = 1
+	s.opts.NFSHostAccessRetries = 1
+	err = s.grantNFSShareHostAccess(ctx, nil, "fs_1", "NFSShare_1", "Host_2", "node2", false, []string{"Host_1"}, gounity.ReadWriteRootAccessType)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Host_1", "Host_3", "Host_2"}, shareHosts)
+
+	//Update applied despite reporting an error succeeds
+	modifyNFSShareHostAccess = func(ctx context.Context, unity *gounity.Client, volID, nfsShareID string, isSnapshot bool, hostIDs []string, accessType gounity.AccessType) error {
+		shareHosts = hostIDs
+		return errors.New("Request timed out")
+	}
+	s.opts.NFSHostAccessRetries = 0
+	err = s.grantNFSShareHostAccess(ctx, nil, "fs_1", "NFSShare_1", "Host_4", "node4", false, shareHosts, gounity.ReadWriteRootAccessType)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Host_1", "Host_3", "Host_2", "Host_4"}, shareHosts)
+}
+
+func TestCreateSnapshotSourceArrayReachability(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	client, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	s := &service{arrays: new(sync.Map), opts: Opts{AutoProbe: true}}
+	array := &StorageArrayConfig{ArrayId: "array1", RestGateway: "https://127.0.0.1:1", UnityClient: client, IsProbeSuccess: true}
+	s.arrays.Store("array1", array)
+
+	origAuth, origReachable := authenticateArray, isRestGatewayReachable
+	defer func() { authenticateArray, isRestGatewayReachable = origAuth, origReachable }()
+	logins := 0
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		logins++
+		return nil
+	}
+	reachable := false
+	isRestGatewayReachable = func(ctx context.Context, array *StorageArrayConfig) bool {
+		return reachable
+	}
+	req := &csi.CreateSnapshotRequest{Name: "snap1", SourceVolumeId: "vol1-FC-array1-sv_1"}
+
+	//Source array down fails fast without logging in
+	_, err := s.CreateSnapshot(ctx, req)
+	assert.NotNil(t, err)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "Source array array1 is unreachable on RestGateway https://127.0.0.1:1"), "Unexpected error message: %v", err)
+	assert.False(t, array.IsProbeSuccess)
+	assert.Equal(t, 0, logins)
+
+	//Source array up proceeds to the probe and the snapshot creation
+	reachable = true
+	_, err = s.CreateSnapshot(ctx, req)
+	assert.Equal(t, 1, logins)
+	assert.True(t, array.IsProbeSuccess)
+	if err != nil {
+		assert.False(t, strings.Contains(err.Error(), "is unreachable"), "Unexpected error message: %v", err)
+	}
+}
+
+func TestHostLunLimit(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+
+	//Host LUNs are counted with the Unity REST API
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, `host.id eq "Host_1"`, r.URL.Query().Get("filter"))
+		w.Write([]byte(`{"entryCount": 2, "entries": [{"content": {"id": "Host_1_sv_1"}}, {"content": {"id": "Host_1_sv_2"}}]}`))
+	}))
+	defer server.Close()
+	count, err := getHostLunCount(ctx, &StorageArrayConfig{ArrayId: "array1", RestGateway: server.URL}, "Host_1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	origCount := getHostLunCount
+	defer func() { getHostLunCount = origCount }()
+	count = 0
+	getHostLunCount = func(ctx context.Context, array *StorageArrayConfig, hostID string) (int, error) {
+		return count, nil
+	}
+	s := &service{arrays: new(sync.Map), opts: Opts{MaxHostLuns: 3}}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1"})
+
+	//Host under the limit is mapped
+	count = 2
+	assert.Nil(t, s.checkHostLunLimit(ctx, "array1", "Host_1", "node1"))
+
+	//Host at the limit is rejected
+	count = 3
+	err = s.checkHostLunLimit(ctx, "array1", "Host_1", "node1")
+	assert.NotNil(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "Host node1 already has 3 LUNs mapped on array array1, the maximum allowed is 3"), "Unexpected error message: %v", err)
+
+	//Check is disabled without a limit
+	s.opts.MaxHostLuns = 0
+	assert.Nil(t, s.checkHostLunLimit(ctx, "array1", "Host_1", "node1"))
+
+	//Volume is mapped when the LUNs cannot be counted
+	s.opts.MaxHostLuns = 3
+	getHostLunCount = func(ctx context.Context, array *StorageArrayConfig, hostID string) (int, error) {
+		return 0, errors.New("connection refused")
+	}
+	assert.Nil(t, s.checkHostLunLimit(ctx, "array1", "Host_1", "node1"))
+}
+
+func TestNFSAccessPolicy(t *testing.T) {
+	readWrite := &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER}
+	readOnly := &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY}
+
+	//Hosts get root access by default
+	policy, err := getNFSAccessPolicy("")
+	assert.Nil(t, err)
+	assert.Equal(t, NFSAccessRoot, policy)
+	assert.Equal(t, gounity.ReadWriteRootAccessType, getNFSHostAccessType(policy, readWrite))
+	assert.Equal(t, gounity.ReadOnlyRootAccessType, getNFSHostAccessType(policy, readOnly))
+
+	//Root squash maps the root user of the hosts to an anonymous user
+	policy, err = getNFSAccessPolicy(" RootSquash ")
+	assert.Nil(t, err)
+	assert.Equal(t, NFSAccessRootSquash, policy)
+	assert.Equal(t, gounity.ReadWriteAccessType, getNFSHostAccessType(policy, readWrite))
+	assert.Equal(t, gounity.ReadOnlyAccessType, getNFSHostAccessType(policy, readOnly))
+
+	//Read-only grants read-only access whatever the access mode
+	policy, err = getNFSAccessPolicy("readOnly")
+	assert.Nil(t, err)
+	assert.Equal(t, gounity.ReadOnlyAccessType, getNFSHostAccessType(policy, readWrite))
+	assert.Equal(t, gounity.ReadOnlyAccessType, getNFSHostAccessType(policy, readOnly))
+
+	policy, err = getNFSAccessPolicy("root")
+	assert.Nil(t, err)
+	assert.Equal(t, gounity.ReadWriteRootAccessType, getNFSHostAccessType(policy, readWrite))
+
+	//Unsupported values are rejected
+	_, err = getNFSAccessPolicy("noRootSquash")
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "invalid value noRootSquash for accessPolicy"), "Unexpected error message: %v", err)
+
+	//The policy is passed in the volume context
+	resp := &csi.CreateVolumeResponse{Volume: &csi.Volume{VolumeContext: map[string]string{}}}
+	setNFSAccessPolicyContext(resp, map[string]string{keyAccessPolicy: "rootSquash"})
+	assert.Equal(t, "rootSquash", resp.Volume.VolumeContext[keyAccessPolicy])
+}
+
+func TestListVolumesIncompleteRecords(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	client, err := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	if err != nil {
+		t.Fatalf("Unable to create unity client: %v", err)
+	}
+	s := &service{arrays: new(sync.Map), opts: Opts{ListAllVolumes: true}}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", UnityClient: client})
+
+	origAuth, origList := authenticateArray, listArrayVolumes
+	defer func() { authenticateArray, listArrayVolumes = origAuth, origList }()
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		return nil
+	}
+	listArrayVolumes = func(ctx context.Context, unity *gounity.Client, startToken, maxEntries int) ([]types.Volume, int, error) {
+		complete, sizeless, idless := types.Volume{}, types.Volume{}, types.Volume{}
+		complete.VolumeContent.ResourceId = "sv_1"
+		complete.VolumeContent.SizeTotal = 5 * 1024 * 1024 * 1024
+		sizeless.VolumeContent.ResourceId = "sv_2"
+		idless.VolumeContent.Name = "vol3"
+		idless.VolumeContent.SizeTotal = 8192
+		return []types.Volume{sizeless, complete, idless}, 0, nil
+	}
+
+	//Incomplete records are skipped, complete records are still returned
+	resp, err := s.ListVolumes(grpc.NewContextWithServerTransportStream(ctx, &headerStream{}), &csi.ListVolumesRequest{})
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(resp.Entries)) {
+		assert.Equal(t, "sv_1", resp.Entries[0].Volume.VolumeId)
+		assert.Equal(t, int64(5*1024*1024*1024), resp.Entries[0].Volume.CapacityBytes)
+	}
+
+	//The fail policy fails the listing
+	s.opts.IncompleteVolumePolicy = IncompleteVolumeFail
+	_, err = s.ListVolumes(grpc.NewContextWithServerTransportStream(ctx, &headerStream{}), &csi.ListVolumesRequest{})
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "sv_2 is missing size"), "Unexpected error message: %v", err)
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dell/csi-unity/service/utils"
+	"github.com/dell/gounity"
+)
+
+//deleteGraceRetryInterval is the interval between the retries of the deletion of a volume not found on the array while
+//its creation may still be in progress
+const deleteGraceRetryInterval = 2 * time.Second
+
+//volumeCreations tracks the volumes being created by the controller and the time their creation completed, so that
+//DeleteVolume can tell a volume whose creation is still in progress from a volume that never existed
+type volumeCreations struct {
+	mutex      sync.Mutex
+	inProgress map[string]int
+	completed  map[string]time.Time
+}
+
+//start records the start of the creation of the volume and returns the function recording its completion. Creations
+//completed more than the grace period ago are forgotten
+func (c *volumeCreations) start(name string, grace time.Duration) func() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.inProgress == nil {
+		c.inProgress = make(map[string]int)
+		c.completed = make(map[string]time.Time)
+	}
+	for volume, completed := range c.completed {
+		if time.Since(completed) > grace {
+			delete(c.completed, volume)
+		}
+	}
+	c.inProgress[name]++
+	return func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.inProgress[name]--
+		if c.inProgress[name] <= 0 {
+			delete(c.inProgress, name)
+		}
+		c.completed[name] = time.Now()
+	}
+}
+
+//isRecent returns true when the creation of the volume is in progress or completed less than the grace period ago
+func (c *volumeCreations) isRecent(name string, grace time.Duration) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.inProgress[name] > 0 {
+		return true
+	}
+	completed, ok := c.completed[name]
+	return ok && time.Since(completed) <= grace
+}
+
+//trackVolumeCreation records the creation of the volume when a deletion grace period is configured, and returns the
+//function recording its completion
+func (s *service) trackVolumeCreation(name string) func() {
+	if s.opts.DeleteGracePeriod <= 0 {
+		return func() {}
+	}
+	return s.volumeCreations.start(name, time.Duration(s.opts.DeleteGracePeriod)*time.Second)
+}
+
+//getVolumeNameFromVolumeContext returns the name of the volume of the name-protocol-arrayId-resourceId volume id, or ""
+//for the volume ids of csi-unity v1.0 and v1.1 that do not include it
+func getVolumeNameFromVolumeContext(contextVolId string) string {
+	tokens := strings.Split(contextVolId, "-")
+	if len(tokens) < 4 {
+		return ""
+	}
+	return strings.Join(tokens[:len(tokens)-3], "-")
+}
+
+//isVolumeNotFound returns true when the deletion of the volume reported that it does not exist on the array
+func isVolumeNotFound(err, snapErr error) bool {
+	return err == gounity.FilesystemNotFoundError || err == gounity.VolumeNotFoundError || snapErr == gounity.SnapshotNotFoundError
+}
+
+//deleteVolumeWithGrace deletes the volume from the array. A volume not found while its creation by the controller is in
+//progress or recently completed is deleted again until the deletion grace period expires, as the array may not report
+//it yet. A volume the controller did not create recently is reported not found straight away
+func (s *service) deleteVolumeWithGrace(ctx context.Context, volumeContextID, volID, protocol string, unity *gounity.Client) (error, error, error) {
+	log := utils.GetRunidLogger(ctx)
+	err, snapErr, throwErr := deleteVolumeResource(ctx, s, volID, protocol, unity)
+	if throwErr != nil || !isVolumeNotFound(err, snapErr) || s.opts.DeleteGracePeriod <= 0 {
+		return err, snapErr, throwErr
+	}
+	grace := time.Duration(s.opts.DeleteGracePeriod) * time.Second
+	name := getVolumeNameFromVolumeContext(volumeContextID)
+	if name == "" || !s.volumeCreations.isRecent(name, grace) {
+		log.Debugf("Volume %s was not created recently by the controller. Considering it never existed", volumeContextID)
+		return err, snapErr, throwErr
+	}
+
+	deadline := time.Now().Add(grace)
+	for time.Now().Before(deadline) {
+		log.Infof("Volume %s not found while its creation may be in progress. Retrying the deletion in %v", volumeContextID, deleteGraceRetryInterval)
+		if retrySleep(ctx, deleteGraceRetryInterval) != nil {
+			break
+		}
+		err, snapErr, throwErr = deleteVolumeResource(ctx, s, volID, protocol, unity)
+		if throwErr != nil || !isVolumeNotFound(err, snapErr) {
+			return err, snapErr, throwErr
+		}
+	}
+	log.Infof("Volume %s still not found after the deletion grace period of %v", volumeContextID, grace)
+	return err, snapErr, throwErr
+}
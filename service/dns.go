@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+
+	"github.com/dell/csi-unity/service/utils"
+)
+
+//lookupHost resolves the host name to its addresses. It is a variable so that tests can override it
+var lookupHost = func(ctx context.Context, host string) ([]string, error) {
+	return net.DefaultResolver.LookupHost(ctx, host)
+}
+
+//restGatewayDNSFallback enables the use of the last resolved address of a RestGateway host when its resolution fails
+var restGatewayDNSFallback = false
+
+//resolvedGateways caches the last resolved address of the RestGateway hosts
+var resolvedGateways sync.Map
+
+//resolveRestGateway resolves the RestGateway host of the array before logging in, so that resolution failures are
+//reported explicitly rather than as a generic login failure. When the fallback is enabled, the array is reached on the
+//last resolved address of the host during a DNS outage, and on the host again once it resolves
+func resolveRestGateway(ctx context.Context, array *StorageArrayConfig) error {
+	log := utils.GetRunidLogger(ctx)
+	gatewayURL, err := url.Parse(array.RestGateway)
+	if err != nil || gatewayURL.Hostname() == "" {
+		//Invalid gateways are reported by the login
+		return nil
+	}
+	host := gatewayURL.Hostname()
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+
+	addresses, err := lookupHost(ctx, host)
+	if err == nil && len(addresses) > 0 {
+		resolvedGateways.Store(host, addresses[0])
+		if array.dnsFallbackActive {
+			client, err := newArrayUnityClient(ctx, array, array.RestGateway)
+			if err != nil {
+				log.Warnf("Unable to create Unity client for RestGateway %s of array %s. Error: %v", displayRestGateway(array.RestGateway, array.maskRestGateway), array.ArrayId, err)
+				return nil
+			}
+			log.Infof("RestGateway host %s of array %s resolves again. No longer using its last resolved address", displayGatewayHost(host, array.maskRestGateway), array.ArrayId)
+			array.UnityClient = client
+			array.dnsFallbackActive = false
+		}
+		return nil
+	}
+	if err == nil {
+		err = errors.New("no address found")
+	}
+
+	if cached, ok := resolvedGateways.Load(host); ok && restGatewayDNSFallback {
+		address := cached.(string)
+		if !array.dnsFallbackActive {
+			fallbackURL := *gatewayURL
+			if port := gatewayURL.Port(); port != "" {
+				fallbackURL.Host = net.JoinHostPort(address, port)
+			} else if net.ParseIP(address).To4() == nil {
+				fallbackURL.Host = "[" + address + "]"
+			} else {
+				fallbackURL.Host = address
+			}
+			client, clientErr := newArrayUnityClient(ctx, array, fallbackURL.String())
+			if clientErr != nil {
+				return fmt.Errorf("cannot resolve RestGateway host %s and unable to use its last resolved address %s. Error: %v", displayGatewayHost(host, array.maskRestGateway), displayGatewayHost(address, array.maskRestGateway), clientErr)
+			}
+			array.UnityClient = client
+			array.dnsFallbackActive = true
+		}
+		log.Warnf("Cannot resolve RestGateway host %s of array %s. Using its last resolved address %s. Error: %v", displayGatewayHost(host, array.maskRestGateway), array.ArrayId, displayGatewayHost(address, array.maskRestGateway), err)
+		return nil
+	}
+	return fmt.Errorf("cannot resolve RestGateway host %s. Verify the DNS configuration of the node or use the IP address of the array. Error: %v", displayGatewayHost(host, array.maskRestGateway), err)
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/dell/gounity"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestRestGatewayDNSFailure(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	origLookup, origAuth := lookupHost, authenticateArray
+	defer func() { lookupHost, authenticateArray = origLookup, origAuth }()
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return nil, errors.New("no such host")
+	}
+	logins := 0
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		logins++
+		return nil
+	}
+
+	client, _ := gounity.NewClientWithArgs(ctx, "https://unity-dns-failure.example.com", true)
+	array := &StorageArrayConfig{ArrayId: "array1", RestGateway: "https://unity-dns-failure.example.com", Insecure: true, UnityClient: client}
+	err := singleArrayProbe(ctx, "Test", array)
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "cannot resolve RestGateway host unity-dns-failure.example.com"), "Unexpected error message: %v", err)
+	assert.Equal(t, 0, logins, "Login attempted although the RestGateway host does not resolve")
+	assert.False(t, array.IsProbeSuccess)
+
+	//IP addresses are not resolved
+	client, _ = gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	array = &StorageArrayConfig{ArrayId: "array1", RestGateway: "https://127.0.0.1:1", Insecure: true, UnityClient: client}
+	assert.Nil(t, singleArrayProbe(ctx, "Test", array))
+	assert.Equal(t, 1, logins)
+}
+
+func TestRestGatewayDNSCachedFallback(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	origLookup, origAuth, origClient, origFallback := lookupHost, authenticateArray, newUnityClient, restGatewayDNSFallback
+	defer func() {
+		lookupHost, authenticateArray, newUnityClient, restGatewayDNSFallback = origLookup, origAuth, origClient, origFallback
+	}()
+	dnsUp := true
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		if dnsUp {
+			return []string{"10.0.0.5"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		return nil
+	}
+	endpoints := make([]string, 0)
+	newUnityClient = func(ctx context.Context, endpoint string, insecure bool) (*gounity.Client, error) {
+		endpoints = append(endpoints, endpoint)
+		return gounity.NewClientWithArgs(ctx, endpoint, insecure)
+	}
+	restGatewayDNSFallback = true
+
+	client, _ := gounity.NewClientWithArgs(ctx, "https://unity-fallback.example.com:8443", true)
+	array := &StorageArrayConfig{ArrayId: "array1", RestGateway: "https://unity-fallback.example.com:8443", Insecure: true, UnityClient: client}
+
+	//Successful resolution caches the address
+	assert.Nil(t, singleArrayProbe(ctx, "Test", array))
+	assert.Equal(t, 0, len(endpoints))
+
+	//During a DNS outage the last resolved address is used
+	dnsUp = false
+	assert.Nil(t, singleArrayProbe(ctx, "Test", array))
+	assert.Equal(t, []string{"https://10.0.0.5:8443"}, endpoints)
+	assert.True(t, array.dnsFallbackActive)
+
+	//The host is used again once it resolves
+	dnsUp = true
+	assert.Nil(t, singleArrayProbe(ctx, "Test", array))
+	assert.Equal(t, []string{"https://10.0.0.5:8443", "https://unity-fallback.example.com:8443"}, endpoints)
+	assert.False(t, array.dnsFallbackActive)
+
+	//Without the fallback the DNS failure is reported
+	dnsUp = false
+	restGatewayDNSFallback = false
+	err := singleArrayProbe(ctx, "Test", array)
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "cannot resolve RestGateway host unity-fallback.example.com"), "Unexpected error message: %v", err)
+}
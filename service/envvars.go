@@ -27,6 +27,232 @@ const (
 	// by technical support.
 	EnvISCSIChroot = "X_CSI_ISCSI_CHROOT"
 
+	//EnvStagingPathTemplate is the template for the per-volume staging subdirectory created under the
+	//staging target path. Supported placeholders are {volumeId}, {arrayId} and {protocol}. Templates resolving outside
+	//the staging target path are rejected
+	EnvStagingPathTemplate = "X_CSI_UNITY_STAGING_PATH_TEMPLATE"
+
+	//EnvHealthAddress is the address (e.g. ":9090") on which the health, readiness, metrics and arrays endpoints are
+	//served. The endpoints are disabled when it is not set
+	EnvHealthAddress = "X_CSI_UNITY_HEALTH_ADDRESS"
+
+	//EnvAdminAddress is the loopback address (e.g. "127.0.0.1:9091") on which the array maintenance admin endpoint is
+	//served. The endpoint is disabled when it is not set, and is not served on an address other than a loopback address
+	EnvAdminAddress = "X_CSI_UNITY_ADMIN_ADDRESS"
+
+	//EnvPoolFreeReservation is the free capacity that must remain in a storage pool after a volume is created.
+	//It is either a percentage of the pool size (e.g. "10%") or an absolute size (e.g. "100Gi")
+	EnvPoolFreeReservation = "X_CSI_UNITY_POOL_FREE_RESERVATION"
+
+	//EnvIdempotencyKeyHeader is the gRPC metadata header carrying the idempotency key of an operation.
+	//Default is "idempotency-key"
+	EnvIdempotencyKeyHeader = "X_CSI_UNITY_IDEMPOTENCY_KEY_HEADER"
+
+	//EnvEmptyConfigPolicy selects what happens when a reload of the driver config yields no valid arrays.
+	//"keep-last-good" (default) keeps the previously loaded arrays and "accept-empty" removes all arrays
+	EnvEmptyConfigPolicy = "X_CSI_UNITY_EMPTY_CONFIG_POLICY"
+
+	//EnvStagingStateDir is the node directory where the staging state of the volumes is recorded.
+	//Defaults to the .staging-state directory under X_CSI_PRIVATE_MOUNT_DIR
+	EnvStagingStateDir = "X_CSI_UNITY_STAGING_STATE_DIR"
+
+	//EnvLightweightProbe enables the identity Probe to check the arrays with an unauthenticated basicSystemInfo
+	//query instead of a login. Operations still login to the arrays when needed
+	EnvLightweightProbe = "X_CSI_UNITY_LIGHTWEIGHT_PROBE"
+
+	//EnvArrayIdCaseSensitive makes the array ids of the driver config case sensitive. By default array ids
+	//are compared case-insensitively
+	EnvArrayIdCaseSensitive = "X_CSI_UNITY_ARRAYID_CASE_SENSITIVE"
+
+	//EnvForceDisconnect enables the forceful removal of a device in NodeUnstageVolume when the graceful
+	//disconnect fails. Pending I/O on the device is lost, so enable it only when instructed by technical support
+	EnvForceDisconnect = "X_CSI_UNITY_FORCE_DISCONNECT"
+
+	//EnvRestMaxRetries is the maximum number of retries of a Unity REST call that failed with 429 Too Many Requests
+	//or 503 Service Unavailable. Default is 3, 0 disables the retries
+	EnvRestMaxRetries = "X_CSI_UNITY_REST_MAX_RETRIES"
+
+	//EnvArrayConfigJSON provides the storageArrayList driver config as a JSON string. When set, it is used instead
+	//of the driver config file and the file is not watched for changes
+	EnvArrayConfigJSON = "X_CSI_UNITY_ARRAY_CONFIG_JSON"
+
+	//EnvProbeFailureThreshold is the number of consecutive probes of all the arrays that must fail before the driver
+	//reports itself not ready on the readiness endpoint. Default is 1
+	EnvProbeFailureThreshold = "X_CSI_UNITY_PROBE_FAILURE_THRESHOLD"
+
+	//EnvSizeMismatchPolicy is the policy applied by CreateVolume when a volume with the requested name exists and is smaller
+	//than requested. "strict-alreadyexists" (default) returns AlreadyExists, "auto-expand" expands the volume to the requested size
+	EnvSizeMismatchPolicy = "X_CSI_UNITY_SIZE_MISMATCH_POLICY"
+
+	//EnvInitiatorRefreshInterval is the interval in minutes at which the node initiators are re-discovered. When they
+	//change, the host on the arrays is updated. Default 0 disables the re-discovery
+	EnvInitiatorRefreshInterval = "X_CSI_UNITY_INITIATOR_REFRESH_INTERVAL"
+
+	//EnvMinRequestDeadline is the minimum remaining deadline in seconds of a NodeStageVolume or NodePublishVolume request.
+	//Requests with a shorter deadline are rejected with DeadlineExceeded before starting any work. Default 0 disables the check
+	EnvMinRequestDeadline = "X_CSI_UNITY_MIN_REQUEST_DEADLINE"
+
+	//EnvDefaultProtocol is the protocol of the volumes created from storage classes that do not set the protocol
+	//parameter. Possible values are FC, iSCSI and NFS. Default is FC
+	EnvDefaultProtocol = "X_CSI_UNITY_DEFAULT_PROTOCOL"
+
+	//EnvRestGatewayDNSFallback enables the use of the last resolved address of a RestGateway host name when its DNS
+	//resolution fails, so that brief DNS outages do not fail the login. Default is false
+	EnvRestGatewayDNSFallback = "X_CSI_UNITY_RESTGATEWAY_DNS_FALLBACK"
+
+	//EnvMaxConcurrentCreateVolume is the maximum number of CreateVolume requests processed concurrently across all arrays.
+	//Excess requests wait for a slot until their deadline. Default 0 does not cap the requests
+	EnvMaxConcurrentCreateVolume = "X_CSI_UNITY_MAX_CONCURRENT_CREATE_VOLUME"
+
+	//EnvListAllVolumes includes the volumes not created by the driver in ListVolumes and in the reconciliation of the
+	//volumes, for migration scenarios. By default the volumes bearing the ownership tag of another driver are excluded
+	EnvListAllVolumes = "X_CSI_UNITY_LIST_ALL_VOLUMES"
+
+	//EnvVolumeNamePrefix is the volume name prefix given to the external provisioner. When set, the volumes bearing no
+	//ownership tag are included in ListVolumes only when their name starts with the prefix
+	EnvVolumeNamePrefix = "X_CSI_UNITY_VOLUME_NAME_PREFIX"
+
+	//EnvWarmupTimeout is the timeout in seconds of the probe of all arrays during startup, so that the driver starts with
+	//logged in arrays. Default 0 disables the warmup and the arrays are probed on their first request
+	EnvWarmupTimeout = "X_CSI_UNITY_WARMUP_TIMEOUT"
+
+	//EnvInitiatorWaitTimeout is the timeout in seconds that NodeGetInfo waits for the FC or iSCSI initiators of the node
+	//to be discoverable. Default 0 waits once for the discovery and does not require initiators
+	EnvInitiatorWaitTimeout = "X_CSI_UNITY_INITIATOR_WAIT_TIMEOUT"
+
+	//EnvNFSHostAccessRetries is the number of times ControllerPublishVolume retries a failed update of the host access
+	//of an NFS share before returning a retriable error. Default 0
+	EnvNFSHostAccessRetries = "X_CSI_UNITY_NFS_HOST_ACCESS_RETRIES"
+
+	//EnvKeepCloneSnapshots when true keeps the intermediate snapshots left on the source volume by a failed clone, for
+	//troubleshooting. By default they are deleted so that retries start clean
+	EnvKeepCloneSnapshots = "X_CSI_UNITY_KEEP_CLONE_SNAPSHOTS"
+
+	//EnvTracePayloads when true logs the request and response of each CSI method at trace level, with the secrets
+	//redacted, for deep debugging. The payloads are logged only while the log level is trace. Default false
+	EnvTracePayloads = "X_CSI_UNITY_TRACE_PAYLOADS"
+
+	//EnvMaxHostLuns is the maximum number of LUNs and snapshots mapped to a host. ControllerPublishVolume does not map a
+	//volume to a host that reached it. Default 0 disables the check
+	EnvMaxHostLuns = "X_CSI_UNITY_MAX_HOST_LUNS"
+
+	//EnvDisableVolumeLocking when true disables the serialization of the CreateVolume requests with the same name and
+	//of the DeleteVolume requests with the same volume id. Default false
+	EnvDisableVolumeLocking = "X_CSI_UNITY_DISABLE_VOLUME_LOCKING"
+
+	//EnvProbeOnDemand when true makes the identity Probe probe all the arrays and report the driver ready only when at
+	//least one array is reachable, so that liveness checks drive the reconnection of the arrays. Default false
+	EnvProbeOnDemand = "X_CSI_UNITY_PROBE_ON_DEMAND"
+
+	//EnvProbeArrayStatus when true makes the identity Probe probe all the arrays, as with EnvProbeOnDemand, and log the
+	//status of each array. Ready reflects whether at least one array is reachable. The status of each array is served on
+	//the arrays health endpoint whether enabled or not. Default false
+	EnvProbeArrayStatus = "X_CSI_UNITY_PROBE_ARRAY_STATUS"
+
+	//EnvDeleteGracePeriod is the time in seconds during which DeleteVolume retries the deletion of a volume not found on
+	//the array while its creation by the controller is in progress or recently completed. Default 0 considers volumes not
+	//found deleted straight away
+	EnvDeleteGracePeriod = "X_CSI_UNITY_DELETE_GRACE_PERIOD"
+
+	//EnvDebugLogSamplingRate logs one in the given number of debug and trace lines, so that the logs of mass operations
+	//do not overwhelm the log pipeline. Errors, warnings and info lines are always logged. Default 1 logs all the lines
+	EnvDebugLogSamplingRate = "X_CSI_UNITY_DEBUG_LOG_SAMPLING_RATE"
+
+	//EnvJobTimeout is the time in seconds to wait for the Unity jobs expanding a block volume or creating a block volume
+	//from a snapshot to complete. Default 300
+	EnvJobTimeout = "X_CSI_UNITY_JOB_TIMEOUT"
+
+	//EnvFCZoningPolicy is the policy applied by NodeStageVolume when the node has no FC paths to the array, e.g. because
+	//of a missing SAN zoning. "fail" rejects the request with an actionable error and "warn" only logs it. Default "fail"
+	EnvFCZoningPolicy = "X_CSI_UNITY_FC_ZONING_POLICY"
+
+	//EnvStateDumpOnSignal when true logs the state of the arrays, the requests in progress and a summary of the goroutines
+	//on SIGUSR1, for field debugging. Default false
+	EnvStateDumpOnSignal = "X_CSI_UNITY_STATE_DUMP_ON_SIGNAL"
+
+	//EnvDuplicateSerialPolicy is the policy applied after a probe when two configured arrays report the same serial
+	//number, i.e. their RestGateways point at the same physical array. "warn" logs it and "fail" fails the probe of
+	//these arrays. The serial numbers are not checked by default
+	EnvDuplicateSerialPolicy = "X_CSI_UNITY_DUPLICATE_SERIAL_POLICY"
+
+	//EnvKeepAliveInterval is the interval in seconds at which a lightweight call is issued to each logged in array, so
+	//that the connections to idle arrays are not dropped by intermediaries. Arrays in maintenance are skipped. Keepalive
+	//is disabled when 0, the default
+	EnvKeepAliveInterval = "X_CSI_UNITY_KEEPALIVE_INTERVAL"
+
+	//EnvMaxConfigSize is the maximum size of the driver config file, in bytes or with a Mi unit. A larger file is
+	//rejected before it is parsed and the last known good config is kept. Default 1Mi
+	EnvMaxConfigSize = "X_CSI_UNITY_MAX_CONFIG_SIZE"
+
+	//EnvFCMountOptions, EnvISCSIMountOptions and EnvNFSMountOptions are the comma separated default mount options of
+	//the filesystem volumes of each protocol, e.g. hard,timeo=600 for NFS. They are merged with the mount flags of the
+	//volume in NodeStageVolume, the mount flags of the volume taking precedence on conflict. No defaults by default
+	EnvFCMountOptions    = "X_CSI_UNITY_FC_MOUNT_OPTIONS"
+	EnvISCSIMountOptions = "X_CSI_UNITY_ISCSI_MOUNT_OPTIONS"
+	EnvNFSMountOptions   = "X_CSI_UNITY_NFS_MOUNT_OPTIONS"
+
+	//EnvArrayIdFormat is the format of the array ids of the driver config, checked when the config is loaded so that a
+	//mistyped array id is rejected by its index rather than failing at probe time. unity expects the serial number of
+	//a Unity or UnityVSA array, e.g. APM00123456789, any other value is a regular expression. Not checked by default
+	EnvArrayIdFormat = "X_CSI_UNITY_ARRAY_ID_FORMAT"
+
+	//EnvISCSIScanConcurrency is the maximum number of iSCSI portals checked for reachability, discovered or logged in to
+	//at once by the node, so that multipath discovery does not scan the portals one after the other without overwhelming
+	//the initiator. 1 scans the portals one after the other. Default 4
+	EnvISCSIScanConcurrency = "X_CSI_UNITY_ISCSI_SCAN_CONCURRENCY"
+
+	//EnvTransportToolingPolicy is the policy applied at the start of the node when neither the iscsiadm binary nor an FC
+	//HBA is found. fail does not start the node, warn logs a warning so that the node still serves NFS volumes. Default warn
+	EnvTransportToolingPolicy = "X_CSI_UNITY_TRANSPORT_TOOLING_POLICY"
+
+	//EnvStrictParameters when true makes CreateVolume reject the storage class parameters it does not read, e.g. a
+	//mistyped tieringpolicy, with InvalidArgument. The parameters added by the external provisioner are accepted.
+	//Default false, unknown parameters are ignored
+	EnvStrictParameters = "X_CSI_UNITY_STRICT_PARAMETERS"
+
+	//EnvBindMountRetries is the number of retries of a bind mount of NodePublishVolume failing with EBUSY or EAGAIN, e.g.
+	//while the kubelet is working on the same target path. Other errors are not retried. 0 disables the retries. Default 3
+	EnvBindMountRetries = "X_CSI_UNITY_BIND_MOUNT_RETRIES"
+
+	//EnvDisableSnapshotIdVerification when true disables the verification that the snapshot found by DeleteSnapshot or
+	//restored by CreateVolume is the snapshot named in its snapshot id. Default false
+	EnvDisableSnapshotIdVerification = "X_CSI_UNITY_DISABLE_SNAPSHOT_ID_VERIFICATION"
+
+	//EnvRegistrationProbeTimeout is the timeout in seconds that the first registration of the node on an array waits for
+	//the array to be probed, so that the host is registered with an authenticated client. Default 0 registers the node
+	//without waiting
+	EnvRegistrationProbeTimeout = "X_CSI_UNITY_REGISTRATION_PROBE_TIMEOUT"
+
+	//EnvMaskRestGateway when true masks the RestGateway of the arrays in the logs, showing only the last octet of an IP
+	//address or the first label of a host name. Default false
+	EnvMaskRestGateway = "X_CSI_UNITY_MASK_REST_GATEWAY"
+
+	//EnvMixedProtocolPolicy is the policy applied by CreateVolume to the storage class parameters implying both a block
+	//and an NFS volume, e.g. nasServer with the FC protocol. reject fails the request with InvalidArgument, warn logs the
+	//conflict and uses the protocol parameter. Default reject
+	EnvMixedProtocolPolicy = "X_CSI_UNITY_MIXED_PROTOCOL_POLICY"
+
+	//EnvConnectorTraceFile is the path of the file the traces of the FC and iSCSI connectors are written to, with the run
+	//id and volume id of each trace. The file is rotated at 10 MiB. Default empty discards the traces
+	EnvConnectorTraceFile = "X_CSI_UNITY_CONNECTOR_TRACE_FILE"
+
+	//EnvKubeletCSIDir is the directory of the kubelet staging and publishing the CSI volumes. The node does not start when
+	//the private mount directory overlaps it. Empty disables the check. Default /var/lib/kubelet/plugins/kubernetes.io/csi
+	EnvKubeletCSIDir = "X_CSI_UNITY_KUBELET_CSI_DIR"
+
+	//EnvIncompleteVolumePolicy is the policy applied by ListVolumes to the volume records of the array missing a field
+	//required in the response, e.g. the size. skip leaves them out of the response with a warning, fail fails the
+	//listing. Default skip
+	EnvIncompleteVolumePolicy = "X_CSI_UNITY_INCOMPLETE_VOLUME_POLICY"
+
+	//EnvPerArrayMetrics when true records the latency and the result of CreateVolume, DeleteVolume and the array probes
+	//by array id. Array ids that are not configured are recorded as unknown. Default false
+	EnvPerArrayMetrics = "X_CSI_UNITY_PER_ARRAY_METRICS"
+
+	//EnvRequiredEnvVars is a comma separated list of environment variables required in addition to the variables the
+	//driver requires in its mode. The driver does not start when any of them is not set
+	EnvRequiredEnvVars = "X_CSI_UNITY_REQUIRED_ENV_VARS"
+
 	//Time interval to add node info to array. Default 60 minutes.
 	SyncNodeInfoTimeInterval = "X_CSI_UNITY_SYNC_NODEINFO_INTERVAL"
 )
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"sort"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/csi-unity/service/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//keyAllowArrayFallback is the storage class parameter allowing CreateVolume to create a fresh block volume on another
+//eligible array when the storage pool of the selected array lacks capacity. NFS volumes do not fall back, as the NAS
+//server is specific to each array
+const keyAllowArrayFallback = "allowArrayFallback"
+
+//hasPoolCapacity returns true when the storage pool of the array has the free capacity for the volume, keeping the
+//configured free reservation
+func (s *service) hasPoolCapacity(ctx context.Context, arrayID, storagePool string, size int64) bool {
+	log := utils.GetRunidLogger(ctx)
+	unity, err := s.getUnityClient(ctx, arrayID)
+	if err != nil {
+		log.Warnf("Unable to check capacity of storage pool %s on array %s. Error: %v", storagePool, arrayID, err)
+		return false
+	}
+	free, total, err := getStoragePoolCapacity(ctx, unity, storagePool)
+	if err != nil {
+		log.Warnf("Unable to get capacity of storage pool %s on array %s. Error: %v", storagePool, arrayID, utils.GetUnityError(err))
+		return false
+	}
+	var reserved uint64
+	if s.opts.PoolFreeReservation != "" {
+		if reserved, err = parsePoolReservation(s.opts.PoolFreeReservation, total); err != nil {
+			log.Warnf("Invalid pool free reservation. Error: %v", err)
+			return false
+		}
+	}
+	if uint64(size) > free || free-uint64(size) < reserved {
+		log.Infof("Storage pool %s on array %s has %d bytes free, %d requested with %d reserved", storagePool, arrayID, free, size, reserved)
+		return false
+	}
+	return true
+}
+
+//selectFallbackArray returns the array when its storage pool has the capacity for the volume. Otherwise it returns the
+//first other array, in arrayId order, that matches the array selector, is allowed by the topology requirement, supports
+//the protocol and the storage pool, is not in maintenance or read-only, is reachable and has the capacity. ResourceExhausted is
+//returned when no eligible array has the capacity
+func (s *service) selectFallbackArray(ctx context.Context, arrayID, selector, protocol, storagePool string, size int64, accessibility *csi.TopologyRequirement) (string, error) {
+	rid, log := utils.GetRunidAndLogger(ctx)
+	if s.hasPoolCapacity(ctx, arrayID, storagePool, size) {
+		return arrayID, nil
+	}
+
+	var labels map[string]string
+	if selector != "" {
+		var err error
+		if labels, err = parseArraySelector(selector); err != nil {
+			return "", status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "%v", err))
+		}
+	}
+	allowed := make([]string, 0)
+	for _, topology := range append(accessibility.GetRequisite(), accessibility.GetPreferred()...) {
+		allowed = append(allowed, getTopologyArrayIds(topology)...)
+	}
+	arrays := s.getStorageArrayList()
+	sort.Slice(arrays, func(i, j int) bool { return arrays[i].ArrayId < arrays[j].ArrayId })
+	for _, array := range arrays {
+		if array.ArrayId == arrayID || (labels != nil && !array.hasLabels(labels)) {
+			continue
+		}
+		if len(allowed) > 0 && !utils.ArrayContains(allowed, array.ArrayId) {
+			log.Debugf("Array %s is not allowed by the topology requirement", array.ArrayId)
+			continue
+		}
+		if !array.isProtocolSupported(protocol) || !array.isPoolAllowed(storagePool) {
+			log.Debugf("Array %s does not support protocol %s or storage pool %s", array.ArrayId, protocol, storagePool)
+			continue
+		}
+		if s.isArrayInMaintenance(array.ArrayId) {
+			log.Debugf("Array %s is in maintenance", array.ArrayId)
+			continue
+		}
+		if array.ReadOnly {
+			log.Debugf("Array %s is read-only", array.ArrayId)
+			continue
+		}
+		if err := s.requireProbe(ctx, array.ArrayId); err != nil {
+			log.Warnf("Array %s is unreachable. Error: %v", array.ArrayId, err)
+			continue
+		}
+		if s.hasPoolCapacity(ctx, array.ArrayId, storagePool, size) {
+			log.Infof("Storage pool %s on array %s lacks capacity. Creating volume on array %s", storagePool, arrayID, array.ArrayId)
+			return array.ArrayId, nil
+		}
+	}
+	return "", status.Error(codes.ResourceExhausted, utils.GetMessageWithRunID(rid, "No eligible array has %d bytes free in storage pool %s", size, storagePool))
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/gounity"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSelectFallbackArray(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	gib := int64(1024 * 1024 * 1024)
+	s := &service{arrays: new(sync.Map), opts: Opts{AutoProbe: true}}
+	free := make(map[*gounity.Client]uint64)
+	for i, arrayID := range []string{"array1", "array2", "array3", "array4"} {
+		client, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+		s.arrays.Store(arrayID, &StorageArrayConfig{ArrayId: arrayID, UnityClient: client, Labels: map[string]string{"tier": "gold"}})
+		free[client] = uint64(i) * 10 * uint64(gib)
+	}
+	s.getStorageArray("array2").Labels = map[string]string{"tier": "silver"}
+
+	origAuth, origCapacity := authenticateArray, getStoragePoolCapacity
+	defer func() { authenticateArray, getStoragePoolCapacity = origAuth, origCapacity }()
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		return nil
+	}
+	getStoragePoolCapacity = func(ctx context.Context, unity *gounity.Client, storagePool string) (uint64, uint64, error) {
+		return free[unity], 100 * uint64(gib), nil
+	}
+
+	//Array with capacity is kept
+	arrayID, err := s.selectFallbackArray(ctx, "array2", "", FC, "pool_1", 5*gib, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "array2", arrayID)
+
+	//Full array falls back to the next eligible array with capacity
+	arrayID, err = s.selectFallbackArray(ctx, "array1", "", FC, "pool_1", 5*gib, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "array2", arrayID)
+	arrayID, err = s.selectFallbackArray(ctx, "array1", "", FC, "pool_1", 15*gib, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "array3", arrayID)
+
+	//Label and topology constraints are honored
+	arrayID, err = s.selectFallbackArray(ctx, "array1", "tier=gold", FC, "pool_1", 5*gib, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "array3", arrayID)
+	topology := &csi.TopologyRequirement{Preferred: []*csi.Topology{{Segments: map[string]string{Name + "/array4-fc": "true"}}}}
+	arrayID, err = s.selectFallbackArray(ctx, "array1", "", FC, "pool_1", 5*gib, topology)
+	assert.Nil(t, err)
+	assert.Equal(t, "array4", arrayID)
+
+	//No eligible array with capacity
+	_, err = s.selectFallbackArray(ctx, "array1", "", FC, "pool_1", 50*gib, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "No eligible array has"), "Unexpected error message: %v", err)
+	s.setArrayMaintenance("array4", true)
+	_, err = s.selectFallbackArray(ctx, "array1", "", FC, "pool_1", 25*gib, nil)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	s.setArrayMaintenance("array4", false)
+
+	//Read-only arrays are skipped
+	s.getStorageArray("array4").ReadOnly = true
+	_, err = s.selectFallbackArray(ctx, "array1", "", FC, "pool_1", 25*gib, nil)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/dell/csi-unity/service/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//fcRemotePortsDir lists the FC remote ports, i.e. the FC targets, visible to the node
+var fcRemotePortsDir = "/sys/class/fc_remote_ports"
+
+//getVisibleFCTargets returns the WWPNs of the FC targets visible to the node. It is a variable so that tests can override it
+var getVisibleFCTargets = func(ctx context.Context) ([]string, error) {
+	portNames, err := filepath.Glob(filepath.Join(fcRemotePortsDir, "*", "port_name"))
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]string, 0, len(portNames))
+	for _, portName := range portNames {
+		wwpn, err := ioutil.ReadFile(portName)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, normalizeWwpn(string(wwpn)))
+	}
+	return targets, nil
+}
+
+//normalizeWwpn returns the WWPN in lower case hexadecimal digits without prefix or separators
+func normalizeWwpn(wwpn string) string {
+	wwpn = strings.ToLower(strings.TrimSpace(wwpn))
+	wwpn = strings.TrimPrefix(wwpn, "0x")
+	return strings.Replace(wwpn, ":", "", -1)
+}
+
+//checkFCZoning makes sure that the node has FC paths to the array before connecting a volume, so that a missing SAN
+//zoning is reported clearly rather than by a connector timeout. There are no paths when the array reports no FC target
+//ports for the initiators of the node, or when none of these target ports is visible to the node. With the warn
+//policy the missing paths are only logged
+func (s *service) checkFCZoning(ctx context.Context, arrayID string, targets []string) error {
+	rid, log := utils.GetRunidAndLogger(ctx)
+	zoned := len(targets) > 0
+	if zoned {
+		visible, err := getVisibleFCTargets(ctx)
+		if err != nil {
+			log.Warnf("Unable to list the FC targets visible to the node. Error: %v", err)
+			return nil
+		}
+		zoned = false
+		for _, target := range targets {
+			if utils.ArrayContains(visible, normalizeWwpn(target)) {
+				zoned = true
+				break
+			}
+		}
+	}
+	if zoned {
+		return nil
+	}
+	if s.opts.FCZoningPolicy == FCZoningWarn {
+		log.Warnf("No FC paths to array %s from node %s. Check the SAN zoning", arrayID, s.opts.NodeName)
+		return nil
+	}
+	return status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "No FC paths to array %s from node %s. Check the SAN zoning of the node FC initiators with the array FC ports", arrayID, s.opts.NodeName))
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/csi-unity/service/utils"
+	"github.com/dell/gofsutil"
+)
+
+//keyFsType is the volume context key holding the filesystem type a volume is created for
+const keyFsType = "fsType"
+
+//findFsType returns the filesystem type mounted on the path. It is a variable so that tests can override it
+var findFsType = gofsutil.FindFSType
+
+//getRequestedFsType returns the filesystem type the volume capability requests, or "" for raw block volumes
+func getRequestedFsType(volCap *csi.VolumeCapability, protocol string) string {
+	if protocol == NFS {
+		return "nfs"
+	}
+	if mount := volCap.GetMount(); mount != nil {
+		return getStageFsType(mount.GetFsType())
+	}
+	return ""
+}
+
+//setFsTypeContext records the filesystem type the volume is created for in the volume context
+func setFsTypeContext(volumeResp *csi.CreateVolumeResponse, volCaps []*csi.VolumeCapability, protocol string) {
+	for _, volCap := range volCaps {
+		if fsType := getRequestedFsType(volCap, protocol); fsType != "" {
+			volumeResp.Volume.VolumeContext[keyFsType] = fsType
+			return
+		}
+	}
+}
+
+//recordStagedFsType records in the staging state the requested filesystem type of the volume and the filesystem type
+//found on the staging target path, and logs a warning when they differ
+func recordStagedFsType(ctx context.Context, state *stagingState, req *csi.NodeStageVolumeRequest, protocol, stagingPath string) {
+	log := utils.GetRunidLogger(ctx)
+	state.RequestedFsType = req.GetVolumeContext()[keyFsType]
+	if state.RequestedFsType == "" {
+		state.RequestedFsType = getRequestedFsType(req.GetVolumeCapability(), protocol)
+	}
+	fsType, err := findFsType(ctx, stagingPath)
+	if err != nil {
+		log.Warnf("Unable to find the filesystem type of volume %s on %s. Error: %v", state.VolumeId, stagingPath, err)
+		return
+	}
+	state.FsType = fsType
+	if protocol != NFS && state.RequestedFsType != "" && state.RequestedFsType != fsType {
+		log.Warnf("Volume %s is formatted with %s although %s is requested", state.VolumeId, fsType, state.RequestedFsType)
+	}
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+//maskedGatewayPart replaces the hidden parts of the RestGateway host
+const maskedGatewayPart = "x"
+
+//displayRestGateway returns the RestGateway to log. With masking enabled only the last octet or group of an IP address,
+//or the first label of a host name, is shown. The RestGateway itself is left untouched
+func displayRestGateway(gateway string, mask bool) string {
+	if !mask || gateway == "" {
+		return gateway
+	}
+	gatewayURL, err := url.Parse(gateway)
+	if err != nil || gatewayURL.Hostname() == "" {
+		return maskedGatewayPart
+	}
+	host := maskGatewayHost(gatewayURL.Hostname())
+	if port := gatewayURL.Port(); port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return gatewayURL.Scheme + "://" + host
+}
+
+//displayGatewayHost returns the RestGateway host or address to log, masked as in displayRestGateway
+func displayGatewayHost(host string, mask bool) string {
+	if !mask || host == "" {
+		return host
+	}
+	return maskGatewayHost(host)
+}
+
+//maskGatewayHost masks all but the last octet or group of an IP address, and all but the first label of a host name
+func maskGatewayHost(host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		separator := "."
+		if ip.To4() == nil {
+			separator = ":"
+		}
+		tokens := strings.Split(host, separator)
+		for i := 0; i < len(tokens)-1; i++ {
+			tokens[i] = maskedGatewayPart
+		}
+		return strings.Join(tokens, separator)
+	}
+	tokens := strings.Split(host, ".")
+	for i := 1; i < len(tokens); i++ {
+		tokens[i] = maskedGatewayPart
+	}
+	return strings.Join(tokens, ".")
+}
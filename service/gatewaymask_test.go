@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMaskRestGateway(t *testing.T) {
+	array := StorageArrayConfig{ArrayId: "array1", Username: "admin", RestGateway: "https://10.247.96.42"}
+
+	//Without masking the full RestGateway is logged
+	assert.True(t, strings.Contains(array.String(), "https://10.247.96.42"), "Unexpected stringer output: %s", array.String())
+
+	array.maskRestGateway = true
+	output := array.String()
+	assert.False(t, strings.Contains(output, "10.247.96"), "RestGateway not masked: %s", output)
+	assert.True(t, strings.Contains(output, "https://x.x.x.42"), "Unexpected stringer output: %s", output)
+	assert.Equal(t, "https://10.247.96.42", array.RestGateway, "RestGateway changed by the masking")
+
+	assert.Equal(t, "https://x.x.x.42:8443", displayRestGateway("https://10.247.96.42:8443", true))
+	assert.Equal(t, "https://unity01.x.x", displayRestGateway("https://unity01.lab.example", true))
+	assert.Equal(t, "https://[x:x:x:x:5]", displayRestGateway("https://[fd00:1:2::5]", true))
+	assert.Equal(t, "x", displayRestGateway("10.247.96.42", true))
+	assert.Equal(t, "x.x.x.42", displayGatewayHost("10.247.96.42", true))
+	assert.Equal(t, "10.247.96.42", displayGatewayHost("10.247.96.42", false))
+}
+
+func TestMaskRestGatewayOption(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	s := &service{arrays: new(sync.Map), opts: Opts{MaskRestGateway: true,
+		ArrayConfigJSON: `{"storageArrayList": [{"arrayId": "array1", "username": "user", "password": "pwd", "restGateway": "https://127.0.0.1:1"}]}`}}
+	assert.Nil(t, s.syncDriverConfig(ctx))
+
+	//The loaded arrays and their status are masked
+	array := s.getStorageArray("array1")
+	assert.Equal(t, "https://127.0.0.1:1", array.RestGateway)
+	assert.True(t, strings.Contains(array.String(), "https://x.x.x.1:1"), "Unexpected stringer output: %s", array.String())
+	assert.Equal(t, "https://x.x.x.1:1", s.getArrayStatusList()[0].RestGateway)
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dell/csi-unity/service/utils"
+)
+
+//defaultProbeFailureThreshold is the default number of consecutive failed probes of all the arrays before the driver is not ready
+const defaultProbeFailureThreshold = 1
+
+//readinessState tracks the consecutive failed probes of all the arrays
+type readinessState struct {
+	mutex               sync.Mutex
+	consecutiveFailures int
+}
+
+//record records the result of a probe of all the arrays and returns the number of consecutive failures
+func (r *readinessState) record(success bool) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if success {
+		r.consecutiveFailures = 0
+	} else {
+		r.consecutiveFailures++
+	}
+	return r.consecutiveFailures
+}
+
+//failures returns the number of consecutive failed probes
+func (r *readinessState) failures() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.consecutiveFailures
+}
+
+//recordFleetProbe records the result of a probe of all the arrays for the readiness endpoint
+func (s *service) recordFleetProbe(ctx context.Context, success bool) {
+	log := utils.GetRunidLogger(ctx)
+	failures := s.readiness.record(success)
+	if failures > 0 {
+		log.Warnf("Probe of all arrays failed %d consecutive times. Driver is reported not ready after %d", failures, s.getProbeFailureThreshold())
+	}
+}
+
+//getProbeFailureThreshold returns the number of consecutive failed probes after which the driver is not ready
+func (s *service) getProbeFailureThreshold() int {
+	if s.opts.ProbeFailureThreshold < 1 {
+		return defaultProbeFailureThreshold
+	}
+	return s.opts.ProbeFailureThreshold
+}
+
+//isReady returns false once the consecutive failed probes of all the arrays reach the threshold
+func (s *service) isReady() bool {
+	return s.readiness.failures() < s.getProbeFailureThreshold()
+}
+
+//arrayStatus is the read-only view of a configured array. It never includes the password
+type arrayStatus struct {
+	ArrayId        string `json:"arrayId"`
+	Username       string `json:"username"`
+	RestGateway    string `json:"restGateway"`
+	Insecure       bool   `json:"insecure"`
+	IsDefaultArray bool   `json:"isDefaultArray"`
+	IsProbeSuccess bool   `json:"isProbeSuccess"`
+	IsHostAdded    bool   `json:"isHostAdded"`
+	InMaintenance  bool   `json:"inMaintenance"`
+	ReadOnly       bool   `json:"readOnly"`
+	LastProbeTime  string `json:"lastProbeTime,omitempty"`
+	LastProbeError string `json:"lastProbeError,omitempty"`
+}
+
+//arrayProbeResult is the result of the last probe of an array
+type arrayProbeResult struct {
+	time time.Time
+	err  string
+}
+
+//recordArrayProbe records the result of the probe of the array for the arrays endpoint
+func (s *service) recordArrayProbe(arrayId string, err error) {
+	result := arrayProbeResult{time: time.Now()}
+	if err != nil {
+		result.err = err.Error()
+	}
+	s.arrayProbeResults.Store(arrayId, result)
+}
+
+//logArrayProbeStatus logs the status of each array after a probe
+func (s *service) logArrayProbeStatus(ctx context.Context) {
+	log := utils.GetRunidLogger(ctx)
+	list := s.getArrayStatusList()
+	reachable := 0
+	for _, array := range list {
+		if array.IsProbeSuccess {
+			reachable++
+		}
+		log.Infof("Array %s reachable: %v, in maintenance: %v, last probe: %s %s", array.ArrayId, array.IsProbeSuccess, array.InMaintenance, array.LastProbeTime, array.LastProbeError)
+	}
+	log.Infof("%d of %d arrays reachable", reachable, len(list))
+}
+
+//getArrayStatusList returns the status of the arrays loaded by the driver sorted by array id. The username is redacted
+//and the RestGateway is masked as in the logs
+func (s *service) getArrayStatusList() []arrayStatus {
+	list := make([]arrayStatus, 0)
+	for _, array := range s.getStorageArrayList() {
+		list = append(list, arrayStatus{
+			ArrayId:        array.ArrayId,
+			Username:       redactedValue,
+			RestGateway:    displayRestGateway(array.RestGateway, s.opts.MaskRestGateway),
+			Insecure:       array.Insecure,
+			IsDefaultArray: array.IsDefaultArray,
+			IsProbeSuccess: array.IsProbeSuccess,
+			IsHostAdded:    array.IsHostAdded,
+			InMaintenance:  s.isArrayInMaintenance(array.ArrayId),
+			ReadOnly:       array.ReadOnly,
+		})
+		if result, ok := s.arrayProbeResults.Load(array.ArrayId); ok {
+			list[len(list)-1].LastProbeTime = result.(arrayProbeResult).time.UTC().Format(time.RFC3339)
+			list[len(list)-1].LastProbeError = result.(arrayProbeResult).err
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ArrayId < list[j].ArrayId })
+	return list
+}
+
+//healthHandler returns the handler serving the health, readiness, Prometheus metrics, arrays and staged volumes endpoints
+func (s *service) healthHandler(ctx context.Context) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !s.isReady() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(fmt.Sprintf("not ready: probe of all arrays failed %d consecutive times", s.readiness.failures())))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		gauges := map[string]float64{metricArrays: float64(s.getStorageArrayLength())}
+		if err := driverMetrics.writeText(w, gauges); err != nil {
+			utils.GetRunidLogger(ctx).Errorf("Unable to write metrics. Error: %v", err)
+		}
+	})
+	mux.HandleFunc("/arrays", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.getArrayStatusList())
+	})
+	mux.HandleFunc("/volumes", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		states, err := s.listStagingStates(ctx)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(fmt.Sprintf("unable to list staged volumes: %v", err)))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(states)
+	})
+	return mux
+}
+
+//startHealthServer serves the health endpoints on the given address
+func (s *service) startHealthServer(ctx context.Context, address string) {
+	log := utils.GetRunidLogger(ctx)
+	log.Infof("Starting health endpoint on %s", address)
+	go func() {
+		if err := http.ListenAndServe(address, s.healthHandler(ctx)); err != nil {
+			log.Errorf("Health endpoint on %s stopped. Error: %v", address, err)
+		}
+	}()
+}
+
+//adminHandler returns the handler serving the array maintenance admin endpoint
+func (s *service) adminHandler(ctx context.Context) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/arrays/maintenance", s.maintenanceHandler(ctx))
+	return mux
+}
+
+//isLoopbackAddress returns true when the host of the address is localhost or a loopback IP address, so that the
+//unauthenticated admin endpoint is only reachable from the node running the driver
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+//startAdminServer serves the admin endpoints on the given address, which must be a loopback address
+func (s *service) startAdminServer(ctx context.Context, address string) {
+	log := utils.GetRunidLogger(ctx)
+	if !isLoopbackAddress(address) {
+		log.Errorf("Admin endpoint is not started as %s is not a loopback address", address)
+		return
+	}
+	log.Infof("Starting admin endpoint on %s", address)
+	go func() {
+		if err := http.ListenAndServe(address, s.adminHandler(ctx)); err != nil {
+			log.Errorf("Admin endpoint on %s stopped. Error: %v", address, err)
+		}
+	}()
+}
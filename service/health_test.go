@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/dell/gounity"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestArraysEndpoint(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	conf, err := ioutil.TempFile("", "unity-config")
+	if err != nil {
+		t.Fatalf("Unable to create temp config: %v", err)
+	}
+	defer os.Remove(conf.Name())
+	origConfig := DriverConfig
+	defer func() { DriverConfig = origConfig }()
+	DriverConfig = conf.Name()
+
+	s := &service{arrays: new(sync.Map)}
+	server := httptest.NewServer(s.healthHandler(ctx))
+	defer server.Close()
+
+	getArrays := func() (string, []arrayStatus) {
+		resp, err := http.Get(server.URL + "/arrays")
+		if err != nil {
+			t.Fatalf("Unable to get arrays: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		list := make([]arrayStatus, 0)
+		if err := json.Unmarshal(body, &list); err != nil {
+			t.Fatalf("Unable to parse arrays: %v", err)
+		}
+		return string(body), list
+	}
+
+	_ = ioutil.WriteFile(conf.Name(), []byte(`{"storageArrayList": [
+		{"arrayId": "array1", "username": "user", "password": "secret-pwd-1", "restGateway": "https://127.0.0.1:1", "isDefaultArray": true},
+		{"arrayId": "array2", "username": "user", "password": "secret-pwd-2", "restGateway": "https://127.0.0.1:2"}]}`), 0644)
+	assert.Nil(t, s.syncDriverConfig(ctx))
+	s.getStorageArray("array1").IsProbeSuccess = true
+	body, list := getArrays()
+	assert.Equal(t, 2, len(list))
+	assert.Equal(t, "array1", list[0].ArrayId)
+	assert.True(t, list[0].IsProbeSuccess)
+	assert.False(t, list[1].IsHostAdded)
+	assert.False(t, strings.Contains(body, "secret-pwd"), "Password exposed by arrays endpoint")
+	assert.False(t, strings.Contains(body, `"username":"user"`), "Username exposed by arrays endpoint")
+
+	//Reload with an array removed
+	_ = ioutil.WriteFile(conf.Name(), []byte(`{"storageArrayList": [
+		{"arrayId": "array2", "username": "user", "password": "secret-pwd-2", "restGateway": "https://127.0.0.1:2", "isDefaultArray": true}]}`), 0644)
+	assert.Nil(t, s.syncDriverConfig(ctx))
+	body, list = getArrays()
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, "array2", list[0].ArrayId)
+	assert.False(t, strings.Contains(body, "secret-pwd"), "Password exposed by arrays endpoint")
+}
+
+func TestReadinessFailureThreshold(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	client, err := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	if err != nil {
+		t.Fatalf("Unable to create Unity client: %v", err)
+	}
+	origAuth := authenticateArray
+	defer func() { authenticateArray = origAuth }()
+	var authErr error
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		return authErr
+	}
+
+	s := &service{arrays: new(sync.Map), opts: Opts{ProbeFailureThreshold: 3}}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", UnityClient: client})
+	server := httptest.NewServer(s.healthHandler(ctx))
+	defer server.Close()
+	readyStatus := func() int {
+		resp, err := http.Get(server.URL + "/readyz")
+		if err != nil {
+			t.Fatalf("Unable to get readiness: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, readyStatus())
+	authErr = errors.New("array unreachable")
+	for i := 1; i < 3; i++ {
+		assert.NotNil(t, s.probe(ctx, "Controller", ""))
+		assert.Equal(t, http.StatusOK, readyStatus(), "Not ready after %d failures", i)
+	}
+	assert.NotNil(t, s.probe(ctx, "Controller", ""))
+	assert.Equal(t, http.StatusServiceUnavailable, readyStatus())
+
+	//A successful probe resets the consecutive failures
+	authErr = nil
+	assert.Nil(t, s.probe(ctx, "Controller", ""))
+	assert.Equal(t, http.StatusOK, readyStatus())
+	authErr = errors.New("array unreachable")
+	assert.NotNil(t, s.probe(ctx, "Controller", ""))
+	assert.Equal(t, http.StatusOK, readyStatus())
+}
+
+func TestMetricsEndpointExposition(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	s := &service{arrays: new(sync.Map)}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1"})
+	s.arrays.Store("array2", &StorageArrayConfig{ArrayId: "array2"})
+	recordProbeLatency(ctx, "array1", 250*time.Millisecond)
+	recordConfigReload(nil)
+	_, _ = metricsInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Identity/Probe"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+
+	server := httptest.NewServer(s.healthHandler(ctx))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Unable to get metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain"))
+
+	text := string(body)
+	for _, expected := range []string{
+		"# TYPE csi_unity_probe_latency_seconds summary",
+		`csi_unity_probe_latency_seconds_count{arrayId="array1"}`,
+		`csi_unity_config_reloads_total{result="success"}`,
+		`csi_unity_rpc_latency_seconds_count{method="/csi.v1.Identity/Probe",code="OK"}`,
+		"# TYPE csi_unity_arrays gauge",
+		"csi_unity_arrays 2",
+	} {
+		assert.True(t, strings.Contains(text, expected), "Metric %s not found in %s", expected, text)
+	}
+
+	//Every line is a comment or a valid sample
+	sampleLine := regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*(\{[a-zA-Z_][a-zA-Z0-9_]*="[^"]*"(,[a-zA-Z_][a-zA-Z0-9_]*="[^"]*")*\})? [-+0-9.eE]+$`)
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		assert.True(t, sampleLine.MatchString(line), "Invalid exposition line: %s", line)
+	}
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/dell/csi-unity/service/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//hostLunPathFormat is the Unity REST query of the LUNs and snapshots mapped to a host
+const hostLunPathFormat = "/api/types/hostLUN/instances?compact=true&fields=id&filter=%s"
+
+//getHostLunCount returns the number of LUNs and snapshots mapped to the host using the Unity REST API, as gounity does
+//not report the mappings of a host. It is a variable so that tests can override it
+var getHostLunCount = func(ctx context.Context, array *StorageArrayConfig, hostID string) (int, error) {
+	client := newArrayHTTPClient(array, nil)
+	filter := url.QueryEscape(fmt.Sprintf(`host.id eq "%s"`, hostID))
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(array.RestGateway, "/")+fmt.Sprintf(hostLunPathFormat, filter), nil)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.SetBasicAuth(array.Username, array.Password)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-EMC-REST-CLIENT", "true")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, newRestStatusError(resp, "query of host LUNs failed")
+	}
+	var result struct {
+		EntryCount int               `json:"entryCount"`
+		Entries    []json.RawMessage `json:"entries"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	if result.EntryCount < len(result.Entries) {
+		return len(result.Entries), nil
+	}
+	return result.EntryCount, nil
+}
+
+//checkHostLunLimit makes sure that the host can be mapped another LUN when a maximum number of LUNs per host is
+//configured, so that the limit is reported clearly rather than by a failed export. The volume is mapped when the
+//number of LUNs of the host cannot be queried
+func (s *service) checkHostLunLimit(ctx context.Context, arrayID, hostID, nodeID string) error {
+	rid, log := utils.GetRunidAndLogger(ctx)
+	if s.opts.MaxHostLuns <= 0 {
+		return nil
+	}
+	array := s.getStorageArray(arrayID)
+	if array == nil {
+		return nil
+	}
+	var count int
+	err := withRetry(ctx, s.opts.RestMaxRetries, func() error {
+		var err error
+		count, err = getHostLunCount(ctx, array, hostID)
+		return err
+	})
+	if err != nil {
+		log.Warnf("Unable to get the number of LUNs mapped to host %s. Error: %v", nodeID, utils.GetUnityError(err))
+		return nil
+	}
+	log.Debugf("Host %s has %d of at most %d LUNs mapped", nodeID, count, s.opts.MaxHostLuns)
+	if count >= s.opts.MaxHostLuns {
+		return status.Error(codes.ResourceExhausted, utils.GetMessageWithRunID(rid, "Host %s already has %d LUNs mapped on array %s, the maximum allowed is %d. Unpublish volumes from the node before publishing more", nodeID, count, arrayID, s.opts.MaxHostLuns))
+	}
+	return nil
+}
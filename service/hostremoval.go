@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/dell/csi-unity/service/utils"
+	"github.com/dell/gounity"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	//hostLunNamesPathFormat is the Unity REST query of the names of the LUNs and snapshots mapped to a host
+	hostLunNamesPathFormat = "/api/types/hostLUN/instances?compact=true&fields=id,lun.name,snap.name&filter=%s"
+
+	//hostPathFormat is the Unity REST resource of a host
+	hostPathFormat = "/api/instances/host/%s"
+)
+
+//listHostLuns returns the names of the LUNs and snapshots mapped to the host using the Unity REST API, as gounity does
+//not report the mappings of a host. It is a variable so that tests can override it
+var listHostLuns = func(ctx context.Context, array *StorageArrayConfig, hostID string) ([]string, error) {
+	session, err := openRestSession(ctx, array)
+	if err != nil {
+		return nil, err
+	}
+	defer session.close()
+
+	filter := url.QueryEscape(fmt.Sprintf(`host.id eq "%s"`, hostID))
+	resp, err := session.do(http.MethodGet, fmt.Sprintf(hostLunNamesPathFormat, filter), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newRestStatusError(resp, "query of host LUNs failed")
+	}
+	type resource struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	var result struct {
+		Entries []struct {
+			Content struct {
+				ID   string    `json:"id"`
+				Lun  *resource `json:"lun"`
+				Snap *resource `json:"snap"`
+			} `json:"content"`
+		} `json:"entries"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	luns := make([]string, 0, len(result.Entries))
+	for _, entry := range result.Entries {
+		mapped := entry.Content.Lun
+		if mapped == nil {
+			mapped = entry.Content.Snap
+		}
+		switch {
+		case mapped != nil && mapped.Name != "":
+			luns = append(luns, mapped.Name)
+		case mapped != nil && mapped.ID != "":
+			luns = append(luns, mapped.ID)
+		default:
+			luns = append(luns, entry.Content.ID)
+		}
+	}
+	return luns, nil
+}
+
+//deleteHostRecord deletes the host from the array using the Unity REST API, as gounity does not support deleting a
+//host. It is a variable so that tests can override it
+var deleteHostRecord = func(ctx context.Context, array *StorageArrayConfig, hostID string) error {
+	session, err := openRestSession(ctx, array)
+	if err != nil {
+		return err
+	}
+	defer session.close()
+
+	resp, err := session.do(http.MethodDelete, fmt.Sprintf(hostPathFormat, hostID), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return newRestStatusError(resp, "delete host failed")
+	}
+	return nil
+}
+
+//findHostID returns the id of the host with the name on the array. It is a variable so that tests can override it
+var findHostID = func(ctx context.Context, unity *gounity.Client, hostName string) (string, error) {
+	host, err := gounity.NewHost(unity).FindHostByName(ctx, hostName)
+	if err != nil {
+		return "", err
+	}
+	return host.HostContent.ID, nil
+}
+
+//removeHostIfUnmapped removes the host record from the array only when no LUN or snapshot remains mapped to it, so that
+//the removal of a host never breaks the access of a workload to its volumes. When LUNs remain mapped the removal is
+//skipped, the blocking LUNs are logged and returned. Every host removal goes through this check
+func (s *service) removeHostIfUnmapped(ctx context.Context, arrayID, hostName string) ([]string, error) {
+	ctx, log := setArrayIdContext(ctx, arrayID)
+	rid, _ := utils.GetRunidAndLogger(ctx)
+	unity, err := s.getUnityClient(ctx, arrayID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireProbe(ctx, arrayID); err != nil {
+		return nil, err
+	}
+	array := s.getStorageArray(arrayID)
+	hostID, err := findHostID(ctx, unity, hostName)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Host %s not found on array %s. Error: %v", hostName, arrayID, utils.GetUnityError(err)))
+	}
+	var luns []string
+	err = withRetry(ctx, s.opts.RestMaxRetries, func() error {
+		var err error
+		luns, err = listHostLuns(ctx, array, hostID)
+		return err
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, utils.GetMessageWithRunID(rid, "Unable to list the LUNs mapped to host %s. Error: %v", hostName, utils.GetUnityError(err)))
+	}
+	if len(luns) > 0 {
+		log.Warnf("Host %s is not removed from array %s as LUNs remain mapped to it: %s", hostName, arrayID, strings.Join(luns, ", "))
+		return luns, nil
+	}
+	if err := deleteHostRecord(ctx, array, hostID); err != nil {
+		return nil, status.Error(codes.Internal, utils.GetMessageWithRunID(rid, "Unable to remove host %s. Error: %v", hostName, utils.GetUnityError(err)))
+	}
+	log.Infof("Host %s removed from array %s", hostName, arrayID)
+	return nil, nil
+}
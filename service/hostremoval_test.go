@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"github.com/dell/gounity"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRemoveHostIfUnmapped(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	origList, origDelete, origFind := listHostLuns, deleteHostRecord, findHostID
+	defer func() { listHostLuns, deleteHostRecord, findHostID = origList, origDelete, origFind }()
+	mapped := map[string][]string{"Host_2": {"csivol-1", "csivol-2"}}
+	deleted := make([]string, 0)
+	listHostLuns = func(ctx context.Context, array *StorageArrayConfig, hostID string) ([]string, error) {
+		return mapped[hostID], nil
+	}
+	deleteHostRecord = func(ctx context.Context, array *StorageArrayConfig, hostID string) error {
+		deleted = append(deleted, hostID)
+		return nil
+	}
+	findHostID = func(ctx context.Context, unity *gounity.Client, hostName string) (string, error) {
+		return map[string]string{"node1": "Host_1", "node2": "Host_2"}[hostName], nil
+	}
+
+	client, err := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	if err != nil {
+		t.Fatalf("Unable to create Unity client: %v", err)
+	}
+	origAuth := authenticateArray
+	defer func() { authenticateArray = origAuth }()
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		return nil
+	}
+	s := &service{arrays: new(sync.Map), opts: Opts{AutoProbe: true}}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", UnityClient: client})
+
+	//Host without LUNs is removed
+	luns, err := s.removeHostIfUnmapped(ctx, "array1", "node1")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(luns))
+	assert.Equal(t, []string{"Host_1"}, deleted)
+
+	//Host with LUNs is kept and the blocking LUNs are listed
+	luns, err = s.removeHostIfUnmapped(ctx, "array1", "node2")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"csivol-1", "csivol-2"}, luns)
+	assert.Equal(t, []string{"Host_1"}, deleted)
+
+	//Unknown array is rejected before any host lookup
+	_, err = s.removeHostIfUnmapped(ctx, "array3", "node1")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "Array array3 not configured"), "Unexpected error message: %v", err)
+	assert.Equal(t, []string{"Host_1"}, deleted)
+
+	//Probe of an unknown array fails instead of passing
+	err = s.requireProbe(ctx, "array3")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
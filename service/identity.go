@@ -5,6 +5,7 @@ import (
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/dell/csi-unity/core"
+	"github.com/golang/protobuf/ptypes/wrappers"
 	"golang.org/x/net/context"
 )
 
@@ -14,6 +15,26 @@ func (s *service) Probe(
 	*csi.ProbeResponse, error) {
 	ctx, log, _ := GetRunidLog(ctx)
 	log.Infof("Executing Probe with args: %+v", *req)
+	if s.opts.ProbeOnDemand || s.opts.ProbeArrayStatus {
+		probeType := "Controller"
+		if strings.EqualFold(s.mode, "node") {
+			probeType = "Node"
+		}
+		ready := s.probeArraysOnDemand(ctx, probeType)
+		if s.opts.ProbeArrayStatus {
+			s.logArrayProbeStatus(ctx)
+		}
+		log.Infof("Identity probe ready: %v", ready)
+		return &csi.ProbeResponse{Ready: &wrappers.BoolValue{Value: ready}}, nil
+	}
+	if s.opts.LightweightProbe {
+		if err := s.lightweightProbe(ctx, "Identity"); err != nil {
+			log.Error("Identity probe failed:", err)
+			return nil, err
+		}
+		log.Info("Identity probe success")
+		return &csi.ProbeResponse{}, nil
+	}
 	if strings.EqualFold(s.mode, "controller") {
 		if err := s.controllerProbe(ctx, ""); err != nil {
 			log.Error("Identity probe failed:", err)
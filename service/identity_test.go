@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/gounity"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestLightweightProbe(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	client, err := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	if err != nil {
+		t.Fatalf("Unable to create unity client: %v", err)
+	}
+	s := &service{arrays: new(sync.Map), mode: "controller", opts: Opts{LightweightProbe: true, AutoProbe: true}}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", RestGateway: "https://127.0.0.1:1", UnityClient: client})
+
+	origInfo, origAuth := getBasicSystemInfo, authenticateArray
+	defer func() { getBasicSystemInfo, authenticateArray = origInfo, origAuth }()
+	infoCalls, authCalls := 0, 0
+	getBasicSystemInfo = func(ctx context.Context, array *StorageArrayConfig) error {
+		infoCalls++
+		return nil
+	}
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		authCalls++
+		return nil
+	}
+
+	//Health check uses the lightweight probe
+	_, err = s.Probe(ctx, &csi.ProbeRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, infoCalls)
+	assert.Equal(t, 0, authCalls)
+
+	//Operations login to the array
+	err = s.requireProbe(ctx, "array1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, infoCalls)
+	assert.Equal(t, 1, authCalls)
+}
+
+func TestProbeOnDemand(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	s := &service{arrays: new(sync.Map), mode: "controller", opts: Opts{ProbeOnDemand: true, AutoProbe: true}}
+	for _, arrayID := range []string{"array1", "array2"} {
+		client, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+		s.arrays.Store(arrayID, &StorageArrayConfig{ArrayId: arrayID, RestGateway: "https://127.0.0.1:1", UnityClient: client})
+	}
+
+	origAuth, origReachable := authenticateArray, isRestGatewayReachable
+	defer func() { authenticateArray, isRestGatewayReachable = origAuth, origReachable }()
+	var mutex sync.Mutex
+	up := map[string]bool{}
+	authCalls := 0
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		authCalls++
+		if !up[array.ArrayId] {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	isRestGatewayReachable = func(ctx context.Context, array *StorageArrayConfig) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return up[array.ArrayId]
+	}
+
+	//All arrays down is not ready
+	resp, err := s.Probe(ctx, &csi.ProbeRequest{})
+	assert.Nil(t, err)
+	assert.False(t, resp.GetReady().GetValue())
+	assert.False(t, s.isReady())
+
+	//One array up is ready
+	up["array2"] = true
+	resp, err = s.Probe(ctx, &csi.ProbeRequest{})
+	assert.Nil(t, err)
+	assert.True(t, resp.GetReady().GetValue())
+	assert.True(t, s.isReady())
+	assert.True(t, s.getStorageArray("array2").IsProbeSuccess)
+	assert.False(t, s.getStorageArray("array1").IsProbeSuccess)
+
+	//Each array is probed once per Probe
+	authCalls = 0
+	_, err = s.Probe(ctx, &csi.ProbeRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, authCalls)
+
+	//Unreachable logged in arrays are probed again
+	up["array2"] = false
+	resp, err = s.Probe(ctx, &csi.ProbeRequest{})
+	assert.Nil(t, err)
+	assert.False(t, resp.GetReady().GetValue())
+	assert.False(t, s.getStorageArray("array2").IsProbeSuccess)
+}
+
+func TestProbeArrayStatus(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	s := &service{arrays: new(sync.Map), mode: "node", opts: Opts{ProbeArrayStatus: true, AutoProbe: true}}
+	for _, arrayID := range []string{"array1", "array2"} {
+		client, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+		s.arrays.Store(arrayID, &StorageArrayConfig{ArrayId: arrayID, RestGateway: "https://127.0.0.1:1", UnityClient: client})
+	}
+
+	origAuth, origReachable := authenticateArray, isRestGatewayReachable
+	defer func() { authenticateArray, isRestGatewayReachable = origAuth, origReachable }()
+	var mutex sync.Mutex
+	up := map[string]bool{}
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !up[array.ArrayId] {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	isRestGatewayReachable = func(ctx context.Context, array *StorageArrayConfig) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return up[array.ArrayId]
+	}
+
+	server := httptest.NewServer(s.healthHandler(ctx))
+	defer server.Close()
+	getArrays := func() []arrayStatus {
+		resp, err := http.Get(server.URL + "/arrays")
+		if err != nil {
+			t.Fatalf("Unable to get arrays: %v", err)
+		}
+		defer resp.Body.Close()
+		list := make([]arrayStatus, 0)
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			t.Fatalf("Unable to parse arrays: %v", err)
+		}
+		return list
+	}
+
+	//No array probed yet
+	list := getArrays()
+	assert.Equal(t, 2, len(list))
+	assert.Equal(t, "", list[0].LastProbeTime)
+
+	//Ready tracks whether at least one array is reachable
+	for _, reachable := range [][]string{{}, {"array2"}, {"array1", "array2"}, {"array1"}, {}} {
+		mutex.Lock()
+		up = map[string]bool{}
+		for _, arrayID := range reachable {
+			up[arrayID] = true
+		}
+		mutex.Unlock()
+		resp, err := s.Probe(ctx, &csi.ProbeRequest{})
+		assert.Nil(t, err)
+		assert.Equal(t, len(reachable) > 0, resp.GetReady().GetValue(), "Unexpected ready with reachable arrays %v", reachable)
+
+		//Health endpoint reports the status of each array
+		for _, array := range getArrays() {
+			assert.NotEqual(t, "", array.LastProbeTime)
+			assert.Equal(t, up[array.ArrayId], array.IsProbeSuccess, "Unexpected status of %s", array.ArrayId)
+			assert.Equal(t, !up[array.ArrayId], array.LastProbeError != "", "Unexpected probe error of %s: %s", array.ArrayId, array.LastProbeError)
+		}
+	}
+}
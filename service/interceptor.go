@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+//idempotencyKeyHeader is the gRPC metadata header carrying the idempotency key of an operation
+var idempotencyKeyHeader = "idempotency-key"
+
+//inFlightCall is an operation in progress whose result is shared with the duplicate requests
+type inFlightCall struct {
+	done chan struct{}
+	resp interface{}
+	err  error
+	dups int
+}
+
+//inFlightGroup collapses identical operations that are in progress at the same time into one
+type inFlightGroup struct {
+	mutex sync.Mutex
+	calls map[string]*inFlightCall
+}
+
+var inFlightOperations = &inFlightGroup{}
+
+//detachedContext carries the values of a request context without its cancellation and deadline, so that an operation
+//shared by several requests is not cancelled by any one of them
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+//do runs the operation for the given key unless one is already in progress, in which case it waits for the result of
+//the running operation. The operation runs on a context detached from the requests, and each request stops waiting
+//when its own context is done. shared reports if the result came from another request
+func (g *inFlightGroup) do(ctx context.Context, key string, op func(ctx context.Context) (interface{}, error)) (resp interface{}, err error, shared bool) {
+	g.mutex.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*inFlightCall)
+	}
+	call, shared := g.calls[key]
+	if shared {
+		call.dups++
+	} else {
+		call = &inFlightCall{done: make(chan struct{})}
+		g.calls[key] = call
+		go func() {
+			call.resp, call.err = op(detachedContext{ctx})
+			g.mutex.Lock()
+			delete(g.calls, key)
+			g.mutex.Unlock()
+			close(call.done)
+		}()
+	}
+	g.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		return call.resp, call.err, shared
+	case <-ctx.Done():
+		code := codes.Canceled
+		if ctx.Err() == context.DeadlineExceeded {
+			code = codes.DeadlineExceeded
+		}
+		return nil, status.Error(code, ctx.Err().Error()), shared
+	}
+}
+
+//duplicates returns the number of requests waiting on the operation in progress for the given key
+func (g *inFlightGroup) duplicates(key string) int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if call, ok := g.calls[key]; ok {
+		return call.dups
+	}
+	return 0
+}
+
+//getIdempotencyKey returns the idempotency key from the incoming request metadata
+func getIdempotencyKey(ctx context.Context) string {
+	headers, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if key, ok := headers[idempotencyKeyHeader]; ok && len(key) > 0 {
+		return key[0]
+	}
+	return ""
+}
+
+//getRequestHash returns the hash of the request payload, so that only the requests with the same payload are collapsed
+func getRequestHash(req interface{}) string {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+//getInFlightKey returns the key of the operation of the request, made of the method, the idempotency key and the hash
+//of the request payload, so that a reused idempotency key with another payload runs its own operation
+func getInFlightKey(method, key string, req interface{}) string {
+	return method + "/" + key + "/" + getRequestHash(req)
+}
+
+//idempotencyInterceptor collapses concurrent requests to the same method carrying the same idempotency key and the
+//same payload into one operation
+func idempotencyInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	key := getIdempotencyKey(ctx)
+	if key == "" {
+		return handler(ctx, req)
+	}
+	resp, err, shared := inFlightOperations.do(ctx, getInFlightKey(info.FullMethod, key, req), func(ctx context.Context) (interface{}, error) {
+		return handler(ctx, req)
+	})
+	if shared {
+		_, log, _ := GetRunidLog(ctx)
+		log.Infof("Request %s with idempotency key %s was collapsed into the operation in progress", info.FullMethod, key)
+	}
+	return resp, err
+}
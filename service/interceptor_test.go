@@ -0,0 +1,130 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/csi-unity/service/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyInterceptorCollapsesRequests(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(idempotencyKeyHeader, "key-1"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+	var calls int32
+	release := make(chan bool)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "volume-1", nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]interface{}, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = idempotencyInterceptor(ctx, nil, info, handler)
+		}(i)
+	}
+
+	//Wait until the second request joins the operation in progress
+	flightKey := getInFlightKey(info.FullMethod, "key-1", nil)
+	for i := 0; i < 100 && inFlightOperations.duplicates(flightKey) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, "volume-1", results[0])
+	assert.Equal(t, "volume-1", results[1])
+}
+
+func TestIdempotencyInterceptorPayloadAndCancellation(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(idempotencyKeyHeader, "key-3"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+
+	//A reused key with another payload runs its own operation
+	assert.NotEqual(t, getInFlightKey(info.FullMethod, "key-3", &csi.CreateVolumeRequest{Name: "vol1"}), getInFlightKey(info.FullMethod, "key-3", &csi.CreateVolumeRequest{Name: "vol2"}))
+	assert.Equal(t, getInFlightKey(info.FullMethod, "key-3", &csi.CreateVolumeRequest{Name: "vol1"}), getInFlightKey(info.FullMethod, "key-3", &csi.CreateVolumeRequest{Name: "vol1"}))
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return req.(*csi.CreateVolumeRequest).Name, nil
+	}
+	resp, err := idempotencyInterceptor(ctx, &csi.CreateVolumeRequest{Name: "vol2"}, info, handler)
+	assert.Nil(t, err)
+	assert.Equal(t, "vol2", resp)
+
+	//The caller cancelling its request does not cancel the operation shared with the other requests
+	release := make(chan bool)
+	opErr := make(chan error, 1)
+	handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+		<-release
+		opErr <- ctx.Err()
+		return "vol1", nil
+	}
+	callerCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() {
+		_, err := idempotencyInterceptor(callerCtx, &csi.CreateVolumeRequest{Name: "vol1"}, info, handler)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	err = <-done
+	assert.Equal(t, codes.Canceled, status.Code(err))
+	close(release)
+	assert.Nil(t, <-opErr)
+}
+
+func TestRunidIncludesIdempotencyKey(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(idempotencyKeyHeader, "key-2"))
+	_, _, rid := GetRunidLog(ctx)
+	assert.True(t, strings.HasSuffix(rid, "-key-2"), "Expected runid to include the idempotency key but found [%s]", rid)
+}
+
+func TestTraceInterceptor(t *testing.T) {
+	logger := utils.GetLogger()
+	origLevel, origOut := logger.GetLevel(), logger.Out
+	defer func() {
+		logger.SetLevel(origLevel)
+		logger.SetOutput(origOut)
+	}()
+	var out bytes.Buffer
+	logger.SetOutput(&out)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Controller/CreateVolume"}
+	req := &csi.CreateVolumeRequest{Name: "vol1", Secrets: map[string]string{"password": "Password123"}}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &csi.CreateVolumeResponse{Volume: &csi.Volume{VolumeId: "vol1-FC-array1-sv_1"}}, nil
+	}
+
+	//Trace mode logs the request with the secrets redacted and the response
+	logger.SetLevel(logrus.TraceLevel)
+	_, err := traceInterceptor(context.Background(), req, info, handler)
+	assert.Nil(t, err)
+	traced := out.String()
+	assert.True(t, strings.Contains(traced, "/csi.v1.Controller/CreateVolume request"), "Request not traced: %s", traced)
+	assert.True(t, strings.Contains(traced, `"password":"******"`), "Secrets not redacted: %s", traced)
+	assert.False(t, strings.Contains(traced, "Password123"), "Secret value logged: %s", traced)
+	assert.True(t, strings.Contains(traced, "vol1-FC-array1-sv_1"), "Response not traced: %s", traced)
+	assert.Equal(t, "Password123", req.Secrets["password"])
+
+	//Normal mode does not log the payloads
+	out.Reset()
+	logger.SetLevel(logrus.InfoLevel)
+	_, err = traceInterceptor(context.Background(), req, info, handler)
+	assert.Nil(t, err)
+	assert.Equal(t, "", out.String())
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/dell/csi-unity/service/utils"
+	"github.com/dell/gounity"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//defaultJobTimeout is the default time to wait for a Unity job to complete
+const defaultJobTimeout = 5 * time.Minute
+
+//expandLunVolume expands the LUN of the block volume to the size. It is a variable so that tests can override it
+var expandLunVolume = func(ctx context.Context, unity *gounity.Client, volID string, size uint64) error {
+	return gounity.NewVolume(unity).ExpandVolume(ctx, volID, size)
+}
+
+//createThinCloneVolume creates the volume as a thin clone of the snapshot of the block volume. It is a variable so
+//that tests can override it
+var createThinCloneVolume = func(ctx context.Context, unity *gounity.Client, volName, snapshotID, volID string) error {
+	_, err := gounity.NewVolume(unity).CreteLunThinClone(ctx, volName, snapshotID, volID)
+	return err
+}
+
+//getJobTimeout returns the time to wait for a Unity job to complete
+func (s *service) getJobTimeout() time.Duration {
+	if s.opts.JobTimeout <= 0 {
+		return defaultJobTimeout
+	}
+	return time.Duration(s.opts.JobTimeout) * time.Second
+}
+
+//runUnityJob runs the Unity operation, logging in to the array again when the session expired, and waits for its job
+//to complete. gounity waits for the job of the operation, so that the resource is usable when the method returns.
+//DeadlineExceeded is returned when the job does not complete within the job timeout
+func (s *service) runUnityJob(ctx context.Context, arrayID, operation string, op func(ctx context.Context) error) error {
+	rid, log := utils.GetRunidAndLogger(ctx)
+	timeout := s.getJobTimeout()
+	jobCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := s.withReauth(jobCtx, arrayID, func() error {
+		return op(jobCtx)
+	})
+	if err != nil && jobCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return status.Error(codes.DeadlineExceeded, utils.GetMessageWithRunID(rid, "%s did not complete within %v. Its job may still be running on array %s", operation, timeout, arrayID))
+	}
+	if err == nil {
+		log.Debugf("%s completed", operation)
+	}
+	return err
+}
+
+//expandLun expands the LUN of the block volume to the size and waits for the expansion to complete
+func (s *service) expandLun(ctx context.Context, arrayID string, unity *gounity.Client, volID string, size uint64) error {
+	return s.runUnityJob(ctx, arrayID, "Expand volume "+volID, func(ctx context.Context) error {
+		return expandLunVolume(ctx, unity, volID, size)
+	})
+}
+
+//createLunThinClone creates the volume as a thin clone of the snapshot of the block volume and waits for the creation
+//to complete. A retry creates the volume again only when it is not found
+func (s *service) createLunThinClone(ctx context.Context, arrayID string, unity *gounity.Client, volName, snapshotID, volID string) error {
+	var jobCtx context.Context
+	create := createOnce(func() error {
+		return createThinCloneVolume(jobCtx, unity, volName, snapshotID, volID)
+	}, func() bool {
+		volume, _ := gounity.NewVolume(unity).FindVolumeByName(jobCtx, volName)
+		return volume != nil
+	})
+	return s.runUnityJob(ctx, arrayID, "Create volume "+volName+" from snapshot "+snapshotID, func(ctx context.Context) error {
+		jobCtx = ctx
+		return create()
+	})
+}
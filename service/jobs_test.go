@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/dell/gounity"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUnityJobs(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	client, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	s := &service{arrays: new(sync.Map), opts: Opts{AutoProbe: true}}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", UnityClient: client})
+
+	origExpand, origClone := expandLunVolume, createThinCloneVolume
+	defer func() { expandLunVolume, createThinCloneVolume = origExpand, origClone }()
+	var calls []string
+	var jobErr error
+	jobDuration := time.Duration(0)
+	runJob := func(ctx context.Context) error {
+		select {
+		case <-time.After(jobDuration):
+			return jobErr
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	expandLunVolume = func(ctx context.Context, unity *gounity.Client, volID string, size uint64) error {
+		calls = append(calls, "expand "+volID)
+		return runJob(ctx)
+	}
+	createThinCloneVolume = func(ctx context.Context, unity *gounity.Client, volName, snapshotID, volID string) error {
+		calls = append(calls, "clone "+volName+" "+snapshotID+" "+volID)
+		return runJob(ctx)
+	}
+
+	//Jobs completing within the timeout
+	jobDuration = 10 * time.Millisecond
+	assert.Nil(t, s.expandLun(ctx, "array1", client, "sv_1", 1024))
+	assert.Nil(t, s.createLunThinClone(ctx, "array1", client, "vol1", "snap_1", "sv_1"))
+	assert.Equal(t, []string{"expand sv_1", "clone vol1 snap_1 sv_1"}, calls)
+
+	//Failed job
+	jobErr = errors.New("pool is full")
+	err := s.expandLun(ctx, "array1", client, "sv_1", 1024)
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "pool is full"), "Unexpected error message: %v", err)
+
+	//Job not completing within the timeout
+	s.opts.JobTimeout = 1
+	jobErr, jobDuration = nil, time.Minute
+	err = s.expandLun(ctx, "array1", client, "sv_1", 1024)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "did not complete within 1s"), "Unexpected error message: %v", err)
+}
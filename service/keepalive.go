@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/dell/csi-unity/service/utils"
+)
+
+//keepAliveArray issues a lightweight call to the array through its Unity client, so that the connections of the
+//client are not dropped by intermediaries while the array is idle. It is a variable so that tests can override it
+var keepAliveArray = func(ctx context.Context, array *StorageArrayConfig) error {
+	_, _, err := listArrayVolumes(ctx, array.UnityClient, 0, 1)
+	return err
+}
+
+//keepAliveRoutine keeps the connections to the arrays alive at the keepalive interval until the context is done
+func (s *service) keepAliveRoutine(ctx context.Context) {
+	ctx, log := incrementLogId(ctx, "keepalive")
+	interval := time.Duration(s.opts.KeepAliveInterval) * time.Second
+	log.Infof("Starting goroutine to keep the connections to the arrays alive every %v", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.keepAliveArrays(ctx)
+		}
+	}
+}
+
+//keepAliveArrays issues a lightweight call to each logged in array. Arrays in maintenance are skipped
+func (s *service) keepAliveArrays(ctx context.Context) {
+	log := utils.GetRunidLogger(ctx)
+	for _, array := range s.getStorageArrayList() {
+		if !array.IsProbeSuccess || array.UnityClient == nil {
+			continue
+		}
+		if s.isArrayInMaintenance(array.ArrayId) {
+			log.Debugf("Array %s is in maintenance. Keepalive skipped", array.ArrayId)
+			continue
+		}
+		arrayCtx, _ := setArrayIdContext(ctx, array.ArrayId)
+		if err := keepAliveArray(arrayCtx, array); err != nil {
+			log.Warnf("Keepalive of array %s failed. Error: %v", array.ArrayId, utils.GetUnityError(err))
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"github.com/dell/gounity"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeepAliveRoutine(t *testing.T) {
+	origKeepAlive := keepAliveArray
+	defer func() { keepAliveArray = origKeepAlive }()
+	var mutex sync.Mutex
+	calls := make(map[string]int)
+	keepAliveArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		calls[array.ArrayId]++
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	s := &service{arrays: new(sync.Map), opts: Opts{KeepAliveInterval: 1}}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", UnityClient: client, IsProbeSuccess: true})
+	s.arrays.Store("array2", &StorageArrayConfig{ArrayId: "array2", UnityClient: client, IsProbeSuccess: true})
+	s.arrays.Store("array3", &StorageArrayConfig{ArrayId: "array3", UnityClient: client})
+	s.setArrayMaintenance("array2", true)
+	done := make(chan struct{})
+	go func() {
+		s.keepAliveRoutine(ctx)
+		close(done)
+	}()
+
+	//A lightweight call is issued at every interval to the logged in arrays that are not in maintenance
+	time.Sleep(2500 * time.Millisecond)
+	cancel()
+	<-done
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, 2, calls["array1"])
+	assert.Equal(t, 0, calls["array2"], "Arrays in maintenance are skipped")
+	assert.Equal(t, 0, calls["array3"], "Arrays not logged in are skipped")
+}
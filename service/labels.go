@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/csi-unity/service/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//keyArraySelector is the storage class parameter selecting the array of the volumes by the labels of the arrays, as a
+//comma separated list of key=value pairs. It is used when the arrayId parameter is not set
+const keyArraySelector = "arraySelector"
+
+//parseArraySelector returns the labels of the array selector
+func parseArraySelector(selector string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(selector, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		tokens := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(tokens[0])
+		if len(tokens) != 2 || key == "" {
+			return nil, fmt.Errorf("invalid label %s in %s. Labels must be key=value pairs", pair, keyArraySelector)
+		}
+		labels[key] = strings.TrimSpace(tokens[1])
+	}
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("%s has no labels", keyArraySelector)
+	}
+	return labels, nil
+}
+
+//hasLabels returns true when the array has all the labels
+func (a *StorageArrayConfig) hasLabels(labels map[string]string) bool {
+	for key, value := range labels {
+		if arrayValue, ok := a.Labels[key]; !ok || arrayValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+//selectArrayByLabels returns the first array, in arrayId order, that has all the labels of the selector, is allowed
+//by the topology requirement, is not in maintenance or read-only and is reachable. ResourceExhausted is returned when no array matches
+func (s *service) selectArrayByLabels(ctx context.Context, selector string, accessibility *csi.TopologyRequirement) (string, error) {
+	rid, log := utils.GetRunidAndLogger(ctx)
+	labels, err := parseArraySelector(selector)
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "%v", err))
+	}
+
+	allowed := make([]string, 0)
+	for _, topology := range append(accessibility.GetRequisite(), accessibility.GetPreferred()...) {
+		allowed = append(allowed, getTopologyArrayIds(topology)...)
+	}
+	arrays := s.getStorageArrayList()
+	sort.Slice(arrays, func(i, j int) bool { return arrays[i].ArrayId < arrays[j].ArrayId })
+	for _, array := range arrays {
+		if !array.hasLabels(labels) {
+			continue
+		}
+		if len(allowed) > 0 && !utils.ArrayContains(allowed, array.ArrayId) {
+			log.Debugf("Array %s matches %s %s but is not allowed by the topology requirement", array.ArrayId, keyArraySelector, selector)
+			continue
+		}
+		if s.isArrayInMaintenance(array.ArrayId) {
+			log.Debugf("Array %s matches %s %s but is in maintenance", array.ArrayId, keyArraySelector, selector)
+			continue
+		}
+		if array.ReadOnly {
+			log.Debugf("Array %s matches %s %s but is read-only", array.ArrayId, keyArraySelector, selector)
+			continue
+		}
+		if err := s.requireProbe(ctx, array.ArrayId); err != nil {
+			log.Warnf("Array %s matches %s %s but is unreachable. Error: %v", array.ArrayId, keyArraySelector, selector, err)
+			continue
+		}
+		log.Infof("Array %s selected by %s %s", array.ArrayId, keyArraySelector, selector)
+		return array.ArrayId, nil
+	}
+	return "", status.Error(codes.ResourceExhausted, utils.GetMessageWithRunID(rid, "No reachable array matches %s %s", keyArraySelector, selector))
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/gounity"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"testing"
+)
+
+func TestSelectArrayByLabels(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	client, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	s := &service{arrays: new(sync.Map), opts: Opts{AutoProbe: true}}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", UnityClient: client, Labels: map[string]string{"tier": "silver", "region": "east"}})
+	s.arrays.Store("array2", &StorageArrayConfig{ArrayId: "array2", UnityClient: client, Labels: map[string]string{"tier": "gold", "region": "east"}})
+	s.arrays.Store("array3", &StorageArrayConfig{ArrayId: "array3", UnityClient: client, Labels: map[string]string{"tier": "gold", "region": "west"}})
+
+	origAuth := authenticateArray
+	defer func() { authenticateArray = origAuth }()
+	unreachable := ""
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		if array.ArrayId == unreachable {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	//Single label
+	arrayID, err := s.selectArrayByLabels(ctx, "tier=gold", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "array2", arrayID)
+
+	//Multiple labels must all match
+	arrayID, err = s.selectArrayByLabels(ctx, "tier=gold, region=west", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "array3", arrayID)
+
+	//Unreachable, in maintenance, read-only and topology excluded arrays are skipped
+	unreachable = "array2"
+	arrayID, err = s.selectArrayByLabels(ctx, "tier=gold", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "array3", arrayID)
+	unreachable = ""
+	s.setArrayMaintenance("array2", true)
+	arrayID, _ = s.selectArrayByLabels(ctx, "tier=gold", nil)
+	assert.Equal(t, "array3", arrayID)
+	s.setArrayMaintenance("array2", false)
+	s.getStorageArray("array2").ReadOnly = true
+	arrayID, _ = s.selectArrayByLabels(ctx, "tier=gold", nil)
+	assert.Equal(t, "array3", arrayID)
+	s.getStorageArray("array2").ReadOnly = false
+	topology := &csi.TopologyRequirement{Preferred: []*csi.Topology{{Segments: map[string]string{Name + "/array3-fc": "true"}}}}
+	arrayID, _ = s.selectArrayByLabels(ctx, "tier=gold", topology)
+	assert.Equal(t, "array3", arrayID)
+
+	//No match
+	_, err = s.selectArrayByLabels(ctx, "tier=gold,region=north", nil)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	_, err = s.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "vol1", Parameters: map[string]string{keyArraySelector: "tier=platinum"}})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	//Invalid selector
+	_, err = s.selectArrayByLabels(ctx, "tier", nil)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
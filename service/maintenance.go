@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dell/csi-unity/service/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//setArrayMaintenance marks the array as in maintenance, or clears the mark. The mark is kept apart from the array
+//config so that it survives reloads of the driver config
+func (s *service) setArrayMaintenance(arrayId string, enabled bool) {
+	arrayId = normalizeArrayId(arrayId, s.opts.ArrayIdCaseSensitive)
+	if enabled {
+		s.maintenance.Store(arrayId, true)
+	} else {
+		s.maintenance.Delete(arrayId)
+	}
+}
+
+//isArrayInMaintenance returns true when the array is marked as in maintenance
+func (s *service) isArrayInMaintenance(arrayId string) bool {
+	_, ok := s.maintenance.Load(normalizeArrayId(arrayId, s.opts.ArrayIdCaseSensitive))
+	return ok
+}
+
+//requireNotInMaintenance rejects the scheduling of new volumes on an array marked as in maintenance
+func (s *service) requireNotInMaintenance(ctx context.Context, arrayId string) error {
+	rid, _ := utils.GetRunidAndLogger(ctx)
+	if s.isArrayInMaintenance(arrayId) {
+		return status.Error(codes.Unavailable, utils.GetMessageWithRunID(rid, "Array %s is in maintenance mode. New volumes can not be created on it", arrayId))
+	}
+	return nil
+}
+
+//isArrayReadOnly returns true when the array is configured as read-only
+func (s *service) isArrayReadOnly(arrayId string) bool {
+	array := s.getStorageArray(arrayId)
+	return array != nil && array.ReadOnly
+}
+
+//requireWritable rejects the operations creating or growing resources on an array configured as read-only. Deletes and
+//reads are not checked
+func (s *service) requireWritable(ctx context.Context, arrayId, operation string) error {
+	rid, _ := utils.GetRunidAndLogger(ctx)
+	if s.isArrayReadOnly(arrayId) {
+		return status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "Array %s is read-only. %s is not allowed, only deletes and reads are", arrayId, operation))
+	}
+	return nil
+}
+
+//maintenanceHandler serves the admin endpoint marking an array as in maintenance. It expects a POST or PUT request
+//with the arrayId and enabled query parameters
+func (s *service) maintenanceHandler(ctx context.Context) http.HandlerFunc {
+	log := utils.GetRunidLogger(ctx)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		arrayId := strings.TrimSpace(r.URL.Query().Get("arrayId"))
+		enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+		if arrayId == "" || err != nil {
+			http.Error(w, "arrayId and enabled query parameters are required", http.StatusBadRequest)
+			return
+		}
+		if s.getStorageArray(arrayId) == nil {
+			http.Error(w, "array "+arrayId+" is not configured", http.StatusNotFound)
+			return
+		}
+		s.setArrayMaintenance(arrayId, enabled)
+		log.Infof("Maintenance mode of array %s set to %v", arrayId, enabled)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"arrayId": arrayId, "inMaintenance": enabled})
+	}
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/gounity"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//fakeUnityGateway is a fake Unity REST gateway recording the requests reaching the array. It lists no snapshots and
+//answers not found for every other resource
+type fakeUnityGateway struct {
+	*httptest.Server
+	mutex sync.Mutex
+	paths []string
+}
+
+func newFakeUnityGateway() *fakeUnityGateway {
+	gateway := &fakeUnityGateway{paths: make([]string, 0)}
+	gateway.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gateway.mutex.Lock()
+		gateway.paths = append(gateway.paths, r.Method+" "+r.URL.Path)
+		gateway.mutex.Unlock()
+		if r.Method == http.MethodGet && r.URL.Path == "/api/types/snap/instances" {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"entries": []}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	return gateway
+}
+
+//requests returns the method and path of the requests that reached the gateway
+func (g *fakeUnityGateway) requests() []string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return append([]string{}, g.paths...)
+}
+
+//reached returns true when a request on the resource reached the gateway
+func (g *fakeUnityGateway) reached(resourceId string) bool {
+	return strings.Contains(strings.Join(g.requests(), ","), resourceId)
+}
+
+func TestArrayMaintenance(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+
+	gateway := newFakeUnityGateway()
+	defer gateway.Close()
+	client, err := gounity.NewClientWithArgs(ctx, gateway.URL, true)
+	if err != nil {
+		t.Fatalf("Unable to create Unity client: %v", err)
+	}
+
+	origAuth := authenticateArray
+	defer func() { authenticateArray = origAuth }()
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		return nil
+	}
+
+	s := &service{arrays: new(sync.Map), opts: Opts{AutoProbe: true}}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", RestGateway: gateway.URL, Insecure: true, UnityClient: client})
+
+	//The health endpoint does not serve the admin endpoint
+	health := httptest.NewServer(s.healthHandler(ctx))
+	defer health.Close()
+	resp, err := http.Post(health.URL+"/arrays/maintenance?arrayId=array1&enabled=true", "application/json", nil)
+	if assert.Nil(t, err) {
+		resp.Body.Close()
+		assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+	}
+	assert.False(t, s.isArrayInMaintenance("array1"))
+
+	//The admin endpoint is only served on a loopback address
+	assert.True(t, isLoopbackAddress("127.0.0.1:9091"))
+	assert.True(t, isLoopbackAddress("localhost:9091"))
+	assert.True(t, isLoopbackAddress("[::1]:9091"))
+	assert.False(t, isLoopbackAddress(":9091"))
+	assert.False(t, isLoopbackAddress("0.0.0.0:9091"))
+	assert.False(t, isLoopbackAddress("10.0.0.1:9091"))
+
+	//Mark the array as in maintenance through the admin endpoint
+	admin := httptest.NewServer(s.adminHandler(ctx))
+	defer admin.Close()
+	resp, err = http.Post(admin.URL+"/arrays/maintenance?arrayId=ARRAY1&enabled=true", "application/json", nil)
+	if assert.Nil(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	assert.True(t, s.isArrayInMaintenance("array1"))
+	resp, err = http.Get(admin.URL + "/arrays/maintenance?arrayId=array1&enabled=false")
+	if assert.Nil(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+	resp, err = http.Post(admin.URL+"/arrays/maintenance?arrayId=array2&enabled=true", "application/json", nil)
+	if assert.Nil(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+	list := s.getArrayStatusList()
+	assert.True(t, list[0].InMaintenance)
+
+	//CreateVolume does not schedule new volumes on the array
+	_, err = s.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:       "vol1",
+		Parameters: map[string]string{keyArrayId: "array1"},
+	})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, 0, len(gateway.requests()), "CreateVolume reached an array in maintenance")
+
+	//DeleteVolume still reaches the array
+	_, err = s.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "vol1-FC-array1-sv_1"})
+	assert.False(t, err != nil && strings.Contains(err.Error(), "maintenance"), "Unexpected error: %v", err)
+	assert.True(t, gateway.reached("sv_1"), "DeleteVolume did not reach the array in maintenance. Requests: %v", gateway.requests())
+
+	//Clearing the maintenance mark allows CreateVolume again
+	s.setArrayMaintenance("array1", false)
+	_, err = s.CreateVolume(ctx, &csi.CreateVolumeRequest{
+		Name:       "vol1",
+		Parameters: map[string]string{keyArrayId: "array1"},
+	})
+	assert.NotEqual(t, codes.Unavailable, status.Code(err))
+}
+
+func TestReadOnlyArray(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+
+	gateway := newFakeUnityGateway()
+	defer gateway.Close()
+	client, err := gounity.NewClientWithArgs(ctx, gateway.URL, true)
+	if err != nil {
+		t.Fatalf("Unable to create Unity client: %v", err)
+	}
+
+	origAuth := authenticateArray
+	defer func() { authenticateArray = origAuth }()
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		return nil
+	}
+
+	s := &service{arrays: new(sync.Map), opts: Opts{AutoProbe: true}}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", RestGateway: gateway.URL, Insecure: true, UnityClient: client, ReadOnly: true})
+	assert.True(t, s.getArrayStatusList()[0].ReadOnly)
+
+	//Mutating operations are rejected before reaching the array
+	_, err = s.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "vol1", Parameters: map[string]string{keyArrayId: "array1"}})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "read-only"), "Unexpected error message: %v", err)
+	_, err = s.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{Name: "snap1", SourceVolumeId: "vol1-FC-array1-sv_1"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = s.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{VolumeId: "vol1-FC-array1-sv_1", CapacityRange: &csi.CapacityRange{RequiredBytes: 10 * 1024 * 1024 * 1024}})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.False(t, gateway.reached("sv_1"), "Mutating operation reached a read-only array. Requests: %v", gateway.requests())
+
+	//Reads and deletes still reach the array
+	_, err = s.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           "vol1-FC-array1-sv_2",
+		VolumeCapabilities: []*csi.VolumeCapability{{AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER}}},
+	})
+	assert.False(t, err != nil && strings.Contains(err.Error(), "read-only"), "Unexpected error: %v", err)
+	assert.True(t, gateway.reached("sv_2"), "ValidateVolumeCapabilities did not reach the read-only array. Requests: %v", gateway.requests())
+	_, err = s.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "vol1-FC-array1-sv_3"})
+	assert.False(t, err != nil && strings.Contains(err.Error(), "read-only"), "Unexpected error: %v", err)
+	assert.True(t, gateway.reached("sv_3"), "DeleteVolume did not reach the read-only array. Requests: %v", gateway.requests())
+}
@@ -0,0 +1,254 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/csi-unity/service/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	//Latency in seconds of the Unity authentication performed by the array probe
+	metricProbeLatency = "csi_unity_probe_latency_seconds"
+	//Number of malformed volume ids that could not be parsed, labeled by the parsing method
+	metricVolumeIdParseFailures = "csi_unity_volume_id_parse_failures_total"
+	//Number of driver config loads, labeled by result
+	metricConfigReloads = "csi_unity_config_reloads_total"
+	//Latency in seconds of the CSI requests, labeled by method and status code
+	metricRPCLatency = "csi_unity_rpc_latency_seconds"
+	//Number of arrays loaded from the driver config
+	metricArrays = "csi_unity_arrays"
+	//Latency in seconds of the operations on an array, labeled by operation, array id and status code
+	metricArrayOperationLatency = "csi_unity_array_operation_latency_seconds"
+)
+
+//unknownArrayLabel is the array id label of the operations on arrays that are not configured, so that the label values
+//are bounded by the configured arrays
+const unknownArrayLabel = "unknown"
+
+//metricInfo is the type and help text of a metric in the Prometheus text exposition format
+type metricInfo struct {
+	Type string
+	Help string
+}
+
+//metricInfos describes the metrics of the driver
+var metricInfos = map[string]metricInfo{
+	metricProbeLatency:          {"summary", "Latency in seconds of the Unity authentication performed by the array probe"},
+	metricVolumeIdParseFailures: {"counter", "Number of malformed volume ids that could not be parsed"},
+	metricConfigReloads:         {"counter", "Number of driver config loads"},
+	metricRPCLatency:            {"summary", "Latency in seconds of the CSI requests"},
+	metricArrays:                {"gauge", "Number of arrays loaded from the driver config"},
+	metricArrayOperationLatency: {"summary", "Latency in seconds of the operations on an array"},
+}
+
+//metricSummary keeps the number, sum and last value of the observations of a metric
+type metricSummary struct {
+	Count int64   `json:"count"`
+	Sum   float64 `json:"sum"`
+	Last  float64 `json:"last"`
+}
+
+//metricsRegistry is an in-memory registry of the driver metrics. Samples are keyed by the metric
+//name and the label pairs of the sample
+type metricsRegistry struct {
+	mutex     sync.Mutex
+	summaries map[string]map[string]*metricSummary
+}
+
+//driverMetrics holds the metrics of the driver process
+var driverMetrics = newMetricsRegistry()
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		summaries: make(map[string]map[string]*metricSummary),
+	}
+}
+
+//labelKey builds the sample key from label name and value pairs
+func labelKey(labels ...string) string {
+	pairs := make([]string, 0)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+//observe records a value for the metric sample identified by name and labels
+func (m *metricsRegistry) observe(name string, value float64, labels ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	samples, ok := m.summaries[name]
+	if !ok {
+		samples = make(map[string]*metricSummary)
+		m.summaries[name] = samples
+	}
+	key := labelKey(labels...)
+	sample, ok := samples[key]
+	if !ok {
+		sample = &metricSummary{}
+		samples[key] = sample
+	}
+	sample.Count++
+	sample.Sum += value
+	sample.Last = value
+}
+
+//getSummary returns a copy of the metric sample identified by name and labels
+func (m *metricsRegistry) getSummary(name string, labels ...string) (metricSummary, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if sample, ok := m.summaries[name][labelKey(labels...)]; ok {
+		return *sample, true
+	}
+	return metricSummary{}, false
+}
+
+//snapshot returns a copy of all the metric samples
+func (m *metricsRegistry) snapshot() map[string]map[string]metricSummary {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	result := make(map[string]map[string]metricSummary)
+	for name, samples := range m.summaries {
+		result[name] = make(map[string]metricSummary)
+		for key, sample := range samples {
+			result[name][key] = *sample
+		}
+	}
+	return result
+}
+
+//writeText writes the metric samples and the given gauges in the Prometheus text exposition format
+func (m *metricsRegistry) writeText(w io.Writer, gauges map[string]float64) error {
+	samples := m.snapshot()
+	names := make([]string, 0)
+	for name := range samples {
+		names = append(names, name)
+	}
+	for name := range gauges {
+		if _, ok := samples[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		info, ok := metricInfos[name]
+		if !ok {
+			info = metricInfo{Type: "untyped", Help: name}
+		}
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, info.Help, name, info.Type); err != nil {
+			return err
+		}
+		if value, ok := gauges[name]; ok {
+			if _, err := fmt.Fprintf(w, "%s %v\n", name, value); err != nil {
+				return err
+			}
+			continue
+		}
+		keys := make([]string, 0)
+		for key := range samples[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			sample := samples[name][key]
+			labels := ""
+			if key != "" {
+				labels = "{" + key + "}"
+			}
+			var err error
+			if info.Type == "summary" {
+				_, err = fmt.Fprintf(w, "%s_sum%s %v\n%s_count%s %d\n", name, labels, sample.Sum, name, labels, sample.Count)
+			} else {
+				_, err = fmt.Fprintf(w, "%s%s %d\n", name, labels, sample.Count)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//recordConfigReload counts a load of the driver config
+func recordConfigReload(err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	driverMetrics.observe(metricConfigReloads, 1, "result", result)
+}
+
+//metricsInterceptor records the latency of the CSI requests and counts the requests in progress
+func metricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	inFlightRequests.add(info.FullMethod, 1)
+	defer inFlightRequests.add(info.FullMethod, -1)
+	resp, err := handler(ctx, req)
+	driverMetrics.observe(metricRPCLatency, time.Since(start).Seconds(), "method", info.FullMethod, "code", status.Code(err).String())
+	return resp, err
+}
+
+//recordVolumeIdParseFailure counts a malformed volume id that the given method could not parse
+func recordVolumeIdParseFailure(method string) {
+	driverMetrics.observe(metricVolumeIdParseFailures, 1, "method", method)
+}
+
+//recordProbeLatency records the authentication latency of the probe for the given array
+func recordProbeLatency(ctx context.Context, arrayId string, latency time.Duration) {
+	log := utils.GetRunidLogger(ctx)
+	driverMetrics.observe(metricProbeLatency, latency.Seconds(), "arrayId", arrayId)
+	log.Debugf("Probe latency for array %s: %v", arrayId, latency)
+}
+
+//recordArrayOperation records the latency and the result of the operation on the array when the per-array metrics are
+//enabled
+func (s *service) recordArrayOperation(operation, arrayId string, start time.Time, err error) {
+	if !s.opts.PerArrayMetrics {
+		return
+	}
+	label := unknownArrayLabel
+	if array := s.getStorageArray(normalizeArrayId(arrayId, s.opts.ArrayIdCaseSensitive)); array != nil {
+		label = array.ArrayId
+	}
+	driverMetrics.observe(metricArrayOperationLatency, time.Since(start).Seconds(), "operation", operation, "arrayId", label, "code", status.Code(err).String())
+}
+
+//getArrayIdOfVolumeId returns the array id encoded in the volume id, or an empty string when it has none
+func getArrayIdOfVolumeId(volumeId string) string {
+	tokens := strings.Split(volumeId, "-")
+	if len(tokens) < 4 {
+		return ""
+	}
+	return tokens[len(tokens)-2]
+}
+
+//arrayMetricsInterceptor records the latency and the result of CreateVolume and DeleteVolume by array id. The array of
+//a created volume is read from its volume id, as the volume may be placed on another array than the requested one
+func (s *service) arrayMetricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !s.opts.PerArrayMetrics {
+		return handler(ctx, req)
+	}
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	switch r := req.(type) {
+	case *csi.CreateVolumeRequest:
+		arrayId := strings.TrimSpace(r.GetParameters()[keyArrayId])
+		if volumeResp, ok := resp.(*csi.CreateVolumeResponse); ok && volumeResp.GetVolume() != nil {
+			arrayId = getArrayIdOfVolumeId(volumeResp.GetVolume().GetVolumeId())
+		}
+		s.recordArrayOperation("CreateVolume", arrayId, start, err)
+	case *csi.DeleteVolumeRequest:
+		s.recordArrayOperation("DeleteVolume", getArrayIdOfVolumeId(r.GetVolumeId()), start, err)
+	}
+	return resp, err
+}
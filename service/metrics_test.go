@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/gounity"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"testing"
+)
+
+func TestProbeRecordsLatency(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	client, err := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	if err != nil {
+		t.Fatalf("Unable to create unity client: %v", err)
+	}
+	array := &StorageArrayConfig{ArrayId: "latency-array", Username: "user", Password: "pwd", RestGateway: "https://127.0.0.1:1", UnityClient: client}
+
+	_ = singleArrayProbe(ctx, "Test", array)
+	sample, ok := driverMetrics.getSummary(metricProbeLatency, "arrayId", "latency-array")
+	assert.True(t, ok, "Probe latency not recorded for the probed array")
+	assert.Equal(t, int64(1), sample.Count)
+	assert.True(t, sample.Last > 0, "Expected non-zero probe latency but found %v", sample.Last)
+
+	_, ok = driverMetrics.getSummary(metricProbeLatency, "arrayId", "other-array")
+	assert.False(t, ok, "Probe latency recorded for an array that was not probed")
+}
+
+func TestVolumeIdParseFailureMetric(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	s := &service{arrays: new(sync.Map)}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1"})
+	count := func(method string) int64 {
+		sample, _ := driverMetrics.getSummary(metricVolumeIdParseFailures, "method", method)
+		return sample.Count
+	}
+	before := count("getVolumeIdFromVolumeContext")
+
+	//Malformed id increments the counter of the failing method
+	_, _, _, _, err := s.validateAndGetResourceDetails(ctx, "vol1-FC", volumeType)
+	assert.NotNil(t, err)
+	assert.Equal(t, before+1, count("getVolumeIdFromVolumeContext"))
+
+	beforeArray, beforeProtocol := count("getArrayIdFromVolumeContext"), count("getProtocolFromVolumeContext")
+	_, err = s.getArrayIdFromVolumeContext("vol1-FC")
+	assert.NotNil(t, err)
+	_, err = s.getProtocolFromVolumeContext("vol1-FC")
+	assert.NotNil(t, err)
+	assert.Equal(t, beforeArray+1, count("getArrayIdFromVolumeContext"))
+	assert.Equal(t, beforeProtocol+1, count("getProtocolFromVolumeContext"))
+
+	//Well formed and legacy ids are not counted
+	before = count("getVolumeIdFromVolumeContext")
+	assert.Equal(t, "sv_1", getVolumeIdFromVolumeContext("vol1-FC-array1-sv_1"))
+	assert.Equal(t, "sv_1", getVolumeIdFromVolumeContext("sv_1"))
+	assert.Equal(t, before, count("getVolumeIdFromVolumeContext"))
+}
+
+func TestPerArrayOperationMetrics(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	s := &service{arrays: new(sync.Map), opts: Opts{PerArrayMetrics: true}}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1"})
+	s.arrays.Store("array2", &StorageArrayConfig{ArrayId: "array2"})
+	count := func(operation, arrayId, code string) int64 {
+		sample, _ := driverMetrics.getSummary(metricArrayOperationLatency, "operation", operation, "arrayId", arrayId, "code", code)
+		return sample.Count
+	}
+	beforeCreate, beforeDelete := count("CreateVolume", "array1", "OK"), count("DeleteVolume", "array2", "Internal")
+	beforeUnknown := count("DeleteVolume", unknownArrayLabel, "OK")
+
+	info := &grpc.UnaryServerInfo{}
+	created := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &csi.CreateVolumeResponse{Volume: &csi.Volume{VolumeId: "vol1-FC-array1-sv_1"}}, nil
+	}
+	failed := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.Internal, "delete failed")
+	}
+	deleted := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	//Operations against two arrays are recorded in separately labeled samples
+	_, err := s.arrayMetricsInterceptor(ctx, &csi.CreateVolumeRequest{Name: "vol1", Parameters: map[string]string{keyArrayId: "array2"}}, info, created)
+	assert.Nil(t, err)
+	_, err = s.arrayMetricsInterceptor(ctx, &csi.DeleteVolumeRequest{VolumeId: "vol2-FC-array2-sv_2"}, info, failed)
+	assert.NotNil(t, err)
+	assert.Equal(t, beforeCreate+1, count("CreateVolume", "array1", "OK"), "CreateVolume not recorded on the array of the volume")
+	assert.Equal(t, beforeDelete+1, count("DeleteVolume", "array2", "Internal"))
+	assert.Equal(t, int64(0), count("CreateVolume", "array2", "OK"))
+
+	//Array ids that are not configured share a single label value
+	_, err = s.arrayMetricsInterceptor(ctx, &csi.DeleteVolumeRequest{VolumeId: "vol3-FC-crafted-sv_3"}, info, deleted)
+	assert.Nil(t, err)
+	assert.Equal(t, beforeUnknown+1, count("DeleteVolume", unknownArrayLabel, "OK"))
+	assert.Equal(t, int64(0), count("DeleteVolume", "crafted", "OK"))
+
+	//Nothing is recorded when the per-array metrics are disabled
+	s.opts.PerArrayMetrics = false
+	_, _ = s.arrayMetricsInterceptor(ctx, &csi.DeleteVolumeRequest{VolumeId: "vol2-FC-array2-sv_2"}, info, failed)
+	assert.Equal(t, beforeDelete+1, count("DeleteVolume", "array2", "Internal"))
+}
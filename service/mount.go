@@ -8,7 +8,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
@@ -17,6 +19,20 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+const (
+	//Volume context keys for the group ownership applied to the volume at node publish
+	keyFsGroup             = "fsGroup"
+	keyFsGroupChangePolicy = "fsGroupChangePolicy"
+
+	//FsGroupChangeAlways changes the group ownership of all the files of the volume at every publish
+	FsGroupChangeAlways = "Always"
+	//FsGroupChangeOnRootMismatch changes the group ownership only when the root of the volume has a different group
+	FsGroupChangeOnRootMismatch = "OnRootMismatch"
+)
+
+//lchown changes the group ownership of a file. It is a variable so that tests can override it
+var lchown = os.Lchown
+
 // Device is a struct for holding details about a block device
 type Device struct {
 	FullPath string
@@ -206,7 +222,7 @@ func publishNFS(ctx context.Context, req *csi.NodePublishVolumeRequest, exportPa
 	//Proceeding to perform bind mount to target path
 	if nfsv4 || mountOverride {
 		nfsv4 = false
-		err = gofsutil.BindMount(ctx, stagingTargetPath, targetPath, rwoArray...)
+		err = bindMountWithRetry(ctx, stagingTargetPath, targetPath, rwoArray...)
 		if err == nil {
 			nfsv4 = true
 		}
@@ -215,7 +231,7 @@ func publishNFS(ctx context.Context, req *csi.NodePublishVolumeRequest, exportPa
 	if nfsv3 && !nfsv4 && !mountOverride {
 		rwo += ",vers=3"
 		rwoArray = append(rwoArray, "vers=3")
-		err = gofsutil.BindMount(ctx, stagingTargetPath, targetPath, rwoArray...)
+		err = bindMountWithRetry(ctx, stagingTargetPath, targetPath, rwoArray...)
 	}
 
 	if err != nil {
@@ -224,6 +240,15 @@ func publishNFS(ctx context.Context, req *csi.NodePublishVolumeRequest, exportPa
 	return nil
 }
 
+//getStageFsType returns the filesystem type a block volume is formatted with for the requested fsType. Unsupported
+//and empty values use the default ext4
+func getStageFsType(fs string) string {
+	if fs != "ext3" && fs != "ext4" && fs != "xfs" {
+		return "ext4"
+	}
+	return fs
+}
+
 func stageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest, stagingPath, symlinkPath string) error {
 	rid, log := utils.GetRunidAndLogger(ctx)
 
@@ -284,9 +309,9 @@ func stageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest, stagingPa
 
 			log.Debugf("Stage - Mount flags for Volume: %s", mntFlags)
 
-			if fs != "ext3" && fs != "ext4" && fs != "xfs" {
+			if getStageFsType(fs) != fs {
 				log.Info("Using default FS Type ext4 since no FS Type is provided")
-				fs = "ext4"
+				fs = getStageFsType(fs)
 			}
 
 			if fs == "xfs" {
@@ -418,7 +443,7 @@ func publishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest, targe
 
 	log.Debugf("Publish - Mount flags for Volume: %s", mntFlags)
 
-	if err := gofsutil.BindMount(ctx, stagingPath, targetPath, mntFlags...); err != nil {
+	if err := bindMountWithRetry(ctx, stagingPath, targetPath, mntFlags...); err != nil {
 		return status.Error(codes.Internal, utils.GetMessageWithRunID(rid, "error publish volume to target path: %v", err))
 	}
 
@@ -904,9 +929,56 @@ func mountBlock(ctx context.Context, device *Device, target string, mntFlags []s
 		}
 	}
 
-	err = gofsutil.BindMount(ctx, device.RealDev, target, mntFlags...)
+	err = bindMountWithRetry(ctx, device.RealDev, target, mntFlags...)
 	if err != nil {
 		return status.Error(codes.Internal, utils.GetMessageWithRunID(rid, "Block Mount error: bind mounting to target path: %s", target))
 	}
 	return nil
 }
+
+//applyFsGroup changes the group ownership of the files on the target path to the fsGroup in the volume context when
+//a fsGroupChangePolicy is requested. Without a policy the ownership is left unchanged. With the OnRootMismatch policy
+//the recursive change is skipped when the root directory already has the fsGroup
+func applyFsGroup(ctx context.Context, targetPath string, volumeContext map[string]string) error {
+	rid, log := utils.GetRunidAndLogger(ctx)
+	fsGroup, ok := volumeContext[keyFsGroup]
+	if !ok || fsGroup == "" {
+		return nil
+	}
+	gid, err := strconv.Atoi(fsGroup)
+	if err != nil || gid < 0 {
+		return status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Invalid value for %s: %s", keyFsGroup, fsGroup))
+	}
+
+	policy := volumeContext[keyFsGroupChangePolicy]
+	if policy == "" {
+		log.Debugf("No %s requested. Leaving the group ownership of target path %s unchanged", keyFsGroupChangePolicy, targetPath)
+		return nil
+	}
+	if policy != FsGroupChangeAlways && policy != FsGroupChangeOnRootMismatch {
+		return status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Invalid value for %s: %s", keyFsGroupChangePolicy, policy))
+	}
+
+	if policy == FsGroupChangeOnRootMismatch {
+		info, err := os.Stat(targetPath)
+		if err != nil {
+			return status.Error(codes.Internal, utils.GetMessageWithRunID(rid, "Unable to stat target path %s. Error: %v", targetPath, err))
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Gid) == gid {
+			log.Debugf("Root of target path %s already has group %d. Skipping recursive ownership change", targetPath, gid)
+			return nil
+		}
+	}
+
+	log.Debugf("Changing group ownership of target path %s to %d", targetPath, gid)
+	err = filepath.Walk(targetPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return lchown(path, -1, gid)
+	})
+	if err != nil {
+		return status.Error(codes.Internal, utils.GetMessageWithRunID(rid, "Unable to change group ownership of target path %s. Error: %v", targetPath, err))
+	}
+	return nil
+}
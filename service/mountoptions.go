@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/csi-unity/service/utils"
+)
+
+//opposingMountOptions maps the mount options to the option they are the opposite of, so that they conflict
+var opposingMountOptions = map[string]string{
+	"rw":      "ro",
+	"soft":    "hard",
+	"async":   "sync",
+	"nfsvers": "vers",
+}
+
+//getMountOptionKey returns the key of the mount option, shared by the options that conflict with each other, e.g.
+//timeo=600 and timeo=30, noatime and atime, or soft and hard
+func getMountOptionKey(option string) string {
+	key := strings.TrimSpace(option)
+	if i := strings.Index(key, "="); i >= 0 {
+		key = key[:i]
+	}
+	if opposite, ok := opposingMountOptions[key]; ok {
+		return opposite
+	}
+	if strings.HasPrefix(key, "no") && len(key) > 2 {
+		return key[2:]
+	}
+	return key
+}
+
+//parseMountOptions returns the mount options of the comma separated list
+func parseMountOptions(value string) []string {
+	options := make([]string, 0)
+	for _, option := range strings.Split(value, ",") {
+		if option = strings.TrimSpace(option); option != "" {
+			options = append(options, option)
+		}
+	}
+	return options
+}
+
+//mergeMountOptions returns the default mount options that do not conflict with the user mount options, followed by
+//the user mount options, so that the user mount options take precedence
+func mergeMountOptions(defaults, user []string) []string {
+	userKeys := make(map[string]bool, len(user))
+	for _, option := range user {
+		userKeys[getMountOptionKey(option)] = true
+	}
+	merged := make([]string, 0, len(defaults)+len(user))
+	for _, option := range defaults {
+		if !userKeys[getMountOptionKey(option)] {
+			merged = append(merged, option)
+		}
+	}
+	return append(merged, user...)
+}
+
+//setDefaultMountFlags merges the default mount options of the protocol into the mount flags of the volume capability.
+//Raw block volumes are not mounted and keep their flags
+func (s *service) setDefaultMountFlags(ctx context.Context, volCap *csi.VolumeCapability, protocol string) {
+	mount := volCap.GetMount()
+	defaults := s.opts.DefaultMountOptions[protocol]
+	if mount == nil || len(defaults) == 0 {
+		return
+	}
+	mount.MountFlags = mergeMountOptions(defaults, mount.MountFlags)
+	utils.GetRunidLogger(ctx).Debugf("Mount flags with the %s default mount options: %v", protocol, mount.MountFlags)
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"context"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDefaultMountOptions(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	s := &service{opts: Opts{DefaultMountOptions: map[string][]string{
+		NFS:   parseMountOptions(" hard, timeo=600,,nfsvers=4.1"),
+		ISCSI: parseMountOptions("_netdev,noatime"),
+	}}}
+	mountCap := func(flags ...string) *csi.VolumeCapability {
+		return &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: flags}}}
+	}
+
+	//Defaults apply when the user provides no mount flags
+	volCap := mountCap()
+	s.setDefaultMountFlags(ctx, volCap, NFS)
+	assert.Equal(t, []string{"hard", "timeo=600", "nfsvers=4.1"}, volCap.GetMount().GetMountFlags())
+	volCap = mountCap()
+	s.setDefaultMountFlags(ctx, volCap, ISCSI)
+	assert.Equal(t, []string{"_netdev", "noatime"}, volCap.GetMount().GetMountFlags())
+
+	//User mount flags override the conflicting defaults and are kept along with the others
+	volCap = mountCap("soft", "timeo=30", "vers=3", "nolock")
+	s.setDefaultMountFlags(ctx, volCap, NFS)
+	assert.Equal(t, []string{"soft", "timeo=30", "vers=3", "nolock"}, volCap.GetMount().GetMountFlags())
+	volCap = mountCap("atime")
+	s.setDefaultMountFlags(ctx, volCap, ISCSI)
+	assert.Equal(t, []string{"_netdev", "atime"}, volCap.GetMount().GetMountFlags())
+
+	//Protocols without defaults and raw block volumes are unchanged
+	volCap = mountCap("discard")
+	s.setDefaultMountFlags(ctx, volCap, FC)
+	assert.Equal(t, []string{"discard"}, volCap.GetMount().GetMountFlags())
+	blockCap := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}}
+	s.setDefaultMountFlags(ctx, blockCap, ISCSI)
+	assert.Nil(t, blockCap.GetMount())
+}
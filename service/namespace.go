@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/csi-unity/service/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	//keyNamespace is the storage class parameter placing the volumes of a namespace on the same eligible array when the
+	//arrayId parameter is not set
+	keyNamespace = "namespace"
+	//keyPVCNamespace is the namespace of the PVC passed by the external provisioner with --extra-create-metadata
+	keyPVCNamespace = "csi.storage.k8s.io/pvc/namespace"
+)
+
+//getRequestNamespace returns the namespace of the volume from the namespace parameter, or else from the PVC metadata
+func getRequestNamespace(params map[string]string) string {
+	if namespace := strings.TrimSpace(params[keyNamespace]); namespace != "" {
+		return namespace
+	}
+	return strings.TrimSpace(params[keyPVCNamespace])
+}
+
+//namespaceArrayScore returns the score of the array for the namespace. The namespace prefers the eligible array with
+//the highest score, so that it keeps its array when other arrays are added or removed
+func namespaceArrayScore(namespace, arrayID string) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(namespace + "/" + arrayID))
+	return hash.Sum32()
+}
+
+//selectArrayByNamespace returns the array preferred by the namespace among the arrays that match the array selector,
+//are allowed by the topology requirement and are not in maintenance or read-only. The next preferred array is returned
+//when the preferred array is unreachable. ResourceExhausted is returned when no eligible array is reachable
+func (s *service) selectArrayByNamespace(ctx context.Context, namespace, selector string, accessibility *csi.TopologyRequirement) (string, error) {
+	rid, log := utils.GetRunidAndLogger(ctx)
+	var labels map[string]string
+	if selector != "" {
+		var err error
+		if labels, err = parseArraySelector(selector); err != nil {
+			return "", status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "%v", err))
+		}
+	}
+	allowed := make([]string, 0)
+	for _, topology := range append(accessibility.GetRequisite(), accessibility.GetPreferred()...) {
+		allowed = append(allowed, getTopologyArrayIds(topology)...)
+	}
+
+	arrays := s.getStorageArrayList()
+	sort.Slice(arrays, func(i, j int) bool {
+		return namespaceArrayScore(namespace, arrays[i].ArrayId) > namespaceArrayScore(namespace, arrays[j].ArrayId)
+	})
+	for _, array := range arrays {
+		if labels != nil && !array.hasLabels(labels) {
+			continue
+		}
+		if len(allowed) > 0 && !utils.ArrayContains(allowed, array.ArrayId) {
+			log.Debugf("Array %s is not allowed by the topology requirement", array.ArrayId)
+			continue
+		}
+		if s.isArrayInMaintenance(array.ArrayId) {
+			log.Debugf("Array %s preferred by namespace %s is in maintenance", array.ArrayId, namespace)
+			continue
+		}
+		if array.ReadOnly {
+			log.Debugf("Array %s preferred by namespace %s is read-only", array.ArrayId, namespace)
+			continue
+		}
+		if err := s.requireProbe(ctx, array.ArrayId); err != nil {
+			log.Warnf("Array %s preferred by namespace %s is unreachable. Error: %v", array.ArrayId, namespace, err)
+			continue
+		}
+		log.Infof("Array %s selected for namespace %s", array.ArrayId, namespace)
+		return array.ArrayId, nil
+	}
+	return "", status.Error(codes.ResourceExhausted, utils.GetMessageWithRunID(rid, "No reachable array is eligible for namespace %s", namespace))
+}
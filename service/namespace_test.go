@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/gounity"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSelectArrayByNamespace(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	client, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	s := &service{arrays: new(sync.Map), opts: Opts{AutoProbe: true}}
+	for _, arrayID := range []string{"array1", "array2", "array3", "array4"} {
+		s.arrays.Store(arrayID, &StorageArrayConfig{ArrayId: arrayID, UnityClient: client, Labels: map[string]string{"tier": "gold"}})
+	}
+	s.getStorageArray("array4").Labels = map[string]string{"tier": "silver"}
+
+	origAuth := authenticateArray
+	defer func() { authenticateArray = origAuth }()
+	unreachable := ""
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		if array.ArrayId == unreachable {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	//The same namespace consistently maps to the same eligible array
+	selected := make(map[string]bool)
+	for _, namespace := range []string{"team-a", "team-b", "team-c", "team-d", "team-e", "team-f", "team-g", "team-h"} {
+		arrayID, err := s.selectArrayByNamespace(ctx, namespace, "tier=gold", nil)
+		assert.Nil(t, err)
+		assert.NotEqual(t, "array4", arrayID)
+		for i := 0; i < 5; i++ {
+			again, err := s.selectArrayByNamespace(ctx, namespace, "tier=gold", nil)
+			assert.Nil(t, err)
+			assert.Equal(t, arrayID, again, "Namespace %s moved to another array", namespace)
+		}
+		selected[arrayID] = true
+	}
+	assert.True(t, len(selected) > 1, "All namespaces mapped to the same array")
+
+	//The namespace keeps its array when another array is removed
+	arrayID, _ := s.selectArrayByNamespace(ctx, "team-a", "", nil)
+	for _, other := range []string{"array1", "array2", "array3", "array4"} {
+		if other == arrayID {
+			continue
+		}
+		array := s.getStorageArray(other)
+		s.arrays.Delete(other)
+		again, _ := s.selectArrayByNamespace(ctx, "team-a", "", nil)
+		assert.Equal(t, arrayID, again)
+		s.arrays.Store(other, array)
+	}
+
+	//Unreachable, read-only and topology excluded arrays are skipped
+	unreachable = arrayID
+	again, err := s.selectArrayByNamespace(ctx, "team-a", "", nil)
+	assert.Nil(t, err)
+	assert.NotEqual(t, arrayID, again)
+	unreachable = ""
+	s.getStorageArray(arrayID).ReadOnly = true
+	again, err = s.selectArrayByNamespace(ctx, "team-a", "", nil)
+	assert.Nil(t, err)
+	assert.NotEqual(t, arrayID, again)
+	s.getStorageArray(arrayID).ReadOnly = false
+	topology := &csi.TopologyRequirement{Preferred: []*csi.Topology{{Segments: map[string]string{Name + "/array4-fc": "true"}}}}
+	again, _ = s.selectArrayByNamespace(ctx, "team-a", "", topology)
+	assert.Equal(t, "array4", again)
+	_, err = s.selectArrayByNamespace(ctx, "team-a", "tier=gold", topology)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	//Namespace from the PVC metadata, overridden by the namespace parameter
+	assert.Equal(t, "team-a", getRequestNamespace(map[string]string{keyPVCNamespace: "team-a"}))
+	assert.Equal(t, "tenant", getRequestNamespace(map[string]string{keyPVCNamespace: "team-a", keyNamespace: "tenant"}))
+
+	//An explicit array pin is honored
+	s.setArrayMaintenance("array1", true)
+	s.setArrayMaintenance("array2", true)
+	s.setArrayMaintenance("array3", true)
+	s.setArrayMaintenance("array4", true)
+	_, err = s.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "vol1", Parameters: map[string]string{keyNamespace: "team-a"}})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	_, err = s.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "vol1", Parameters: map[string]string{keyNamespace: "team-a", keyArrayId: "array2"}})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "Array array2 is in maintenance"), "Unexpected error message: %v", err)
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/csi-unity/service/utils"
+	"github.com/dell/gounity"
+	"github.com/dell/gounity/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	//keyAccessPolicy is the storage class parameter setting the access granted to the hosts on the NFS share of the volume
+	keyAccessPolicy = "accessPolicy"
+
+	//NFS access policies. root grants the hosts root access, rootSquash maps the root user of the hosts to an anonymous
+	//user and readOnly grants the hosts read-only access with the root user squashed, whatever the access mode
+	NFSAccessRoot       = "root"
+	NFSAccessRootSquash = "rootSquash"
+	NFSAccessReadOnly   = "readOnly"
+)
+
+//getNFSAccessPolicy returns the NFS access policy of the accessPolicy value, root when it is not set, or an error when
+//the value is not supported
+func getNFSAccessPolicy(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return NFSAccessRoot, nil
+	}
+	for _, policy := range []string{NFSAccessRoot, NFSAccessRootSquash, NFSAccessReadOnly} {
+		if strings.EqualFold(value, policy) {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("invalid value %s for %s. Supported values are %s, %s and %s", value, keyAccessPolicy, NFSAccessRoot, NFSAccessRootSquash, NFSAccessReadOnly)
+}
+
+//getNFSHostAccessType returns the access type granted to the host on the NFS share for the access policy and mode
+func getNFSHostAccessType(policy string, am *csi.VolumeCapability_AccessMode) gounity.AccessType {
+	readOnly := am.GetMode() == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+	switch {
+	case policy == NFSAccessReadOnly:
+		return gounity.ReadOnlyAccessType
+	case policy == NFSAccessRootSquash && readOnly:
+		return gounity.ReadOnlyAccessType
+	case policy == NFSAccessRootSquash:
+		return gounity.ReadWriteAccessType
+	case readOnly:
+		return gounity.ReadOnlyRootAccessType
+	}
+	return gounity.ReadWriteRootAccessType
+}
+
+//setNFSAccessPolicyContext passes the accessPolicy storage class parameter to the controller and node publish requests
+//in the volume context
+func setNFSAccessPolicyContext(volumeResp *csi.CreateVolumeResponse, params map[string]string) {
+	if value := strings.TrimSpace(params[keyAccessPolicy]); value != "" {
+		volumeResp.Volume.VolumeContext[keyAccessPolicy] = value
+	}
+}
+
+//nfsShareLocks serializes the host access updates of each NFS share, as an update replaces the whole host list of an
+//access type and concurrent updates for different nodes would otherwise drop each other's hosts
+var nfsShareLocks sync.Map
+
+//lockNFSShare locks the host access of the NFS share and returns the function that unlocks it
+func lockNFSShare(nfsShareID string) func() {
+	lock, _ := nfsShareLocks.LoadOrStore(nfsShareID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	return lock.(*sync.Mutex).Unlock
+}
+
+//modifyNFSShareHostAccess replaces the hosts with the access type on the NFS share. It is a variable so that tests can override it
+var modifyNFSShareHostAccess = func(ctx context.Context, unity *gounity.Client, volID, nfsShareID string, isSnapshot bool, hostIDs []string, accessType gounity.AccessType) error {
+	fileAPI := gounity.NewFilesystem(unity)
+	if isSnapshot {
+		return fileAPI.ModifyNFSShareCreatedFromSnapshotHostAccess(ctx, nfsShareID, hostIDs, accessType)
+	}
+	return fileAPI.ModifyNFSShareHostAccess(ctx, volID, nfsShareID, hostIDs, accessType)
+}
+
+//findNFSShareHostIDs returns the IDs of the hosts with the access type on the NFS share. It is a variable so that tests can override it
+var findNFSShareHostIDs = func(ctx context.Context, unity *gounity.Client, nfsShareID string, accessType gounity.AccessType) ([]string, error) {
+	nfsShare, err := gounity.NewFilesystem(unity).FindNFSShareById(ctx, nfsShareID)
+	if err != nil {
+		return nil, err
+	}
+	return getNFSShareHostIDs(nfsShare)[accessType], nil
+}
+
+//getNFSShareHostIDs returns the IDs of the hosts with access on the NFS share by access type
+func getNFSShareHostIDs(nfsShare *types.NFSShare) map[gounity.AccessType][]string {
+	hostIDs := make(map[gounity.AccessType][]string)
+	for _, host := range nfsShare.NFSShareContent.ReadOnlyHosts {
+		hostIDs[gounity.ReadOnlyAccessType] = append(hostIDs[gounity.ReadOnlyAccessType], host.ID)
+	}
+	for _, host := range nfsShare.NFSShareContent.ReadWriteHosts {
+		hostIDs[gounity.ReadWriteAccessType] = append(hostIDs[gounity.ReadWriteAccessType], host.ID)
+	}
+	for _, host := range nfsShare.NFSShareContent.ReadOnlyRootAccessHosts {
+		hostIDs[gounity.ReadOnlyRootAccessType] = append(hostIDs[gounity.ReadOnlyRootAccessType], host.ID)
+	}
+	for _, host := range nfsShare.NFSShareContent.RootAccessHosts {
+		hostIDs[gounity.ReadWriteRootAccessType] = append(hostIDs[gounity.ReadWriteRootAccessType], host.ID)
+	}
+	return hostIDs
+}
+
+//grantNFSShareHostAccess adds the host to the hosts with the access type on the NFS share. A failed update leaves the
+//hosts that already have access untouched, and is verified against the share so that an update applied despite the
+//error succeeds. The update is retried up to the configured number of times with the hosts currently on the share,
+//after which a retriable error is returned for the host
+func (s *service) grantNFSShareHostAccess(ctx context.Context, unity *gounity.Client, volID, nfsShareID, hostID, nodeID string, isSnapshot bool, hostIDs []string, accessType gounity.AccessType) error {
+	ctx, log, rid := GetRunidLog(ctx)
+	for attempt := 0; ; attempt++ {
+		err := modifyNFSShareHostAccess(ctx, unity, volID, nfsShareID, isSnapshot, append(append([]string{}, hostIDs...), hostID), accessType)
+		if err == nil {
+			return nil
+		}
+		granted, findErr := findNFSShareHostIDs(ctx, unity, nfsShareID, accessType)
+		if findErr == nil {
+			if utils.ArrayContains(granted, hostID) {
+				log.Infof("Host %s has access %s on NFS Share %s although the update reported an error: %v", nodeID, accessType, nfsShareID, err)
+				return nil
+			}
+			hostIDs = granted
+		}
+		if attempt >= s.opts.NFSHostAccessRetries {
+			return status.Error(codes.Unavailable, utils.GetMessageWithRunID(rid, "Allocating host %s access to NFS Share %s failed. Hosts that already have access are not affected. Error: %v", nodeID, nfsShareID, utils.GetUnityError(err)))
+		}
+		log.Warnf("Allocating host %s access to NFS Share %s failed, retrying. Error: %v", nodeID, nfsShareID, err)
+	}
+}
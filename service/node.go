@@ -9,7 +9,10 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -29,6 +32,7 @@ import (
 var (
 	targetMountRecheckSleepTime = 3 * time.Second
 	disconnectVolumeRetryTime   = 1 * time.Second
+	disconnectVolumeTimeout     = 120 * time.Second
 	nodeStartTimeout            = 3 * time.Second
 	lunzMutex                   sync.Mutex
 	LUNZHLU                     = 0
@@ -49,6 +53,10 @@ func (s *service) NodeStageVolume(
 	*csi.NodeStageVolumeResponse, error) {
 	ctx, log, rid := GetRunidLog(ctx)
 	log.Debugf("Executing NodeStageVolume with args: %+v", *req)
+	if err := s.checkRequestDeadline(ctx); err != nil {
+		return nil, err
+	}
+	req.VolumeContext = normalizeVolumeContext(ctx, req.GetVolumeContext())
 	volId, protocol, arrayId, unity, err := s.validateAndGetResourceDetails(ctx, req.GetVolumeId(), volumeType)
 	if err != nil {
 		return nil, err
@@ -63,6 +71,11 @@ func (s *service) NodeStageVolume(
 	if stagingPath == "" {
 		return nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "staging target path required"))
 	}
+	stagingPath, err = s.getStagingPath(ctx, stagingPath, volId, arrayId, protocol)
+	if err != nil {
+		return nil, err
+	}
+	req.StagingTargetPath = stagingPath
 
 	vc := req.GetVolumeCapability()
 	if vc == nil {
@@ -75,12 +88,19 @@ func (s *service) NodeStageVolume(
 
 	isBlock := accTypeBlock(vc)
 
+	seLinuxContext, err := getSELinuxContext(ctx, vc, req.GetVolumeContext())
+	if err != nil {
+		return nil, err
+	}
+	setSELinuxContextFlag(vc, seLinuxContext)
+
 	protocol, err = ValidateAndGetProtocol(ctx, protocol, req.GetVolumeContext()[keyProtocol])
 	if err != nil {
 		return nil, err
 	}
 
 	log.Debugf("Protocol is: %s", protocol)
+	s.setDefaultMountFlags(ctx, vc, protocol)
 
 	if protocol == NFS {
 		//Perform stage mount for NFS
@@ -89,7 +109,12 @@ func (s *service) NodeStageVolume(
 			return nil, err
 		}
 
-		err = s.checkFilesystemMapping(ctx, nfsShare, am, arrayId)
+		accessPolicy, err := getNFSAccessPolicy(req.GetVolumeContext()[keyAccessPolicy])
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "%v", err))
+		}
+
+		err = s.checkFilesystemMapping(ctx, nfsShare, am, arrayId, accessPolicy)
 		if err != nil {
 			return nil, err
 		}
@@ -103,6 +128,11 @@ func (s *service) NodeStageVolume(
 		if err != nil {
 			return nil, err
 		}
+		state := &stagingState{VolumeId: req.GetVolumeId(), ArrayId: arrayId, Protocol: protocol, Transport: NFS}
+		recordStagedFsType(ctx, state, req, protocol, stagingPath)
+		if err := s.writeStagingStateWithReference(ctx, state, stagingPath); err != nil {
+			log.Warnf("Unable to record staging state of volume %s. Error: %v", volId, err)
+		}
 		log.Debugf("Node Stage completed successfully: filesystem: %s is mounted on staging target path: %s", volId, stagingPath)
 		return &csi.NodeStageVolumeResponse{}, nil
 	} else {
@@ -183,24 +213,31 @@ func (s *service) NodeStageVolume(
 			}
 			publishContextData.fcTargets = targetWwns
 			log.Debugf("Found FC Targets: %s", publishContextData.iscsiTargets)
+			if err := s.checkFCZoning(ctx, arrayId, targetWwns); err != nil {
+				return nil, err
+			}
 
 			if s.fcConnector == nil {
 				s.initFCConnector(s.opts.Chroot)
 			}
 		}
 
+		disableMultipath := false
+		if value, ok := req.GetVolumeContext()[keyDisableMultipath]; ok && value != "" {
+			disableMultipath, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Invalid value for %s: %s", keyDisableMultipath, value))
+			}
+		}
+
 		log.Debug("Connect context data: ", publishContextData)
-		devicePath, err := s.connectDevice(ctx, publishContextData, useFC)
+		devicePath, err := s.connectDevice(ctx, req.GetVolumeId(), publishContextData, useFC, disableMultipath)
 		if err != nil {
 			return nil, err
 		}
 
-		//Skip staging for Block devices
-		if !isBlock {
-			err = stageVolume(ctx, req, stagingPath, devicePath)
-			if err != nil {
-				return nil, err
-			}
+		if err := s.stageDevice(ctx, req, arrayId, protocol, stagingPath, devicePath, publishContextData, isBlock); err != nil {
+			return nil, err
 		}
 
 		log.Debugf("Node Stage completed successfully - Device path is %s", devicePath)
@@ -208,6 +245,52 @@ func (s *service) NodeStageVolume(
 	}
 }
 
+//mountStagingDevice formats the device if needed and mounts it on the staging target path. It is a variable so that
+//tests can override it
+var mountStagingDevice = stageVolume
+
+//stageDevice stages the connected device of an FC or iSCSI volume. Filesystem volumes are mounted on the staging target
+//path, while raw block volumes are neither formatted nor mounted. The device path is recorded in the staging state, from
+//which NodePublishVolume bind mounts raw block volumes
+func (s *service) stageDevice(ctx context.Context, req *csi.NodeStageVolumeRequest, arrayId, protocol, stagingPath, devicePath string, data publishContextData, isBlock bool) error {
+	log := utils.GetRunidLogger(ctx)
+	if !isBlock {
+		if err := mountStagingDevice(ctx, req, stagingPath, devicePath); err != nil {
+			return err
+		}
+	}
+
+	//Record the transport the volume was connected over for troubleshooting
+	state := newStagingState(req.GetVolumeId(), arrayId, protocol, data, devicePath)
+	state.RawBlock = isBlock
+	if !isBlock {
+		recordStagedFsType(ctx, state, req, protocol, stagingPath)
+	}
+	if err := s.writeStagingStateWithReference(ctx, state, stagingPath); err != nil {
+		log.Warnf("Unable to record staging state of volume %s. Error: %v", req.GetVolumeId(), err)
+	}
+	return nil
+}
+
+//getStagedDevicePath returns the device path recorded when the raw block volume was staged, or empty when none is
+//recorded or the device no longer exists
+func (s *service) getStagedDevicePath(ctx context.Context, volumeId string) string {
+	log := utils.GetRunidLogger(ctx)
+	state, err := s.readStagingState(ctx, volumeId)
+	if err != nil {
+		log.Warnf("Unable to read staging state of volume %s. Error: %v", volumeId, err)
+		return ""
+	}
+	if state == nil || !state.RawBlock || state.DevicePath == "" {
+		return ""
+	}
+	if _, err := os.Stat(state.DevicePath); err != nil {
+		log.Infof("Staged device %s of volume %s is not found. Error: %v", state.DevicePath, volumeId, err)
+		return ""
+	}
+	return state.DevicePath
+}
+
 func (s *service) NodeUnstageVolume(
 	ctx context.Context,
 	req *csi.NodeUnstageVolumeRequest) (
@@ -230,6 +313,11 @@ func (s *service) NodeUnstageVolume(
 	if stageTgt == "" {
 		return nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "A Staging Target argument is required"))
 	}
+	stageTgt, err = s.getStagingPath(ctx, stageTgt, volId, arrayId, protocol)
+	if err != nil {
+		return nil, err
+	}
+	req.StagingTargetPath = stageTgt
 
 	if protocol == NFS {
 		nfsShare, _, _, err := s.getNFSShare(ctx, volId, arrayId)
@@ -252,6 +340,9 @@ func (s *service) NodeUnstageVolume(
 		if err != nil {
 			return nil, status.Error(codes.Internal, utils.GetMessageWithRunID(rid, "%v", err))
 		}
+		if _, err := s.releaseStagingReference(ctx, req.GetVolumeId(), stageTgt); err != nil {
+			log.Warnf("Unable to update staging state of volume %s. Error: %v", volId, err)
+		}
 		log.Debugf("Node Unstage completed successfully. No mounts on staging target path: %s", req.GetStagingTargetPath())
 		return &csi.NodeUnstageVolumeResponse{}, nil
 	} else if protocol == ProtocolUnknown {
@@ -286,53 +377,9 @@ func (s *service) NodeUnstageVolume(
 	}
 
 	volumeWwn := utils.GetWwnFromVolumeContentWwn(volume.VolumeContent.Wwn)
-	lastMounted, devicePath, err := unstageVolume(ctx, req, volumeWwn, s.opts.Chroot)
-	if err != nil {
+	if err := s.unstageBlockVolume(ctx, req, volId, volumeWwn, protocol, stageTgt); err != nil {
 		return nil, err
 	}
-
-	if !lastMounted {
-		// It is unusual that we have not removed the last mount (i.e. lastUnmounted == false)
-		// Recheck to make sure the target is unmounted.
-		log.Debug("Not the last mount - rechecking target mount is gone")
-		targetMount, err := getTargetMount(ctx, stageTgt)
-		if err != nil {
-			return nil, err
-		}
-		if targetMount.Device != "" {
-			return nil, status.Error(codes.Internal, utils.GetMessageWithRunID(rid, "Target Mount still present"))
-		}
-
-		if devicePath == "" {
-			devicePath = targetMount.Source
-		}
-
-		// Get the device mounts
-		dev, err := GetDevice(ctx, devicePath)
-		if err != nil {
-			return nil, status.Error(codes.Internal, utils.GetMessageWithRunID(rid, err.Error()))
-		}
-		log.Debug("Rechecking dev mounts")
-		mnts, err := getDevMounts(ctx, dev)
-		if err != nil {
-			return nil, status.Error(codes.Internal, utils.GetMessageWithRunID(rid, err.Error()))
-		}
-		if len(mnts) > 0 {
-			return nil, status.Error(codes.Internal, utils.GetMessageWithRunID(rid, "Device mounts still present after unmounting target and staging mounts %#v", mnts))
-		}
-	}
-
-	err = s.disconnectVolume(ctx, volumeWwn, protocol)
-	if err != nil {
-		return nil, err
-	}
-
-	// Remove the mount private directory if present, and the directory
-	err = removeWithRetry(ctx, stageTgt)
-	if err != nil {
-		log.Infof("Error removing stageTgt: %v", err)
-	}
-
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
@@ -342,6 +389,10 @@ func (s *service) NodePublishVolume(
 	*csi.NodePublishVolumeResponse, error) {
 	ctx, log, rid := GetRunidLog(ctx)
 	log.Debugf("Executing NodePublishVolume with args: %+v", *req)
+	if err := s.checkRequestDeadline(ctx); err != nil {
+		return nil, err
+	}
+	req.VolumeContext = normalizeVolumeContext(ctx, req.GetVolumeContext())
 
 	var ephemeralVolume bool
 	ephemeral, ok := req.VolumeContext["csi.storage.k8s.io/ephemeral"]
@@ -376,6 +427,10 @@ func (s *service) NodePublishVolume(
 	if stagingTargetPath == "" {
 		return nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "staging target path required"))
 	}
+	req.StagingTargetPath, err = s.getStagingPath(ctx, stagingTargetPath, volID, arrayId, protocol)
+	if err != nil {
+		return nil, err
+	}
 
 	volCap := req.GetVolumeCapability()
 	if volCap == nil {
@@ -387,6 +442,17 @@ func (s *service) NodePublishVolume(
 		return nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "volume access mode required"))
 	}
 
+	propagation, err := getMountPropagation(ctx, volCap, req.GetVolumeContext())
+	if err != nil {
+		return nil, err
+	}
+	setMountPropagationFlag(volCap, propagation)
+	seLinuxContext, err := getSELinuxContext(ctx, volCap, req.GetVolumeContext())
+	if err != nil {
+		return nil, err
+	}
+	setSELinuxContextFlag(volCap, seLinuxContext)
+
 	if protocol == NFS {
 		//Perform target mount for NFS
 		nfsShare, nfsv3, nfsv4, err := s.getNFSShare(ctx, volID, arrayId)
@@ -401,6 +467,11 @@ func (s *service) NodePublishVolume(
 		if err != nil {
 			return nil, err
 		}
+		if !req.GetReadonly() {
+			if err := applyFsGroup(ctx, targetPath, req.GetVolumeContext()); err != nil {
+				return nil, err
+			}
+		}
 		log.Debugf("Node Publish completed successfully: filesystem: %s is mounted on target path: %s", volID, targetPath)
 		return &csi.NodePublishVolumeResponse{}, nil
 	}
@@ -421,15 +492,28 @@ func (s *service) NodePublishVolume(
 
 	deviceWWN := utils.GetWwnFromVolumeContentWwn(volume.VolumeContent.Wwn)
 
-	symlinkPath, _, err := gofsutil.WWNToDevicePathX(ctx, deviceWWN)
-	if err != nil {
-		return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Disk path not found. Error: %v", err))
+	//Raw block volumes are bind mounted from the device recorded when they were staged
+	symlinkPath := ""
+	if isBlock {
+		symlinkPath = s.getStagedDevicePath(ctx, req.GetVolumeId())
+	}
+	if symlinkPath == "" {
+		symlinkPath, _, err = gofsutil.WWNToDevicePathX(ctx, deviceWWN)
+		if err != nil {
+			return nil, status.Error(codes.NotFound, utils.GetMessageWithRunID(rid, "Disk path not found. Error: %v", err))
+		}
 	}
 
 	if err := publishVolume(ctx, req, targetPath, symlinkPath, s.opts.Chroot); err != nil {
 		return nil, err
 	}
 
+	if !isBlock && !req.GetReadonly() {
+		if err := applyFsGroup(ctx, targetPath, req.GetVolumeContext()); err != nil {
+			return nil, err
+		}
+	}
+
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
@@ -778,8 +862,10 @@ func (s *service) NodeGetInfo(
 	log.Debugf("Executing NodeGetInfo with args: %+v", *req)
 
 	atleastOneArraySuccess := false
-	//Sleep for a while and wait untill iscsi discovery is completed
-	time.Sleep(nodeStartTimeout)
+	//Wait untill iscsi discovery is completed
+	if err := s.waitForNodeInitiators(ctx); err != nil {
+		return nil, err
+	}
 	arraysList := s.getStorageArrayList()
 
 	for _, array := range arraysList {
@@ -975,6 +1061,23 @@ func (s *service) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolum
 	return &csi.NodeExpandVolumeResponse{CapacityBytes: size}, nil
 }
 
+//getStagingPath returns the path used to stage a volume. When a staging path template is configured the
+//expanded template is appended to the staging target path, otherwise the staging target path is used as is. The
+//volumes of CSI-Unity v1.0 or v1.1, whose volume id carries no protocol, use the staging target path as is. Stage,
+//publish and unstage compute the path with this method only, so that they agree on the path
+func (s *service) getStagingPath(ctx context.Context, stagingTargetPath, volId, arrayId, protocol string) (string, error) {
+	if s.opts.StagingPathTemplate == "" || protocol == ProtocolUnknown {
+		return stagingTargetPath, nil
+	}
+	rid, _ := utils.GetRunidAndLogger(ctx)
+	replacer := strings.NewReplacer("{volumeId}", volId, "{arrayId}", arrayId, "{protocol}", protocol)
+	stagingPath := path.Join(stagingTargetPath, replacer.Replace(s.opts.StagingPathTemplate))
+	if !strings.HasPrefix(stagingPath, path.Clean(stagingTargetPath)+"/") {
+		return "", status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Staging path template %s resolves to %s, which is not under the staging target path %s", s.opts.StagingPathTemplate, stagingPath, stagingTargetPath))
+	}
+	return stagingPath, nil
+}
+
 func (s *service) nodeProbe(ctx context.Context, arrayId string) error {
 	return s.probe(ctx, "Node", arrayId)
 }
@@ -1041,12 +1144,11 @@ func (s *service) getNFSShare(ctx context.Context, filesystemId, arrayId string)
 	return nfsShare, nasServer.NASServerContent.NFSServer.NFSv3Enabled, nasServer.NASServerContent.NFSServer.NFSv4Enabled, nil
 }
 
-//Check if the Filesystem has access to the node
-func (s *service) checkFilesystemMapping(ctx context.Context, nfsShare *types.NFSShare, am *csi.VolumeCapability_AccessMode, arrayId string) error {
+//Check if the Filesystem has access to the node with the access type of the access policy and mode
+func (s *service) checkFilesystemMapping(ctx context.Context, nfsShare *types.NFSShare, am *csi.VolumeCapability_AccessMode, arrayId, accessPolicy string) error {
 	ctx, _, rid := GetRunidLog(ctx)
 	ctx, _ = setArrayIdContext(ctx, arrayId)
 	_, err := s.getUnityClient(ctx, arrayId)
-	var accessType gounity.AccessType
 	if err != nil {
 		return err
 	}
@@ -1055,26 +1157,10 @@ func (s *service) checkFilesystemMapping(ctx context.Context, nfsShare *types.NF
 	if err != nil {
 		return err
 	}
-	hostContent := host.HostContent
-	hostID := hostContent.ID
+	hostID := host.HostContent.ID
 
-	hostHasAccess := false
-	if am.Mode == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY {
-		accessType = gounity.ReadOnlyRootAccessType
-		for _, host := range nfsShare.NFSShareContent.ReadOnlyRootAccessHosts {
-			if host.ID == hostID {
-				hostHasAccess = true
-			}
-		}
-	} else {
-		accessType = gounity.ReadWriteRootAccessType
-		for _, host := range nfsShare.NFSShareContent.RootAccessHosts {
-			if host.ID == hostID {
-				hostHasAccess = true
-			}
-		}
-	}
-	if !hostHasAccess {
+	accessType := getNFSHostAccessType(accessPolicy, am)
+	if !utils.ArrayContains(getNFSShareHostIDs(nfsShare)[accessType], hostID) {
 		return status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "Host: %s does not have access: %s on NFS Share: %s", host.HostContent.Name, accessType, nfsShare.NFSShareContent.Id))
 	}
 	return nil
@@ -1155,28 +1241,38 @@ func (s *service) iScsiDiscoverAndLogin(ctx context.Context, interfaceIps []stri
 
 	log.Debug("Valid IPs: ", validIPs)
 
-	for _, ip := range validIPs {
-		// passing true to login to target after discovery
-		log.Debug("Begin discover and login to: ", ip)
-
-		targets, err := s.iscsiClient.DiscoverTargets(ip, false)
+	//Discover the targets of the IPs concurrently, within the scan concurrency limit
+	discovered := make([][]goiscsi.ISCSITarget, len(validIPs))
+	runConcurrently(len(validIPs), s.getISCSIScanConcurrency(), func(i int) {
+		log.Debug("Begin discover and login to: ", validIPs[i])
+		targets, err := s.iscsiClient.DiscoverTargets(validIPs[i], false)
 		if err != nil {
 			log.Debugf("Error executing iscsiadm discovery: %v", err)
-			continue
+			return
 		}
+		discovered[i] = targets
+	})
 
+	//Each portal of the targets reported by several IPs is logged in once
+	loginTargets := make([]goiscsi.ISCSITarget, 0)
+	seen := make(map[string]bool)
+	for _, targets := range discovered {
 		for _, tgt := range targets {
 			ipSlice := strings.Split(tgt.Portal, ":")
-			if utils.ArrayContains(validIPs, ipSlice[0]) {
-				err = s.iscsiClient.PerformLogin(tgt)
-				if err != nil {
-					log.Debugf("Error logging in to target %s : %v", tgt.Target, err)
-				} else {
-					log.Debugf("Login successful to target %s", tgt.Target)
-				}
+			if utils.ArrayContains(validIPs, ipSlice[0]) && !seen[tgt.Target+"@"+tgt.Portal] {
+				seen[tgt.Target+"@"+tgt.Portal] = true
+				loginTargets = append(loginTargets, tgt)
 			}
 		}
 	}
+	runConcurrently(len(loginTargets), s.getISCSIScanConcurrency(), func(i int) {
+		tgt := loginTargets[i]
+		if err := s.iscsiClient.PerformLogin(tgt); err != nil {
+			log.Debugf("Error logging in to target %s : %v", tgt.Target, err)
+		} else {
+			log.Debugf("Login successful to target %s", tgt.Target)
+		}
+	})
 	log.Debug("Completed discovery and rescan of all IP Interfaces")
 }
 
@@ -1230,8 +1326,13 @@ func (s *service) getValidInterfaceIps(ctx context.Context, interfaceIps []strin
 	ctx, log, _ := GetRunidLog(ctx)
 	validIPs := make([]string, 0)
 
-	for _, ip := range interfaceIps {
-		if utils.IPReachable(ctx, ip, IScsiPort, TcpDialTimeout) {
+	//The portals are checked concurrently, within the scan concurrency limit, and kept in the order of the interfaces
+	reachable := make([]bool, len(interfaceIps))
+	runConcurrently(len(interfaceIps), s.getISCSIScanConcurrency(), func(i int) {
+		reachable[i] = isISCSIPortalReachable(ctx, interfaceIps[i])
+	})
+	for i, ip := range interfaceIps {
+		if reachable[i] {
 			validIPs = append(validIPs, ip)
 		} else {
 			log.Debugf("Skipping IP : %s", ip)
@@ -1240,6 +1341,20 @@ func (s *service) getValidInterfaceIps(ctx context.Context, interfaceIps []strin
 	return validIPs
 }
 
+//isISCSIPortalReachable returns true when the iSCSI port of the IP accepts connections within TcpDialTimeout. It is a
+//variable so that tests can override it
+var isISCSIPortalReachable = func(ctx context.Context, ip string) bool {
+	return utils.IPReachable(ctx, ip, IScsiPort, TcpDialTimeout)
+}
+
+//getISCSIScanConcurrency returns the maximum number of iSCSI portals checked, discovered or logged in to at once
+func (s *service) getISCSIScanConcurrency() int {
+	if s.opts.ISCSIScanConcurrency < 1 {
+		return defaultISCSIScanConcurrency
+	}
+	return s.opts.ISCSIScanConcurrency
+}
+
 // copyMultipathConfig file copies the /etc/multipath.conf file from the nodeRoot chdir path to
 // /etc/multipath.conf if testRoot is "". testRoot can be set for testing to copy somehwere else,
 // but it should be empty ( "" ) for normal operation. nodeRoot is normally iscsiChroot env. variable.
@@ -1264,8 +1379,29 @@ func (s *service) copyMultipathConfigFile(ctx context.Context, nodeRoot string)
 	return err
 }
 
-func (s *service) connectDevice(ctx context.Context, data publishContextData, useFC bool) (string, error) {
+//checkRequestDeadline rejects the request with DeadlineExceeded when its remaining deadline is below the configured minimum,
+//rather than starting work such as device discovery or mkfs that would be cancelled before completion
+func (s *service) checkRequestDeadline(ctx context.Context) error {
+	if s.opts.MinRequestDeadline <= 0 {
+		return nil
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
 	rid, _ := utils.GetRunidAndLogger(ctx)
+	minDeadline := time.Duration(s.opts.MinRequestDeadline) * time.Second
+	if remaining := time.Until(deadline); remaining < minDeadline {
+		return status.Error(codes.DeadlineExceeded, utils.GetMessageWithRunID(rid, "Request deadline %v is shorter than the minimum of %v. Retry with a longer timeout", remaining.Round(time.Millisecond), minDeadline))
+	}
+	return nil
+}
+
+//connectDevice connects the volume and returns the path of its device. When multipath is disabled, the path of a
+//single underlying block device is returned instead of the multipath device. The connector logs carry the volume id
+func (s *service) connectDevice(ctx context.Context, volumeID string, data publishContextData, useFC, disableMultipath bool) (string, error) {
+	ctx, _ = setVolumeIdContext(ctx, volumeID)
+	rid, log := utils.GetRunidAndLogger(ctx)
 	var err error
 	var device gobrick.Device
 	if useFC {
@@ -1277,6 +1413,14 @@ func (s *service) connectDevice(ctx context.Context, data publishContextData, us
 	if err != nil {
 		return "", status.Error(codes.Internal, utils.GetMessageWithRunID(rid, "Unable to find device after multiple discovery attempts: [%v]", err))
 	}
+	if disableMultipath && device.MultipathID != "" {
+		slaves, err := ioutil.ReadDir(path.Join(sysBlock, device.Name, "slaves"))
+		if err != nil || len(slaves) == 0 {
+			return "", status.Error(codes.Internal, utils.GetMessageWithRunID(rid, "Unable to find the single path device of multipath device %s: [%v]", device.Name, err))
+		}
+		log.Warnf("Multipath is disabled for the volume. Using single path device %s instead of multipath device %s. The volume is not highly available", slaves[0].Name(), device.Name)
+		return path.Join("/dev/", slaves[0].Name()), nil
+	}
 	devicePath := path.Join("/dev/", device.Name)
 	return devicePath, nil
 }
@@ -1336,15 +1480,9 @@ func (s *service) disconnectVolume(ctx context.Context, volumeWWN, protocol stri
 		devicePathComponents := strings.Split(devicePath, "/")
 		deviceName = devicePathComponents[len(devicePathComponents)-1]
 
-		nodeUnstageCtx, cancel := context.WithTimeout(ctx, time.Second*120)
-
-		if protocol == FC {
-			s.fcConnector.DisconnectVolumeByDeviceName(nodeUnstageCtx, deviceName)
-		} else if protocol == ISCSI {
-			s.iscsiConnector.DisconnectVolumeByDeviceName(nodeUnstageCtx, deviceName)
+		if err := s.disconnectDevice(ctx, protocol, deviceName); err != nil {
+			log.Errorf("Disconnect of device %s failed. Error: %v", deviceName, err)
 		}
-
-		cancel()
 		time.Sleep(disconnectVolumeRetryTime)
 
 		// Check that the /sys/block/DeviceName actually exists
@@ -1364,6 +1502,57 @@ func (s *service) disconnectVolume(ctx context.Context, volumeWWN, protocol stri
 	return status.Errorf(codes.Internal, utils.GetMessageWithRunID(rid, "disconnectVolume exceeded retry limit WWN %s devPath %s", volumeWWN, devPath))
 }
 
+//disconnectDevice disconnects the device using the connector of the protocol. When force disconnect is enabled
+//and the graceful disconnect fails, the device is removed forcefully
+func (s *service) disconnectDevice(ctx context.Context, protocol, deviceName string) error {
+	log := utils.GetRunidLogger(ctx)
+	nodeUnstageCtx, cancel := context.WithTimeout(ctx, disconnectVolumeTimeout)
+	defer cancel()
+
+	var err error
+	if protocol == FC {
+		err = s.fcConnector.DisconnectVolumeByDeviceName(nodeUnstageCtx, deviceName)
+	} else if protocol == ISCSI {
+		err = s.iscsiConnector.DisconnectVolumeByDeviceName(nodeUnstageCtx, deviceName)
+	}
+	if err == nil || !s.opts.ForceDisconnect {
+		return err
+	}
+
+	log.Warnf("Graceful disconnect of device %s failed. Escalating to forceful removal. Error: %v", deviceName, err)
+	if forceErr := forceRemoveDevice(ctx, deviceName); forceErr != nil {
+		return status.Errorf(codes.Internal, "force removal of device %s failed: %v (graceful disconnect error: %v)", deviceName, forceErr, err)
+	}
+	log.Warnf("Device %s removed forcefully", deviceName)
+	return nil
+}
+
+//forceRemoveDevice flushes the multipath device and deletes the underlying SCSI devices without waiting for pending I/O.
+//It is a variable so that tests can override it
+var forceRemoveDevice = func(ctx context.Context, deviceName string) error {
+	log := utils.GetRunidLogger(ctx)
+	devices := []string{deviceName}
+	if strings.HasPrefix(deviceName, "dm-") {
+		slaves, err := ioutil.ReadDir(path.Join(sysBlock, deviceName, "slaves"))
+		if err != nil {
+			return err
+		}
+		devices = make([]string, 0)
+		for _, slave := range slaves {
+			devices = append(devices, slave.Name())
+		}
+		if out, err := exec.Command("multipath", "-f", "/dev/"+deviceName).CombinedOutput(); err != nil {
+			log.Warnf("Multipath flush of %s failed. Output: %s Error: %v", deviceName, string(out), err)
+		}
+	}
+	for _, device := range devices {
+		if err := ioutil.WriteFile(path.Join(sysBlock, device, "device", "delete"), []byte("1"), 0200); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
 type publishContextData struct {
 	deviceWWN        string
 	volumeLUNAddress int
@@ -1606,7 +1795,7 @@ func (s *service) addNewNodeToArray(ctx context.Context, array *StorageArrayConf
 }
 
 func (s *service) syncNodeInfoRoutine(ctx context.Context) {
-	ctx, log := setRunIdContext(ctx, "node-0")
+	ctx, log := incrementLogId(ctx, "node")
 	log.Info("Starting goroutine to add Node information to storage array")
 	for {
 		select {
@@ -1636,6 +1825,90 @@ func (s *service) syncNodeInfoRoutine(ctx context.Context) {
 }
 
 //Synchronize node information using addNodeInformationIntoArray
+//getNodeInitiators returns the FC and iSCSI initiators of the node. It is a variable so that tests can override it
+var getNodeInitiators = func(ctx context.Context, iscsiClient goiscsi.ISCSIinterface) []string {
+	initiators := make([]string, 0)
+	if wwns, err := utils.GetFCInitiators(ctx); err == nil {
+		initiators = append(initiators, wwns...)
+	}
+	if iscsiClient != nil {
+		if iqns, err := iscsiClient.GetInitiators(""); err == nil {
+			initiators = append(initiators, iqns...)
+		}
+	}
+	return initiators
+}
+
+//waitForNodeInitiators waits for the FC or iSCSI initiators of the node to be discoverable, so that NodeGetInfo reports
+//the complete topology on nodes that boot faster than their initiators are initialized. When no wait timeout is set it
+//sleeps once for the discovery
+func (s *service) waitForNodeInitiators(ctx context.Context) error {
+	ctx, log, rid := GetRunidLog(ctx)
+	if s.opts.InitiatorWaitTimeout <= 0 {
+		time.Sleep(nodeStartTimeout)
+		return nil
+	}
+	timeout := time.Duration(s.opts.InitiatorWaitTimeout) * time.Second
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		if initiators := getNodeInitiators(ctx, s.iscsiClient); len(initiators) != 0 {
+			log.Debugf("Discovered node initiators: %v", initiators)
+			return nil
+		}
+		log.Debugf("No FC or iSCSI initiators discovered yet, retrying in %v", nodeStartTimeout)
+		if err := retrySleep(waitCtx, nodeStartTimeout); err != nil {
+			return status.Error(codes.Unavailable, utils.GetMessageWithRunID(rid, "No FC or iSCSI initiators discovered on node %s within %v. Verify the iSCSI and FC configuration of the node", s.opts.NodeName, timeout))
+		}
+	}
+}
+
+//resyncNodeInfo updates the node information on the arrays. It is a variable so that tests can override it
+var resyncNodeInfo = func(ctx context.Context, s *service) {
+	s.syncNodeInfo(ctx)
+}
+
+//initiatorRefreshRoutine re-discovers the node initiators on their own interval, so that initiator changes are
+//reconciled with the hosts on the arrays without waiting for the node info sync
+func (s *service) initiatorRefreshRoutine(ctx context.Context) {
+	ctx, log := incrementLogId(ctx, "initiator")
+	interval := time.Duration(s.opts.InitiatorRefreshInterval) * time.Minute
+	log.Infof("Starting goroutine to refresh node initiators every %v", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	s.refreshInitiators(ctx)
+	for range ticker.C {
+		if s.refreshInitiators(ctx) {
+			ctx, log = incrementLogId(ctx, "initiator")
+		}
+	}
+}
+
+//refreshInitiators discovers the node initiators and, when they changed since the last discovery, updates the
+//hosts on all the arrays. Returns true when the initiators changed
+func (s *service) refreshInitiators(ctx context.Context) bool {
+	log := utils.GetRunidLogger(ctx)
+	initiators := getNodeInitiators(ctx, s.iscsiClient)
+	sort.Strings(initiators)
+	previous := s.knownInitiators
+	s.knownInitiators = initiators
+	if previous == nil || reflect.DeepEqual(previous, initiators) {
+		return false
+	}
+
+	log.Infof("Node initiators changed from %v to %v. Updating the host on the arrays", previous, initiators)
+	removed := utils.FindAdditionalWwns(initiators, previous)
+	if len(removed) > 0 {
+		log.Warnf("Initiators %v are no longer present on the node. Remove them from the host on the arrays if they are not reused", removed)
+	}
+	s.arrays.Range(func(key, value interface{}) bool {
+		value.(*StorageArrayConfig).IsHostAdded = false
+		return true
+	})
+	resyncNodeInfo(ctx, s)
+	return true
+}
+
 func (s *service) syncNodeInfo(ctx context.Context) {
 	nodeMutex.Lock()
 	defer nodeMutex.Unlock()
@@ -1652,14 +1925,8 @@ func (s *service) syncNodeInfo(ctx context.Context) {
 		array := value.(*StorageArrayConfig)
 		if !array.IsHostAdded {
 			go func() {
-				ctx, log := incrementLogId(ctx, "node")
-				err := s.addNodeInformationIntoArray(ctx, array)
-				if err == nil {
-					array.IsHostAdded = true
-					log.Debugf("Node [%s] Added successfully", array.ArrayId)
-				} else {
-					log.Debugf("Adding node [%s] failed, Error: [%v]", array.ArrayId, err)
-				}
+				ctx, _ := incrementLogId(ctx, "node")
+				s.registerNode(ctx, array)
 			}()
 		}
 		return true
@@ -1,7 +1,25 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/csi-unity/service/utils"
+	"github.com/dell/gobrick"
+	"github.com/dell/goiscsi"
+	"github.com/dell/gounity"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestNodeGetInfo(t *testing.T) {
@@ -10,3 +28,554 @@ func TestNodeGetInfo(t *testing.T) {
 	//testConf.service.discoverNodes(testConf.ctx, "1")
 	//time.Sleep(30 * time.Second)
 }
+
+func TestGetStagingPath(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	stagingTargetPath := "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-1/globalmount"
+	stagingPath := func(s *service, volId, arrayId, protocol string) string {
+		resolved, err := s.getStagingPath(ctx, stagingTargetPath, volId, arrayId, protocol)
+		assert.Nil(t, err)
+		return resolved
+	}
+
+	//Without template the staging target path is used as is
+	s := &service{}
+	assert.Equal(t, stagingTargetPath, stagingPath(s, "sv_1", "array1", ISCSI))
+
+	s.opts.StagingPathTemplate = "{arrayId}/{protocol}/{volumeId}"
+	expected := stagingTargetPath + "/array1/iSCSI/sv_1"
+	stagePath := stagingPath(s, "sv_1", "array1", ISCSI)
+	assert.Equal(t, expected, stagePath)
+
+	//Unstage must resolve the same path that was used during stage
+	unstagePath := stagingPath(s, "sv_1", "array1", ISCSI)
+	assert.Equal(t, stagePath, unstagePath)
+
+	s.opts.StagingPathTemplate = "unity-{volumeId}"
+	assert.Equal(t, stagingTargetPath+"/unity-fs_2", stagingPath(s, "fs_2", "array1", NFS))
+
+	//Volumes of CSI-Unity v1.0 or v1.1 use the staging target path as is
+	assert.Equal(t, stagingTargetPath, stagingPath(s, "sv_3", "array1", ProtocolUnknown))
+
+	//Templates resolving outside the staging target path are rejected
+	for _, template := range []string{"../{volumeId}", "{volumeId}/../../other", ".", "{volumeId}/.."} {
+		s.opts.StagingPathTemplate = template
+		_, err := s.getStagingPath(ctx, stagingTargetPath, "sv_1", "array1", ISCSI)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "Template %s not rejected", template)
+	}
+}
+
+func TestApplyFsGroup(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	targetPath, err := ioutil.TempDir("", "fsgroup")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(targetPath)
+	if err := ioutil.WriteFile(filepath.Join(targetPath, "data"), []byte("data"), 0644); err != nil {
+		t.Fatalf("Unable to create file: %v", err)
+	}
+	info, _ := os.Stat(targetPath)
+	rootGid := int(info.Sys().(*syscall.Stat_t).Gid)
+
+	origLchown := lchown
+	defer func() { lchown = origLchown }()
+	var changed []string
+	lchown = func(path string, uid, gid int) error {
+		changed = append(changed, path)
+		return nil
+	}
+
+	//OnRootMismatch skips the recursive change when the root already has the group
+	err = applyFsGroup(ctx, targetPath, map[string]string{keyFsGroup: strconv.Itoa(rootGid), keyFsGroupChangePolicy: FsGroupChangeOnRootMismatch})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(changed))
+
+	//OnRootMismatch applies the change when the root group differs
+	err = applyFsGroup(ctx, targetPath, map[string]string{keyFsGroup: strconv.Itoa(rootGid + 1), keyFsGroupChangePolicy: FsGroupChangeOnRootMismatch})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(changed))
+
+	//Always applies the change even when the root group matches
+	changed = nil
+	err = applyFsGroup(ctx, targetPath, map[string]string{keyFsGroup: strconv.Itoa(rootGid), keyFsGroupChangePolicy: FsGroupChangeAlways})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(changed))
+
+	//No policy, the ownership is left unchanged
+	changed = nil
+	err = applyFsGroup(ctx, targetPath, map[string]string{keyFsGroup: strconv.Itoa(rootGid + 1)})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(changed))
+
+	//No fsGroup, nothing to change
+	changed = nil
+	assert.Nil(t, applyFsGroup(ctx, targetPath, map[string]string{}))
+	assert.Equal(t, 0, len(changed))
+
+	err = applyFsGroup(ctx, targetPath, map[string]string{keyFsGroup: "1000", keyFsGroupChangePolicy: "Never"})
+	assert.NotNil(t, err)
+}
+
+//fakeFCConnector returns the configured device on connect and the configured error on disconnect
+type fakeFCConnector struct {
+	device        gobrick.Device
+	disconnectErr error
+}
+
+func (f *fakeFCConnector) ConnectVolume(ctx context.Context, info gobrick.FCVolumeInfo) (gobrick.Device, error) {
+	return f.device, nil
+}
+func (f *fakeFCConnector) DisconnectVolumeByDeviceName(ctx context.Context, name string) error {
+	return f.disconnectErr
+}
+func (f *fakeFCConnector) GetInitiatorPorts(ctx context.Context) ([]string, error) {
+	return []string{}, nil
+}
+
+func TestDisconnectDeviceForce(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	origForce := forceRemoveDevice
+	defer func() { forceRemoveDevice = origForce }()
+	forced := 0
+	forceRemoveDevice = func(ctx context.Context, deviceName string) error {
+		forced++
+		return nil
+	}
+
+	//Graceful disconnect succeeds
+	s := &service{fcConnector: &fakeFCConnector{}, opts: Opts{ForceDisconnect: true}}
+	assert.Nil(t, s.disconnectDevice(ctx, FC, "dm-1"))
+	assert.Equal(t, 0, forced)
+
+	//Graceful disconnect fails and force is disabled
+	s = &service{fcConnector: &fakeFCConnector{disconnectErr: errors.New("device busy")}}
+	assert.NotNil(t, s.disconnectDevice(ctx, FC, "dm-1"))
+	assert.Equal(t, 0, forced)
+
+	//Graceful disconnect fails and escalates to force
+	s.opts.ForceDisconnect = true
+	assert.Nil(t, s.disconnectDevice(ctx, FC, "dm-1"))
+	assert.Equal(t, 1, forced)
+}
+
+func TestConnectDeviceDisableMultipath(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	origSysBlock := sysBlock
+	defer func() { sysBlock = origSysBlock }()
+	dir, err := ioutil.TempDir("", "sysblock")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	sysBlock = dir
+	assert.Nil(t, os.MkdirAll(filepath.Join(sysBlock, "dm-3", "slaves", "sdb"), 0755))
+
+	device := gobrick.Device{Name: "dm-3", WWN: "60060160abcd", MultipathID: "360060160abcd"}
+	s := &service{fcConnector: &fakeFCConnector{device: device}}
+
+	//Multipath enabled stages the multipath device
+	devicePath, err := s.connectDevice(ctx, "vol1-FC-array1-sv_1", publishContextData{}, true, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "/dev/dm-3", devicePath)
+
+	//Multipath disabled stages the raw single path device
+	devicePath, err = s.connectDevice(ctx, "vol1-FC-array1-sv_1", publishContextData{}, true, true)
+	assert.Nil(t, err)
+	assert.Equal(t, "/dev/sdb", devicePath)
+
+	//Single path device without multipath is staged as is
+	s.fcConnector = &fakeFCConnector{device: gobrick.Device{Name: "sdc", WWN: "60060160abce"}}
+	devicePath, err = s.connectDevice(ctx, "vol1-FC-array1-sv_1", publishContextData{}, true, true)
+	assert.Nil(t, err)
+	assert.Equal(t, "/dev/sdc", devicePath)
+}
+
+//loggingFCConnector logs the connection of the volume through the gobrick logger of the driver
+type loggingFCConnector struct {
+	fakeFCConnector
+}
+
+func (f *loggingFCConnector) ConnectVolume(ctx context.Context, info gobrick.FCVolumeInfo) (gobrick.Device, error) {
+	(&customLogger{}).Info(ctx, "Connecting LUN %d", info.Lun)
+	return f.device, nil
+}
+
+func TestConnectDeviceLogsVolumeId(t *testing.T) {
+	logger := utils.GetLogger()
+	origOut, origFormatter := logger.Out, logger.Formatter
+	defer func() {
+		logger.SetOutput(origOut)
+		logger.SetFormatter(origFormatter)
+	}()
+	out := &syncBuffer{}
+	logger.SetOutput(out)
+	logger.SetFormatter(&utils.Formatter{})
+
+	ctx, _, _ := GetRunidLog(context.Background())
+	ctx, _ = setArrayIdContext(ctx, "array1")
+	s := &service{fcConnector: &loggingFCConnector{fakeFCConnector{device: gobrick.Device{Name: "sdc", WWN: "60060160abce"}}}}
+	_, err := s.connectDevice(ctx, "vol1-FC-array1-sv_1", publishContextData{volumeLUNAddress: 3}, true, false)
+	assert.Nil(t, err)
+
+	//The connector log line of the stage carries the volume id along with the array id
+	var line string
+	for _, l := range strings.Split(out.String(), "\n") {
+		if strings.Contains(l, "Connecting LUN 3") {
+			line = l
+		}
+	}
+	assert.True(t, strings.Contains(line, "volumeid=vol1-FC-array1-sv_1"), "Volume id missing: %s", line)
+	assert.True(t, strings.Contains(line, "arrayid=array1"), "Array id missing: %s", line)
+
+	//The volume id does not leak into the context of the caller
+	assert.Nil(t, getLogFields(ctx)[utils.VOLUMEID])
+}
+
+func TestRefreshInitiators(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	origInitiators, origResync := getNodeInitiators, resyncNodeInfo
+	defer func() { getNodeInitiators, resyncNodeInfo = origInitiators, origResync }()
+	initiators := []string{"iqn.1994-05.com.redhat:node1", "20000090fa000001"}
+	getNodeInitiators = func(ctx context.Context, iscsiClient goiscsi.ISCSIinterface) []string {
+		return append([]string{}, initiators...)
+	}
+	resyncs := 0
+	resyncNodeInfo = func(ctx context.Context, s *service) {
+		resyncs++
+	}
+
+	s := &service{arrays: new(sync.Map)}
+	array := &StorageArrayConfig{ArrayId: "array1", IsHostAdded: true}
+	s.arrays.Store("array1", array)
+
+	//First discovery only records the initiators
+	assert.False(t, s.refreshInitiators(ctx))
+	assert.Equal(t, 0, resyncs)
+	assert.True(t, array.IsHostAdded)
+
+	//Unchanged initiators, in any order, do not update the host
+	initiators = []string{"20000090fa000001", "iqn.1994-05.com.redhat:node1"}
+	assert.False(t, s.refreshInitiators(ctx))
+	assert.Equal(t, 0, resyncs)
+
+	//Changed initiators update the host on the next discovery tick
+	initiators = []string{"iqn.1994-05.com.redhat:node1", "20000090fa000002"}
+	assert.True(t, s.refreshInitiators(ctx))
+	assert.Equal(t, 1, resyncs)
+	assert.False(t, array.IsHostAdded)
+	assert.False(t, s.refreshInitiators(ctx))
+	assert.Equal(t, 1, resyncs)
+}
+
+func TestWaitForNodeInitiators(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	origInitiators, origTimeout := getNodeInitiators, nodeStartTimeout
+	defer func() { getNodeInitiators, nodeStartTimeout = origInitiators, origTimeout }()
+	nodeStartTimeout = 10 * time.Millisecond
+
+	//Fake connector reporting its initiators only after a delay
+	ready := time.Now().Add(50 * time.Millisecond)
+	calls := 0
+	getNodeInitiators = func(ctx context.Context, iscsiClient goiscsi.ISCSIinterface) []string {
+		calls++
+		if time.Now().Before(ready) {
+			return []string{}
+		}
+		return []string{"iqn.1994-05.com.redhat:node1"}
+	}
+
+	s := &service{arrays: new(sync.Map), opts: Opts{NodeName: "node1", InitiatorWaitTimeout: 5}}
+	assert.Nil(t, s.waitForNodeInitiators(ctx))
+	assert.True(t, calls > 1, "Expected discovery to be retried, got %d calls", calls)
+
+	//Initiators that never appear fail NodeGetInfo after the deadline
+	ready = time.Now().Add(time.Hour)
+	s.opts.InitiatorWaitTimeout = 1
+	start := time.Now()
+	_, err := s.NodeGetInfo(ctx, &csi.NodeGetInfoRequest{})
+	assert.NotNil(t, err)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "No FC or iSCSI initiators discovered on node node1"), "Unexpected error message: %v", err)
+	assert.True(t, time.Since(start) < 5*time.Second, "Wait was not bounded by its timeout")
+
+	//Without a wait timeout the discovery is waited for once and initiators are not required
+	calls = 0
+	s.opts.InitiatorWaitTimeout = 0
+	assert.Nil(t, s.waitForNodeInitiators(ctx))
+	assert.Equal(t, 0, calls)
+}
+
+func TestRequestDeadlineFloor(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	s := &service{arrays: new(sync.Map), opts: Opts{MinRequestDeadline: 30}}
+
+	//Below the floor is rejected before any work
+	shortCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	err := s.checkRequestDeadline(shortCtx)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	_, err = s.NodeStageVolume(shortCtx, &csi.NodeStageVolumeRequest{VolumeId: "vol-iSCSI-array1-sv_1"})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	_, err = s.NodePublishVolume(shortCtx, &csi.NodePublishVolumeRequest{VolumeId: "vol-iSCSI-array1-sv_1"})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	//Above the floor proceeds
+	longCtx, cancelLong := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancelLong()
+	assert.Nil(t, s.checkRequestDeadline(longCtx))
+	_, err = s.NodeStageVolume(longCtx, &csi.NodeStageVolumeRequest{VolumeId: "vol-iSCSI-array1-sv_1"})
+	assert.NotEqual(t, codes.DeadlineExceeded, status.Code(err))
+
+	//No deadline or disabled check proceeds
+	assert.Nil(t, s.checkRequestDeadline(ctx))
+	s.opts.MinRequestDeadline = 0
+	assert.Nil(t, s.checkRequestDeadline(shortCtx))
+}
+
+func TestMountPropagation(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	mountCap := func(flags ...string) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: flags}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}
+	}
+
+	//Propagation requested in the volume context is applied to the bind mount flags
+	volCap := mountCap("noatime")
+	propagation, err := getMountPropagation(ctx, volCap, map[string]string{keyMountPropagation: "RShared"})
+	assert.Nil(t, err)
+	setMountPropagationFlag(volCap, propagation)
+	assert.Equal(t, []string{"noatime", "rshared"}, volCap.GetMount().GetMountFlags())
+
+	//Propagation requested in the mount flags is kept once
+	volCap = mountCap("rslave", "noatime")
+	propagation, err = getMountPropagation(ctx, volCap, map[string]string{keyMountPropagation: "rslave"})
+	assert.Nil(t, err)
+	setMountPropagationFlag(volCap, propagation)
+	assert.Equal(t, []string{"noatime", "rslave"}, volCap.GetMount().GetMountFlags())
+
+	//No propagation leaves the flags unchanged
+	volCap = mountCap("noatime")
+	propagation, err = getMountPropagation(ctx, volCap, nil)
+	assert.Nil(t, err)
+	setMountPropagationFlag(volCap, propagation)
+	assert.Equal(t, []string{"noatime"}, volCap.GetMount().GetMountFlags())
+
+	//Conflicting requests and raw block volumes are rejected
+	_, err = getMountPropagation(ctx, mountCap("rslave"), map[string]string{keyMountPropagation: "rshared"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	blockCap := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}}
+	_, err = getMountPropagation(ctx, blockCap, map[string]string{keyMountPropagation: "rshared"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	//Invalid value is rejected by NodePublishVolume before mounting
+	client, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	s := &service{arrays: new(sync.Map), opts: Opts{AutoProbe: true}}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", UnityClient: client})
+	origAuth := authenticateArray
+	defer func() { authenticateArray = origAuth }()
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		return nil
+	}
+	_, err = s.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:          "vol1-NFS-array1-fs_1",
+		TargetPath:        "/var/lib/kubelet/pods/pod1/volumes/kubernetes.io~csi/pvc-1/mount",
+		StagingTargetPath: "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-1/globalmount",
+		VolumeCapability:  mountCap(),
+		VolumeContext:     map[string]string{keyMountPropagation: "bidirectional"},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "Invalid value for mountPropagation: bidirectional"), "Unexpected error message: %v", err)
+}
+
+func TestSELinuxContext(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	mountCap := func(flags ...string) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{MountFlags: flags}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		}
+	}
+
+	//Context requested in the volume context is applied to the filesystem mount flags
+	volCap := mountCap("noatime")
+	seLinuxContext, err := getSELinuxContext(ctx, volCap, map[string]string{keySELinuxContext: "system_u:object_r:container_file_t:s0:c1,c2"})
+	assert.Nil(t, err)
+	setSELinuxContextFlag(volCap, seLinuxContext)
+	assert.Equal(t, []string{"noatime", `context="system_u:object_r:container_file_t:s0:c1,c2"`}, volCap.GetMount().GetMountFlags())
+
+	//Context requested in the mount options is applied once
+	volCap = mountCap("seLinuxContext=system_u:object_r:container_file_t:s0", "noatime")
+	seLinuxContext, err = getSELinuxContext(ctx, volCap, nil)
+	assert.Nil(t, err)
+	setSELinuxContextFlag(volCap, seLinuxContext)
+	assert.Equal(t, []string{"noatime", `context="system_u:object_r:container_file_t:s0"`}, volCap.GetMount().GetMountFlags())
+	seLinuxContext, err = getSELinuxContext(ctx, volCap, map[string]string{keySELinuxContext: "system_u:object_r:container_file_t:s0"})
+	assert.Nil(t, err)
+	setSELinuxContextFlag(volCap, seLinuxContext)
+	assert.Equal(t, []string{"noatime", `context="system_u:object_r:container_file_t:s0"`}, volCap.GetMount().GetMountFlags())
+
+	//No context leaves the flags unchanged
+	volCap = mountCap("noatime")
+	seLinuxContext, err = getSELinuxContext(ctx, volCap, nil)
+	assert.Nil(t, err)
+	setSELinuxContextFlag(volCap, seLinuxContext)
+	assert.Equal(t, []string{"noatime"}, volCap.GetMount().GetMountFlags())
+
+	//Raw block volumes are not labelled
+	blockCap := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}}
+	seLinuxContext, err = getSELinuxContext(ctx, blockCap, map[string]string{keySELinuxContext: "system_u:object_r:container_file_t:s0"})
+	assert.Nil(t, err)
+	assert.Equal(t, "", seLinuxContext)
+
+	//Malformed and conflicting contexts are rejected
+	for _, value := range []string{"container_file_t", "system_u:object_r", "system_u:object_r:container_file_t:c1", "system_u:object_r:container_file_t:s0,rw"} {
+		_, err = getSELinuxContext(ctx, mountCap(), map[string]string{keySELinuxContext: value})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "Context %s accepted", value)
+	}
+	_, err = getSELinuxContext(ctx, mountCap("context=system_u:object_r:container_file_t:s0"), map[string]string{keySELinuxContext: "system_u:object_r:svirt_sandbox_file_t:s0"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	//Malformed context is rejected by NodePublishVolume before mounting
+	client, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	s := &service{arrays: new(sync.Map), opts: Opts{AutoProbe: true}}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", UnityClient: client})
+	origAuth := authenticateArray
+	defer func() { authenticateArray = origAuth }()
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		return nil
+	}
+	_, err = s.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:          "vol1-NFS-array1-fs_1",
+		TargetPath:        "/var/lib/kubelet/pods/pod1/volumes/kubernetes.io~csi/pvc-1/mount",
+		StagingTargetPath: "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/pvc-1/globalmount",
+		VolumeCapability:  mountCap("context=container_file_t"),
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "Invalid value for seLinuxContext: container_file_t"), "Unexpected error message: %v", err)
+}
+
+func TestCheckFCZoning(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	dir, err := ioutil.TempDir("", "fc_remote_ports")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	origDir := fcRemotePortsDir
+	defer func() { fcRemotePortsDir = origDir }()
+	fcRemotePortsDir = dir
+	s := &service{opts: Opts{NodeName: "node1", FCZoningPolicy: FCZoningFail}}
+
+	//No FC target visible to the node
+	err = s.checkFCZoning(ctx, "array1", []string{"50060160c7e00e2e"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "No FC paths to array array1 from node node1. Check the SAN zoning"), "Unexpected error message: %v", err)
+
+	//No FC target port reported by the array for the node initiators
+	err = s.checkFCZoning(ctx, "array1", []string{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	//Only targets of other arrays visible to the node
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "rport-1:0-0"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "rport-1:0-0", "port_name"), []byte("0x500601690ba00e2e\n"), 0644))
+	err = s.checkFCZoning(ctx, "array1", []string{"50060160c7e00e2e"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	//A target of the array visible to the node
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "rport-1:0-1"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "rport-1:0-1", "port_name"), []byte("0x50060160C7E00E2E\n"), 0644))
+	assert.Nil(t, s.checkFCZoning(ctx, "array1", []string{"50060160c7e00e2e", "50060168c7e00e2e"}))
+
+	//Warn policy only logs the missing paths
+	s.opts.FCZoningPolicy = FCZoningWarn
+	assert.Nil(t, s.checkFCZoning(ctx, "array1", []string{}))
+
+	//Targets that cannot be listed do not block the staging
+	s.opts.FCZoningPolicy = FCZoningFail
+	origTargets := getVisibleFCTargets
+	defer func() { getVisibleFCTargets = origTargets }()
+	getVisibleFCTargets = func(ctx context.Context) ([]string, error) {
+		return nil, errors.New("permission denied")
+	}
+	assert.Nil(t, s.checkFCZoning(ctx, "array1", []string{"50060160c7e00e2e"}))
+}
+
+//fakeISCSIClient is an iSCSI client whose portals each report all the targets, tracking the concurrent calls
+type fakeISCSIClient struct {
+	goiscsi.ISCSIinterface
+	targets    []goiscsi.ISCSITarget
+	mutex      sync.Mutex
+	running    int
+	maxRunning int
+	logins     []string
+}
+
+func (c *fakeISCSIClient) enter() {
+	c.mutex.Lock()
+	c.running++
+	if c.running > c.maxRunning {
+		c.maxRunning = c.running
+	}
+	c.mutex.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	c.mutex.Lock()
+	c.running--
+	c.mutex.Unlock()
+}
+
+func (c *fakeISCSIClient) DiscoverTargets(address string, login bool) ([]goiscsi.ISCSITarget, error) {
+	c.enter()
+	return c.targets, nil
+}
+
+func (c *fakeISCSIClient) PerformLogin(target goiscsi.ISCSITarget) error {
+	c.enter()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.logins = append(c.logins, target.Portal)
+	return nil
+}
+
+func TestISCSIScanConcurrency(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	ips := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6"}
+	targets := make([]goiscsi.ISCSITarget, 0)
+	for _, ip := range ips {
+		targets = append(targets, goiscsi.ISCSITarget{Portal: ip + ":3260", Target: "iqn.1992-04.com.emc:cx.apm00123456789.a0"})
+	}
+
+	origReachable := isISCSIPortalReachable
+	defer func() { isISCSIPortalReachable = origReachable }()
+	var mutex sync.Mutex
+	running, maxRunning := 0, 0
+	isISCSIPortalReachable = func(ctx context.Context, ip string) bool {
+		mutex.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mutex.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		return ip != "10.0.0.6"
+	}
+
+	for _, limit := range []int{1, 3} {
+		running, maxRunning = 0, 0
+		client := &fakeISCSIClient{targets: targets}
+		s := &service{iscsiClient: client, opts: Opts{ISCSIScanConcurrency: limit}}
+
+		//Unreachable portals are skipped and the order of the interfaces is kept
+		assert.Equal(t, ips[:5], s.getValidInterfaceIps(ctx, ips))
+		assert.Equal(t, limit, maxRunning, "Unexpected concurrent portal checks with limit %d", limit)
+
+		//Each reachable portal is logged in once, scanning concurrently within the limit
+		s.iScsiDiscoverAndLogin(ctx, ips)
+		assert.Equal(t, 5, len(client.logins))
+		assert.Equal(t, limit, client.maxRunning, "Unexpected concurrent scans with limit %d", limit)
+	}
+
+	//Default limit applies when not configured
+	assert.Equal(t, defaultISCSIScanConcurrency, (&service{}).getISCSIScanConcurrency())
+}
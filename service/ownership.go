@@ -0,0 +1,64 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dell/gounity/types"
+)
+
+//ownerTagFormat is the tag appended to the description of the volumes created by the driver, so that volumes created
+//by other drivers or users of a shared array are not acted upon
+const ownerTagFormat = "[csi-owner=%s]"
+
+//ownerTagPrefix is the start of the ownership tag of any driver
+const ownerTagPrefix = "[csi-owner="
+
+//getOwnerTag returns the ownership tag of the volumes created by the driver
+func getOwnerTag() string {
+	return fmt.Sprintf(ownerTagFormat, Name)
+}
+
+//addOwnerTag appends the ownership tag to the volume description
+func addOwnerTag(description string) string {
+	if description == "" {
+		return getOwnerTag()
+	}
+	return description + " " + getOwnerTag()
+}
+
+//isOwnedVolume returns true when the volume description bears the ownership tag of the driver
+func isOwnedVolume(description string) bool {
+	return strings.Contains(description, getOwnerTag())
+}
+
+//isOwnedByDriver returns true when the volume bears the ownership tag of the driver, or bears no ownership tag at all
+//as the volumes created by the earlier versions of the driver. Untagged volumes are owned only when their name starts
+//with the volume name prefix of the driver, when it is configured
+func (s *service) isOwnedByDriver(volume types.Volume) bool {
+	description := volume.VolumeContent.Description
+	if isOwnedVolume(description) {
+		return true
+	}
+	if strings.Contains(description, ownerTagPrefix) {
+		return false
+	}
+	if s.opts.VolumeNamePrefix == "" {
+		return true
+	}
+	return strings.HasPrefix(volume.VolumeContent.Name, s.opts.VolumeNamePrefix+"-")
+}
+
+//filterOwnedVolumes returns the volumes created by the driver, or all volumes when listing all volumes is enabled
+func (s *service) filterOwnedVolumes(volumes []types.Volume) []types.Volume {
+	if s.opts.ListAllVolumes {
+		return volumes
+	}
+	owned := make([]types.Volume, 0, len(volumes))
+	for _, volume := range volumes {
+		if s.isOwnedByDriver(volume) {
+			owned = append(owned, volume)
+		}
+	}
+	return owned
+}
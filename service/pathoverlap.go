@@ -0,0 +1,41 @@
+package service
+
+import (
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//defaultKubeletCSIDir is the directory of the kubelet staging and publishing the CSI volumes
+const defaultKubeletCSIDir = "/var/lib/kubelet/plugins/kubernetes.io/csi"
+
+//isPathNested returns true when the paths are the same or one of them is nested within the other
+func isPathNested(first, second string) bool {
+	first, second = filepath.Clean(first), filepath.Clean(second)
+	rel, err := filepath.Rel(first, second)
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+		return true
+	}
+	rel, err = filepath.Rel(second, first)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+//checkMountPathOverlap checks at the start of the node that the private mount directory is distinct from, and not
+//nested within, the ephemeral staging path and the kubelet CSI directory, as the mounts of one would otherwise be
+//mounted again within the other. The ephemeral staging path lives within the kubelet CSI directory by design. Empty
+//paths are not checked
+func (s *service) checkMountPathOverlap() error {
+	pvtMountDir := strings.TrimSpace(s.opts.PvtMountDir)
+	if pvtMountDir == "" {
+		return nil
+	}
+	if stagingPath := strings.TrimSpace(s.opts.EnvEphemeralStagingTargetPath); stagingPath != "" && isPathNested(pvtMountDir, stagingPath) {
+		return status.Errorf(codes.InvalidArgument, "%s %s overlaps %s %s. Configure distinct directories that are not nested within each other", EnvPvtMountDir, pvtMountDir, EnvEphemeralStagingPath, stagingPath)
+	}
+	if kubeletDir := strings.TrimSpace(s.opts.KubeletCSIDir); kubeletDir != "" && isPathNested(pvtMountDir, kubeletDir) {
+		return status.Errorf(codes.InvalidArgument, "%s %s overlaps the kubelet CSI directory %s. Configure a directory that is not nested within it, or set %s", EnvPvtMountDir, pvtMountDir, kubeletDir, EnvKubeletCSIDir)
+	}
+	return nil
+}
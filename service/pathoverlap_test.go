@@ -0,0 +1,48 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"testing"
+)
+
+func TestMountPathOverlap(t *testing.T) {
+	opts := func(pvtMountDir, stagingPath string) Opts {
+		return Opts{PvtMountDir: pvtMountDir, EnvEphemeralStagingTargetPath: stagingPath, KubeletCSIDir: defaultKubeletCSIDir}
+	}
+
+	//Default node configuration
+	s := &service{opts: opts("/var/lib/kubelet/plugins/unity.emc.dell.com/disks", "/var/lib/kubelet/plugins/kubernetes.io/csi/pv/")}
+	assert.Nil(t, s.checkMountPathOverlap())
+
+	//Similar prefixes are not nested
+	s.opts = opts("/var/lib/unity/disks", "/var/lib/unity/disks-ephemeral")
+	assert.Nil(t, s.checkMountPathOverlap())
+
+	for _, paths := range [][]string{
+		{"/var/lib/unity/disks", "/var/lib/unity/disks/"},
+		{"/var/lib/unity/disks/ephemeral", "/var/lib/unity/disks"},
+		{"/var/lib/unity", "/var/lib/unity/disks/ephemeral"},
+	} {
+		s.opts = opts(paths[0], paths[1])
+		err := s.checkMountPathOverlap()
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "Overlap of %v not detected", paths)
+		if err != nil {
+			assert.True(t, strings.Contains(err.Error(), EnvEphemeralStagingPath), "Unexpected error message: %v", err)
+		}
+	}
+
+	//The private mount directory must not overlap the kubelet CSI directory
+	s.opts = opts("/var/lib/kubelet/plugins/kubernetes.io/csi/disks", "")
+	err := s.checkMountPathOverlap()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "kubelet CSI directory"), "Unexpected error message: %v", err)
+	s.opts = opts("/var/lib/kubelet", "")
+	assert.NotNil(t, s.checkMountPathOverlap())
+
+	//An empty kubelet CSI directory disables its check
+	s.opts.KubeletCSIDir = ""
+	assert.Nil(t, s.checkMountPathOverlap())
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/csi-unity/service/utils"
+)
+
+//placementGroupTagFormat is the tag appended to the description of the volumes of a placement group, so that the
+//association of the group with its array can be rebuilt from the arrays after a restart
+const placementGroupTagFormat = "[csi-placement-group=%s]"
+
+//placementGroupTagPattern matches the placement group tag in a volume description
+var placementGroupTagPattern = regexp.MustCompile(`\[csi-placement-group=([^\]]+)\]`)
+
+//addPlacementGroupTag appends the placement group tag to the volume description
+func addPlacementGroupTag(description, group string) string {
+	tag := fmt.Sprintf(placementGroupTagFormat, group)
+	if description == "" {
+		return tag
+	}
+	return description + " " + tag
+}
+
+//getPlacementGroupFromDescription returns the placement group tagged in the volume description, or "" when it has none
+func getPlacementGroupFromDescription(description string) string {
+	match := placementGroupTagPattern.FindStringSubmatch(description)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+//recordPlacementGroup associates the placement group with the array hosting its first volume
+func (s *service) recordPlacementGroup(ctx context.Context, group, arrayID string) {
+	if group == "" {
+		return
+	}
+	log := utils.GetRunidLogger(ctx)
+	if existing, loaded := s.placementGroups.LoadOrStore(group, arrayID); !loaded {
+		log.Infof("Placement group %s is associated with array %s", group, arrayID)
+	} else if existing.(string) != arrayID {
+		log.Debugf("Volume of placement group %s created on array %s. Group stays associated with array %s", group, arrayID, existing)
+	}
+}
+
+//selectPlacementArray returns the array associated with the placement group when it can host the volume, i.e. it is
+//allowed by the topology requirement, supports the protocol and the storage pool, is not in maintenance or read-only, is reachable
+//and has enough free capacity in the storage pool.
+//Otherwise the requested array is returned
+func (s *service) selectPlacementArray(ctx context.Context, group, arrayID, protocol, storagePool string, size int64, accessibility *csi.TopologyRequirement) string {
+	log := utils.GetRunidLogger(ctx)
+	value, ok := s.placementGroups.Load(group)
+	if !ok || value.(string) == arrayID {
+		return arrayID
+	}
+	affinity := value.(string)
+
+	allowed := make([]string, 0)
+	for _, topology := range append(accessibility.GetRequisite(), accessibility.GetPreferred()...) {
+		allowed = append(allowed, getTopologyArrayIds(topology)...)
+	}
+	if len(allowed) > 0 && !utils.ArrayContains(allowed, affinity) {
+		log.Warnf("Array %s of placement group %s is not allowed by the topology requirement. Using array %s", affinity, group, arrayID)
+		return arrayID
+	}
+	if array := s.getStorageArray(affinity); array != nil && !array.isProtocolSupported(protocol) {
+		log.Warnf("Protocol %s is not supported by array %s of placement group %s. Using array %s", protocol, affinity, group, arrayID)
+		return arrayID
+	}
+	if array := s.getStorageArray(affinity); array != nil && !array.isPoolAllowed(storagePool) {
+		log.Warnf("Storage pool %s is not allowed on array %s of placement group %s. Using array %s", storagePool, affinity, group, arrayID)
+		return arrayID
+	}
+	if s.isArrayInMaintenance(affinity) {
+		log.Warnf("Array %s of placement group %s is in maintenance. Using array %s", affinity, group, arrayID)
+		return arrayID
+	}
+	if s.isArrayReadOnly(affinity) {
+		log.Warnf("Array %s of placement group %s is read-only. Using array %s", affinity, group, arrayID)
+		return arrayID
+	}
+	if err := s.requireProbe(ctx, affinity); err != nil {
+		log.Warnf("Array %s of placement group %s is unreachable. Using array %s. Error: %v", affinity, group, arrayID, err)
+		return arrayID
+	}
+	unity, err := s.getUnityClient(ctx, affinity)
+	if err != nil {
+		log.Warnf("Array %s of placement group %s is unreachable. Using array %s. Error: %v", affinity, group, arrayID, err)
+		return arrayID
+	}
+	free, _, err := getStoragePoolCapacity(ctx, unity, storagePool)
+	if err != nil {
+		log.Warnf("Unable to get capacity of storage pool %s on array %s of placement group %s. Using array %s. Error: %v", storagePool, affinity, group, arrayID, utils.GetUnityError(err))
+		return arrayID
+	}
+	if free < uint64(size) {
+		log.Warnf("Storage pool %s on array %s of placement group %s has %d bytes free, %d requested. Using array %s", storagePool, affinity, group, free, size, arrayID)
+		return arrayID
+	}
+	log.Infof("Creating volume on array %s hosting placement group %s instead of array %s", affinity, group, arrayID)
+	return affinity
+}
+
+//getTopologiesForArray returns the topologies that include the array
+func getTopologiesForArray(topologies []*csi.Topology, arrayID string) []*csi.Topology {
+	result := make([]*csi.Topology, 0)
+	for _, topology := range topologies {
+		if utils.ArrayContains(getTopologyArrayIds(topology), arrayID) {
+			result = append(result, topology)
+		}
+	}
+	return result
+}
+
+//rebuildPlacementGroups rebuilds the association of the placement groups with the arrays from the tags in the
+//descriptions of the volumes on the arrays
+func (s *service) rebuildPlacementGroups(ctx context.Context) {
+	log := utils.GetRunidLogger(ctx)
+	for _, array := range s.getStorageArrayList() {
+		if err := s.requireProbe(ctx, array.ArrayId); err != nil {
+			log.Warnf("Unable to rebuild placement groups from array %s. Error: %v", array.ArrayId, err)
+			continue
+		}
+		startToken := 0
+		for {
+			volumes, next, err := listArrayVolumes(ctx, array.UnityClient, startToken, MAX_ENTRIES_VOLUME)
+			if err != nil {
+				log.Warnf("Unable to rebuild placement groups from array %s. Error: %v", array.ArrayId, utils.GetUnityError(err))
+				break
+			}
+			for _, volume := range s.filterOwnedVolumes(volumes) {
+				if group := getPlacementGroupFromDescription(volume.VolumeContent.Description); group != "" {
+					s.recordPlacementGroup(ctx, group, array.ArrayId)
+				}
+			}
+			if next == 0 || next == startToken || len(volumes) == 0 {
+				break
+			}
+			startToken = next
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/gounity"
+	"github.com/dell/gounity/types"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestPlacementGroupAffinity(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	gib := uint64(1024 * 1024 * 1024)
+	client1, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	client2, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:2", true)
+	s := &service{arrays: new(sync.Map), opts: Opts{AutoProbe: true}}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", UnityClient: client1})
+	s.arrays.Store("array2", &StorageArrayConfig{ArrayId: "array2", UnityClient: client2})
+
+	origAuth, origCapacity, origList := authenticateArray, getStoragePoolCapacity, listArrayVolumes
+	defer func() {
+		authenticateArray, getStoragePoolCapacity, listArrayVolumes = origAuth, origCapacity, origList
+	}()
+	array2Reachable := true
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		if array.ArrayId == "array2" && !array2Reachable {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	getStoragePoolCapacity = func(ctx context.Context, unity *gounity.Client, storagePool string) (uint64, uint64, error) {
+		return 10 * gib, 100 * gib, nil
+	}
+	listArrayVolumes = func(ctx context.Context, unity *gounity.Client, startToken, maxEntries int) ([]types.Volume, int, error) {
+		volume := types.Volume{}
+		if unity == client2 {
+			volume.VolumeContent.Description = addOwnerTag(addPlacementGroupTag("database volume", "db"))
+		}
+		return []types.Volume{volume}, 0, nil
+	}
+
+	//The association is rebuilt from the tags of the volumes
+	s.rebuildPlacementGroups(ctx)
+	assert.Equal(t, "db", getPlacementGroupFromDescription("database volume [csi-placement-group=db]"))
+	assert.Equal(t, "", getPlacementGroupFromDescription("database volume"))
+
+	//Affinity honored: volumes of the group go to the array hosting it
+	assert.Equal(t, "array2", s.selectPlacementArray(ctx, "db", "array1", FC, "pool_1", int64(5*gib), nil))
+	topology := &csi.TopologyRequirement{Preferred: []*csi.Topology{{Segments: map[string]string{Name + "/array1-iscsi": "true", Name + "/array2-iscsi": "true"}}}}
+	assert.Equal(t, "array2", s.selectPlacementArray(ctx, "db", "array1", FC, "pool_1", int64(5*gib), topology))
+
+	//Unknown group stays on the requested array
+	assert.Equal(t, "array1", s.selectPlacementArray(ctx, "web", "array1", FC, "pool_1", int64(5*gib), nil))
+
+	//Affinity fallback: the array of the group is full
+	assert.Equal(t, "array1", s.selectPlacementArray(ctx, "db", "array1", FC, "pool_1", int64(50*gib), nil))
+
+	//Affinity fallback: the array of the group is not allowed by the topology
+	topology = &csi.TopologyRequirement{Preferred: []*csi.Topology{{Segments: map[string]string{Name + "/array1-iscsi": "true"}}}}
+	assert.Equal(t, "array1", s.selectPlacementArray(ctx, "db", "array1", FC, "pool_1", int64(5*gib), topology))
+
+	//Affinity fallback: the array of the group does not support the protocol
+	s.getStorageArray("array2").Protocols = []string{NFS}
+	assert.Equal(t, "array1", s.selectPlacementArray(ctx, "db", "array1", FC, "pool_1", int64(5*gib), nil))
+	s.getStorageArray("array2").Protocols = nil
+
+	//Affinity fallback: the array of the group is in maintenance
+	s.setArrayMaintenance("array2", true)
+	assert.Equal(t, "array1", s.selectPlacementArray(ctx, "db", "array1", FC, "pool_1", int64(5*gib), nil))
+	s.setArrayMaintenance("array2", false)
+
+	//Affinity fallback: the array of the group is read-only
+	s.getStorageArray("array2").ReadOnly = true
+	assert.Equal(t, "array1", s.selectPlacementArray(ctx, "db", "array1", FC, "pool_1", int64(5*gib), nil))
+	s.getStorageArray("array2").ReadOnly = false
+
+	//Affinity fallback: the array of the group is unreachable
+	array2Reachable = false
+	assert.Equal(t, "array1", s.selectPlacementArray(ctx, "db", "array1", FC, "pool_1", int64(5*gib), nil))
+
+	//The first array hosting a group keeps the association
+	s.recordPlacementGroup(ctx, "web", "array1")
+	s.recordPlacementGroup(ctx, "web", "array2")
+	group, _ := s.placementGroups.Load("web")
+	assert.Equal(t, "array1", group)
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/csi-unity/service/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//keyMountPropagation is the storage class parameter setting the mount propagation of the target path of the volumes
+const keyMountPropagation = "mountPropagation"
+
+//mountPropagationModes are the mount propagation modes supported on the target path of a volume
+var mountPropagationModes = map[string]bool{
+	"private":  true,
+	"rprivate": true,
+	"slave":    true,
+	"rslave":   true,
+	"shared":   true,
+	"rshared":  true,
+}
+
+//setMountPropagationContext passes the mountPropagation storage class parameter to the node in the volume context
+func setMountPropagationContext(volumeResp *csi.CreateVolumeResponse, params map[string]string) {
+	if value := strings.TrimSpace(params[keyMountPropagation]); value != "" {
+		volumeResp.Volume.VolumeContext[keyMountPropagation] = value
+	}
+}
+
+//getMountPropagation returns the mount propagation requested in the mount flags of the volume capability or in the
+//volume context, or "" when none is requested. Unsupported values, conflicting requests and propagation of raw block
+//volumes are rejected
+func getMountPropagation(ctx context.Context, volCap *csi.VolumeCapability, volumeContext map[string]string) (string, error) {
+	rid, _ := utils.GetRunidAndLogger(ctx)
+	propagation := ""
+	if value := strings.ToLower(strings.TrimSpace(volumeContext[keyMountPropagation])); value != "" {
+		if !mountPropagationModes[value] {
+			return "", status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Invalid value for %s: %s. Supported values are private, rprivate, slave, rslave, shared and rshared", keyMountPropagation, value))
+		}
+		propagation = value
+	}
+	for _, flag := range volCap.GetMount().GetMountFlags() {
+		flag = strings.ToLower(strings.TrimSpace(flag))
+		if !mountPropagationModes[flag] {
+			continue
+		}
+		if propagation != "" && propagation != flag {
+			return "", status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Conflicting mount propagation %s and %s requested", propagation, flag))
+		}
+		propagation = flag
+	}
+	if propagation != "" && accTypeBlock(volCap) {
+		return "", status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Mount propagation %s is not supported for raw block volumes", propagation))
+	}
+	return propagation, nil
+}
+
+//setMountPropagationFlag sets the mount propagation in the mount flags of the volume capability, so that it is applied
+//to the bind mount of the target path
+func setMountPropagationFlag(volCap *csi.VolumeCapability, propagation string) {
+	mount := volCap.GetMount()
+	if propagation == "" || mount == nil {
+		return
+	}
+	flags := make([]string, 0, len(mount.MountFlags)+1)
+	for _, flag := range mount.MountFlags {
+		if !mountPropagationModes[strings.ToLower(strings.TrimSpace(flag))] {
+			flags = append(flags, flag)
+		}
+	}
+	mount.MountFlags = append(flags, propagation)
+}
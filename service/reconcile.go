@@ -0,0 +1,156 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+
+	"github.com/dell/csi-unity/service/utils"
+	"github.com/dell/gounity/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	//loginSessionInfoPath is the Unity REST resource used to open a session and obtain the CSRF token
+	loginSessionInfoPath = "/api/types/loginSessionInfo/instances"
+	//logoutPath is the Unity REST action closing the session
+	logoutPath = "/api/types/loginSessionInfo/action/logout"
+	//modifyLuPathFormat is the Unity REST action modifying the LUN of a storage resource
+	modifyLuPathFormat = "/api/instances/storageResource/%s/action/modifyLu"
+	//csrfTokenHeader carries the CSRF token required by the Unity REST API for POST requests
+	csrfTokenHeader = "EMC-CSRF-TOKEN"
+)
+
+//lunAttributes are the LUN attributes that are re-applied to a volume restored from a snapshot.
+//Only the attributes that are set are modified
+type lunAttributes struct {
+	TieringPolicy *int64
+	DataReduction *bool
+	HostIOLimitId string
+}
+
+//restSession is a session of direct calls to the Unity REST API, for the operations gounity does not support
+type restSession struct {
+	ctx     context.Context
+	array   *StorageArrayConfig
+	client  *http.Client
+	gateway string
+	token   string
+}
+
+//openRestSession logs in to the Unity REST API of the array and obtains the CSRF token required by POST requests
+func openRestSession(ctx context.Context, array *StorageArrayConfig) (*restSession, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	session := &restSession{
+		ctx:     ctx,
+		array:   array,
+		client:  newArrayHTTPClient(array, jar),
+		gateway: strings.TrimSuffix(array.RestGateway, "/"),
+	}
+	resp, err := session.do(http.MethodGet, loginSessionInfoPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newRestStatusError(resp, "login failed")
+	}
+	session.token = resp.Header.Get(csrfTokenHeader)
+	return session, nil
+}
+
+//do sends the request of the session
+func (r *restSession) do(method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, r.gateway+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(r.ctx)
+	req.SetBasicAuth(r.array.Username, r.array.Password)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-EMC-REST-CLIENT", "true")
+	if r.token != "" {
+		req.Header.Set(csrfTokenHeader, r.token)
+	}
+	return r.client.Do(req)
+}
+
+//close logs out of the session
+func (r *restSession) close() {
+	if resp, err := r.do(http.MethodPost, logoutPath, []byte("{}")); err == nil {
+		resp.Body.Close()
+	}
+}
+
+//modifyLunAttributes modifies the attributes of the LUN using the Unity REST API, as gounity does not support modifying a LUN.
+//It is a variable so that tests can override it
+var modifyLunAttributes = func(ctx context.Context, array *StorageArrayConfig, lunId string, attrs lunAttributes) error {
+	session, err := openRestSession(ctx, array)
+	if err != nil {
+		return err
+	}
+	defer session.close()
+
+	lunParameters := make(map[string]interface{})
+	if attrs.TieringPolicy != nil {
+		lunParameters["fastVPParameters"] = map[string]interface{}{"tieringPolicy": *attrs.TieringPolicy}
+	}
+	if attrs.DataReduction != nil {
+		lunParameters["isDataReductionEnabled"] = *attrs.DataReduction
+	}
+	if attrs.HostIOLimitId != "" {
+		lunParameters["ioLimitParameters"] = map[string]interface{}{"ioLimitPolicy": map[string]string{"id": attrs.HostIOLimitId}}
+	}
+	body, err := json.Marshal(map[string]interface{}{"lunParameters": lunParameters})
+	if err != nil {
+		return err
+	}
+	resp, err := session.do(http.MethodPost, fmt.Sprintf(modifyLuPathFormat, lunId), body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return newRestStatusError(resp, "modify LUN failed")
+	}
+	return nil
+}
+
+//reconcileRestoredVolume re-applies the requested tiering policy, data reduction and host IO limit to a volume restored
+//from a snapshot, so that it matches the requested parameters rather than the attributes inherited from the source volume
+func (s *service) reconcileRestoredVolume(ctx context.Context, volResp *types.Volume, crParams *CRParams, hostIOLimitId, arrayID string) error {
+	ctx, log, rid := GetRunidLog(ctx)
+	attrs := lunAttributes{HostIOLimitId: hostIOLimitId}
+	if int64(volResp.VolumeContent.TieringPolicy) != crParams.TieringPolicy {
+		attrs.TieringPolicy = &crParams.TieringPolicy
+	}
+	if volResp.VolumeContent.IsDataReductionEnabled != crParams.DataReduction {
+		attrs.DataReduction = &crParams.DataReduction
+	}
+	if attrs.TieringPolicy == nil && attrs.DataReduction == nil && attrs.HostIOLimitId == "" {
+		log.Debugf("Volume %s restored from snapshot already matches the requested parameters", volResp.VolumeContent.ResourceId)
+		return nil
+	}
+
+	array := s.getStorageArray(arrayID)
+	if array == nil {
+		return status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Unable to get array %s", arrayID))
+	}
+	log.Infof("Re-applying requested parameters to volume %s restored from snapshot", volResp.VolumeContent.ResourceId)
+	err := withRetry(ctx, s.opts.RestMaxRetries, func() error {
+		return modifyLunAttributes(ctx, array, volResp.VolumeContent.ResourceId, attrs)
+	})
+	if err != nil {
+		return status.Error(codes.Unknown, utils.GetMessageWithRunID(rid, "Unable to re-apply requested parameters to volume %s restored from snapshot. Error: %v", volResp.VolumeContent.ResourceId, utils.GetUnityError(err)))
+	}
+	return nil
+}
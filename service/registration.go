@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/dell/csi-unity/service/utils"
+)
+
+//registrationProbeInterval is the interval between the probes of an array waiting for the registration of the node
+var registrationProbeInterval = 5 * time.Second
+
+//probeArrayForRegistration probes the array before the registration of the node. It is a variable so that tests can
+//override it
+var probeArrayForRegistration = func(ctx context.Context, s *service, array *StorageArrayConfig) error {
+	return s.nodeProbe(ctx, array.ArrayId)
+}
+
+//registerNodeOnArray adds the node information into the array. It is a variable so that tests can override it
+var registerNodeOnArray = func(ctx context.Context, s *service, array *StorageArrayConfig) error {
+	return s.addNodeInformationIntoArray(ctx, array)
+}
+
+//waitForRegistrationProbe waits for the array to be probed before the first registration of the node, so that the host
+//is registered with an authenticated client. The array is probed until the probe succeeds or the configured timeout
+//expires, after which the registration proceeds as without the wait
+func (s *service) waitForRegistrationProbe(ctx context.Context, array *StorageArrayConfig) {
+	if s.opts.RegistrationProbeTimeout <= 0 || array.IsProbeSuccess {
+		return
+	}
+	log := utils.GetRunidLogger(ctx)
+	timeout := time.Duration(s.opts.RegistrationProbeTimeout) * time.Second
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		err := probeArrayForRegistration(ctx, s, array)
+		if err == nil {
+			log.Debugf("Array %s probed, registering the node", array.ArrayId)
+			return
+		}
+		log.Debugf("Array %s not probed yet, deferring the registration of the node. Error: %v", array.ArrayId, err)
+		if retrySleep(waitCtx, registrationProbeInterval) != nil {
+			log.Warnf("Array %s not probed within %v. Registering the node anyway", array.ArrayId, timeout)
+			return
+		}
+	}
+}
+
+//registerNode registers the node on the array once the array is probed, and records the successful registration
+func (s *service) registerNode(ctx context.Context, array *StorageArrayConfig) error {
+	log := utils.GetRunidLogger(ctx)
+	s.waitForRegistrationProbe(ctx, array)
+	if err := registerNodeOnArray(ctx, s, array); err != nil {
+		log.Debugf("Adding node [%s] failed, Error: [%v]", array.ArrayId, err)
+		return err
+	}
+	array.IsHostAdded = true
+	log.Debugf("Node [%s] Added successfully", array.ArrayId)
+	return nil
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRegistrationWaitsForProbe(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	origProbe, origRegister, origSleep := probeArrayForRegistration, registerNodeOnArray, retrySleep
+	defer func() {
+		probeArrayForRegistration, registerNodeOnArray, retrySleep = origProbe, origRegister, origSleep
+	}()
+
+	events := make([]string, 0)
+	probeFailures := 2
+	probeArrayForRegistration = func(ctx context.Context, s *service, array *StorageArrayConfig) error {
+		if probeFailures > 0 {
+			probeFailures--
+			events = append(events, "probe failed")
+			return errors.New("array not authenticated")
+		}
+		events = append(events, "probe")
+		return nil
+	}
+	registerNodeOnArray = func(ctx context.Context, s *service, array *StorageArrayConfig) error {
+		events = append(events, "register")
+		return nil
+	}
+	retrySleep = func(ctx context.Context, delay time.Duration) error {
+		return ctx.Err()
+	}
+
+	//Registration is deferred until the probe succeeds
+	s := &service{opts: Opts{RegistrationProbeTimeout: 60}}
+	array := &StorageArrayConfig{ArrayId: "array1"}
+	assert.Nil(t, s.registerNode(ctx, array))
+	assert.Equal(t, []string{"probe failed", "probe failed", "probe", "register"}, events)
+	assert.True(t, array.IsHostAdded)
+
+	//Probed arrays are registered without waiting
+	events = events[:0]
+	array = &StorageArrayConfig{ArrayId: "array2", IsProbeSuccess: true}
+	assert.Nil(t, s.registerNode(ctx, array))
+	assert.Equal(t, []string{"register"}, events)
+
+	//Registration proceeds when the probe does not succeed within the timeout
+	events = events[:0]
+	probeFailures = 100
+	sleeps := 0
+	retrySleep = func(ctx context.Context, delay time.Duration) error {
+		sleeps++
+		if sleeps > 3 {
+			return context.DeadlineExceeded
+		}
+		return nil
+	}
+	array = &StorageArrayConfig{ArrayId: "array3"}
+	assert.Nil(t, s.registerNode(ctx, array))
+	assert.Equal(t, "register", events[len(events)-1])
+	assert.Equal(t, 4, len(events)-1)
+
+	//Without the timeout the node is registered without waiting
+	events = events[:0]
+	s.opts.RegistrationProbeTimeout = 0
+	registerNodeOnArray = func(ctx context.Context, s *service, array *StorageArrayConfig) error {
+		events = append(events, "register")
+		return errors.New("host registration failed")
+	}
+	array = &StorageArrayConfig{ArrayId: "array4"}
+	assert.NotNil(t, s.registerNode(ctx, array))
+	assert.Equal(t, []string{"register"}, events)
+	assert.False(t, array.IsHostAdded)
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+//configReloadDebounce is the time to wait for further signals after a SIGHUP, so that a burst of signals reloads the
+//driver config once
+var configReloadDebounce = time.Second
+
+//reloadDriverConfig reloads the driver config. It is a variable so that tests can override it
+var reloadDriverConfig = func(ctx context.Context, s *service) error {
+	return s.syncDriverConfig(ctx)
+}
+
+//startConfigReloadOnSignal reloads the driver config on SIGHUP, for drivers managed outside Kubernetes where the
+//config file is not updated through a mounted secret. It runs alongside the config file watcher until the context is done
+func (s *service) startConfigReloadOnSignal(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(signals)
+		s.reloadOnSignal(ctx, signals)
+	}()
+}
+
+//reloadOnSignal reloads the driver config, and the node information in node mode, for every burst of signals received
+//on the channel, until the context is done. The last known good config is kept when the reload fails
+func (s *service) reloadOnSignal(ctx context.Context, signals <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+		}
+		timer := time.NewTimer(configReloadDebounce)
+	debounce:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-signals:
+			case <-timer.C:
+				break debounce
+			}
+		}
+
+		reloadCtx, log := incrementLogId(ctx, "sighup")
+		log.Info("****************SIGHUP received. Reloading the driver config****************")
+		if err := reloadDriverConfig(reloadCtx, s); err != nil {
+			log.Debug("Driver configuration array length:", s.getStorageArrayLength())
+			log.Error("Invalid driver configuration. Error:", err)
+		}
+		if s.mode == "node" {
+			syncNodeInfoChan <- true
+		}
+	}
+}
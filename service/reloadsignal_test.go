@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestConfigReloadOnSignal(t *testing.T) {
+	origReload, origDebounce := reloadDriverConfig, configReloadDebounce
+	defer func() { reloadDriverConfig, configReloadDebounce = origReload, origDebounce }()
+	configReloadDebounce = 100 * time.Millisecond
+	var reloads int32
+	reloadDriverConfig = func(ctx context.Context, s *service) error {
+		atomic.AddInt32(&reloads, 1)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &service{arrays: new(sync.Map), mode: "controller"}
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		s.reloadOnSignal(ctx, signals)
+		close(done)
+	}()
+
+	//A burst of signals reloads the config once
+	signals <- syscall.SIGHUP
+	signals <- syscall.SIGHUP
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&reloads))
+
+	//A later signal reloads the config again
+	signals <- syscall.SIGHUP
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&reloads))
+
+	//The reload stops with the context
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Config reload did not stop with the context")
+	}
+}
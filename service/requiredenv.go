@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	csictx "github.com/rexray/gocsi/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//driverConfigArg is the argument providing the driver config file, which meets the driver config requirement as the
+//EnvArrayConfigJSON variable does
+const driverConfigArg = "--driver-config"
+
+//requiredEnvVars are the environment variables the driver requires in each mode. Each requirement lists alternatives,
+//any one of them being set meets it. The controller mode, and the mode serving both services, require the driver config
+//file or the driver config JSON
+var requiredEnvVars = map[string][][]string{
+	"node":       {{EnvNodeName}},
+	"controller": {{driverConfigArg, EnvArrayConfigJSON}},
+	"":           {{driverConfigArg, EnvArrayConfigJSON}},
+}
+
+//getRequiredEnvVars returns the environment variables required in the mode, including the variables listed in
+//X_CSI_UNITY_REQUIRED_ENV_VARS
+func getRequiredEnvVars(ctx context.Context, mode string) [][]string {
+	required := append([][]string{}, requiredEnvVars[mode]...)
+	if extra, ok := csictx.LookupEnv(ctx, EnvRequiredEnvVars); ok {
+		for _, name := range strings.Split(extra, ",") {
+			if name = strings.TrimSpace(name); name != "" && !isRequired(required, name) {
+				required = append(required, []string{name})
+			}
+		}
+	}
+	return required
+}
+
+//isRequired returns true when the variable alone is a requirement
+func isRequired(required [][]string, name string) bool {
+	for _, alternatives := range required {
+		if len(alternatives) == 1 && alternatives[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+//isRequirementSet returns true when the variable, or the driver config argument, is set and not empty
+func isRequirementSet(ctx context.Context, name string) bool {
+	if name == driverConfigArg {
+		return strings.TrimSpace(DriverConfig) != ""
+	}
+	value, ok := csictx.LookupEnv(ctx, name)
+	return ok && strings.TrimSpace(value) != ""
+}
+
+//checkRequiredEnvVars returns an error listing all the environment variables required in the mode that are not set or
+//empty, so that a first deployment is fixed at once rather than one variable at a time
+func checkRequiredEnvVars(ctx context.Context, mode string) error {
+	missing := make([]string, 0)
+	for _, alternatives := range getRequiredEnvVars(ctx, mode) {
+		set := false
+		for _, name := range alternatives {
+			if isRequirementSet(ctx, name) {
+				set = true
+				break
+			}
+		}
+		if !set {
+			missing = append(missing, strings.Join(alternatives, " or "))
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if mode == "" {
+		mode = "controller and node"
+	}
+	return status.Error(codes.InvalidArgument, fmt.Sprintf("Required environment variables are not set for the %s mode: %s", mode, strings.Join(missing, ", ")))
+}
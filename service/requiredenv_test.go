@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"github.com/rexray/gocsi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRequiredEnvVars(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	for _, name := range []string{EnvNodeName, EnvArrayConfigJSON, EnvRequiredEnvVars, EnvISCSIChroot} {
+		if value, ok := os.LookupEnv(name); ok {
+			defer os.Setenv(name, value)
+		} else {
+			defer os.Unsetenv(name)
+		}
+		os.Unsetenv(name)
+	}
+	origConfig := DriverConfig
+	defer func() { DriverConfig = origConfig }()
+	DriverConfig = ""
+
+	//Node mode lists all the missing variables at once
+	os.Setenv(EnvRequiredEnvVars, "X_CSI_ISCSI_CHROOT")
+	err := checkRequiredEnvVars(ctx, "node")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.HasSuffix(err.Error(), "not set for the node mode: X_CSI_UNITY_NODENAME, X_CSI_ISCSI_CHROOT"), "Unexpected error message: %v", err)
+	os.Unsetenv(EnvRequiredEnvVars)
+
+	//BeforeServe fails with the consolidated list
+	os.Setenv(gocsi.EnvVarMode, "node")
+	defer os.Unsetenv(gocsi.EnvVarMode)
+	err = new(service).BeforeServe(ctx, nil, nil)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "node mode: X_CSI_UNITY_NODENAME"), "Unexpected error message: %v", err)
+
+	//Empty values are missing
+	os.Setenv(EnvNodeName, " ")
+	err = checkRequiredEnvVars(ctx, "node")
+	assert.True(t, strings.HasSuffix(err.Error(), "node mode: X_CSI_UNITY_NODENAME"), "Unexpected error message: %v", err)
+	os.Setenv(EnvNodeName, "worker-1")
+	assert.Nil(t, checkRequiredEnvVars(ctx, "node"))
+
+	//Controller mode requires the driver config file or the driver config JSON instead
+	os.Unsetenv(EnvNodeName)
+	err = checkRequiredEnvVars(ctx, "controller")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.HasSuffix(err.Error(), "controller mode: --driver-config or X_CSI_UNITY_ARRAY_CONFIG_JSON"), "Unexpected error message: %v", err)
+	err = checkRequiredEnvVars(ctx, "")
+	assert.True(t, strings.HasSuffix(err.Error(), "controller and node mode: --driver-config or X_CSI_UNITY_ARRAY_CONFIG_JSON"), "Unexpected error message: %v", err)
+	os.Setenv(EnvArrayConfigJSON, `{"storageArrayList": []}`)
+	assert.Nil(t, checkRequiredEnvVars(ctx, "controller"))
+	os.Unsetenv(EnvArrayConfigJSON)
+	DriverConfig = "/unity-config/config"
+	assert.Nil(t, checkRequiredEnvVars(ctx, "controller"))
+	assert.Nil(t, checkRequiredEnvVars(ctx, ""))
+
+	//Additional variables are required in every mode
+	os.Setenv(EnvRequiredEnvVars, "X_CSI_ISCSI_CHROOT, X_CSI_UNITY_NODENAME")
+	err = checkRequiredEnvVars(ctx, "controller")
+	assert.True(t, strings.HasSuffix(err.Error(), "controller mode: X_CSI_ISCSI_CHROOT, X_CSI_UNITY_NODENAME"), "Unexpected error message: %v", err)
+	err = checkRequiredEnvVars(ctx, "node")
+	assert.True(t, strings.HasSuffix(err.Error(), "node mode: X_CSI_UNITY_NODENAME, X_CSI_ISCSI_CHROOT"), "Unexpected error message: %v", err)
+}
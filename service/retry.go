@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dell/csi-unity/service/utils"
+)
+
+//defaultRestMaxRetries is the default maximum number of retries of a throttled Unity REST call
+const defaultRestMaxRetries = 3
+
+//restRetryBaseDelay is the delay before the first retry when the array does not send a Retry-After header.
+//The delay doubles on every retry up to restRetryMaxDelay. They are variables so that tests can override them
+var restRetryBaseDelay = time.Second
+var restRetryMaxDelay = 30 * time.Second
+
+//retrySleep waits for the given duration or until the context is done. It is a variable so that tests can override it
+var retrySleep = func(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+//restStatusError is returned by the direct calls to the Unity REST API when the response status is not successful
+type restStatusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Message    string
+}
+
+func (e *restStatusError) Error() string {
+	return fmt.Sprintf("%s with response status %d %s", e.Message, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+//newRestStatusError returns the error for the unsuccessful response, including the delay requested by its Retry-After header
+func newRestStatusError(resp *http.Response, message string) error {
+	return &restStatusError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		Message:    message,
+	}
+}
+
+//parseRetryAfter parses a Retry-After header given either in seconds or as a HTTP date. Returns 0 when it is not set or invalid
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+//isRetryableError returns true when the error reports that the array is throttling requests (429) or is temporarily
+//unavailable (503), along with the delay requested by the array if known
+func isRetryableError(err error) (bool, time.Duration) {
+	if err == nil {
+		return false, 0
+	}
+	if e, ok := err.(*restStatusError); ok {
+		return isRetryableStatus(e.StatusCode), e.RetryAfter
+	}
+	return isRetryableStatus(utils.GetUnityHTTPStatusCode(err)), 0
+}
+
+//isRetryableStatus returns true for the 429 and 503 response statuses
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable
+}
+
+//createOnce returns the operation creating a resource for withRetry and withReauth, which are otherwise only safe for
+//idempotent operations. As the array may have created the resource before a failed response, a retry first looks up
+//the resource by name and creates it again only when it is not found
+func createOnce(create func() error, lookup func() bool) func() error {
+	attempted := false
+	return func() error {
+		if attempted && lookup() {
+			return nil
+		}
+		attempted = true
+		return create()
+	}
+}
+
+//withRetry runs the Unity operation and retries it with backoff, up to maxRetries times, while it fails because
+//the array is throttling requests or temporarily unavailable. Other failures are returned without retrying
+func withRetry(ctx context.Context, maxRetries int, op func() error) error {
+	log := utils.GetRunidLogger(ctx)
+	delay := restRetryBaseDelay
+	for attempt := 0; ; attempt++ {
+		err := op()
+		retryable, retryAfter := isRetryableError(err)
+		if !retryable || attempt >= maxRetries {
+			return err
+		}
+		wait := delay
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		if wait > restRetryMaxDelay {
+			wait = restRetryMaxDelay
+		}
+		log.Infof("Unity REST call failed with a retryable error. Retrying in %v (retry %d of %d). Error: %v", wait, attempt+1, maxRetries, err)
+		if sleepErr := retrySleep(ctx, wait); sleepErr != nil {
+			return err
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryOn429WithRetryAfter(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	origSleep := retrySleep
+	defer func() { retrySleep = origSleep }()
+	delays := make([]time.Duration, 0)
+	retrySleep = func(ctx context.Context, delay time.Duration) error {
+		delays = append(delays, delay)
+		return nil
+	}
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	array := &StorageArrayConfig{ArrayId: "array1", RestGateway: server.URL}
+	err := withRetry(ctx, 3, func() error {
+		return getBasicSystemInfo(ctx, array)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, []time.Duration{2 * time.Second}, delays)
+
+	//Retries are capped by the configured maximum
+	calls := 0
+	delays = delays[:0]
+	err = withRetry(ctx, 2, func() error {
+		calls++
+		return &restStatusError{StatusCode: http.StatusServiceUnavailable, Message: "maintenance"}
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, []time.Duration{restRetryBaseDelay, 2 * restRetryBaseDelay}, delays)
+
+	//gounity errors are classified by their response status, not by their message
+	calls = 0
+	err = withRetry(ctx, 1, func() error {
+		calls++
+		throttled := &unityTestError{}
+		throttled.ErrorContent.HTTPStatusCode = http.StatusTooManyRequests
+		return throttled
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, calls)
+	calls = 0
+	err = withRetry(ctx, 1, func() error {
+		calls++
+		return errors.New("error: 429 Too Many Requests")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestCreateOnceRetry(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	origSleep := retrySleep
+	defer func() { retrySleep = origSleep }()
+	retrySleep = func(ctx context.Context, delay time.Duration) error {
+		return nil
+	}
+	unavailable := &restStatusError{StatusCode: http.StatusServiceUnavailable, Message: "create failed"}
+
+	//The resource created before the failed response is found and not created again
+	creates, lookups, exists := 0, 0, false
+	create := func() error {
+		creates++
+		exists = true
+		return unavailable
+	}
+	lookup := func() bool {
+		lookups++
+		return exists
+	}
+	err := withRetry(ctx, 3, createOnce(create, lookup))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, creates)
+	assert.Equal(t, 1, lookups)
+
+	//The resource not created is created again
+	creates, lookups = 0, 0
+	err = withRetry(ctx, 2, createOnce(func() error {
+		creates++
+		return unavailable
+	}, func() bool {
+		lookups++
+		return false
+	}))
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, creates)
+	assert.Equal(t, 2, lookups)
+}
+
+func TestNoRetryOn400(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	origSleep := retrySleep
+	defer func() { retrySleep = origSleep }()
+	retrySleep = func(ctx context.Context, delay time.Duration) error {
+		t.Fatalf("Unexpected retry after %v", delay)
+		return nil
+	}
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	array := &StorageArrayConfig{ArrayId: "array1", RestGateway: server.URL}
+	err := withRetry(ctx, 3, func() error {
+		return getBasicSystemInfo(ctx, array)
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, requests)
+	e, ok := err.(*restStatusError)
+	if assert.True(t, ok) {
+		assert.Equal(t, http.StatusBadRequest, e.StatusCode)
+	}
+
+	//Resource ids containing the status digits are not mistaken for a retryable status
+	retryable, _ := isRetryableError(errors.New("filesystem fs_1503 not found"))
+	assert.False(t, retryable)
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dell/csi-unity/service/utils"
+	"github.com/sirupsen/logrus"
+)
+
+//runIdGenerator generates the runids correlating the log messages of an operation. Requests without a request id get
+//a number, and background routines get their prefix and a number counted per prefix, e.g. config-1 or node-2, so that
+//request and background runids never collide
+type runIdGenerator struct {
+	requests   int64
+	mutex      sync.Mutex
+	background map[string]int64
+}
+
+//runIds generates the runids of the requests and the background routines of the driver
+var runIds = &runIdGenerator{}
+
+//nextRequestId returns the runid of a request without a request id
+func (g *runIdGenerator) nextRequestId() string {
+	return strconv.FormatInt(atomic.AddInt64(&g.requests, 1), 10)
+}
+
+//nextBackgroundId returns the next runid of the background routine with the prefix, starting from 0
+func (g *runIdGenerator) nextBackgroundId(prefix string) string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.background == nil {
+		g.background = make(map[string]int64)
+	}
+	id := fmt.Sprintf("%s-%d", prefix, g.background[prefix])
+	g.background[prefix]++
+	return id
+}
+
+//copyLogFields returns a copy of the log fields of the context, so that the fields of a context are never modified
+//by the operations derived from it
+func copyLogFields(ctx context.Context) logrus.Fields {
+	fields := logrus.Fields{}
+	if parent, ok := ctx.Value(utils.LogFields).(logrus.Fields); ok {
+		for key, value := range parent {
+			fields[key] = value
+		}
+	}
+	return fields
+}
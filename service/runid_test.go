@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"github.com/dell/csi-unity/service/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRunIdGeneratorUnique(t *testing.T) {
+	generator := &runIdGenerator{}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs())
+	var mutex sync.Mutex
+	ids := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			generated := make([]string, 0)
+			for j := 0; j < 200; j++ {
+				generated = append(generated, generator.nextRequestId())
+				generated = append(generated, generator.nextBackgroundId([]string{"config", "node"}[j%2]))
+				_, _, rid := GetRunidLog(ctx)
+				generated = append(generated, "request "+rid)
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			for _, id := range generated {
+				ids[id]++
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 20*200*3, len(ids))
+	for id, count := range ids {
+		assert.Equal(t, 1, count, "Runid %s generated %d times", id, count)
+	}
+	assert.Equal(t, 1, ids["config-0"])
+	assert.Equal(t, 1, ids["node-1999"])
+}
+
+func TestIncrementLogId(t *testing.T) {
+	ctx, _ := setArrayIdContext(context.Background(), "array1")
+
+	//Background runids of any prefix are numbered independently and keep the fields of the parent context
+	first, _ := incrementLogId(ctx, "initiator")
+	assert.NotNil(t, first)
+	second, log := incrementLogId(ctx, "initiator")
+	firstId := first.Value(utils.LogFields).(logrus.Fields)[utils.RUNID].(string)
+	secondId := second.Value(utils.LogFields).(logrus.Fields)[utils.RUNID].(string)
+	assert.True(t, strings.HasPrefix(firstId, "initiator-"), "Unexpected runid %s", firstId)
+	assert.NotEqual(t, firstId, secondId)
+	assert.Equal(t, "array1", log.Data[utils.ARRAYID])
+
+	//Parent context fields are not modified
+	_, found := ctx.Value(utils.LogFields).(logrus.Fields)[utils.RUNID]
+	assert.False(t, found)
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/csi-unity/service/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//keySELinuxContext is the storage class parameter and mount option setting the SELinux context of the filesystem of
+//the volumes
+const keySELinuxContext = "seLinuxContext"
+
+//seLinuxContextFlag is the mount flag applying the SELinux context to a filesystem mount
+const seLinuxContextFlag = "context"
+
+//seLinuxContextRegex matches the user:role:type[:range] SELinux contexts, e.g.
+//system_u:object_r:container_file_t:s0:c1,c2
+var seLinuxContextRegex = regexp.MustCompile(`^[A-Za-z0-9_.]+:[A-Za-z0-9_.]+:[A-Za-z0-9_.]+(:s[0-9]+(:c[0-9]+([.,]c[0-9]+)*)?(-s[0-9]+(:c[0-9]+([.,]c[0-9]+)*)?)?)?$`)
+
+//setSELinuxContext passes the seLinuxContext storage class parameter to the node in the volume context
+func setSELinuxContext(volumeResp *csi.CreateVolumeResponse, params map[string]string) {
+	if value := strings.TrimSpace(params[keySELinuxContext]); value != "" {
+		volumeResp.Volume.VolumeContext[keySELinuxContext] = value
+	}
+}
+
+//parseSELinuxContextFlag returns the SELinux context of a seLinuxContext or context mount flag, and false for other flags
+func parseSELinuxContextFlag(flag string) (string, bool) {
+	parts := strings.SplitN(strings.TrimSpace(flag), "=", 2)
+	if len(parts) != 2 || (parts[0] != keySELinuxContext && parts[0] != seLinuxContextFlag) {
+		return "", false
+	}
+	return strings.Trim(strings.TrimSpace(parts[1]), `"'`), true
+}
+
+//getSELinuxContext returns the SELinux context requested in the mount flags of the volume capability or in the volume
+//context, or "" when none is requested. Malformed and conflicting contexts are rejected. Raw block volumes have no
+//filesystem to label, so the context is ignored for them
+func getSELinuxContext(ctx context.Context, volCap *csi.VolumeCapability, volumeContext map[string]string) (string, error) {
+	rid, log := utils.GetRunidAndLogger(ctx)
+	seLinuxContext := strings.TrimSpace(volumeContext[keySELinuxContext])
+	for _, flag := range volCap.GetMount().GetMountFlags() {
+		value, ok := parseSELinuxContextFlag(flag)
+		if !ok {
+			continue
+		}
+		if seLinuxContext != "" && seLinuxContext != value {
+			return "", status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Conflicting SELinux contexts %s and %s requested", seLinuxContext, value))
+		}
+		seLinuxContext = value
+	}
+	if seLinuxContext == "" {
+		return "", nil
+	}
+	if !seLinuxContextRegex.MatchString(seLinuxContext) {
+		return "", status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Invalid value for %s: %s. The expected format is user:role:type[:range]", keySELinuxContext, seLinuxContext))
+	}
+	if accTypeBlock(volCap) {
+		log.Debugf("Ignoring SELinux context %s of raw block volume", seLinuxContext)
+		return "", nil
+	}
+	return seLinuxContext, nil
+}
+
+//setSELinuxContextFlag sets the context mount flag of the volume capability, so that the SELinux context is applied
+//to the filesystem mount
+func setSELinuxContextFlag(volCap *csi.VolumeCapability, seLinuxContext string) {
+	mount := volCap.GetMount()
+	if seLinuxContext == "" || mount == nil {
+		return
+	}
+	flags := make([]string, 0, len(mount.MountFlags)+1)
+	for _, flag := range mount.MountFlags {
+		if _, ok := parseSELinuxContextFlag(flag); !ok {
+			flags = append(flags, flag)
+		}
+	}
+	mount.MountFlags = append(flags, seLinuxContextFlag+`="`+seLinuxContext+`"`)
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/dell/csi-unity/service/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//systemSerialPath is the Unity REST resource reporting the serial number of the array
+const systemSerialPath = "/api/types/system/instances?compact=true&fields=serialNumber"
+
+//getArraySerial returns the serial number of the array. It is a variable so that tests can override it
+var getArraySerial = func(ctx context.Context, array *StorageArrayConfig) (string, error) {
+	session, err := openRestSession(ctx, array)
+	if err != nil {
+		return "", err
+	}
+	defer session.close()
+
+	resp, err := session.do(http.MethodGet, systemSerialPath, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", newRestStatusError(resp, "query of system failed")
+	}
+	var result struct {
+		Entries []struct {
+			Content struct {
+				SerialNumber string `json:"serialNumber"`
+			} `json:"content"`
+		} `json:"entries"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if len(result.Entries) == 0 || result.Entries[0].Content.SerialNumber == "" {
+		return "", fmt.Errorf("serial number not reported by array %s", array.ArrayId)
+	}
+	return result.Entries[0].Content.SerialNumber, nil
+}
+
+//getSerial returns the serial number of the array, queried once per RestGateway of the array
+func (s *service) getSerial(ctx context.Context, array *StorageArrayConfig) (string, error) {
+	key := array.ArrayId + "@" + array.RestGateway
+	if serial, ok := s.arraySerials.Load(key); ok {
+		return serial.(string), nil
+	}
+	serial, err := getArraySerial(ctx, array)
+	if err != nil {
+		return "", err
+	}
+	serial = strings.ToUpper(strings.TrimSpace(serial))
+	s.arraySerials.Store(key, serial)
+	return serial, nil
+}
+
+//checkDuplicateSerials checks that no two probed arrays report the same serial number, as the volume ids would no
+//longer identify the array of their volume. With the fail policy an error is returned when the array, or any array
+//when arrayId is empty, shares its serial number with another array. Arrays whose serial number cannot be queried are
+//skipped
+func (s *service) checkDuplicateSerials(ctx context.Context, arrayId string) error {
+	if s.opts.DuplicateSerialPolicy == "" {
+		return nil
+	}
+	rid, log := utils.GetRunidAndLogger(ctx)
+	arraysBySerial := make(map[string][]string)
+	for _, array := range s.getStorageArrayList() {
+		if !array.IsProbeSuccess {
+			continue
+		}
+		serial, err := s.getSerial(ctx, array)
+		if err != nil {
+			log.Warnf("Unable to get the serial number of array %s. Error: %v", array.ArrayId, utils.GetUnityError(err))
+			continue
+		}
+		arraysBySerial[serial] = append(arraysBySerial[serial], array.ArrayId)
+	}
+
+	serials := make([]string, 0, len(arraysBySerial))
+	for serial := range arraysBySerial {
+		serials = append(serials, serial)
+	}
+	sort.Strings(serials)
+	for _, serial := range serials {
+		arrayIds := arraysBySerial[serial]
+		if len(arrayIds) < 2 {
+			continue
+		}
+		sort.Strings(arrayIds)
+		if s.opts.DuplicateSerialPolicy == DuplicateSerialFail && (arrayId == "" || utils.ArrayContains(arrayIds, arrayId)) {
+			return status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "Arrays %s report the same serial number %s. Configure each physical array once", strings.Join(arrayIds, ", "), serial))
+		}
+		log.Warnf("Arrays %s report the same serial number %s. Configure each physical array once", strings.Join(arrayIds, ", "), serial)
+	}
+	return nil
+}
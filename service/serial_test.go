@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/dell/csi-unity/service/utils"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCheckDuplicateSerials(t *testing.T) {
+	origSerial := getArraySerial
+	defer func() { getArraySerial = origSerial }()
+	serials := map[string]string{"array1": "CKM00190000001", "array2": "ckm00190000001 ", "array3": "CKM00190000003"}
+	queries := 0
+	getArraySerial = func(ctx context.Context, array *StorageArrayConfig) (string, error) {
+		queries++
+		if serial, ok := serials[array.ArrayId]; ok {
+			return serial, nil
+		}
+		return "", errors.New("connection refused")
+	}
+	logger := utils.GetLogger()
+	origOut := logger.Out
+	defer logger.SetOutput(origOut)
+	out := &syncBuffer{}
+	logger.SetOutput(out)
+
+	ctx, _, _ := GetRunidLog(context.Background())
+	newService := func(policy string) *service {
+		s := &service{arrays: new(sync.Map), opts: Opts{DuplicateSerialPolicy: policy}}
+		for _, arrayId := range []string{"array1", "array2", "array3", "array4"} {
+			s.arrays.Store(arrayId, &StorageArrayConfig{ArrayId: arrayId, RestGateway: "https://" + arrayId, IsProbeSuccess: true})
+		}
+		return s
+	}
+
+	//The serial numbers are not checked by default
+	assert.Nil(t, newService("").checkDuplicateSerials(ctx, ""))
+	assert.Equal(t, 0, queries)
+
+	//The warn policy logs the arrays sharing a serial number
+	s := newService(DuplicateSerialWarn)
+	assert.Nil(t, s.checkDuplicateSerials(ctx, ""))
+	assert.True(t, strings.Contains(out.String(), "Arrays array1, array2 report the same serial number CKM00190000001"), "Warning missing: %s", out.String())
+	assert.Equal(t, 4, queries)
+	assert.Nil(t, s.checkDuplicateSerials(ctx, "array1"))
+	assert.Equal(t, 5, queries, "Serial numbers are queried once per array")
+
+	//The fail policy fails the probe of the arrays sharing a serial number, or of all the arrays
+	s = newService(DuplicateSerialFail)
+	err := s.checkDuplicateSerials(ctx, "array2")
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "Arrays array1, array2 report the same serial number CKM00190000001"), "Unexpected error message: %v", err)
+	assert.Nil(t, s.checkDuplicateSerials(ctx, "array3"))
+	assert.Equal(t, codes.FailedPrecondition, status.Code(s.checkDuplicateSerials(ctx, "")))
+
+	//Arrays with distinct serial numbers pass
+	serials["array2"] = "CKM00190000002"
+	assert.Nil(t, newService(DuplicateSerialFail).checkDuplicateSerials(ctx, ""))
+}
@@ -1,7 +1,9 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -18,13 +20,17 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"io"
 	"io/ioutil"
 	"net"
+	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
@@ -38,14 +44,37 @@ const (
 	TcpDialTimeout = 1000
 
 	IScsiPort = "3260"
+
+	//defaultISCSIScanConcurrency is the default maximum number of iSCSI portals scanned at once
+	defaultISCSIScanConcurrency = 4
+
+	//Policies applied when the driver config has no valid arrays
+	EmptyConfigKeepLastGood = "keep-last-good"
+	EmptyConfigAcceptEmpty  = "accept-empty"
+
+	//Policies applied by an idempotent CreateVolume when the existing volume is smaller than requested
+	SizeMismatchStrict     = "strict-alreadyexists"
+	SizeMismatchAutoExpand = "auto-expand"
+
+	//Policies applied by NodeStageVolume when the node has no FC paths to the array
+	FCZoningFail = "fail"
+	FCZoningWarn = "warn"
+
+	//Policies applied after a probe when two arrays report the same serial number
+	DuplicateSerialWarn = "warn"
+	DuplicateSerialFail = "fail"
+
+	//Policies applied at the start of the node when neither the iSCSI nor the FC tooling is present
+	TransportToolingWarn = "warn"
+	TransportToolingFail = "fail"
+
+	//Array id format of the serial numbers of the Unity arrays
+	ArrayIdFormatUnity = "unity"
 )
 
 var Name string
 var DriverConfig string
 
-//To maintain runid for Non debug mode. Note: CSI will not generate runid if CSI_DEBUG=false
-var runid int64
-
 // Manifest is the SP's manifest.
 var Manifest = map[string]string{
 	"url":    "http://github.com/dell/csi-unity",
@@ -64,11 +93,30 @@ type StorageArrayConfig struct {
 	Username       string `json:"username"`
 	Password       string `json:"password"`
 	RestGateway    string `json:"restGateway"`
-	Insecure       bool   `json:"insecure, omitempty"`
-	IsDefaultArray bool   `json:"isDefaultArray, omitempty"`
+	Insecure       bool   `json:"insecure,omitempty"`
+	IsDefaultArray bool   `json:"isDefaultArray,omitempty"`
+	//Storage pools that storage classes may target on the array. All pools are allowed when empty
+	AllowedPools []string `json:"allowedPools,omitempty"`
+	//Protocols supported by the array. All protocols are supported when empty
+	Protocols []string `json:"protocols,omitempty"`
+	//Minimum TLS version of the connections to the array, 1.2 or 1.3. Default is 1.2
+	MinTLSVersion string `json:"minTLSVersion,omitempty"`
+	//Labels selecting the array with the arraySelector storage class parameter
+	Labels map[string]string `json:"labels,omitempty"`
+	//Capabilities forced on or off, overriding their detection from the OE version of the array
+	Capabilities map[string]bool `json:"capabilities,omitempty"`
+	//Refuses the creation of volumes and snapshots and the expansion of volumes, e.g. during a migration of the array,
+	//while deletes and reads proceed
+	ReadOnly       bool `json:"readOnly,omitempty"`
 	IsProbeSuccess bool
 	IsHostAdded    bool
 	UnityClient    *gounity.Client
+	//set when the Unity client uses the last resolved address of the RestGateway host
+	dnsFallbackActive bool
+	//minimum TLS version parsed from MinTLSVersion
+	minTLSVersion uint16
+	//set when the RestGateway is masked in the logs
+	maskRestGateway bool
 }
 
 // Service is a CSI SP and idempotency.Provider.
@@ -77,6 +125,7 @@ type Service interface {
 	csi.IdentityServer
 	csi.NodeServer
 	BeforeServe(context.Context, *gocsi.StoragePlugin, net.Listener) error
+	EffectiveOpts() Opts
 }
 
 // Opts defines service configuration options.
@@ -90,6 +139,55 @@ type Opts struct {
 	Debug                         bool
 	SyncNodeInfoTimeInterval      int
 	EnvEphemeralStagingTargetPath string
+	StagingPathTemplate           string
+	HealthAddress                 string
+	AdminAddress                  string
+	PoolFreeReservation           string
+	EmptyConfigPolicy             string
+	StagingStateDir               string
+	LightweightProbe              bool
+	ArrayIdCaseSensitive          bool
+	ForceDisconnect               bool
+	RestMaxRetries                int
+	ArrayConfigJSON               string
+	ProbeFailureThreshold         int
+	SizeMismatchPolicy            string
+	InitiatorRefreshInterval      int
+	MinRequestDeadline            int
+	DefaultProtocol               string
+	MaxConcurrentCreateVolume     int
+	ListAllVolumes                bool
+	VolumeNamePrefix              string
+	WarmupTimeout                 int
+	InitiatorWaitTimeout          int
+	NFSHostAccessRetries          int
+	KeepCloneSnapshots            bool
+	TracePayloads                 bool
+	MaxHostLuns                   int
+	DisableVolumeLocking          bool
+	ProbeOnDemand                 bool
+	DeleteGracePeriod             int
+	DebugLogSamplingRate          int
+	JobTimeout                    int
+	FCZoningPolicy                string
+	StateDumpOnSignal             bool
+	DuplicateSerialPolicy         string
+	KeepAliveInterval             int
+	MaxConfigSize                 int64
+	DefaultMountOptions           map[string][]string
+	ArrayIdFormat                 *regexp.Regexp
+	ProbeArrayStatus              bool
+	ISCSIScanConcurrency          int
+	TransportToolingPolicy        string
+	StrictParameters              bool
+	DisableSnapshotIdVerification bool
+	RegistrationProbeTimeout      int
+	MixedProtocolPolicy           string
+	ConnectorTraceFile            string
+	KubeletCSIDir                 string
+	IncompleteVolumePolicy        string
+	PerArrayMetrics               bool
+	MaskRestGateway               bool
 }
 
 type service struct {
@@ -99,6 +197,24 @@ type service struct {
 	iscsiClient    goiscsi.ISCSIinterface
 	fcConnector    fcConnector //gobrick connectors
 	iscsiConnector iSCSIConnector
+	maintenance    sync.Map //arrays in maintenance mode
+	readiness      readinessState
+	//arrays hosting the volumes of the placement groups
+	placementGroups sync.Map
+	//node initiators found by the last initiator discovery
+	knownInitiators []string
+	//caps the concurrent CreateVolume requests
+	createVolumeLimiter concurrencyLimiter
+	//serializes the operations on the same volume
+	volumeLocks volumeLocks
+	//volumes being or recently created
+	volumeCreations volumeCreations
+	//capabilities detected on the arrays by array id
+	arrayCapabilities sync.Map
+	//serial numbers of the arrays by array id and RestGateway
+	arraySerials sync.Map
+	//results of the last probe of the arrays by array id
+	arrayProbeResults sync.Map
 }
 
 type iSCSIConnector interface {
@@ -121,7 +237,7 @@ func New() Service {
 //To display the StorageArrayConfig content
 func (s StorageArrayConfig) String() string {
 	return fmt.Sprintf("ArrayID: %s, Username: %s, RestGateway: %s, Insecure: %v, IsDefaultArray:%v, IsProbeSuccess:%v, IsHostAdded:%v",
-		s.ArrayId, s.Username, s.RestGateway, s.Insecure, s.IsDefaultArray, s.IsProbeSuccess, s.IsHostAdded)
+		s.ArrayId, s.Username, displayRestGateway(s.RestGateway, s.maskRestGateway), s.Insecure, s.IsDefaultArray, s.IsProbeSuccess, s.IsHostAdded)
 }
 
 // BeforeServe allows the SP to participate in the startup
@@ -145,11 +261,180 @@ func (s *service) BeforeServe(
 	// Get the SP's operating mode.
 	s.mode = csictx.Getenv(ctx, gocsi.EnvVarMode)
 	log.Info("Driver Mode:", s.mode)
+	if err := checkRequiredEnvVars(ctx, s.mode); err != nil {
+		return err
+	}
 
-	opts := Opts{}
-	if name, ok := csictx.LookupEnv(ctx, gocsi.EnvVarDebug); ok {
-		opts.Debug, _ = strconv.ParseBool(name)
+	opts := getOptsFromEnv(ctx)
+
+	if keyHeader, ok := csictx.LookupEnv(ctx, EnvIdempotencyKeyHeader); ok && keyHeader != "" {
+		idempotencyKeyHeader = strings.ToLower(keyHeader)
+	}
+	if fallback, ok := csictx.LookupEnv(ctx, EnvRestGatewayDNSFallback); ok {
+		restGatewayDNSFallback, _ = strconv.ParseBool(fallback)
+	}
+	if retries, ok := csictx.LookupEnv(ctx, EnvBindMountRetries); ok {
+		if count, err := strconv.Atoi(strings.TrimSpace(retries)); err != nil || count < 0 {
+			log.Warnf("Invalid value %s for %s. Using %d", retries, EnvBindMountRetries, defaultBindMountRetries)
+		} else {
+			bindMountRetries = count
+		}
+	}
+
+	// setup the iscsi client
+	iscsiOpts := make(map[string]string, 0)
+	if opts.Chroot != "" {
+		iscsiOpts[goiscsi.ChrootDirectory] = opts.Chroot
+	}
+	s.iscsiClient = goiscsi.NewLinuxISCSI(iscsiOpts)
+
+	s.opts = opts
+
+	//Record the latency of the requests and collapse duplicate in-flight requests carrying the same idempotency key
+	if sp != nil {
+		sp.Interceptors = append(sp.Interceptors, metricsInterceptor, s.arrayMetricsInterceptor, idempotencyInterceptor)
+	}
+	//Log the request and response payloads for deep debugging, while the configured log level allows trace
+	if s.opts.TracePayloads {
+		if sp != nil {
+			sp.Interceptors = append(sp.Interceptors, traceInterceptor)
+		}
+		if utils.GetLogger().IsLevelEnabled(logrus.TraceLevel) {
+			log.Warn("Tracing of the request and response payloads is enabled")
+		} else {
+			log.Warnf("%s is set but the log level is %s. The payloads are traced only at trace level", EnvTracePayloads, utils.GetLogger().GetLevel())
+		}
+	}
+	//Sample the debug logs of mass operations
+	if s.opts.DebugLogSamplingRate > 1 {
+		utils.SetDebugLogSampling(s.opts.DebugLogSamplingRate)
+		log.Infof("Logging one in %d debug lines", s.opts.DebugLogSamplingRate)
+	}
+
+	//Update the storage array list
+	ctx, log = incrementLogId(ctx, "config")
+	s.arrays = new(sync.Map)
+	err = s.syncDriverConfig(ctx)
+	if err != nil {
+		//Arrays that were loaded successfully are still served when only some of them failed
+		if s.getStorageArrayLength() == 0 {
+			return err
+		}
+		log.Errorf("Some arrays could not be loaded from the driver config. Error: %v", err)
+	}
+	if s.opts.WarmupTimeout > 0 {
+		s.warmupProbe(ctx, time.Duration(s.opts.WarmupTimeout)*time.Second)
+	}
+	if s.opts.HealthAddress != "" {
+		s.startHealthServer(ctx, s.opts.HealthAddress)
+	}
+	if s.opts.AdminAddress != "" {
+		s.startAdminServer(ctx, s.opts.AdminAddress)
+	}
+
+	syncNodeInfoChan = make(chan bool)
+	//Dynamically load the config
+	if s.isConfigWatchEnabled() {
+		go s.loadDynamicConfig(ctx, DriverConfig)
+	} else {
+		log.Infof("Driver config is provided by %s. Driver config file %s is not watched", EnvArrayConfigJSON, DriverConfig)
+	}
+	s.startConfigReloadOnSignal(ctx)
+	if s.opts.StateDumpOnSignal {
+		s.startStateDumpOnSignal(ctx)
+	}
+
+	if s.opts.KeepAliveInterval > 0 {
+		go s.keepAliveRoutine(ctx)
+	}
+
+	if s.mode != "node" {
+		go s.rebuildPlacementGroups(ctx)
+	}
+
+	//Add node information to hosts
+	if s.mode == "node" {
+		//Get Host Name
+		if s.opts.NodeName == "" {
+			return status.Error(codes.InvalidArgument, "'Node Name' has not been configured. Set environment variable X_CSI_UNITY_NODENAME")
+		}
+		if err := s.checkMountPathOverlap(); err != nil {
+			return err
+		}
+		if err := s.checkTransportTooling(ctx); err != nil {
+			return err
+		}
+
+		go s.syncNodeInfoRoutine(ctx)
+		syncNodeInfoChan <- true
+		if s.opts.InitiatorRefreshInterval > 0 {
+			go s.initiatorRefreshRoutine(ctx)
+		}
+	}
+
+	return nil
+}
+
+//parseBoolEnv returns the boolean value of the environment variable, or false when it is not set or is not a boolean
+func parseBoolEnv(ctx context.Context, name string) bool {
+	value, ok := csictx.LookupEnv(ctx, name)
+	if !ok {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		utils.GetRunidLogger(ctx).WithField(name, value).Debug("invalid boolean value. defaulting to false")
+		return false
+	}
+	return b
+}
+
+//parseIntEnv returns the integer value of the environment variable, or the default when it is not set. A value that is
+//not an integer or is below the minimum is logged with the fallback, "Using <default>" when empty, and the default is
+//returned
+func parseIntEnv(ctx context.Context, name string, defaultValue, min int, fallback string) int {
+	return parseNumberEnv(ctx, name, defaultValue, min, fallback, strconv.Atoi)
+}
+
+//parseDurationEnv returns the value of the environment variable as a number of units, or the default when it is not
+//set. The value is either a number of units or a duration such as 90s or 5m. Invalid values are handled as by
+//parseIntEnv
+func parseDurationEnv(ctx context.Context, name string, unit time.Duration, defaultValue, min int, fallback string) int {
+	return parseNumberEnv(ctx, name, defaultValue, min, fallback, func(value string) (int, error) {
+		count, err := strconv.Atoi(value)
+		if err == nil {
+			return count, nil
+		}
+		duration, durationErr := time.ParseDuration(value)
+		if durationErr != nil {
+			return 0, err
+		}
+		return int(duration / unit), nil
+	})
+}
+
+//parseNumberEnv parses the environment variable with the parse function for parseIntEnv and parseDurationEnv
+func parseNumberEnv(ctx context.Context, name string, defaultValue, min int, fallback string, parse func(string) (int, error)) int {
+	value, ok := csictx.LookupEnv(ctx, name)
+	if !ok {
+		return defaultValue
+	}
+	number, err := parse(strings.TrimSpace(value))
+	if err != nil || number < min {
+		if fallback == "" {
+			fallback = fmt.Sprintf("Using %d", defaultValue)
+		}
+		utils.GetRunidLogger(ctx).Warnf("Invalid value %s for %s. %s", value, name, fallback)
+		return defaultValue
 	}
+	return number
+}
+
+//getOptsFromEnv parses the driver configuration options from the environment
+func getOptsFromEnv(ctx context.Context) Opts {
+	log := utils.GetRunidLogger(ctx)
+	opts := Opts{}
+	opts.Debug = parseBoolEnv(ctx, gocsi.EnvVarDebug)
 	if name, ok := csictx.LookupEnv(ctx, EnvNodeName); ok {
 		log.Info("X_CSI_UNITY_NODENAME:", name)
 		opts.LongNodeName = name
@@ -157,31 +442,62 @@ func (s *service) BeforeServe(
 		opts.NodeName = shortHostName
 	}
 
-	opts.SyncNodeInfoTimeInterval = 15
-	if syncNodeInfoTimeInterval, ok := csictx.LookupEnv(ctx, SyncNodeInfoTimeInterval); ok {
-		opts.SyncNodeInfoTimeInterval, err = strconv.Atoi(syncNodeInfoTimeInterval)
-		log.Debugf("SyncNodeInfoTimeInterval %d", opts.SyncNodeInfoTimeInterval)
+	opts.SyncNodeInfoTimeInterval = parseDurationEnv(ctx, SyncNodeInfoTimeInterval, time.Minute, 15, 1, "")
+	log.Debugf("SyncNodeInfoTimeInterval %d", opts.SyncNodeInfoTimeInterval)
+	opts.ProbeFailureThreshold = parseIntEnv(ctx, EnvProbeFailureThreshold, defaultProbeFailureThreshold, 1, "")
+	opts.RestMaxRetries = parseIntEnv(ctx, EnvRestMaxRetries, defaultRestMaxRetries, 0, "")
+
+	opts.DefaultMountOptions = make(map[string][]string)
+	for protocol, env := range map[string]string{FC: EnvFCMountOptions, ISCSI: EnvISCSIMountOptions, NFS: EnvNFSMountOptions} {
+		if options, ok := csictx.LookupEnv(ctx, env); ok {
+			opts.DefaultMountOptions[protocol] = parseMountOptions(options)
+		}
+	}
+
+	if arrayIdFormat, ok := csictx.LookupEnv(ctx, EnvArrayIdFormat); ok && strings.TrimSpace(arrayIdFormat) != "" {
+		format, err := getArrayIdFormat(arrayIdFormat)
 		if err != nil {
-			opts.SyncNodeInfoTimeInterval = 15
+			log.Warnf("Invalid value %s for %s. Array ids are not validated. Error: %v", arrayIdFormat, EnvArrayIdFormat, err)
+		} else {
+			opts.ArrayIdFormat = format
 		}
 	}
 
-	// pb parses an environment variable into a boolean value. If an error
-	// is encountered, default is set to false, and error is logged
-	pb := func(n string) bool {
-		if v, ok := csictx.LookupEnv(ctx, n); ok {
-			b, err := strconv.ParseBool(v)
-			if err != nil {
-				log.WithField(n, v).Debug(
-					"invalid boolean value. defaulting to false")
-				return false
-			}
-			return b
+	if maxConfigSize, ok := csictx.LookupEnv(ctx, EnvMaxConfigSize); ok {
+		size, err := strconv.ParseInt(strings.TrimSpace(maxConfigSize), 10, 64)
+		if err != nil {
+			size, err = utils.ParseSize(maxConfigSize)
+		}
+		if err != nil || size <= 0 {
+			log.Warnf("Invalid value %s for %s. Using %d", maxConfigSize, EnvMaxConfigSize, defaultMaxConfigSize)
+		} else {
+			opts.MaxConfigSize = size
 		}
-		return false
 	}
 
-	opts.AutoProbe = pb(EnvAutoProbe)
+	opts.KeepAliveInterval = parseDurationEnv(ctx, EnvKeepAliveInterval, time.Second, 0, 0, "Keepalive is disabled")
+	opts.InitiatorRefreshInterval = parseDurationEnv(ctx, EnvInitiatorRefreshInterval, time.Minute, 0, 0, "Initiator refresh is disabled")
+	opts.MinRequestDeadline = parseDurationEnv(ctx, EnvMinRequestDeadline, time.Second, 0, 0, "Request deadline check is disabled")
+
+	opts.AutoProbe = parseBoolEnv(ctx, EnvAutoProbe)
+	opts.LightweightProbe = parseBoolEnv(ctx, EnvLightweightProbe)
+	opts.ArrayIdCaseSensitive = parseBoolEnv(ctx, EnvArrayIdCaseSensitive)
+	opts.ForceDisconnect = parseBoolEnv(ctx, EnvForceDisconnect)
+	opts.ListAllVolumes = parseBoolEnv(ctx, EnvListAllVolumes)
+	opts.KeepCloneSnapshots = parseBoolEnv(ctx, EnvKeepCloneSnapshots)
+	opts.TracePayloads = parseBoolEnv(ctx, EnvTracePayloads)
+	opts.DisableVolumeLocking = parseBoolEnv(ctx, EnvDisableVolumeLocking)
+	opts.ProbeOnDemand = parseBoolEnv(ctx, EnvProbeOnDemand)
+	opts.ProbeArrayStatus = parseBoolEnv(ctx, EnvProbeArrayStatus)
+	opts.StrictParameters = parseBoolEnv(ctx, EnvStrictParameters)
+	opts.DisableSnapshotIdVerification = parseBoolEnv(ctx, EnvDisableSnapshotIdVerification)
+	opts.MaskRestGateway = parseBoolEnv(ctx, EnvMaskRestGateway)
+	opts.PerArrayMetrics = parseBoolEnv(ctx, EnvPerArrayMetrics)
+	opts.StateDumpOnSignal = parseBoolEnv(ctx, EnvStateDumpOnSignal)
+
+	if prefix, ok := csictx.LookupEnv(ctx, EnvVolumeNamePrefix); ok {
+		opts.VolumeNamePrefix = strings.TrimSpace(prefix)
+	}
 
 	//Global mount directory will be used to node unstage volumes mounted via CSI-Unity v1.0 or v1.1
 	if pvtmountDir, ok := csictx.LookupEnv(ctx, EnvPvtMountDir); ok {
@@ -192,44 +508,157 @@ func (s *service) BeforeServe(
 		opts.EnvEphemeralStagingTargetPath = ephemeralStagePath
 	}
 
-	// setup the iscsi client
-	iscsiOpts := make(map[string]string, 0)
-	if chroot, ok := csictx.LookupEnv(ctx, EnvISCSIChroot); ok {
-		iscsiOpts[goiscsi.ChrootDirectory] = chroot
-		opts.Chroot = chroot
+	opts.KubeletCSIDir = defaultKubeletCSIDir
+	if kubeletDir, ok := csictx.LookupEnv(ctx, EnvKubeletCSIDir); ok {
+		opts.KubeletCSIDir = strings.TrimSpace(kubeletDir)
 	}
-	s.iscsiClient = goiscsi.NewLinuxISCSI(iscsiOpts)
 
-	s.opts = opts
-	//Update the storage array list
-	runid := fmt.Sprintf("config-%d", 0)
-	ctx, log = setRunIdContext(ctx, runid)
-	s.arrays = new(sync.Map)
-	err = s.syncDriverConfig(ctx)
-	if err != nil {
-		return err
+	if stagingPathTemplate, ok := csictx.LookupEnv(ctx, EnvStagingPathTemplate); ok {
+		opts.StagingPathTemplate = stagingPathTemplate
 	}
-	syncNodeInfoChan = make(chan bool)
-	//Dynamically load the config
-	go s.loadDynamicConfig(ctx, DriverConfig)
 
-	//Add node information to hosts
-	if s.mode == "node" {
-		//Get Host Name
-		if s.opts.NodeName == "" {
-			return status.Error(codes.InvalidArgument, "'Node Name' has not been configured. Set environment variable X_CSI_UNITY_NODENAME")
+	if stagingStateDir, ok := csictx.LookupEnv(ctx, EnvStagingStateDir); ok {
+		opts.StagingStateDir = stagingStateDir
+	}
+
+	if arrayConfigJSON, ok := csictx.LookupEnv(ctx, EnvArrayConfigJSON); ok {
+		opts.ArrayConfigJSON = strings.TrimSpace(arrayConfigJSON)
+	}
+
+	if healthAddress, ok := csictx.LookupEnv(ctx, EnvHealthAddress); ok {
+		opts.HealthAddress = healthAddress
+	}
+
+	if adminAddress, ok := csictx.LookupEnv(ctx, EnvAdminAddress); ok {
+		opts.AdminAddress = strings.TrimSpace(adminAddress)
+	}
+
+	if poolFreeReservation, ok := csictx.LookupEnv(ctx, EnvPoolFreeReservation); ok {
+		opts.PoolFreeReservation = strings.TrimSpace(poolFreeReservation)
+	}
+
+	opts.EmptyConfigPolicy = EmptyConfigKeepLastGood
+	if policy, ok := csictx.LookupEnv(ctx, EnvEmptyConfigPolicy); ok {
+		policy = strings.ToLower(strings.TrimSpace(policy))
+		if policy == EmptyConfigKeepLastGood || policy == EmptyConfigAcceptEmpty {
+			opts.EmptyConfigPolicy = policy
+		} else {
+			log.Warnf("Invalid value %s for %s. Using %s", policy, EnvEmptyConfigPolicy, EmptyConfigKeepLastGood)
 		}
+	}
 
-		go s.syncNodeInfoRoutine(ctx)
-		syncNodeInfoChan <- true
+	opts.SizeMismatchPolicy = SizeMismatchStrict
+	if policy, ok := csictx.LookupEnv(ctx, EnvSizeMismatchPolicy); ok {
+		policy = strings.ToLower(strings.TrimSpace(policy))
+		if policy == SizeMismatchStrict || policy == SizeMismatchAutoExpand {
+			opts.SizeMismatchPolicy = policy
+		} else {
+			log.Warnf("Invalid value %s for %s. Using %s", policy, EnvSizeMismatchPolicy, SizeMismatchStrict)
+		}
 	}
 
-	return nil
+	opts.TransportToolingPolicy = TransportToolingWarn
+	if policy, ok := csictx.LookupEnv(ctx, EnvTransportToolingPolicy); ok {
+		policy = strings.ToLower(strings.TrimSpace(policy))
+		if policy == TransportToolingWarn || policy == TransportToolingFail {
+			opts.TransportToolingPolicy = policy
+		} else {
+			log.Warnf("Invalid value %s for %s. Using %s", policy, EnvTransportToolingPolicy, TransportToolingWarn)
+		}
+	}
+
+	if path, ok := csictx.LookupEnv(ctx, EnvConnectorTraceFile); ok {
+		opts.ConnectorTraceFile = strings.TrimSpace(path)
+	}
+
+	opts.IncompleteVolumePolicy = IncompleteVolumeSkip
+	if policy, ok := csictx.LookupEnv(ctx, EnvIncompleteVolumePolicy); ok {
+		policy = strings.ToLower(strings.TrimSpace(policy))
+		if policy == IncompleteVolumeSkip || policy == IncompleteVolumeFail {
+			opts.IncompleteVolumePolicy = policy
+		} else {
+			log.Warnf("Invalid value %s for %s. Using %s", policy, EnvIncompleteVolumePolicy, IncompleteVolumeSkip)
+		}
+	}
+
+	opts.MixedProtocolPolicy = MixedProtocolReject
+	if policy, ok := csictx.LookupEnv(ctx, EnvMixedProtocolPolicy); ok {
+		policy = strings.ToLower(strings.TrimSpace(policy))
+		if policy == MixedProtocolReject || policy == MixedProtocolWarn {
+			opts.MixedProtocolPolicy = policy
+		} else {
+			log.Warnf("Invalid value %s for %s. Using %s", policy, EnvMixedProtocolPolicy, MixedProtocolReject)
+		}
+	}
+
+	opts.FCZoningPolicy = FCZoningFail
+	if policy, ok := csictx.LookupEnv(ctx, EnvFCZoningPolicy); ok {
+		policy = strings.ToLower(strings.TrimSpace(policy))
+		if policy == FCZoningFail || policy == FCZoningWarn {
+			opts.FCZoningPolicy = policy
+		} else {
+			log.Warnf("Invalid value %s for %s. Using %s", policy, EnvFCZoningPolicy, FCZoningFail)
+		}
+	}
+
+	if policy, ok := csictx.LookupEnv(ctx, EnvDuplicateSerialPolicy); ok {
+		policy = strings.ToLower(strings.TrimSpace(policy))
+		if policy == "" || policy == DuplicateSerialWarn || policy == DuplicateSerialFail {
+			opts.DuplicateSerialPolicy = policy
+		} else {
+			log.Warnf("Invalid value %s for %s. The serial numbers of the arrays are not checked", policy, EnvDuplicateSerialPolicy)
+		}
+	}
+
+	opts.DefaultProtocol = FC
+	if protocol, ok := csictx.LookupEnv(ctx, EnvDefaultProtocol); ok {
+		if canonical := getCanonicalProtocol(protocol); canonical != "" {
+			opts.DefaultProtocol = canonical
+		} else {
+			log.Warnf("Invalid value %s for %s. Using %s", protocol, EnvDefaultProtocol, FC)
+		}
+	}
+
+	opts.MaxConcurrentCreateVolume = parseIntEnv(ctx, EnvMaxConcurrentCreateVolume, 0, 0, "Concurrent CreateVolume requests are not capped")
+	opts.WarmupTimeout = parseDurationEnv(ctx, EnvWarmupTimeout, time.Second, 0, 0, "Startup warmup is disabled")
+	opts.InitiatorWaitTimeout = parseDurationEnv(ctx, EnvInitiatorWaitTimeout, time.Second, 0, 0, "NodeGetInfo does not wait for the node initiators")
+	opts.RegistrationProbeTimeout = parseDurationEnv(ctx, EnvRegistrationProbeTimeout, time.Second, 0, 0, "The node is registered without waiting for the probe of the arrays")
+	opts.NFSHostAccessRetries = parseIntEnv(ctx, EnvNFSHostAccessRetries, 0, 0, "Failed NFS host access updates are not retried")
+	opts.ISCSIScanConcurrency = parseIntEnv(ctx, EnvISCSIScanConcurrency, 0, 1, fmt.Sprintf("Using %d", defaultISCSIScanConcurrency))
+	opts.MaxHostLuns = parseIntEnv(ctx, EnvMaxHostLuns, 0, 0, "The number of LUNs per host is not checked")
+	opts.DeleteGracePeriod = parseDurationEnv(ctx, EnvDeleteGracePeriod, time.Second, 0, 0, "Volumes not found are considered deleted straight away")
+	opts.DebugLogSamplingRate = parseIntEnv(ctx, EnvDebugLogSamplingRate, 0, 1, "All debug lines are logged")
+	opts.JobTimeout = parseDurationEnv(ctx, EnvJobTimeout, time.Second, 0, 1, fmt.Sprintf("Using %v", defaultJobTimeout))
+
+	if chroot, ok := csictx.LookupEnv(ctx, EnvISCSIChroot); ok {
+		opts.Chroot = chroot
+	}
+
+	return opts
+}
+
+//EffectiveOpts returns a copy of the options parsed from the environment in BeforeServe. The array config
+//provided in the environment is masked as it contains the array credentials
+func (s *service) EffectiveOpts() Opts {
+	opts := s.opts
+	if opts.ArrayConfigJSON != "" {
+		opts.ArrayConfigJSON = "*******"
+	}
+	return opts
+}
+
+//normalizeArrayId returns the array id used to store and look up an array. Array ids are
+//compared case-insensitively unless case sensitive array ids are enabled
+func normalizeArrayId(arrayId string, caseSensitive bool) string {
+	if caseSensitive {
+		return arrayId
+	}
+	return strings.ToLower(arrayId)
 }
 
 //Get storage array from sync Map
 func (s *service) getStorageArray(arrayID string) *StorageArrayConfig {
-	if a, ok := s.arrays.Load(arrayID); ok {
+	if a, ok := s.arrays.Load(normalizeArrayId(arrayID, s.opts.ArrayIdCaseSensitive)); ok {
 		return a.(*StorageArrayConfig)
 	}
 	return nil
@@ -263,11 +692,101 @@ func (s *service) getUnityClient(ctx context.Context, arrayID string) (*gounity.
 	}
 
 	array := s.getStorageArray(arrayID)
-	if array != nil && array.UnityClient != nil {
-		return array.UnityClient, nil
-	} else {
-		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Unity client not found for array %s", arrayID))
+	if array == nil {
+		return nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Array %s not configured. Verify the arrayId against the storageArrayList of the driver config", arrayID))
+	}
+	if array.UnityClient == nil {
+		return nil, status.Error(codes.Unavailable, utils.GetMessageWithRunID(rid, "Array %s client not initialized, check connectivity to RestGateway %s", arrayID, displayRestGateway(array.RestGateway, s.opts.MaskRestGateway)))
+	}
+	return array.UnityClient, nil
+}
+
+//authenticateArray logs in to the array. It is a variable so that tests can override it
+var authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+	return array.UnityClient.Authenticate(ctx, &gounity.ConfigConnect{
+		Endpoint: array.RestGateway,
+		Username: array.Username,
+		Password: array.Password,
+	})
+}
+
+//reauthenticateArray logs in to the array again. It is a variable so that tests can override it
+var reauthenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+	return authenticateArray(ctx, array)
+}
+
+//isUnauthorizedError returns true when the error reports an expired or invalid Unity session, i.e. the response status
+//of the Unity REST API is 401
+func isUnauthorizedError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if e, ok := status.FromError(err); ok && e.Code() == codes.Unauthenticated {
+		return true
 	}
+	if e, ok := err.(*restStatusError); ok {
+		return e.StatusCode == http.StatusUnauthorized
+	}
+	return utils.GetUnityHTTPStatusCode(err) == http.StatusUnauthorized
+}
+
+//reauthError is returned by withReauth when the array rejects the session and logging in to it again fails
+type reauthError struct {
+	rid     string
+	arrayId string
+	err     error
+}
+
+func (e *reauthError) Error() string {
+	return e.GRPCStatus().Message()
+}
+
+//GRPCStatus reports the failed re-authentication as Unavailable
+func (e *reauthError) GRPCStatus() *status.Status {
+	return status.New(codes.Unavailable, utils.GetMessageWithRunID(e.rid, "re-authentication failed for array %s. Error: %v", e.arrayId, utils.GetUnityError(e.err)))
+}
+
+//withReauth runs the given Unity operation and, when it fails because the session is no longer valid,
+//re-authenticates with the array once and retries the operation. When the re-authentication fails, the array is
+//marked as not probed so that the next probe logs in again, and a reauthError is returned
+func (s *service) withReauth(ctx context.Context, arrayId string, op func() error) error {
+	rid, log := utils.GetRunidAndLogger(ctx)
+	err := withRetry(ctx, s.opts.RestMaxRetries, op)
+	if !isUnauthorizedError(err) {
+		return err
+	}
+
+	array := s.getStorageArray(arrayId)
+	if array == nil || array.UnityClient == nil {
+		return err
+	}
+	log.Infof("Unity session for array %s is not valid. Re-authenticating and retrying the operation. Error: %v", arrayId, err)
+	if reauthErr := reauthenticateArray(ctx, array); reauthErr != nil {
+		log.Errorf("Re-authentication failed for array %s. Error: %v", arrayId, reauthErr)
+		array.IsProbeSuccess = false
+		return &reauthError{rid: rid, arrayId: arrayId, err: reauthErr}
+	}
+	return withRetry(ctx, s.opts.RestMaxRetries, op)
+}
+
+//volumeIdScheme is the version of the volume id scheme of this driver, name-protocol-arrayId-resourceId. Volume ids of
+//later schemes are prefixed with their version, e.g. v2:
+const volumeIdScheme = 1
+
+//volumeIdSchemePrefix matches the scheme version prefix of a volume id
+var volumeIdSchemePrefix = regexp.MustCompile(`^v([0-9]+):`)
+
+//checkVolumeIdScheme returns an error when the volume id is of a later scheme than this driver supports, rather than
+//parsing it as a volume id of the current scheme
+func checkVolumeIdScheme(contextVolId string) error {
+	match := volumeIdSchemePrefix.FindStringSubmatch(contextVolId)
+	if match == nil {
+		return nil
+	}
+	if version, err := strconv.Atoi(match[1]); err != nil || version > volumeIdScheme {
+		return fmt.Errorf("volume id scheme v%s not supported by this driver version; upgrade the node plugin", match[1])
+	}
+	return nil
 }
 
 //return volumeid from csi volume context
@@ -282,6 +801,7 @@ func getVolumeIdFromVolumeContext(contextVolId string) string {
 	} else if len(tokens) >= 4 {
 		return tokens[len(tokens)-1]
 	}
+	recordVolumeIdParseFailure("getVolumeIdFromVolumeContext")
 	return ""
 }
 
@@ -302,15 +822,68 @@ func (s *service) getArrayIdFromVolumeContext(contextVolId string) (string, erro
 	} else if len(tokens) >= 4 {
 		return tokens[len(tokens)-2], nil
 	}
+	recordVolumeIdParseFailure("getArrayIdFromVolumeContext")
 	return "", errors.New("invalid volume context id or no default array found in the csi-unity driver configuration")
 }
 
+//checkDefaultArrayReachable makes sure the default array used for csi-unity v1.0 and v1.1 volumes can be reached,
+//so that an unreachable default array is reported clearly instead of failing later with an opaque error
+func (s *service) checkDefaultArrayReachable(ctx context.Context, arrayId string) error {
+	rid, log := utils.GetRunidAndLogger(ctx)
+	array := s.getStorageArray(arrayId)
+	if array == nil || array.IsProbeSuccess {
+		return nil
+	}
+	if err := singleArrayProbe(ctx, "Default array", array); err != nil {
+		log.Warnf("Default array %s is unreachable. Error: %v", arrayId, err)
+		return status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "Default array %s used for volumes created by csi-unity v1.0 and v1.1 is unreachable. Make the default array reachable or correct its details in the unity-creds secret. Error: %v", arrayId, err))
+	}
+	return nil
+}
+
+//isRestGatewayReachable returns true when a TCP connection to the RestGateway of the array can be opened within
+//TcpDialTimeout. It is a variable so that tests can override it
+var isRestGatewayReachable = func(ctx context.Context, array *StorageArrayConfig) bool {
+	gatewayURL, err := url.Parse(array.RestGateway)
+	if err != nil || gatewayURL.Hostname() == "" {
+		//Invalid gateways are reported by the login
+		return true
+	}
+	port := gatewayURL.Port()
+	if port == "" {
+		port = "443"
+		if strings.ToLower(gatewayURL.Scheme) == "http" {
+			port = "80"
+		}
+	}
+	return utils.IPReachable(ctx, gatewayURL.Hostname(), port, TcpDialTimeout)
+}
+
+//checkSourceArrayReachable makes sure the array of the source of a snapshot can be reached, so that CreateSnapshot
+//fails fast instead of timing out on an unreachable array. The array is probed again once it is reachable
+func (s *service) checkSourceArrayReachable(ctx context.Context, arrayId string) error {
+	rid, log := utils.GetRunidAndLogger(ctx)
+	array := s.getStorageArray(arrayId)
+	if array == nil {
+		return nil
+	}
+	if !isRestGatewayReachable(ctx, array) {
+		log.Warnf("Source array %s is unreachable on RestGateway %s", arrayId, displayRestGateway(array.RestGateway, s.opts.MaskRestGateway))
+		array.IsProbeSuccess = false
+		return status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "Source array %s is unreachable on RestGateway %s. Verify the connectivity to the array", arrayId, displayRestGateway(array.RestGateway, s.opts.MaskRestGateway)))
+	}
+	return nil
+}
+
 var watcher *fsnotify.Watcher
 
+//isConfigWatchEnabled returns true when the driver config is read from the config file, which is then watched for changes
+func (s *service) isConfigWatchEnabled() bool {
+	return s.opts.ArrayConfigJSON == ""
+}
+
 func (s *service) loadDynamicConfig(ctx context.Context, configFile string) error {
-	i := 1
-	runid := fmt.Sprintf("config-%d", i)
-	ctx, log := setRunIdContext(ctx, runid)
+	ctx, log := incrementLogId(ctx, "config")
 
 	log.Info("Dynamic config load goroutine invoked")
 	watcher, _ := fsnotify.NewWatcher()
@@ -337,10 +910,8 @@ func (s *service) loadDynamicConfig(ctx context.Context, configFile string) erro
 					if s.mode == "node" {
 						syncNodeInfoChan <- true
 					}
-					i++
+					ctx, log = incrementLogId(ctx, "config")
 				}
-				runid = fmt.Sprintf("config-%d", i)
-				ctx, log = setRunIdContext(ctx, runid)
 			case err, ok := <-watcher.Errors:
 				if !ok {
 					return
@@ -370,95 +941,241 @@ func (s *service) getProtocolFromVolumeContext(contextVolId string) (string, err
 	} else if len(tokens) >= 4 {
 		return tokens[len(tokens)-3], nil
 	}
+	recordVolumeIdParseFailure("getProtocolFromVolumeContext")
 	return "", errors.New("invalid volume context id")
 }
 
 var syncMutex sync.Mutex
 
+//newUnityClient creates the Unity client for an array. It is a variable so that tests can override it
+var newUnityClient = gounity.NewClientWithArgs
+
 //Reads the credentials from secrets and initialize all arrays.
 func (s *service) syncDriverConfig(ctx context.Context) error {
 	ctx, log, _ := GetRunidLog(ctx)
 	log.Info("*************Synchronizing driver config**************")
 	syncMutex.Lock()
 	defer syncMutex.Unlock()
+
+	var arrays map[string]*StorageArrayConfig
+	var err error
+	if s.opts.ArrayConfigJSON != "" {
+		log.Infof("Loading the driver config from %s", EnvArrayConfigJSON)
+		arrays, err = ValidateConfig(ctx, []byte(s.opts.ArrayConfigJSON), s.opts.ArrayIdCaseSensitive, s.opts.ArrayIdFormat)
+	} else {
+		arrays, err = loadDriverConfig(ctx, s.opts.ArrayIdCaseSensitive, s.opts.ArrayIdFormat, s.getMaxConfigSize())
+	}
+	for _, array := range arrays {
+		array.maskRestGateway = s.opts.MaskRestGateway
+		logArrayConfig(array)
+	}
+	if len(arrays) == 0 {
+		//A config file too large to be read is a failure to read the config rather than an empty config
+		if (s.opts.EmptyConfigPolicy != EmptyConfigAcceptEmpty || errors.Is(err, errConfigTooLarge)) && s.getStorageArrayLength() > 0 {
+			log.Warnf("*************Driver config has no valid arrays. Keeping the last known good config with %d arrays. Error: %v*************", s.getStorageArrayLength(), err)
+			recordConfigReload(err)
+			return err
+		}
+		log.Warnf("*************Driver config has no valid arrays. Driver will not be able to serve any request. Error: %v*************", err)
+	}
+
 	s.arrays.Range(func(key interface{}, value interface{}) bool {
+		if array, ok := arrays[key.(string)]; ok {
+			reconcileArrayState(ctx, value.(*StorageArrayConfig), array)
+		}
 		s.arrays.Delete(key)
 		return true
 	})
-	configBytes, err := ioutil.ReadFile(DriverConfig)
+	for arrayId, array := range arrays {
+		s.arrays.Store(arrayId, array)
+	}
+	recordConfigReload(err)
+	return err
+}
+
+//getMaxConfigSize returns the maximum size in bytes of the driver config file
+func (s *service) getMaxConfigSize() int64 {
+	if s.opts.MaxConfigSize <= 0 {
+		return defaultMaxConfigSize
+	}
+	return s.opts.MaxConfigSize
+}
+
+//reconcileArrayState carries the runtime state of an array over a config reload. The host added state is kept while the
+//array is reached on the same RestGateway, so that a secret rotation does not register the host again. The probe state
+//is kept only when the connection settings of the array are unchanged
+func reconcileArrayState(ctx context.Context, previous, array *StorageArrayConfig) {
+	log := utils.GetRunidLogger(ctx)
+	if previous.RestGateway != array.RestGateway {
+		log.Infof("RestGateway of array %s changed from %s to %s. Runtime state is reset", array.ArrayId, displayRestGateway(previous.RestGateway, array.maskRestGateway), displayRestGateway(array.RestGateway, array.maskRestGateway))
+		return
+	}
+	array.IsHostAdded = previous.IsHostAdded
+	if previous.Username == array.Username && previous.Password == array.Password && previous.Insecure == array.Insecure &&
+		previous.minTLSVersion == array.minTLSVersion {
+		array.IsProbeSuccess = previous.IsProbeSuccess
+	}
+}
+
+//unityArrayIdFormat matches the serial numbers of the Unity arrays, e.g. APM00123456789, and of the UnityVSA arrays,
+//e.g. VIRT1234ABCD56
+var unityArrayIdFormat = regexp.MustCompile(`^(?i)(APM[0-9]{11}|VIRT[0-9A-Z]{10})$`)
+
+//getArrayIdFormat returns the array id format of the value, the Unity serial number format for unity or the regular
+//expression otherwise
+func getArrayIdFormat(value string) (*regexp.Regexp, error) {
+	value = strings.TrimSpace(value)
+	if strings.EqualFold(value, ArrayIdFormatUnity) {
+		return unityArrayIdFormat, nil
+	}
+	return regexp.Compile(value)
+}
+
+//defaultMaxConfigSize is the default maximum size in bytes of the driver config file
+const defaultMaxConfigSize = 1024 * 1024
+
+//errConfigTooLarge is returned when the driver config file exceeds the maximum size
+var errConfigTooLarge = errors.New("driver config file exceeds the maximum size")
+
+//loadDriverConfig reads the arrays from the driver config file. A file larger than the maximum size is rejected
+//before it is read into memory and parsed
+func loadDriverConfig(ctx context.Context, caseSensitive bool, arrayIdFormat *regexp.Regexp, maxSize int64) (map[string]*StorageArrayConfig, error) {
+	file, err := os.Open(DriverConfig)
 	if err != nil {
-		return errors.New(fmt.Sprintf("File ('%s') error: %v", DriverConfig, err))
+		return nil, errors.New(fmt.Sprintf("File ('%s') error: %v", DriverConfig, err))
+	}
+	defer file.Close()
+	configBytes, err := ioutil.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("File ('%s') error: %v", DriverConfig, err))
+	}
+	if int64(len(configBytes)) > maxSize {
+		return nil, fmt.Errorf("%w: file %s is larger than %d bytes", errConfigTooLarge, DriverConfig, maxSize)
 	}
+	return ValidateConfig(ctx, configBytes, caseSensitive, arrayIdFormat)
+}
+
+//utf8BOM is the UTF-8 byte order mark
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+//describeJSONError returns the JSON error with the line and column of its byte offset in the config, as the offset
+//alone is hard to locate in a multi-line config
+func describeJSONError(configBytes []byte, err error) error {
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	default:
+		return err
+	}
+	if offset > int64(len(configBytes)) {
+		offset = int64(len(configBytes))
+	}
+	line := bytes.Count(configBytes[:offset], []byte("\n")) + 1
+	column := offset - int64(bytes.LastIndexByte(configBytes[:offset], '\n')) - 1
+	return fmt.Errorf("invalid JSON at byte offset %d (line %d, column %d): %v", offset, line, column, err)
+}
 
+//ValidateConfig parses and validates the driver config and initializes the Unity client of each array. When only the
+//Unity client of some arrays could not be initialized, the remaining arrays are returned along with the error. Array ids
+//not matching the array id format are rejected, unless the format is nil
+func ValidateConfig(ctx context.Context, configBytes []byte, caseSensitive bool, arrayIdFormat *regexp.Regexp) (map[string]*StorageArrayConfig, error) {
+	_, log, _ := GetRunidLog(ctx)
+	//A leading byte order mark and surrounding whitespace, left by some editors, are not part of the config
+	configBytes = bytes.TrimSpace(bytes.TrimPrefix(configBytes, utf8BOM))
 	if string(configBytes) != "" {
 		jsonConfig := new(StorageArrayList)
 		err := json.Unmarshal(configBytes, &jsonConfig)
 		if err != nil {
-			return errors.New(fmt.Sprintf("Unable to parse the credentials [%v]", err))
+			return nil, errors.New(fmt.Sprintf("Unable to parse the credentials [%v]", describeJSONError(configBytes, err)))
 		}
 
 		if len(jsonConfig.StorageArrayList) == 0 {
-			return errors.New("Arrays details are not provided in unity-creds secret")
+			return nil, errors.New("Arrays details are not provided in unity-creds secret")
 		}
 
-		s.arrays.Range(func(key interface{}, value interface{}) bool {
-			s.arrays.Delete(key)
-			return true
-		})
+		arrays := make(map[string]*StorageArrayConfig)
 		var noOfDefaultArrays int
+		var clientErrors []string
 		for i, config := range jsonConfig.StorageArrayList {
 			if config.ArrayId == "" {
-				return errors.New(fmt.Sprintf("invalid value for ArrayID at index [%d]", i))
+				return nil, errors.New(fmt.Sprintf("invalid value for ArrayID at index [%d]", i))
+			}
+			if arrayIdFormat != nil && !arrayIdFormat.MatchString(config.ArrayId) {
+				return nil, errors.New(fmt.Sprintf("invalid value for ArrayID at index [%d]: %s does not match the array id format %s", i, config.ArrayId, arrayIdFormat))
 			}
 			if config.Username == "" {
-				return errors.New(fmt.Sprintf("invalid value for Username at index [%d]", i))
+				return nil, errors.New(fmt.Sprintf("invalid value for Username at index [%d]", i))
 			}
 			if config.Password == "" {
-				return errors.New(fmt.Sprintf("invalid value for Password at index [%d]", i))
+				return nil, errors.New(fmt.Sprintf("invalid value for Password at index [%d]", i))
 			}
 			if config.RestGateway == "" {
-				return errors.New(fmt.Sprintf("invalid value for RestGateway at index [%d]", i))
+				return nil, errors.New(fmt.Sprintf("invalid value for RestGateway at index [%d]", i))
 			}
 
-			config.ArrayId = strings.ToLower(config.ArrayId)
-			unityClient, err := gounity.NewClientWithArgs(ctx, config.RestGateway, config.Insecure)
+			config.ArrayId = normalizeArrayId(config.ArrayId, caseSensitive)
+			if config.minTLSVersion, err = getMinTLSVersion(config.MinTLSVersion); err != nil {
+				return nil, errors.New(fmt.Sprintf("invalid value for minTLSVersion at index [%d]: %v", i, err))
+			}
+			if err = validateCapabilities(config.Capabilities); err != nil {
+				return nil, errors.New(fmt.Sprintf("invalid value for capabilities at index [%d]: %v", i, err))
+			}
+			unityClient, err := newArrayUnityClient(ctx, &config, config.RestGateway)
 			if err != nil {
-				return errors.New(fmt.Sprintf("unable to initialize the Unity client [%v]", err))
+				log.Errorf("Unable to initialize the Unity client for array %s. Error: %v", config.ArrayId, err)
+				clientErrors = append(clientErrors, fmt.Sprintf("array %s: %v", config.ArrayId, err))
+				continue
 			}
 			config.UnityClient = unityClient
 
 			copy := StorageArrayConfig{}
 			copy = config
 
-			if _, ok := s.arrays.Load(config.ArrayId); ok {
-				return errors.New(fmt.Sprintf("Duplicate ArrayID [%s] found in storageArrayList parameter", config.ArrayId))
+			if _, ok := arrays[config.ArrayId]; ok {
+				if !caseSensitive {
+					return nil, errors.New(fmt.Sprintf("Duplicate ArrayID [%s] found in storageArrayList parameter. ArrayIDs are compared case-insensitively unless %s is enabled", config.ArrayId, EnvArrayIdCaseSensitive))
+				}
+				return nil, errors.New(fmt.Sprintf("Duplicate ArrayID [%s] found in storageArrayList parameter", config.ArrayId))
 			} else {
-				s.arrays.Store(config.ArrayId, &copy)
-			}
-
-			fields := logrus.Fields{
-				"RestGateway":    config.RestGateway,
-				"ArrayId":        config.ArrayId,
-				"username":       config.Username,
-				"password":       "*******",
-				"Insecure":       config.Insecure,
-				"IsDefaultArray": config.IsDefaultArray,
+				arrays[config.ArrayId] = &copy
 			}
-			logrus.WithFields(fields).Infof("configured %s", Name)
 
 			if config.IsDefaultArray {
 				noOfDefaultArrays++
 			}
 
 			if noOfDefaultArrays > 1 {
-				return errors.New(fmt.Sprintf("'isDefaultArray' parameter located in multiple places ArrayId: %s. 'isDefaultArray' parameter should present only once in the storageArrayList.", config.ArrayId))
+				return nil, errors.New(fmt.Sprintf("'isDefaultArray' parameter located in multiple places ArrayId: %s. 'isDefaultArray' parameter should present only once in the storageArrayList.", config.ArrayId))
 			}
 		}
-	} else {
-		return errors.New("Arrays details are not provided in unity-creds secret")
+
+		if len(clientErrors) > 0 {
+			return arrays, errors.New(fmt.Sprintf("unable to initialize the Unity client for %d of %d arrays [%s]", len(clientErrors), len(jsonConfig.StorageArrayList), strings.Join(clientErrors, "; ")))
+		}
+		return arrays, nil
 	}
+	return nil, errors.New("Arrays details are not provided in unity-creds secret")
+}
 
-	return nil
+//logArrayConfig logs the configuration of the array, without its password
+func logArrayConfig(array *StorageArrayConfig) {
+	fields := logrus.Fields{
+		"RestGateway":    displayRestGateway(array.RestGateway, array.maskRestGateway),
+		"ArrayId":        array.ArrayId,
+		"username":       array.Username,
+		"password":       "*******",
+		"Insecure":       array.Insecure,
+		"IsDefaultArray": array.IsDefaultArray,
+		"AllowedPools":   array.AllowedPools,
+		"MinTLSVersion":  getTLSVersionName(array.minTLSVersion),
+		"Labels":         array.Labels,
+		"Capabilities":   array.Capabilities,
+		"ReadOnly":       array.ReadOnly,
+	}
+	logrus.WithFields(fields).Infof("configured %s", Name)
 }
 
 //Set arraysId in log messages and re-initialize the context
@@ -466,6 +1183,12 @@ func setArrayIdContext(ctx context.Context, arrayId string) (context.Context, *l
 	return setLogFieldsInContext(ctx, arrayId, utils.ARRAYID)
 }
 
+//setVolumeIdContext sets the volume id in the log messages of the context, including the log messages of the gobrick
+//connectors, so that the connector logs of volumes staged concurrently can be told apart
+func setVolumeIdContext(ctx context.Context, volumeId string) (context.Context, *logrus.Entry) {
+	return setLogFieldsInContext(ctx, volumeId, utils.VOLUMEID)
+}
+
 //Set arraysId in log messages and re-initialize the context
 func setRunIdContext(ctx context.Context, runId string) (context.Context, *logrus.Entry) {
 	return setLogFieldsInContext(ctx, runId, utils.RUNID)
@@ -478,14 +1201,7 @@ func setLogFieldsInContext(ctx context.Context, logId string, logType string) (c
 	logMutex.Lock()
 	defer logMutex.Unlock()
 
-	fields := logrus.Fields{}
-	fields, ok := ctx.Value(utils.LogFields).(logrus.Fields)
-	if !ok {
-		fields = logrus.Fields{}
-	}
-	if fields == nil {
-		fields = logrus.Fields{}
-	}
+	fields := copyLogFields(ctx)
 	fields[logType] = logId
 	ulog, ok := ctx.Value(utils.UnityLogger).(*logrus.Entry)
 	if !ok {
@@ -497,21 +1213,9 @@ func setLogFieldsInContext(ctx context.Context, logId string, logType string) (c
 	return ctx, ulog
 }
 
-var syncNodeLogCount int32
-var syncConfigLogCount int32
-
 //Increment run id log
 func incrementLogId(ctx context.Context, runidPrefix string) (context.Context, *logrus.Entry) {
-	if runidPrefix == "node" {
-		runid := fmt.Sprintf("%s-%d", runidPrefix, syncNodeLogCount)
-		atomic.AddInt32(&syncNodeLogCount, 1)
-		return setRunIdContext(ctx, runid)
-	} else if runidPrefix == "config" {
-		runid := fmt.Sprintf("%s-%d", runidPrefix, syncConfigLogCount)
-		atomic.AddInt32(&syncConfigLogCount, 1)
-		return setRunIdContext(ctx, runid)
-	}
-	return nil, nil
+	return setRunIdContext(ctx, runIds.nextBackgroundId(runidPrefix))
 }
 
 func GetRunidLog(ctx context.Context) (context.Context, *logrus.Entry, string) {
@@ -527,16 +1231,15 @@ func GetRunidLog(ctx context.Context) (context.Context, *logrus.Entry, string) {
 		if ok && len(reqid) > 0 {
 			rid = reqid[0]
 		} else {
-			atomic.AddInt64(&runid, 1)
-			rid = fmt.Sprintf("%d", runid)
+			rid = runIds.nextRequestId()
+		}
+		//Idempotency key correlates the retries of an operation across sidecar restarts
+		if key := getIdempotencyKey(ctx); key != "" {
+			rid = fmt.Sprintf("%s-%s", rid, key)
 		}
 	}
 
-	fields, _ = ctx.Value(utils.LogFields).(logrus.Fields)
-	if fields == nil {
-		fields = logrus.Fields{}
-	}
-
+	fields = copyLogFields(ctx)
 	if ok {
 		fields[utils.RUNID] = rid
 	}
@@ -584,9 +1287,14 @@ func (s *service) initFCConnector(chroot string) {
 	}
 }
 
+//gobrickSetupOnce makes sure the global gobrick logger and tracer are set once, whichever connector is initialized first
+var gobrickSetupOnce sync.Once
+
 func setupGobrick(srv *service) {
-	gobrick.SetLogger(&customLogger{})
-	gobrick.SetTracer(&emptyTracer{})
+	gobrickSetupOnce.Do(func() {
+		gobrick.SetLogger(&customLogger{})
+		gobrick.SetTracer(srv.getConnectorTracer())
+	})
 }
 
 type emptyTracer struct{}
@@ -616,6 +1324,10 @@ func (s *service) requireProbe(ctx context.Context, arrayId string) error {
 	}
 	log.Debug("Probing controller service automatically")
 	if err := s.controllerProbe(ctx, arrayId); err != nil {
+		//An unknown array is a configuration mistake rather than a probe failure
+		if status.Code(err) == codes.InvalidArgument {
+			return err
+		}
 		return status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "failed to probe/init plugin: %s", err.Error()))
 	}
 	return nil
@@ -624,22 +1336,26 @@ func (s *service) requireProbe(ctx context.Context, arrayId string) error {
 func singleArrayProbe(ctx context.Context, probeType string, array *StorageArrayConfig) error {
 	rid, log := utils.GetRunidAndLogger(ctx)
 	ctx, log = setArrayIdContext(ctx, array.ArrayId)
-	if array.UnityClient.GetToken() == "" {
-		err := array.UnityClient.Authenticate(ctx, &gounity.ConfigConnect{
-			Endpoint: array.RestGateway,
-			Username: array.Username,
-			Password: array.Password,
-		})
+	//Arrays marked as not probed, e.g. after a failed re-authentication, log in again
+	if array.UnityClient.GetToken() == "" || !array.IsProbeSuccess {
+		if err := resolveRestGateway(ctx, array); err != nil {
+			log.Errorf("RestGateway resolution failed for array %s error: %v", array.ArrayId, err)
+			array.IsProbeSuccess = false
+			return status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "Unable to login to Unity. %v", err))
+		}
+		start := time.Now()
+		err := authenticateArray(ctx, array)
+		recordProbeLatency(ctx, array.ArrayId, time.Since(start))
 		if err != nil {
-			log.Errorf("Unity authentication failed for array %s error: %v", array.ArrayId, err)
+			log.Errorf("Unity authentication failed for array %s error: %v", array.ArrayId, utils.GetUnityError(err))
 			if e, ok := status.FromError(err); ok {
 				if e.Code() == codes.Unauthenticated {
 					array.IsProbeSuccess = false
-					return status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "Unable to login to Unity. Error: %s", err.Error()))
+					return status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "Unable to login to Unity. Error: %s", utils.GetUnityError(err)))
 				}
 			}
 			array.IsProbeSuccess = false
-			return status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "Unable to login to Unity. Verify hostname/IP Address of unity. Error: %s", err.Error()))
+			return status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "Unable to login to Unity. Verify hostname/IP Address of unity. Error: %s", utils.GetUnityError(err)))
 		} else {
 			array.IsProbeSuccess = true
 			log.Debugf("%s Probe Success", probeType)
@@ -654,13 +1370,24 @@ func (s *service) probe(ctx context.Context, probeType string, arrayId string) e
 	log.Debugf("Inside %s Probe", probeType)
 	if arrayId != "" {
 		if array := s.getStorageArray(arrayId); array != nil {
-			return singleArrayProbe(ctx, probeType, array)
+			start := time.Now()
+			err := singleArrayProbe(ctx, probeType, array)
+			s.recordArrayProbe(array.ArrayId, err)
+			s.recordArrayOperation("probe", array.ArrayId, start, err)
+			if err != nil {
+				return err
+			}
+			return s.checkDuplicateSerials(ctx, arrayId)
 		}
+		return status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Array %s not configured. Verify the arrayId against the storageArrayList of the driver config", arrayId))
 	} else {
 		log.Debug("Probing all arrays")
 		atleastOneArraySuccess := false
 		for _, array := range s.getStorageArrayList() {
+			start := time.Now()
 			err := singleArrayProbe(ctx, probeType, array)
+			s.recordArrayProbe(array.ArrayId, err)
+			s.recordArrayOperation("probe", array.ArrayId, start, err)
 			if err == nil {
 				atleastOneArraySuccess = true
 				break
@@ -670,18 +1397,164 @@ func (s *service) probe(ctx context.Context, probeType string, arrayId string) e
 		}
 
 		if !atleastOneArraySuccess {
+			s.recordFleetProbe(ctx, false)
 			return status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "All unity arrays are not working. Could not proceed further"))
 		}
+		s.recordFleetProbe(ctx, true)
+		if err := s.checkDuplicateSerials(ctx, ""); err != nil {
+			return err
+		}
 	}
 	log.Infof("%s Probe Success", probeType)
 	return nil
 }
 
+//warmupProbe probes all arrays concurrently and waits until they are probed or the timeout expires, so that the first
+//requests do not pay the login latency. The probes run on a context detached from the timeout, so that probes that
+//have not completed at the timeout continue in the background
+func (s *service) warmupProbe(ctx context.Context, timeout time.Duration) {
+	log := utils.GetRunidLogger(ctx)
+	probeType := "Controller"
+	if s.mode == "node" {
+		probeType = "Node"
+	}
+	probeCtx := detachedContext{ctx}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	arrays := s.getStorageArrayList()
+	log.Infof("Warming up %d arrays with a timeout of %v", len(arrays), timeout)
+	var wg sync.WaitGroup
+	for _, array := range arrays {
+		wg.Add(1)
+		go func(array *StorageArrayConfig) {
+			defer wg.Done()
+			if err := singleArrayProbe(probeCtx, probeType, array); err != nil {
+				log.Warnf("Warmup probe failed for array %s. Error: %v", array.ArrayId, err)
+			}
+		}(array)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Info("Warmup of the arrays completed")
+	case <-ctx.Done():
+		log.Warnf("Warmup of the arrays did not complete within %v. The remaining arrays are probed in the background", timeout)
+	}
+}
+
+//newArrayHTTPClient returns a http client for direct calls to the Unity REST API of the array
+func newArrayHTTPClient(array *StorageArrayConfig, jar http.CookieJar) *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Jar:     jar,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: array.Insecure, MinVersion: array.minTLSVersion},
+		},
+	}
+}
+
+//basicSystemInfoPath is the Unity REST resource that can be queried without authentication
+const basicSystemInfoPath = "/api/types/basicSystemInfo/instances"
+
+//getBasicSystemInfo queries the basic system info of the array to check that its management interface is reachable.
+//It is a variable so that tests can override it
+var getBasicSystemInfo = func(ctx context.Context, array *StorageArrayConfig) error {
+	client := newArrayHTTPClient(array, nil)
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(array.RestGateway, "/")+basicSystemInfoPath, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-EMC-REST-CLIENT", "true")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return newRestStatusError(resp, "unexpected response status")
+	}
+	return nil
+}
+
+//lightweightProbe checks that at least one array is reachable without authenticating with it
+func (s *service) lightweightProbe(ctx context.Context, probeType string) error {
+	rid, log := utils.GetRunidAndLogger(ctx)
+	log.Debugf("Inside %s lightweight Probe", probeType)
+	for _, array := range s.getStorageArrayList() {
+		err := getBasicSystemInfo(ctx, array)
+		if err == nil {
+			log.Infof("%s lightweight Probe Success", probeType)
+			s.recordFleetProbe(ctx, true)
+			return nil
+		}
+		log.Errorf("Lightweight probe failed for array %s error:%v", array.ArrayId, utils.GetUnityError(err))
+	}
+	s.recordFleetProbe(ctx, false)
+	return status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "All unity arrays are not reachable. Could not proceed further"))
+}
+
+//onDemandProbeTimeout bounds the probe of all the arrays triggered by the identity Probe
+const onDemandProbeTimeout = 10 * time.Second
+
+//probeArraysOnDemand probes all the arrays concurrently within onDemandProbeTimeout and returns true when at least one
+//of them is reachable. Logged in arrays are kept as long as their RestGateway is reachable, while the other arrays log
+//in again, so that external liveness checks drive the reconnection of the arrays
+func (s *service) probeArraysOnDemand(ctx context.Context, probeType string) bool {
+	log := utils.GetRunidLogger(ctx)
+	ctx, cancel := context.WithTimeout(ctx, onDemandProbeTimeout)
+	defer cancel()
+
+	arrays := s.getStorageArrayList()
+	results := make(chan bool, len(arrays))
+	for _, array := range arrays {
+		go func(array *StorageArrayConfig) {
+			if array.IsProbeSuccess && !isRestGatewayReachable(ctx, array) {
+				log.Warnf("Array %s is unreachable on RestGateway %s", array.ArrayId, displayRestGateway(array.RestGateway, s.opts.MaskRestGateway))
+				array.IsProbeSuccess = false
+			}
+			err := singleArrayProbe(ctx, probeType, array)
+			if err != nil {
+				log.Errorf("On demand probe failed for array %s error:%v", array.ArrayId, err)
+			}
+			s.recordArrayProbe(array.ArrayId, err)
+			results <- err == nil
+		}(array)
+	}
+
+	reachable := 0
+collect:
+	for i := 0; i < len(arrays); i++ {
+		select {
+		case success := <-results:
+			if success {
+				reachable++
+			}
+		case <-ctx.Done():
+			log.Warnf("Probe of %d arrays did not complete within %v", len(arrays)-i, onDemandProbeTimeout)
+			break collect
+		}
+	}
+	log.Infof("%s on demand probe found %d of %d arrays reachable", probeType, reachable, len(arrays))
+	s.recordFleetProbe(ctx, reachable > 0)
+	return reachable > 0
+}
+
 func (s *service) validateAndGetResourceDetails(ctx context.Context, resourceContextId string, resourceType resourceType) (resourceId, protocol, arrayId string, unity *gounity.Client, err error) {
 	ctx, _, rid := GetRunidLog(ctx)
 	if s.getStorageArrayLength() == 0 {
 		return "", "", "", nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Invalid driver csi-driver configuration provided. At least one array should present or invalid json format. "))
 	}
+	if err = checkVolumeIdScheme(resourceContextId); err != nil {
+		recordVolumeIdParseFailure("validateAndGetResourceDetails")
+		return "", "", "", nil, status.Error(codes.FailedPrecondition, utils.GetMessageWithRunID(rid, "[%s] [%s] error:[%v]", resourceType, resourceContextId, err))
+	}
 	resourceId = getVolumeIdFromVolumeContext(resourceContextId)
 	if resourceId == "" {
 		return "", "", "", nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "%sId can't be empty.", resourceType))
@@ -691,6 +1564,13 @@ func (s *service) validateAndGetResourceDetails(ctx context.Context, resourceCon
 		return "", "", "", nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "[%s] [%s] error:[%v]", resourceType, resourceId, err))
 	}
 
+	//Volumes created using csi-unity v1.0 and v1.1 always resolve to the default array
+	if len(strings.Split(resourceContextId, "-")) == 1 {
+		if err = s.checkDefaultArrayReachable(ctx, arrayId); err != nil {
+			return "", "", "", nil, err
+		}
+	}
+
 	protocol, err = s.getProtocolFromVolumeContext(resourceContextId)
 	if err != nil {
 		return "", "", "", nil, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "[%s] [%s] error:[%v]", resourceType, resourceId, err))
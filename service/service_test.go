@@ -2,12 +2,27 @@ package service
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/dell/csi-unity/service/utils"
+	"github.com/dell/gounity"
+	"github.com/dell/gounity/types"
+	"github.com/rexray/gocsi"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestGetDriverConfig(t *testing.T) {
@@ -70,3 +85,642 @@ func TestSetArrayIdContext(t *testing.T) {
 	message, _ = entry.String()
 	assert.True(t, strings.Contains(message, `arrayid=arr1111 runid=1111 msg="Hi this is TestSetArrayIdContext"`), "Log message not found")
 }
+
+func TestCheckDefaultArrayReachable(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	client, err := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	if err != nil {
+		t.Fatalf("Unable to create unity client: %v", err)
+	}
+	s := &service{arrays: new(sync.Map)}
+	s.arrays.Store("default-array", &StorageArrayConfig{ArrayId: "default-array", Username: "user", Password: "pwd", IsDefaultArray: true, UnityClient: client})
+
+	err = s.checkDefaultArrayReachable(ctx, "default-array")
+	assert.NotNil(t, err, "Expected error for unreachable default array")
+	assert.True(t, strings.Contains(err.Error(), "Default array default-array"), "Unexpected error message: %v", err)
+
+	//Arrays already probed successfully are not probed again
+	s.arrays.Store("probed-array", &StorageArrayConfig{ArrayId: "probed-array", IsDefaultArray: true, IsProbeSuccess: true, UnityClient: client})
+	assert.Nil(t, s.checkDefaultArrayReachable(ctx, "probed-array"))
+}
+
+func TestSyncDriverConfigWithBadArray(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	conf, err := ioutil.TempFile("", "unity-config")
+	if err != nil {
+		t.Fatalf("Unable to create temp config: %v", err)
+	}
+	defer os.Remove(conf.Name())
+	arrays := make([]string, 0)
+	for i := 1; i <= 5; i++ {
+		arrays = append(arrays, fmt.Sprintf(`{"arrayId": "array%d", "username": "user", "password": "pwd", "restGateway": "https://gateway%d"}`, i, i))
+	}
+	_, _ = conf.WriteString(fmt.Sprintf(`{"storageArrayList": [%s]}`, strings.Join(arrays, ",")))
+	_ = conf.Close()
+
+	origConfig, origClient := DriverConfig, newUnityClient
+	defer func() { DriverConfig, newUnityClient = origConfig, origClient }()
+	DriverConfig = conf.Name()
+	newUnityClient = func(ctx context.Context, endpoint string, insecure bool) (*gounity.Client, error) {
+		if endpoint == "https://gateway3" {
+			return nil, errors.New("bad gateway")
+		}
+		return origClient(ctx, endpoint, insecure)
+	}
+
+	s := &service{arrays: new(sync.Map)}
+	err = s.syncDriverConfig(ctx)
+	assert.NotNil(t, err, "Expected error for the bad array")
+	assert.True(t, strings.Contains(err.Error(), "array3"), "Unexpected error message: %v", err)
+	assert.Equal(t, 4, s.getStorageArrayLength())
+	assert.Nil(t, s.getStorageArray("array3"))
+	assert.NotNil(t, s.getStorageArray("array5"))
+}
+
+func TestWithReauth(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	client, err := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	if err != nil {
+		t.Fatalf("Unable to create unity client: %v", err)
+	}
+	s := &service{arrays: new(sync.Map)}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", UnityClient: client})
+
+	origReauth := reauthenticateArray
+	defer func() { reauthenticateArray = origReauth }()
+	reauthCount := 0
+	reauthenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		reauthCount++
+		return nil
+	}
+
+	//Operation returns 401 once and succeeds after re-authentication
+	calls := 0
+	err = s.withReauth(ctx, "array1", func() error {
+		calls++
+		if calls == 1 {
+			return newUnauthorizedTestError()
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, 1, reauthCount)
+
+	//The response status decides, not the text of the error
+	calls = 0
+	err = s.withReauth(ctx, "array1", func() error {
+		calls++
+		return errors.New("volume csivol-401 not found")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, 1, reauthCount)
+	assert.True(t, isUnauthorizedError(&restStatusError{StatusCode: http.StatusUnauthorized}))
+
+	//Other errors are returned without re-authentication
+	calls = 0
+	err = s.withReauth(ctx, "array1", func() error {
+		calls++
+		return errors.New("not found")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, 1, reauthCount)
+
+	//Retry happens only once
+	calls = 0
+	err = s.withReauth(ctx, "array1", func() error {
+		calls++
+		return status.Error(codes.Unauthenticated, "session expired")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestEffectiveOpts(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	env := map[string]string{
+		EnvNodeName:              "worker-1.example.com",
+		EnvAutoProbe:             "true",
+		EnvPvtMountDir:           "/var/lib/kubelet/plugins/unity.emc.dell.com/disks",
+		EnvISCSIChroot:           "/noderoot",
+		SyncNodeInfoTimeInterval: "30",
+		EnvPoolFreeReservation:   " 10% ",
+	}
+	for key, value := range env {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+
+	s := &service{}
+	s.opts = getOptsFromEnv(ctx)
+	opts := s.EffectiveOpts()
+	assert.Equal(t, "worker-1", opts.NodeName)
+	assert.Equal(t, "worker-1.example.com", opts.LongNodeName)
+	assert.True(t, opts.AutoProbe)
+	assert.Equal(t, "/var/lib/kubelet/plugins/unity.emc.dell.com/disks", opts.PvtMountDir)
+	assert.Equal(t, "/noderoot", opts.Chroot)
+	assert.Equal(t, 30, opts.SyncNodeInfoTimeInterval)
+	assert.Equal(t, "10%", opts.PoolFreeReservation)
+
+	//Returned options are a copy
+	opts.NodeName = "changed"
+	assert.Equal(t, "worker-1", s.EffectiveOpts().NodeName)
+}
+
+func TestParseEnvHelpers(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	const name = "X_CSI_UNITY_TEST_PARSE_ENV"
+	defer os.Unsetenv(name)
+
+	//Unset variables return the default
+	os.Unsetenv(name)
+	assert.False(t, parseBoolEnv(ctx, name))
+	assert.Equal(t, 7, parseIntEnv(ctx, name, 7, 1, ""))
+	assert.Equal(t, 7, parseDurationEnv(ctx, name, time.Second, 7, 1, ""))
+
+	//Valid values
+	os.Setenv(name, "true")
+	assert.True(t, parseBoolEnv(ctx, name))
+	os.Setenv(name, " 12 ")
+	assert.Equal(t, 12, parseIntEnv(ctx, name, 7, 1, ""))
+	assert.Equal(t, 12, parseDurationEnv(ctx, name, time.Second, 7, 1, ""))
+	os.Setenv(name, "2m")
+	assert.Equal(t, 120, parseDurationEnv(ctx, name, time.Second, 7, 1, ""))
+	assert.Equal(t, 2, parseDurationEnv(ctx, name, time.Minute, 7, 1, ""))
+
+	//Invalid values and values below the minimum return the default
+	assert.False(t, parseBoolEnv(ctx, name))
+	assert.Equal(t, 7, parseIntEnv(ctx, name, 7, 1, ""))
+	os.Setenv(name, "0")
+	assert.Equal(t, 7, parseIntEnv(ctx, name, 7, 1, ""))
+	assert.Equal(t, 0, parseIntEnv(ctx, name, 7, 0, ""))
+	os.Setenv(name, "30s")
+	assert.Equal(t, 7, parseDurationEnv(ctx, name, time.Minute, 7, 1, ""))
+	os.Setenv(name, "soon")
+	assert.Equal(t, 7, parseDurationEnv(ctx, name, time.Second, 7, 0, "Using the default"))
+}
+
+func TestSyncDriverConfigEmptyPolicy(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	conf, err := ioutil.TempFile("", "unity-config")
+	if err != nil {
+		t.Fatalf("Unable to create temp config: %v", err)
+	}
+	defer os.Remove(conf.Name())
+	origConfig := DriverConfig
+	defer func() { DriverConfig = origConfig }()
+	DriverConfig = conf.Name()
+
+	writeConfig := func(content string) {
+		if err := ioutil.WriteFile(conf.Name(), []byte(content), 0644); err != nil {
+			t.Fatalf("Unable to write config: %v", err)
+		}
+	}
+	validConfig := `{"storageArrayList": [{"arrayId": "Array1", "username": "user", "password": "pwd", "restGateway": "https://127.0.0.1:1", "isDefaultArray": true}]}`
+
+	for _, policy := range []string{EmptyConfigKeepLastGood, EmptyConfigAcceptEmpty} {
+		s := &service{arrays: new(sync.Map), opts: Opts{EmptyConfigPolicy: policy}}
+		writeConfig(validConfig)
+		assert.Nil(t, s.syncDriverConfig(ctx))
+		assert.Equal(t, 1, s.getStorageArrayLength())
+
+		writeConfig(`{"storageArrayList": []}`)
+		err = s.syncDriverConfig(ctx)
+		assert.NotNil(t, err, "Expected error for config without arrays")
+		if policy == EmptyConfigKeepLastGood {
+			assert.Equal(t, 1, s.getStorageArrayLength())
+			assert.NotNil(t, s.getStorageArray("array1"))
+		} else {
+			assert.Equal(t, 0, s.getStorageArrayLength())
+		}
+	}
+}
+
+func TestArrayIdCaseSensitivity(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	conf, err := ioutil.TempFile("", "unity-config")
+	if err != nil {
+		t.Fatalf("Unable to create temp config: %v", err)
+	}
+	defer os.Remove(conf.Name())
+	_, _ = conf.WriteString(`{"storageArrayList": [
+		{"arrayId": "Array1", "username": "user", "password": "pwd", "restGateway": "https://127.0.0.1:1"},
+		{"arrayId": "array1", "username": "user", "password": "pwd", "restGateway": "https://127.0.0.1:2"}]}`)
+	_ = conf.Close()
+	origConfig := DriverConfig
+	defer func() { DriverConfig = origConfig }()
+	DriverConfig = conf.Name()
+
+	//Case-insensitive (default)
+	s := &service{arrays: new(sync.Map)}
+	err = s.syncDriverConfig(ctx)
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "case-insensitively"), "Unexpected error message: %v", err)
+
+	//Case-sensitive
+	s = &service{arrays: new(sync.Map), opts: Opts{ArrayIdCaseSensitive: true}}
+	assert.Nil(t, s.syncDriverConfig(ctx))
+	assert.Equal(t, 2, s.getStorageArrayLength())
+	assert.Equal(t, "https://127.0.0.1:1", s.getStorageArray("Array1").RestGateway)
+	assert.Equal(t, "https://127.0.0.1:2", s.getStorageArray("array1").RestGateway)
+	assert.Nil(t, s.getStorageArray("ARRAY1"))
+
+	//Case-insensitive lookup
+	s = &service{arrays: new(sync.Map)}
+	s.arrays.Store("array2", &StorageArrayConfig{ArrayId: "array2"})
+	assert.NotNil(t, s.getStorageArray("ARRAY2"))
+}
+
+func TestArrayConfigFromEnv(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	os.Setenv(EnvArrayConfigJSON, `{"storageArrayList": [
+		{"arrayId": "array1", "username": "user", "password": "pwd", "restGateway": "https://127.0.0.1:1", "isDefaultArray": true},
+		{"arrayId": "array2", "username": "user", "password": "pwd", "restGateway": "https://127.0.0.1:2"}]}`)
+	defer os.Unsetenv(EnvArrayConfigJSON)
+
+	//The config file is not read when the config is provided in the environment
+	origConfig := DriverConfig
+	defer func() { DriverConfig = origConfig }()
+	DriverConfig = "/nonexistent/driver-config.json"
+
+	s := &service{arrays: new(sync.Map)}
+	s.opts = getOptsFromEnv(ctx)
+	assert.Nil(t, s.syncDriverConfig(ctx))
+	assert.Equal(t, 2, s.getStorageArrayLength())
+	assert.NotNil(t, s.getStorageArray("array2"))
+	assert.False(t, s.isConfigWatchEnabled(), "Config file must not be watched when the config is provided in the environment")
+	assert.False(t, strings.Contains(s.EffectiveOpts().ArrayConfigJSON, "pwd"), "Array credentials exposed by the effective options")
+
+	//Invalid config in the environment is rejected by the shared validation
+	os.Setenv(EnvArrayConfigJSON, `{"storageArrayList": [{"arrayId": "array1", "username": "user", "restGateway": "https://127.0.0.1:1"}]}`)
+	s = &service{arrays: new(sync.Map)}
+	s.opts = getOptsFromEnv(ctx)
+	err := s.syncDriverConfig(ctx)
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "Password"), "Unexpected error message: %v", err)
+
+	//The config file is used and watched when the environment variable is not set
+	os.Unsetenv(EnvArrayConfigJSON)
+	s.opts = getOptsFromEnv(ctx)
+	assert.True(t, s.isConfigWatchEnabled())
+	err = s.syncDriverConfig(ctx)
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), DriverConfig), "Unexpected error message: %v", err)
+}
+
+func TestConcurrentConnectorInit(t *testing.T) {
+	//Run with -race: the global gobrick setup must not race when both connectors are initialized concurrently
+	for i := 0; i < 10; i++ {
+		s := &service{}
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.initISCSIConnector("")
+		}()
+		go func() {
+			defer wg.Done()
+			s.initFCConnector("")
+		}()
+		wg.Wait()
+		assert.NotNil(t, s.iscsiConnector)
+		assert.NotNil(t, s.fcConnector)
+	}
+}
+
+func TestMinTLSVersion(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+
+	//Supported values, 1.2 by default
+	for value, expected := range map[string]uint16{"": tls.VersionTLS12, "1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13, "TLS1.3": tls.VersionTLS13} {
+		version, err := getMinTLSVersion(value)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, version, "Unexpected version for %s", value)
+	}
+
+	//Unsupported value is rejected
+	_, err := ValidateConfig(ctx, []byte(`{"storageArrayList": [{"arrayId": "array1", "username": "user", "password": "pwd", "restGateway": "https://127.0.0.1:1", "minTLSVersion": "1.0"}]}`), false, nil)
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "minTLSVersion"), "Unexpected error message: %v", err)
+
+	//Configured minimum is applied to the transports of the Unity client and of the direct calls
+	arrays, err := ValidateConfig(ctx, []byte(`{"storageArrayList": [{"arrayId": "array1", "username": "user", "password": "pwd", "restGateway": "https://127.0.0.1:1", "minTLSVersion": "1.3"}]}`), false, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), arrays["array1"].minTLSVersion)
+	transport, err := getUnityClientTransport(arrays["array1"].UnityClient)
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), transport.TLSClientConfig.MinVersion)
+	transport = newArrayHTTPClient(arrays["array1"], nil).Transport.(*http.Transport)
+	assert.Equal(t, uint16(tls.VersionTLS13), transport.TLSClientConfig.MinVersion)
+
+	//The Unity client does not reach an array that cannot meet the minimum
+	requests := 0
+	gateway := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	gateway.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	gateway.StartTLS()
+	defer gateway.Close()
+	array := &StorageArrayConfig{ArrayId: "array1", RestGateway: gateway.URL, Insecure: true, minTLSVersion: tls.VersionTLS13}
+	client, err := newArrayUnityClient(ctx, array, gateway.URL)
+	assert.Nil(t, err)
+	_, err = gounity.NewVolume(client).FindVolumeById(ctx, "sv_1")
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "tls"), "Unexpected error message: %v", err)
+	assert.Equal(t, 0, requests)
+
+	array.minTLSVersion = tls.VersionTLS12
+	client, err = newArrayUnityClient(ctx, array, gateway.URL)
+	assert.Nil(t, err)
+	_, _ = gounity.NewVolume(client).FindVolumeById(ctx, "sv_1")
+	assert.Equal(t, 1, requests)
+}
+
+func TestReloadKeepsArrayState(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	conf, err := ioutil.TempFile("", "unity-config")
+	if err != nil {
+		t.Fatalf("Unable to create temp config: %v", err)
+	}
+	defer os.Remove(conf.Name())
+	origConfig := DriverConfig
+	defer func() { DriverConfig = origConfig }()
+	DriverConfig = conf.Name()
+	writeConfig := func(password, gateway string) {
+		content := fmt.Sprintf(`{"storageArrayList": [
+			{"arrayId": "array1", "username": "user", "password": "%s", "restGateway": "%s", "isDefaultArray": true},
+			{"arrayId": "array2", "username": "user", "password": "pwd", "restGateway": "https://127.0.0.1:2"}]}`, password, gateway)
+		if err := ioutil.WriteFile(conf.Name(), []byte(content), 0644); err != nil {
+			t.Fatalf("Unable to write config: %v", err)
+		}
+	}
+
+	s := &service{arrays: new(sync.Map)}
+	writeConfig("pwd", "https://127.0.0.1:1")
+	assert.Nil(t, s.syncDriverConfig(ctx))
+	s.getStorageArray("array1").IsHostAdded = true
+	s.getStorageArray("array1").IsProbeSuccess = true
+
+	//Unchanged array keeps its state
+	assert.Nil(t, s.syncDriverConfig(ctx))
+	assert.True(t, s.getStorageArray("array1").IsHostAdded)
+	assert.True(t, s.getStorageArray("array1").IsProbeSuccess)
+	assert.False(t, s.getStorageArray("array2").IsHostAdded)
+
+	//Secret rotation keeps the host added state, the new credentials are probed again
+	writeConfig("rotated-pwd", "https://127.0.0.1:1")
+	assert.Nil(t, s.syncDriverConfig(ctx))
+	assert.True(t, s.getStorageArray("array1").IsHostAdded)
+	assert.False(t, s.getStorageArray("array1").IsProbeSuccess)
+
+	//Array moved to another RestGateway starts over
+	writeConfig("rotated-pwd", "https://127.0.0.1:3")
+	assert.Nil(t, s.syncDriverConfig(ctx))
+	assert.False(t, s.getStorageArray("array1").IsHostAdded)
+}
+
+func TestGetUnityClientErrors(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	client, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	s := &service{arrays: new(sync.Map)}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", RestGateway: "https://127.0.0.1:1", UnityClient: client})
+	s.arrays.Store("array2", &StorageArrayConfig{ArrayId: "array2", RestGateway: "https://127.0.0.1:2"})
+
+	unity, err := s.getUnityClient(ctx, "array1")
+	assert.Nil(t, err)
+	assert.Equal(t, client, unity)
+
+	//Unknown array is a configuration mistake
+	_, err = s.getUnityClient(ctx, "array3")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "Array array3 not configured"), "Unexpected error message: %v", err)
+
+	//Array without a client is a connectivity problem
+	_, err = s.getUnityClient(ctx, "array2")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "Array array2 client not initialized, check connectivity"), "Unexpected error message: %v", err)
+}
+
+func TestStartupWarmup(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	origAuth, origList := authenticateArray, listArrayVolumes
+	defer func() { authenticateArray, listArrayVolumes = origAuth, origList }()
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		return nil
+	}
+	listArrayVolumes = func(ctx context.Context, unity *gounity.Client, startToken, maxEntries int) ([]types.Volume, int, error) {
+		return []types.Volume{}, 0, nil
+	}
+	for name, value := range map[string]string{
+		gocsi.EnvVarMode: "controller",
+		EnvWarmupTimeout: "5",
+		EnvArrayConfigJSON: `{"storageArrayList": [
+			{"arrayId": "array1", "username": "user", "password": "pwd", "restGateway": "https://127.0.0.1:1", "isDefaultArray": true},
+			{"arrayId": "array2", "username": "user", "password": "pwd", "restGateway": "https://127.0.0.1:2"}]}`,
+	} {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	//Warmup probes all arrays before BeforeServe returns
+	s := new(service)
+	assert.Nil(t, s.BeforeServe(ctx, nil, nil))
+	assert.Equal(t, 5, s.opts.WarmupTimeout)
+	assert.True(t, s.getStorageArray("array1").IsProbeSuccess)
+	assert.True(t, s.getStorageArray("array2").IsProbeSuccess)
+
+	//Warmup is bounded by its timeout and the remaining probes complete in the background
+	release := make(chan struct{})
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	s.getStorageArray("array1").IsProbeSuccess = false
+	start := time.Now()
+	s.warmupProbe(ctx, 100*time.Millisecond)
+	assert.True(t, time.Since(start) < 5*time.Second, "Warmup did not honor its timeout")
+	assert.False(t, s.getStorageArray("array1").IsProbeSuccess)
+	close(release)
+	for i := 0; i < 50 && !s.getStorageArray("array1").IsProbeSuccess; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.True(t, s.getStorageArray("array1").IsProbeSuccess, "Warmup probe did not complete in the background")
+}
+
+func TestReauthFailure(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	client, _ := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	s := &service{arrays: new(sync.Map), opts: Opts{AutoProbe: true}}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", UnityClient: client, IsProbeSuccess: true})
+
+	origAuth, origReauth, origDelete := authenticateArray, reauthenticateArray, deleteVolumeResource
+	defer func() {
+		authenticateArray, reauthenticateArray, deleteVolumeResource = origAuth, origReauth, origDelete
+	}()
+	logins := 0
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		logins++
+		return nil
+	}
+	reauthenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		return errors.New("invalid credentials")
+	}
+
+	//Failed re-authentication surfaces the specific error and marks the array unhealthy
+	err := s.withReauth(ctx, "array1", func() error {
+		return newUnauthorizedTestError()
+	})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "re-authentication failed for array array1"), "Unexpected error message: %v", err)
+	assert.False(t, s.getStorageArray("array1").IsProbeSuccess)
+
+	//The next probe logs in to the array again
+	assert.Nil(t, s.requireProbe(ctx, "array1"))
+	assert.Equal(t, 1, logins)
+	assert.True(t, s.getStorageArray("array1").IsProbeSuccess)
+
+	//Operations that handle the errors of the wrapped operation themselves also surface it
+	deleteVolumeResource = func(ctx context.Context, s *service, volID, protocol string, unity *gounity.Client) (error, error, error) {
+		return newUnauthorizedTestError(), nil, nil
+	}
+	_, err = s.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "vol1-FC-array1-sv_1"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "re-authentication failed for array array1"), "Unexpected error message: %v", err)
+	assert.False(t, s.getStorageArray("array1").IsProbeSuccess)
+}
+
+func TestArrayConfigBoolFields(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+
+	//insecure and isDefaultArray are read from their keys, whatever their case
+	arrays, err := ValidateConfig(ctx, []byte(`{"storageArrayList": [
+		{"arrayId": "array1", "username": "user", "password": "pwd", "restGateway": "https://127.0.0.1:1", "insecure": true, "isDefaultArray": true},
+		{"arrayId": "array2", "username": "user", "password": "pwd", "restGateway": "https://127.0.0.1:1", "Insecure": true, "IsDefaultArray": false},
+		{"arrayId": "array3", "username": "user", "password": "pwd", "restGateway": "https://127.0.0.1:1"}]}`), false, nil)
+	assert.Nil(t, err)
+	assert.True(t, arrays["array1"].Insecure)
+	assert.True(t, arrays["array1"].IsDefaultArray)
+	assert.True(t, arrays["array2"].Insecure)
+	assert.False(t, arrays["array2"].IsDefaultArray)
+	assert.False(t, arrays["array3"].Insecure)
+	assert.False(t, arrays["array3"].IsDefaultArray)
+
+	//Unset fields are omitted when the config is marshalled
+	data, err := json.Marshal(StorageArrayConfig{ArrayId: "array3"})
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(string(data), "insecure"), "Unexpected insecure key: %s", string(data))
+	assert.False(t, strings.Contains(string(data), "isDefaultArray"), "Unexpected isDefaultArray key: %s", string(data))
+	data, _ = json.Marshal(StorageArrayConfig{ArrayId: "array1", Insecure: true})
+	assert.True(t, strings.Contains(string(data), `"insecure":true`), "Missing insecure key: %s", string(data))
+}
+
+func TestDriverConfigMaxSize(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	conf, err := ioutil.TempFile("", "unity-config")
+	if err != nil {
+		t.Fatalf("Unable to create temp config: %v", err)
+	}
+	defer os.Remove(conf.Name())
+	origConfig := DriverConfig
+	defer func() { DriverConfig = origConfig }()
+	DriverConfig = conf.Name()
+
+	validConfig := `{"storageArrayList": [{"arrayId": "array1", "username": "user", "password": "pwd", "restGateway": "https://127.0.0.1:1", "isDefaultArray": true}]}`
+	if err := ioutil.WriteFile(conf.Name(), []byte(validConfig), 0644); err != nil {
+		t.Fatalf("Unable to write config: %v", err)
+	}
+
+	//Config under the limit is accepted
+	s := &service{arrays: new(sync.Map), opts: Opts{MaxConfigSize: int64(len(validConfig)), EmptyConfigPolicy: EmptyConfigAcceptEmpty}}
+	assert.Nil(t, s.syncDriverConfig(ctx))
+	assert.Equal(t, 1, s.getStorageArrayLength())
+
+	//Config over the limit is rejected and the last config is kept, whatever the empty config policy
+	s.opts.MaxConfigSize = int64(len(validConfig)) - 1
+	err = s.syncDriverConfig(ctx)
+	assert.NotNil(t, err)
+	assert.True(t, errors.Is(err, errConfigTooLarge), "Unexpected error: %v", err)
+	assert.Equal(t, 1, s.getStorageArrayLength())
+	assert.NotNil(t, s.getStorageArray("array1"))
+
+	//Default limit applies when not configured
+	assert.Equal(t, int64(defaultMaxConfigSize), (&service{}).getMaxConfigSize())
+}
+
+func TestArrayIdFormat(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	config := `{"storageArrayList": [
+		{"arrayId": "APM00123456789", "username": "user", "password": "pwd", "restGateway": "https://127.0.0.1:1", "isDefaultArray": true},
+		{"arrayId": "%s", "username": "user", "password": "pwd", "restGateway": "https://127.0.0.1:2"}]}`
+
+	//Valid array ids are accepted
+	unityFormat, err := getArrayIdFormat("Unity")
+	assert.Nil(t, err)
+	arrays, err := ValidateConfig(ctx, []byte(fmt.Sprintf(config, "virt1234abcd56")), false, unityFormat)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(arrays))
+
+	//Invalid array ids are rejected by their index
+	_, err = ValidateConfig(ctx, []byte(fmt.Sprintf(config, "APM0012345678")), false, unityFormat)
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "ArrayID at index [1]"), "Unexpected error message: %v", err)
+	customFormat, err := getArrayIdFormat(`^APM[0-9]+$`)
+	assert.Nil(t, err)
+	_, err = ValidateConfig(ctx, []byte(fmt.Sprintf(config, "array2")), false, customFormat)
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "ArrayID at index [1]"), "Unexpected error message: %v", err)
+
+	//Array ids are not validated by default
+	arrays, err = ValidateConfig(ctx, []byte(fmt.Sprintf(config, "array2")), false, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(arrays))
+	os.Setenv(EnvArrayIdFormat, "[")
+	defer os.Unsetenv(EnvArrayIdFormat)
+	assert.Nil(t, getOptsFromEnv(ctx).ArrayIdFormat, "Invalid format must disable the validation")
+}
+
+func TestConfigByteOrderMarkAndWhitespace(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	config := `{"storageArrayList": [{"arrayId": "array1", "username": "user", "password": "pwd", "restGateway": "https://127.0.0.1:1", "isDefaultArray": true}]}`
+
+	//BOM prefixed and whitespace wrapped configs are parsed
+	for _, content := range []string{"\xef\xbb\xbf" + config, " \n\t" + config + "\n\n ", "\xef\xbb\xbf\r\n" + config + "\r\n"} {
+		arrays, err := ValidateConfig(ctx, []byte(content), false, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(arrays))
+		assert.NotNil(t, arrays["array1"])
+	}
+
+	//Invalid JSON is reported with its location
+	_, err := ValidateConfig(ctx, []byte("\xef\xbb\xbf{\"storageArrayList\": [\n  {\"arrayId\": \"array1\",}\n]}"), false, nil)
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "byte offset 47 (line 2, column 24)"), "Unexpected error message: %v", err)
+}
+
+func TestVolumeIdScheme(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	s := &service{arrays: new(sync.Map)}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1"})
+
+	//Volume ids of the current scheme are parsed
+	assert.Nil(t, checkVolumeIdScheme("csivol-name1234-FC-array1-sv_1"))
+	assert.Nil(t, checkVolumeIdScheme("sv_1"))
+
+	//Volume ids of a later scheme are rejected with an explicit upgrade error
+	for _, volumeId := range []string{"v2:csivol-name1234-FC-array1-sv_1", "v10:csivol-FC-array1-sv_1"} {
+		_, _, _, _, err := s.validateAndGetResourceDetails(ctx, volumeId, volumeType)
+		assert.NotNil(t, err)
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+		assert.True(t, strings.Contains(err.Error(), "not supported by this driver version; upgrade the node plugin"), "Unexpected error message: %v", err)
+	}
+	err := checkVolumeIdScheme("v3:csivol-name1234-FC-array1-sv_1")
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "volume id scheme v3 not supported"), "Unexpected error message: %v", err)
+}
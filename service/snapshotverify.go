@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/dell/csi-unity/service/utils"
+	"github.com/dell/gounity"
+	"github.com/dell/gounity/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//Prefixes of the ids of the storage resources of the LUNs and of the filesystems
+const (
+	lunStorageResourcePrefix        = "sv_"
+	filesystemStorageResourcePrefix = "res_"
+)
+
+//findSnapshotById returns the snapshot with the id on the array. It is a variable so that tests can override it
+var findSnapshotById = func(ctx context.Context, unity *gounity.Client, snapId string) (*types.Snapshot, error) {
+	return gounity.NewSnapshot(unity).FindSnapshotById(ctx, snapId)
+}
+
+//getSnapshotIdName returns the snapshot name encoded in the snapshot id, or an empty string for the ids made of the
+//resource id only
+func getSnapshotIdName(snapshotId string) string {
+	tokens := strings.Split(snapshotId, "-")
+	if len(tokens) < 4 {
+		return ""
+	}
+	return strings.Join(tokens[:len(tokens)-3], "-")
+}
+
+//getSnapshotIdProtocol returns the protocol encoded in the snapshot id, or an empty string for the ids made of the
+//resource id only
+func getSnapshotIdProtocol(snapshotId string) string {
+	tokens := strings.Split(snapshotId, "-")
+	if len(tokens) < 4 {
+		return ""
+	}
+	return tokens[len(tokens)-3]
+}
+
+//verifySnapshotId checks that the snapshot found on the claimed array is the snapshot named in the snapshot id, as a
+//stale id, or an id of another array, may carry the resource id of another snapshot
+func (s *service) verifySnapshotId(ctx context.Context, snapshotId, arrayId string, snap *types.Snapshot) error {
+	if s.opts.DisableSnapshotIdVerification {
+		return nil
+	}
+	rid, _ := utils.GetRunidAndLogger(ctx)
+	name := getSnapshotIdName(snapshotId)
+	if name != "" && name != snap.SnapshotContent.Name {
+		return status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Snapshot id %s names snapshot %s but snapshot %s was found on array %s. The id may be stale or belong to another array", snapshotId, name, snap.SnapshotContent.Name, arrayId))
+	}
+	return nil
+}
+
+//verifySnapshotSource checks that the source of the snapshot to restore is a storage resource of the protocol encoded
+//in the snapshot id and of the requested protocol
+func (s *service) verifySnapshotSource(ctx context.Context, snapshotId, protocol string, snap *types.Snapshot) error {
+	if s.opts.DisableSnapshotIdVerification {
+		return nil
+	}
+	rid, _ := utils.GetRunidAndLogger(ctx)
+	idProtocol := getSnapshotIdProtocol(snapshotId)
+	if idProtocol != "" && (idProtocol == NFS) != (protocol == NFS) {
+		return status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Snapshot %s of a %s volume cannot be restored to a %s volume", snapshotId, idProtocol, protocol))
+	}
+	sourceId := snap.SnapshotContent.StorageResource.Id
+	if (protocol == NFS && strings.HasPrefix(sourceId, lunStorageResourcePrefix)) || (protocol != NFS && strings.HasPrefix(sourceId, filesystemStorageResourcePrefix)) {
+		return status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Source %s of snapshot %s does not match the protocol %s", sourceId, snapshotId, protocol))
+	}
+	return nil
+}
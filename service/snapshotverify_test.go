@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/gounity"
+	"github.com/dell/gounity/types"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestVerifySnapshotId(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	s := &service{opts: Opts{}}
+	snap := &types.Snapshot{}
+	snap.SnapshotContent.ResourceId = "38654705670"
+	snap.SnapshotContent.Name = "snap-a-1"
+
+	assert.Nil(t, s.verifySnapshotId(ctx, "snap-a-1-FC-array1-38654705670", "array1", snap))
+	assert.Nil(t, s.verifySnapshotId(ctx, "38654705670", "array1", snap), "Ids made of the resource id only are not verified")
+
+	err := s.verifySnapshotId(ctx, "snap-b-2-FC-array1-38654705670", "array1", snap)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "may be stale"), "Unexpected error message: %v", err)
+
+	s.opts.DisableSnapshotIdVerification = true
+	assert.Nil(t, s.verifySnapshotId(ctx, "snap-b-2-FC-array1-38654705670", "array1", snap))
+}
+
+func TestVerifySnapshotSource(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	s := &service{opts: Opts{}}
+	lunSnap := &types.Snapshot{}
+	lunSnap.SnapshotContent.Name = "snap1"
+	lunSnap.SnapshotContent.StorageResource.Id = "sv_1"
+	fsSnap := &types.Snapshot{}
+	fsSnap.SnapshotContent.Name = "snap2"
+	fsSnap.SnapshotContent.StorageResource.Id = "res_1"
+
+	assert.Nil(t, s.verifySnapshotSource(ctx, "snap1-FC-array1-38654705670", FC, lunSnap))
+	assert.Nil(t, s.verifySnapshotSource(ctx, "snap1-FC-array1-38654705670", ISCSI, lunSnap))
+	assert.Nil(t, s.verifySnapshotSource(ctx, "snap2-NFS-array1-171798691852", NFS, fsSnap))
+
+	err := s.verifySnapshotSource(ctx, "snap1-FC-array1-38654705670", NFS, lunSnap)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	err = s.verifySnapshotSource(ctx, "snap2-NFS-array1-171798691852", FC, fsSnap)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	//The source of the snapshot must match the protocol even when the id encodes the protocol of the request
+	err = s.verifySnapshotSource(ctx, "snap1-NFS-array1-38654705670", NFS, lunSnap)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "sv_1"), "Unexpected error message: %v", err)
+}
+
+func TestSnapshotIdOfClaimedArray(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	client1, err := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	if err != nil {
+		t.Fatalf("Unable to create Unity client: %v", err)
+	}
+	client2, err := gounity.NewClientWithArgs(ctx, "https://127.0.0.1:1", true)
+	if err != nil {
+		t.Fatalf("Unable to create Unity client: %v", err)
+	}
+
+	origAuth, origFind := authenticateArray, findSnapshotById
+	defer func() { authenticateArray, findSnapshotById = origAuth, origFind }()
+	authenticateArray = func(ctx context.Context, array *StorageArrayConfig) error {
+		return nil
+	}
+	//Resource 38654705670 holds snap1 on array1 and another snapshot on array2
+	snapshots := map[*gounity.Client]map[string]string{
+		client1: {"38654705670": "snap1"},
+		client2: {"38654705670": "snap9"},
+	}
+	findSnapshotById = func(ctx context.Context, unity *gounity.Client, snapId string) (*types.Snapshot, error) {
+		name, ok := snapshots[unity][snapId]
+		if !ok {
+			return nil, errors.New("snapshot not found")
+		}
+		snap := &types.Snapshot{}
+		snap.SnapshotContent.ResourceId = snapId
+		snap.SnapshotContent.Name = name
+		snap.SnapshotContent.StorageResource.Id = "sv_1"
+		return snap, nil
+	}
+
+	s := &service{arrays: new(sync.Map), opts: Opts{AutoProbe: true}}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", UnityClient: client1})
+	s.arrays.Store("array2", &StorageArrayConfig{ArrayId: "array2", UnityClient: client2})
+
+	//Valid id, the snapshot is deleted, failing on the unreachable array
+	_, err = s.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: "snap1-FC-array1-38654705670"})
+	assert.NotNil(t, err, "Snapshot of a valid id not deleted")
+
+	//Wrong array, the snapshot found on array2 is not the snapshot of the id and is left untouched
+	_, err = s.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: "snap1-FC-array2-38654705670"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "may be stale"), "Unexpected error message: %v", err)
+
+	//Nonexistent snapshot, the deletion is idempotent
+	_, err = s.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: "snap1-FC-array1-38654705671"})
+	assert.Nil(t, err)
+
+	crParams := &CRParams{VolumeName: "vol1", Protocol: FC, Size: 5 * 1024 * 1024 * 1024}
+	_, err = s.createVolumeFromSnap(ctx, crParams, "snap1-FC-array2-38654705670", "array2", nil, client2, nil)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "may be stale"), "Unexpected error message: %v", err)
+
+	_, err = s.createVolumeFromSnap(ctx, crParams, "snap1-FC-array1-38654705671", "array1", nil, client1, nil)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	//Valid id, the restore goes past the verification and fails on the unreachable array
+	_, err = s.createVolumeFromSnap(ctx, crParams, "snap1-FC-array1-38654705670", "array1", nil, client1, nil)
+	assert.NotNil(t, err)
+	assert.False(t, strings.Contains(err.Error(), "may be stale"), "Unexpected error message: %v", err)
+}
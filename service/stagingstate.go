@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/dell/csi-unity/service/utils"
+)
+
+//stagingStateDirName is the directory under the private mount directory used when no staging state directory is configured
+const stagingStateDirName = ".staging-state"
+
+//stagingState is the node local record of how a volume was staged
+type stagingState struct {
+	VolumeId   string   `json:"volumeId"`
+	ArrayId    string   `json:"arrayId"`
+	Protocol   string   `json:"protocol"`
+	Transport  string   `json:"transport"`
+	Portals    []string `json:"portals,omitempty"`
+	Targets    []string `json:"targets,omitempty"`
+	TargetWwns []string `json:"targetWwns,omitempty"`
+	DevicePath string   `json:"devicePath,omitempty"`
+	//Set when the volume is staged as a raw block device, which is neither formatted nor mounted
+	RawBlock bool `json:"rawBlock,omitempty"`
+	//Filesystem type requested for the volume and filesystem type found on the staging target path
+	RequestedFsType string `json:"requestedFsType,omitempty"`
+	FsType          string `json:"fsType,omitempty"`
+	//Staging target paths referencing the staged volume. The volume is disconnected when the last one is released
+	References []string `json:"references,omitempty"`
+	//Steps of the unstage of the volume already completed by an interrupted NodeUnstageVolume
+	UnstageSteps []string `json:"unstageSteps,omitempty"`
+}
+
+//newStagingState returns the staging state of a block volume connected with the given connect context data
+func newStagingState(volumeId, arrayId, protocol string, data publishContextData, devicePath string) *stagingState {
+	state := &stagingState{
+		VolumeId:   volumeId,
+		ArrayId:    arrayId,
+		Protocol:   protocol,
+		Transport:  protocol,
+		DevicePath: devicePath,
+	}
+	for _, target := range data.iscsiTargets {
+		state.Portals = append(state.Portals, target.Portal)
+		state.Targets = append(state.Targets, target.Target)
+	}
+	if len(data.fcTargets) > 0 {
+		state.TargetWwns = append(state.TargetWwns, data.fcTargets...)
+	}
+	return state
+}
+
+//getStagingStateDir returns the directory holding the staging state files. Empty when staging state is not recorded
+func (s *service) getStagingStateDir() string {
+	if s.opts.StagingStateDir != "" {
+		return s.opts.StagingStateDir
+	}
+	if s.opts.PvtMountDir != "" {
+		return path.Join(s.opts.PvtMountDir, stagingStateDirName)
+	}
+	return ""
+}
+
+//getStagingStateFile returns the staging state file of the volume
+func (s *service) getStagingStateFile(volumeId string) string {
+	return path.Join(s.getStagingStateDir(), strings.Replace(volumeId, "/", "_", -1)+".json")
+}
+
+//writeStagingState records the staging state of the volume
+func (s *service) writeStagingState(ctx context.Context, state *stagingState) error {
+	log := utils.GetRunidLogger(ctx)
+	if s.getStagingStateDir() == "" {
+		log.Debug("Staging state directory is not configured. Skipping staging state recording")
+		return nil
+	}
+	if _, err := mkdir(ctx, s.getStagingStateDir()); err != nil {
+		return err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Recording staging state of volume %s: %s", state.VolumeId, string(data))
+	return ioutil.WriteFile(s.getStagingStateFile(state.VolumeId), data, 0600)
+}
+
+//readStagingState returns the recorded staging state of the volume or nil if there is none
+func (s *service) readStagingState(ctx context.Context, volumeId string) (*stagingState, error) {
+	if s.getStagingStateDir() == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(s.getStagingStateFile(volumeId))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	state := &stagingState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+//listStagingStates returns the recorded staging states of the volumes staged on the node
+func (s *service) listStagingStates(ctx context.Context) ([]*stagingState, error) {
+	states := make([]*stagingState, 0)
+	if s.getStagingStateDir() == "" {
+		return states, nil
+	}
+	files, err := ioutil.ReadDir(s.getStagingStateDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return states, nil
+		}
+		return nil, err
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(path.Join(s.getStagingStateDir(), file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		state := &stagingState{}
+		if err := json.Unmarshal(data, state); err != nil {
+			utils.GetRunidLogger(ctx).Warnf("Skipping invalid staging state file %s. Error: %v", file.Name(), err)
+			continue
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+//writeStagingStateWithReference records the staging state of the volume, adding the staging target path to the
+//references kept from the previous staging of the volume
+func (s *service) writeStagingStateWithReference(ctx context.Context, state *stagingState, stagingPath string) error {
+	log := utils.GetRunidLogger(ctx)
+	previous, err := s.readStagingState(ctx, state.VolumeId)
+	if err != nil {
+		log.Warnf("Unable to read staging state of volume %s. Staging references are reset. Error: %v", state.VolumeId, err)
+	} else if previous != nil {
+		state.References = previous.References
+	}
+	if !utils.ArrayContains(state.References, stagingPath) {
+		state.References = append(state.References, stagingPath)
+	}
+	log.Debugf("Volume %s is staged with %d references", state.VolumeId, len(state.References))
+	return s.writeStagingState(ctx, state)
+}
+
+//releaseStagingReference removes the staging target path from the references of the staged volume and returns the
+//number of remaining references. The staging state is removed with the last reference
+func (s *service) releaseStagingReference(ctx context.Context, volumeId, stagingPath string) (int, error) {
+	log := utils.GetRunidLogger(ctx)
+	state, err := s.readStagingState(ctx, volumeId)
+	if err != nil || state == nil {
+		return 0, s.removeStagingState(ctx, volumeId)
+	}
+	references := make([]string, 0)
+	for _, reference := range state.References {
+		if reference != stagingPath {
+			references = append(references, reference)
+		}
+	}
+	if len(references) == 0 {
+		return 0, s.removeStagingState(ctx, volumeId)
+	}
+	state.References = references
+	log.Infof("Volume %s is still staged with %d references", volumeId, len(references))
+	return len(references), s.writeStagingState(ctx, state)
+}
+
+//removeStagingState removes the recorded staging state of the volume
+func (s *service) removeStagingState(ctx context.Context, volumeId string) error {
+	if s.getStagingStateDir() == "" {
+		return nil
+	}
+	err := os.Remove(s.getStagingStateFile(volumeId))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
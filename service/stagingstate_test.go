@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/goiscsi"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestStagingStateRecordsTransport(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	dir, err := ioutil.TempDir("", "staging-state")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	s := &service{opts: Opts{StagingStateDir: dir}}
+
+	//iSCSI
+	iscsiData := publishContextData{
+		deviceWWN:    "0x60060160",
+		iscsiTargets: []goiscsi.ISCSITarget{{Portal: "10.0.0.1:3260", Target: "iqn.1992-04.com.emc:cx.apm1"}},
+	}
+	volumeId := "vol1-iSCSI-array1-sv_1"
+	assert.Nil(t, s.writeStagingState(ctx, newStagingState(volumeId, "array1", ISCSI, iscsiData, "/dev/dm-1")))
+	state, err := s.readStagingState(ctx, volumeId)
+	assert.Nil(t, err)
+	assert.Equal(t, ISCSI, state.Transport)
+	assert.Equal(t, []string{"10.0.0.1:3260"}, state.Portals)
+	assert.Equal(t, []string{"iqn.1992-04.com.emc:cx.apm1"}, state.Targets)
+	assert.Equal(t, "/dev/dm-1", state.DevicePath)
+	assert.Empty(t, state.TargetWwns)
+
+	//FC
+	fcData := publishContextData{deviceWWN: "0x60060161", fcTargets: []string{"5006016089200000"}}
+	volumeId = "vol2-FC-array1-sv_2"
+	assert.Nil(t, s.writeStagingState(ctx, newStagingState(volumeId, "array1", FC, fcData, "/dev/dm-2")))
+	state, err = s.readStagingState(ctx, volumeId)
+	assert.Nil(t, err)
+	assert.Equal(t, FC, state.Transport)
+	assert.Equal(t, []string{"5006016089200000"}, state.TargetWwns)
+	assert.Empty(t, state.Portals)
+
+	//Unstage removes the record
+	assert.Nil(t, s.removeStagingState(ctx, volumeId))
+	state, err = s.readStagingState(ctx, volumeId)
+	assert.Nil(t, err)
+	assert.Nil(t, state)
+}
+
+func TestStagingReferences(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	dir, err := ioutil.TempDir("", "staging-state")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	s := &service{opts: Opts{StagingStateDir: dir}}
+	volumeId := "vol1-NFS-array1-fs_1"
+	newState := func() *stagingState {
+		return &stagingState{VolumeId: volumeId, ArrayId: "array1", Protocol: NFS, Transport: NFS}
+	}
+
+	//Staged once with two references. Staging the same path again does not add a reference
+	assert.Nil(t, s.writeStagingStateWithReference(ctx, newState(), "/staging/pod1"))
+	assert.Nil(t, s.writeStagingStateWithReference(ctx, newState(), "/staging/pod2"))
+	assert.Nil(t, s.writeStagingStateWithReference(ctx, newState(), "/staging/pod2"))
+
+	//Counts survive a restart as they are read from the staging state file
+	s = &service{opts: Opts{StagingStateDir: dir}}
+	state, err := s.readStagingState(ctx, volumeId)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/staging/pod1", "/staging/pod2"}, state.References)
+
+	//Unstage releases the volume on the last reference only
+	references, err := s.releaseStagingReference(ctx, volumeId, "/staging/pod1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, references)
+	state, err = s.readStagingState(ctx, volumeId)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/staging/pod2"}, state.References)
+
+	references, err = s.releaseStagingReference(ctx, volumeId, "/staging/pod2")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, references)
+	state, err = s.readStagingState(ctx, volumeId)
+	assert.Nil(t, err)
+	assert.Nil(t, state)
+
+	//Volumes without staging state are released
+	references, err = s.releaseStagingReference(ctx, volumeId, "/staging/pod1")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, references)
+}
+
+func TestStagedFsType(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	dir, err := ioutil.TempDir("", "staging-state")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	s := &service{arrays: new(sync.Map), opts: Opts{StagingStateDir: dir}}
+
+	//CreateVolume records the filesystem type the volume is created for
+	mountCap := func(fsType string) *csi.VolumeCapability {
+		return &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: fsType}}}
+	}
+	volumeResp := &csi.CreateVolumeResponse{Volume: &csi.Volume{VolumeContext: map[string]string{}}}
+	setFsTypeContext(volumeResp, []*csi.VolumeCapability{mountCap("xfs")}, FC)
+	assert.Equal(t, "xfs", volumeResp.Volume.VolumeContext[keyFsType])
+	volumeResp = &csi.CreateVolumeResponse{Volume: &csi.Volume{VolumeContext: map[string]string{}}}
+	setFsTypeContext(volumeResp, []*csi.VolumeCapability{mountCap("")}, ISCSI)
+	assert.Equal(t, "ext4", volumeResp.Volume.VolumeContext[keyFsType])
+
+	//NodeStageVolume records the filesystem type found on the staging target path
+	origFind := findFsType
+	defer func() { findFsType = origFind }()
+	findFsType = func(ctx context.Context, mountpoint string) (string, error) {
+		assert.Equal(t, "/staging/vol1", mountpoint)
+		return "ext4", nil
+	}
+	req := &csi.NodeStageVolumeRequest{VolumeCapability: mountCap("xfs"), VolumeContext: map[string]string{keyFsType: "xfs"}}
+	state := &stagingState{VolumeId: "vol1-FC-array1-sv_1", ArrayId: "array1", Protocol: FC, Transport: FC}
+	recordStagedFsType(ctx, state, req, FC, "/staging/vol1")
+	assert.Nil(t, s.writeStagingStateWithReference(ctx, state, "/staging/vol1"))
+
+	//The staged volumes endpoint reports the requested and the actual filesystem types
+	server := httptest.NewServer(s.healthHandler(ctx))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/volumes")
+	if err != nil {
+		t.Fatalf("Unable to get staged volumes: %v", err)
+	}
+	defer resp.Body.Close()
+	states := make([]stagingState, 0)
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&states))
+	if assert.Equal(t, 1, len(states)) {
+		assert.Equal(t, "vol1-FC-array1-sv_1", states[0].VolumeId)
+		assert.Equal(t, "xfs", states[0].RequestedFsType)
+		assert.Equal(t, "ext4", states[0].FsType)
+	}
+}
+
+func TestStageRawBlockDevice(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	dir, err := ioutil.TempDir("", "staging-state")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	s := &service{arrays: new(sync.Map), opts: Opts{StagingStateDir: dir}}
+	device, err := ioutil.TempFile(dir, "dm-")
+	if err != nil {
+		t.Fatalf("Unable to create device file: %v", err)
+	}
+	device.Close()
+
+	origMount, origFind := mountStagingDevice, findFsType
+	defer func() { mountStagingDevice, findFsType = origMount, origFind }()
+	mounts := make([]string, 0)
+	mountStagingDevice = func(ctx context.Context, req *csi.NodeStageVolumeRequest, stagingPath, symlinkPath string) error {
+		mounts = append(mounts, symlinkPath)
+		return nil
+	}
+	findFsType = func(ctx context.Context, mountpoint string) (string, error) {
+		return "ext4", nil
+	}
+	data := publishContextData{fcTargets: []string{"0x5006016c09a0124d"}}
+
+	//Raw block staging records the attached device without formatting nor mounting it
+	blockCap := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}}}
+	req := &csi.NodeStageVolumeRequest{VolumeId: "vol1-FC-array1-sv_1", VolumeCapability: blockCap}
+	assert.Nil(t, s.stageDevice(ctx, req, "array1", FC, "/staging/vol1", device.Name(), data, true))
+	assert.Equal(t, 0, len(mounts))
+	state, err := s.readStagingState(ctx, "vol1-FC-array1-sv_1")
+	assert.Nil(t, err)
+	assert.True(t, state.RawBlock)
+	assert.Equal(t, device.Name(), state.DevicePath)
+	assert.Equal(t, "", state.FsType)
+
+	//Publish bind mounts the recorded device while it exists
+	assert.Equal(t, device.Name(), s.getStagedDevicePath(ctx, "vol1-FC-array1-sv_1"))
+	os.Remove(device.Name())
+	assert.Equal(t, "", s.getStagedDevicePath(ctx, "vol1-FC-array1-sv_1"))
+
+	//Filesystem staging mounts the device
+	mountCap := &csi.VolumeCapability{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}}}
+	req = &csi.NodeStageVolumeRequest{VolumeId: "vol2-FC-array1-sv_2", VolumeCapability: mountCap}
+	assert.Nil(t, s.stageDevice(ctx, req, "array1", FC, "/staging/vol2", "/dev/dm-4", data, false))
+	assert.Equal(t, []string{"/dev/dm-4"}, mounts)
+	state, _ = s.readStagingState(ctx, "vol2-FC-array1-sv_2")
+	assert.False(t, state.RawBlock)
+	assert.Equal(t, "ext4", state.FsType)
+	assert.Equal(t, "", s.getStagedDevicePath(ctx, "vol2-FC-array1-sv_2"))
+}
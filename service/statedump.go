@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+//maxGoroutineGroups is the number of goroutine groups, the largest first, logged by the state dump
+const maxGoroutineGroups = 20
+
+//requestCounter counts the CSI requests in progress by method
+type requestCounter struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+//inFlightRequests counts the CSI requests in progress in the driver process
+var inFlightRequests = &requestCounter{}
+
+//add adds the delta to the number of requests in progress of the method
+func (c *requestCounter) add(method string, delta int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int)
+	}
+	c.counts[method] += delta
+	if c.counts[method] <= 0 {
+		delete(c.counts, method)
+	}
+}
+
+//snapshot returns a copy of the numbers of requests in progress by method
+func (c *requestCounter) snapshot() map[string]int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	counts := make(map[string]int)
+	for method, count := range c.counts {
+		counts[method] = count
+	}
+	return counts
+}
+
+//goroutineGroup is a number of goroutines in the same state and function
+type goroutineGroup struct {
+	state    string
+	function string
+	count    int
+}
+
+//getGoroutineGroups returns the goroutines of the process grouped by state and function, the largest groups first
+func getGoroutineGroups() []goroutineGroup {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	counts := make(map[goroutineGroup]int)
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		lines := strings.Split(strings.TrimSpace(stack), "\n")
+		if len(lines) < 2 {
+			continue
+		}
+		state := ""
+		if start, end := strings.Index(lines[0], "["), strings.Index(lines[0], "]"); start >= 0 && end > start {
+			state = lines[0][start+1 : end]
+		}
+		function := lines[1]
+		if paren := strings.LastIndex(function, "("); paren > 0 {
+			function = function[:paren]
+		}
+		counts[goroutineGroup{state: state, function: function}]++
+	}
+
+	groups := make([]goroutineGroup, 0, len(counts))
+	for group, count := range counts {
+		group.count = count
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].count != groups[j].count {
+			return groups[i].count > groups[j].count
+		}
+		return groups[i].function < groups[j].function
+	})
+	return groups
+}
+
+//stateDumpMutex serializes the state dumps, so that the dumps of signals received in a row are not interleaved
+var stateDumpMutex sync.Mutex
+
+//dumpState logs the state of the arrays without their credentials, the CSI requests in progress and a summary of the
+//goroutines, for field debugging
+func (s *service) dumpState(ctx context.Context) {
+	stateDumpMutex.Lock()
+	defer stateDumpMutex.Unlock()
+	_, log := incrementLogId(ctx, "dump")
+
+	log.Info("****************State dump****************")
+	arrays := s.getArrayStatusList()
+	log.Infof("Arrays: %d", len(arrays))
+	for _, array := range arrays {
+		log.Infof("Array %s: restGateway=%s insecure=%v isDefaultArray=%v isProbeSuccess=%v isHostAdded=%v inMaintenance=%v",
+			array.ArrayId, array.RestGateway, array.Insecure, array.IsDefaultArray, array.IsProbeSuccess, array.IsHostAdded, array.InMaintenance)
+	}
+
+	requests := inFlightRequests.snapshot()
+	methods := make([]string, 0, len(requests))
+	for method := range requests {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	log.Infof("Requests in progress: %d methods", len(methods))
+	for _, method := range methods {
+		log.Infof("Request %s: %d in progress", method, requests[method])
+	}
+
+	groups := getGoroutineGroups()
+	log.Infof("Goroutines: %d in %d groups", runtime.NumGoroutine(), len(groups))
+	for i, group := range groups {
+		if i == maxGoroutineGroups {
+			log.Infof("%d smaller goroutine groups not logged", len(groups)-maxGoroutineGroups)
+			break
+		}
+		log.Infof("Goroutines %s [%s]: %d", group.function, group.state, group.count)
+	}
+	log.Info("****************End of state dump****************")
+}
+
+//startStateDumpOnSignal logs a state dump on every SIGUSR1 until the context is done
+func (s *service) startStateDumpOnSignal(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				s.dumpState(ctx)
+			}
+		}
+	}()
+}
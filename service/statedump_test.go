@@ -0,0 +1,69 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"github.com/dell/csi-unity/service/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+//syncBuffer is a buffer safe for concurrent writes and reads
+type syncBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+func TestStateDumpOnSignal(t *testing.T) {
+	logger := utils.GetLogger()
+	origOut := logger.Out
+	defer logger.SetOutput(origOut)
+	out := &syncBuffer{}
+	logger.SetOutput(out)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &service{arrays: new(sync.Map), mode: "controller"}
+	s.arrays.Store("array1", &StorageArrayConfig{ArrayId: "array1", Username: "admin", Password: "Password123", RestGateway: "https://10.0.0.1"})
+	s.arrays.Store("array2", &StorageArrayConfig{ArrayId: "array2", Username: "admin", Password: "Secret456", RestGateway: "https://10.0.0.2"})
+	inFlightRequests.add("/csi.v1.Controller/CreateVolume", 2)
+	defer inFlightRequests.add("/csi.v1.Controller/CreateVolume", -2)
+	s.startStateDumpOnSignal(ctx)
+
+	//The dump lists the arrays without secrets, the requests in progress and the goroutines
+	assert.Nil(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	time.Sleep(500 * time.Millisecond)
+	dump := out.String()
+	assert.True(t, strings.Contains(dump, "Array array1: restGateway=https://10.0.0.1"), "Array missing: %s", dump)
+	assert.True(t, strings.Contains(dump, "Array array2: restGateway=https://10.0.0.2"), "Array missing: %s", dump)
+	assert.True(t, strings.Contains(dump, "Request /csi.v1.Controller/CreateVolume: 2 in progress"), "Requests missing: %s", dump)
+	assert.True(t, strings.Contains(dump, "Goroutines: "), "Goroutines missing: %s", dump)
+	assert.False(t, strings.Contains(dump, "Password123"), "Secret logged: %s", dump)
+	assert.False(t, strings.Contains(dump, "Secret456"), "Secret logged: %s", dump)
+
+	//Repeated signals dump the state again
+	assert.Nil(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	time.Sleep(200 * time.Millisecond)
+	assert.Nil(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	time.Sleep(500 * time.Millisecond)
+	dump = out.String()
+	assert.True(t, strings.Count(dump, "End of state dump") >= 2, "Repeated dumps missing: %s", dump)
+	assert.Equal(t, strings.Count(dump, "****************State dump"), strings.Count(dump, "End of state dump"))
+}
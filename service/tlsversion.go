@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"unsafe"
+
+	"github.com/dell/gounity"
+)
+
+//defaultMinTLSVersion is the minimum TLS version of the connections to an array that does not set minTLSVersion
+const defaultMinTLSVersion = "1.2"
+
+//tlsVersions maps the supported minTLSVersion values to their TLS version
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+//getMinTLSVersion returns the TLS version of the minTLSVersion value, or an error when the value is not supported
+func getMinTLSVersion(value string) (uint16, error) {
+	value = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value)), "TLS")
+	if value == "" {
+		value = defaultMinTLSVersion
+	}
+	if version, ok := tlsVersions[value]; ok {
+		return version, nil
+	}
+	return 0, fmt.Errorf("unsupported minTLSVersion %s. Supported values are 1.2 and 1.3", value)
+}
+
+//getTLSVersionName returns the name of the TLS version
+func getTLSVersionName(version uint16) string {
+	for name, v := range tlsVersions {
+		if v == version {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+//newArrayUnityClient returns a Unity client of the array reaching the endpoint, with the minimum TLS version of the
+//array applied to the connections of the client
+func newArrayUnityClient(ctx context.Context, array *StorageArrayConfig, endpoint string) (*gounity.Client, error) {
+	client, err := newUnityClient(ctx, endpoint, array.Insecure)
+	if err != nil {
+		return nil, err
+	}
+	if err = setUnityClientMinTLSVersion(client, array.minTLSVersion); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+//setUnityClientMinTLSVersion sets the minimum TLS version of the http client the Unity client sends its requests with.
+//gounity does not expose the TLS config of its http client, so its transport is reached through the unexported fields
+//of the client
+func setUnityClientMinTLSVersion(client *gounity.Client, version uint16) error {
+	transport, err := getUnityClientTransport(client)
+	if err != nil {
+		return fmt.Errorf("unable to set the minimum TLS version %s of the Unity client: %v", getTLSVersionName(version), err)
+	}
+	transport.TLSClientConfig.MinVersion = version
+	return nil
+}
+
+//getUnityClientTransport returns the transport of the http client of the Unity client
+func getUnityClientTransport(client *gounity.Client) (*http.Transport, error) {
+	if client == nil {
+		return nil, errors.New("no Unity client")
+	}
+	api := reflect.ValueOf(client).Elem().FieldByName("api")
+	if !api.IsValid() || api.Kind() != reflect.Interface || api.IsNil() || api.Elem().Kind() != reflect.Ptr {
+		return nil, errors.New("unexpected Unity client layout")
+	}
+	httpField := api.Elem().Elem().FieldByName("http")
+	if !httpField.IsValid() || httpField.Type() != reflect.TypeOf(&http.Client{}) {
+		return nil, errors.New("unexpected Unity API client layout")
+	}
+	httpClient := reflect.NewAt(httpField.Type(), unsafe.Pointer(httpField.UnsafeAddr())).Elem().Interface().(*http.Client)
+	transport, ok := httpClient.Transport.(*http.Transport)
+	if !ok || transport.TLSClientConfig == nil {
+		return nil, errors.New("unexpected Unity http client transport")
+	}
+	return transport, nil
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/dell/csi-unity/service/utils"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+//redactedValue replaces the values of the secrets in the traced payloads
+const redactedValue = "******"
+
+//getTracePayload returns the JSON payload of a request or response with the values of its secrets redacted
+func getTracePayload(msg interface{}) string {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Sprintf("<unable to marshal %T: %v>", msg, err)
+	}
+	payload := make(map[string]interface{})
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return string(data)
+	}
+	if secrets, ok := payload["secrets"].(map[string]interface{}); ok {
+		for key := range secrets {
+			secrets[key] = redactedValue
+		}
+		if data, err = json.Marshal(payload); err != nil {
+			return fmt.Sprintf("<unable to marshal %T: %v>", msg, err)
+		}
+	}
+	return string(data)
+}
+
+//traceInterceptor logs the request and the response of each method at trace level, with the secrets redacted
+func traceInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !utils.GetLogger().IsLevelEnabled(logrus.TraceLevel) {
+		return handler(ctx, req)
+	}
+	_, log, _ := GetRunidLog(ctx)
+	log.Tracef("%s request: %s", info.FullMethod, getTracePayload(req))
+	resp, err := handler(ctx, req)
+	if err != nil {
+		log.Tracef("%s error: %v", info.FullMethod, err)
+	} else {
+		log.Tracef("%s response: %s", info.FullMethod, getTracePayload(resp))
+	}
+	return resp, err
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/dell/csi-unity/service/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//fcHostsDir lists the FC HBA ports of the node
+var fcHostsDir = "/sys/class/fc_host"
+
+//iscsiadmPaths are the paths of the iscsiadm binary looked up under the chroot directory of the iSCSI client
+var iscsiadmPaths = []string{"/sbin/iscsiadm", "/usr/sbin/iscsiadm", "/bin/iscsiadm", "/usr/bin/iscsiadm"}
+
+//isISCSIToolingPresent returns true when the iscsiadm binary used by the iSCSI client is found, under the chroot
+//directory when one is configured or on the PATH otherwise. It is a variable so that tests can override it
+var isISCSIToolingPresent = func(chroot string) bool {
+	if chroot == "" {
+		_, err := exec.LookPath("iscsiadm")
+		return err == nil
+	}
+	for _, path := range iscsiadmPaths {
+		if info, err := os.Stat(filepath.Join(chroot, path)); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+//isFCToolingPresent returns true when the node has at least one FC HBA port. It is a variable so that tests can
+//override it
+var isFCToolingPresent = func() bool {
+	hosts, err := ioutil.ReadDir(fcHostsDir)
+	return err == nil && len(hosts) > 0
+}
+
+//checkTransportTooling checks at the start of the node that the tooling of at least one block transport, the iscsiadm
+//binary for iSCSI or an FC HBA for FC, is present, so that a node unable to stage block volumes is reported at startup
+//rather than at the first NodeStageVolume. With the fail policy the node does not start, with the warn policy a warning
+//is logged. NFS volumes need no transport tooling
+func (s *service) checkTransportTooling(ctx context.Context) error {
+	log := utils.GetRunidLogger(ctx)
+	iscsi := isISCSIToolingPresent(s.opts.Chroot)
+	fc := isFCToolingPresent()
+	log.Infof("Transport tooling present on the node: iSCSI %v, FC %v", iscsi, fc)
+	if iscsi || fc {
+		return nil
+	}
+	if s.opts.TransportToolingPolicy == TransportToolingFail {
+		return status.Error(codes.FailedPrecondition, "Neither the iSCSI tooling (iscsiadm) nor the FC tooling (FC HBA) is present on the node. Install open-iscsi or an FC HBA, or set X_CSI_UNITY_TRANSPORT_TOOLING_POLICY to warn to serve NFS volumes only")
+	}
+	log.Warn("*************Neither the iSCSI tooling (iscsiadm) nor the FC tooling (FC HBA) is present on the node. iSCSI and FC volumes can not be staged on this node*************")
+	return nil
+}
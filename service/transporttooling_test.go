@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckTransportTooling(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	origISCSI, origFC := isISCSIToolingPresent, isFCToolingPresent
+	defer func() { isISCSIToolingPresent, isFCToolingPresent = origISCSI, origFC }()
+	iscsi, fc := false, false
+	isISCSIToolingPresent = func(chroot string) bool { return iscsi }
+	isFCToolingPresent = func() bool { return fc }
+
+	for _, policy := range []string{TransportToolingWarn, TransportToolingFail} {
+		s := &service{opts: Opts{TransportToolingPolicy: policy}}
+
+		//Tooling present
+		for _, present := range [][]bool{{true, false}, {false, true}, {true, true}} {
+			iscsi, fc = present[0], present[1]
+			assert.Nil(t, s.checkTransportTooling(ctx))
+		}
+
+		//Tooling absent
+		iscsi, fc = false, false
+		err := s.checkTransportTooling(ctx)
+		if policy == TransportToolingFail {
+			assert.NotNil(t, err)
+			assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+}
+
+func TestISCSIToolingInChroot(t *testing.T) {
+	chroot, err := ioutil.TempDir("", "unity-chroot")
+	if err != nil {
+		t.Fatalf("Unable to create chroot: %v", err)
+	}
+	defer os.RemoveAll(chroot)
+
+	assert.False(t, isISCSIToolingPresent(chroot))
+	_ = os.MkdirAll(filepath.Join(chroot, "usr", "sbin"), 0755)
+	_ = ioutil.WriteFile(filepath.Join(chroot, "usr", "sbin", "iscsiadm"), []byte{}, 0755)
+	assert.True(t, isISCSIToolingPresent(chroot))
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/csi-unity/service/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//Steps of the unstage of a block volume recorded in its staging state once completed, so that a NodeUnstageVolume
+//interrupted by its deadline is resumed by the next one rather than repeated
+const (
+	unstageStepUnmount    = "unmount"
+	unstageStepDisconnect = "disconnect"
+)
+
+//unmountStagedVolume unmounts the staging target path of the volume. It is a variable so that tests can override it
+var unmountStagedVolume = unstageVolume
+
+//disconnectStagedVolume disconnects the device of the volume from the node. It is a variable so that tests can override it
+var disconnectStagedVolume = func(ctx context.Context, s *service, volumeWwn, protocol string) error {
+	return s.disconnectVolume(ctx, volumeWwn, protocol)
+}
+
+//isUnstageStepCompleted returns true when the step of the unstage of the volume has been recorded as completed
+func isUnstageStepCompleted(state *stagingState, step string) bool {
+	return state != nil && utils.ArrayContains(state.UnstageSteps, step)
+}
+
+//checkpointUnstageStep records the step of the unstage of the volume as completed in its staging state
+func (s *service) checkpointUnstageStep(ctx context.Context, state *stagingState, step string) *stagingState {
+	log := utils.GetRunidLogger(ctx)
+	state.UnstageSteps = append(state.UnstageSteps, step)
+	if err := s.writeStagingState(ctx, state); err != nil {
+		log.Warnf("Unable to record the %s step of the unstage of volume %s. Error: %v", step, state.VolumeId, err)
+	}
+	return state
+}
+
+//unstageStepError returns the error of the step of the unstage of the volume, reporting the step that timed out when
+//the request deadline is exceeded
+func unstageStepError(ctx context.Context, volumeId, step string, err error) error {
+	if ctx.Err() == nil {
+		return err
+	}
+	rid, _ := utils.GetRunidAndLogger(ctx)
+	return status.Error(codes.DeadlineExceeded, utils.GetMessageWithRunID(rid, "NodeUnstageVolume of volume %s timed out at the %s step. The next NodeUnstageVolume resumes from this step. Error: %v", volumeId, step, err))
+}
+
+//unstageBlockVolume unmounts the staging target path of the block volume and disconnects the volume from the node once
+//no other staging target path references it. The completed steps are recorded in the staging state of the volume and
+//skipped by a later NodeUnstageVolume resuming an interrupted one
+func (s *service) unstageBlockVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest, volId, volumeWwn, protocol, stageTgt string) error {
+	rid, log := utils.GetRunidAndLogger(ctx)
+	state, err := s.readStagingState(ctx, req.GetVolumeId())
+	if err != nil {
+		log.Warnf("Unable to read staging state of volume %s. Unstage steps are not resumed. Error: %v", volId, err)
+		state = nil
+	}
+	if state == nil {
+		state = &stagingState{VolumeId: req.GetVolumeId(), Protocol: protocol, Transport: protocol, References: []string{stageTgt}}
+	}
+
+	lastMounted, devicePath := true, state.DevicePath
+	if isUnstageStepCompleted(state, unstageStepUnmount) {
+		log.Infof("Resuming the unstage of volume %s. Completed steps: %v", volId, state.UnstageSteps)
+	} else {
+		lastMounted, devicePath, err = unmountStagedVolume(ctx, req, volumeWwn, s.opts.Chroot)
+		if err != nil {
+			return unstageStepError(ctx, volId, unstageStepUnmount, err)
+		}
+	}
+
+	//The volume stays connected while other staging target paths reference it
+	references := 0
+	for _, reference := range state.References {
+		if reference != stageTgt {
+			references++
+		}
+	}
+	if references > 0 {
+		if _, err := s.releaseStagingReference(ctx, req.GetVolumeId(), stageTgt); err != nil {
+			log.Warnf("Unable to update staging state of volume %s. Error: %v", volId, err)
+		}
+		if err := removeWithRetry(ctx, stageTgt); err != nil {
+			log.Infof("Error removing stageTgt: %v", err)
+		}
+		log.Debugf("Volume %s is not disconnected as it is still staged with %d references", volId, references)
+		return nil
+	}
+
+	if !lastMounted {
+		// It is unusual that we have not removed the last mount (i.e. lastUnmounted == false)
+		// Recheck to make sure the target is unmounted.
+		log.Debug("Not the last mount - rechecking target mount is gone")
+		targetMount, err := getTargetMount(ctx, stageTgt)
+		if err != nil {
+			return err
+		}
+		if targetMount.Device != "" {
+			return status.Error(codes.Internal, utils.GetMessageWithRunID(rid, "Target Mount still present"))
+		}
+
+		if devicePath == "" {
+			devicePath = targetMount.Source
+		}
+
+		// Get the device mounts
+		dev, err := GetDevice(ctx, devicePath)
+		if err != nil {
+			return status.Error(codes.Internal, utils.GetMessageWithRunID(rid, err.Error()))
+		}
+		log.Debug("Rechecking dev mounts")
+		mnts, err := getDevMounts(ctx, dev)
+		if err != nil {
+			return status.Error(codes.Internal, utils.GetMessageWithRunID(rid, err.Error()))
+		}
+		if len(mnts) > 0 {
+			return status.Error(codes.Internal, utils.GetMessageWithRunID(rid, "Device mounts still present after unmounting target and staging mounts %#v", mnts))
+		}
+	}
+	if !isUnstageStepCompleted(state, unstageStepUnmount) {
+		state = s.checkpointUnstageStep(ctx, state, unstageStepUnmount)
+	}
+
+	if !isUnstageStepCompleted(state, unstageStepDisconnect) {
+		disconnectCtx, _ := setVolumeIdContext(ctx, req.GetVolumeId())
+		if err := disconnectStagedVolume(disconnectCtx, s, volumeWwn, protocol); err != nil {
+			return unstageStepError(ctx, volId, unstageStepDisconnect, err)
+		}
+		state = s.checkpointUnstageStep(ctx, state, unstageStepDisconnect)
+	}
+
+	// Remove the mount private directory if present, and the directory
+	if err := removeWithRetry(ctx, stageTgt); err != nil {
+		log.Infof("Error removing stageTgt: %v", err)
+	}
+	if _, err := s.releaseStagingReference(ctx, req.GetVolumeId(), stageTgt); err != nil {
+		log.Warnf("Unable to update staging state of volume %s. Error: %v", volId, err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnstageResumesAfterTimeout(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	dir, err := ioutil.TempDir("", "unity-staging")
+	if err != nil {
+		t.Fatalf("Unable to create staging state dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	s := &service{opts: Opts{StagingStateDir: filepath.Join(dir, "state")}}
+	volumeId := "vol1-iSCSI-array1-sv_1"
+	stageTgt := filepath.Join(dir, "staging")
+	state := newStagingState(volumeId, "array1", ISCSI, publishContextData{}, "/dev/dm-1")
+	assert.Nil(t, s.writeStagingStateWithReference(ctx, state, stageTgt))
+
+	origUnmount, origDisconnect := unmountStagedVolume, disconnectStagedVolume
+	defer func() { unmountStagedVolume, disconnectStagedVolume = origUnmount, origDisconnect }()
+	unmounts, disconnects := 0, 0
+	unmountStagedVolume = func(ctx context.Context, req *csi.NodeUnstageVolumeRequest, deviceWWN, chroot string) (bool, string, error) {
+		unmounts++
+		return true, "/dev/dm-1", nil
+	}
+	disconnectStagedVolume = func(ctx context.Context, s *service, volumeWwn, protocol string) error {
+		disconnects++
+		if disconnects == 1 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}
+	req := &csi.NodeUnstageVolumeRequest{VolumeId: volumeId, StagingTargetPath: stageTgt}
+
+	//Disconnect times out after the unmount completed
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	err = s.unstageBlockVolume(timeoutCtx, req, "sv_1", "60060160abcd", ISCSI, stageTgt)
+	assert.NotNil(t, err)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "timed out at the disconnect step"), "Unexpected error message: %v", err)
+	state, err = s.readStagingState(ctx, volumeId)
+	assert.Nil(t, err)
+	if assert.NotNil(t, state) {
+		assert.Equal(t, []string{unstageStepUnmount}, state.UnstageSteps)
+	}
+
+	//Next unstage resumes at the disconnect without unmounting again
+	assert.Nil(t, s.unstageBlockVolume(ctx, req, "sv_1", "60060160abcd", ISCSI, stageTgt))
+	assert.Equal(t, 1, unmounts)
+	assert.Equal(t, 2, disconnects)
+	state, err = s.readStagingState(ctx, volumeId)
+	assert.Nil(t, err)
+	assert.Nil(t, state, "Staging state must be removed once the volume is unstaged")
+
+	//Unstage without recorded state runs every step
+	unmounts, disconnects = 0, 1
+	assert.Nil(t, s.unstageBlockVolume(ctx, req, "sv_1", "60060160abcd", ISCSI, stageTgt))
+	assert.Equal(t, 1, unmounts)
+	assert.Equal(t, 2, disconnects)
+}
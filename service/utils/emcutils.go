@@ -7,8 +7,10 @@ package utils
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io/ioutil"
 	"net"
 	"os"
@@ -345,3 +347,82 @@ func ParseSize(size string) (int64, error) {
 	valueMap["Pi"] = 1125899906842624
 	return valueInt * valueMap[unit], nil
 }
+
+//resourceNamePattern matches the characters allowed in Unity resource names
+var resourceNamePattern = regexp.MustCompile("^[A-Za-z0-9_-]+$")
+
+//SanitizeResourceName returns the name trimmed to meet the Unity resource name length limit. Names longer than maxLength
+//are truncated and suffixed with a hash of the full name, so that distinct names remain distinct and the same name is always
+//sanitized the same way. Returns an error when the name is empty, has characters not allowed by Unity or the limit is too small
+func SanitizeResourceName(name string, maxLength int) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("name should not be empty")
+	}
+	if !resourceNamePattern.MatchString(name) {
+		return "", errors.New(fmt.Sprintf("name %s can contain only alphanumeric characters, hyphen and underscore", name))
+	}
+	if len(name) <= maxLength {
+		return name, nil
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(name))
+	suffix := fmt.Sprintf("-%08x", hash.Sum32())
+	if maxLength <= len(suffix) {
+		return "", errors.New(fmt.Sprintf("name %s can not be shortened to %d characters", name, maxLength))
+	}
+	return name[:maxLength-len(suffix)] + suffix, nil
+}
+
+//unityErrorCodePattern matches the Unity error code in the text of gounity errors
+var unityErrorCodePattern = regexp.MustCompile(`(?i)"?error_?code"?\s*[:=]?\s*(\d+)`)
+
+//GetUnityErrorCode returns the Unity error code carried by a gounity error or an empty string when it has none
+func GetUnityErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	//gounity returns the error body of the Unity REST API decoded in its error type
+	unityError := struct {
+		ErrorContent struct {
+			ErrorCode int `json:"errorCode"`
+		} `json:"error"`
+	}{}
+	if data, jsonErr := json.Marshal(err); jsonErr == nil {
+		if json.Unmarshal(data, &unityError) == nil && unityError.ErrorContent.ErrorCode != 0 {
+			return strconv.Itoa(unityError.ErrorContent.ErrorCode)
+		}
+	}
+	if match := unityErrorCodePattern.FindStringSubmatch(err.Error()); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+//GetUnityHTTPStatusCode returns the HTTP status code of the response carried by a gounity error or 0 when it has none
+func GetUnityHTTPStatusCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	//gounity returns the error body of the Unity REST API decoded in its error type
+	unityError := struct {
+		ErrorContent struct {
+			HTTPStatusCode int `json:"httpStatusCode"`
+		} `json:"error"`
+	}{}
+	if data, jsonErr := json.Marshal(err); jsonErr == nil && json.Unmarshal(data, &unityError) == nil {
+		return unityError.ErrorContent.HTTPStatusCode
+	}
+	return 0
+}
+
+//GetUnityError returns the error message including the Unity error code of the error, so that it is not lost when
+//the error is mapped to a gRPC code
+func GetUnityError(err error) string {
+	message := fmt.Sprint(err)
+	code := GetUnityErrorCode(err)
+	if code == "" || strings.Contains(message, code) {
+		return message
+	}
+	return fmt.Sprintf("[Unity error code %s] %s", code, message)
+}
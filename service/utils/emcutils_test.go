@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeResourceName(t *testing.T) {
+	//Names within the limit are only trimmed
+	name, err := SanitizeResourceName(" snapshot-1 ", 63)
+	assert.Nil(t, err)
+	assert.Equal(t, "snapshot-1", name)
+
+	//Names over the limit are truncated with a stable hash suffix
+	longName := "snapshot-" + strings.Repeat("a", 100)
+	name, err = SanitizeResourceName(longName, 63)
+	assert.Nil(t, err)
+	assert.Equal(t, 63, len(name))
+	assert.True(t, strings.HasPrefix(name, "snapshot-aaaa"))
+	again, _ := SanitizeResourceName(longName, 63)
+	assert.Equal(t, name, again)
+	other, _ := SanitizeResourceName(longName+"b", 63)
+	assert.NotEqual(t, name, other)
+
+	//Degenerate names can't be sanitized
+	for _, degenerate := range []string{"", "   ", "snap@shot!", "snapshot/1"} {
+		_, err = SanitizeResourceName(degenerate, 63)
+		assert.NotNil(t, err, "Expected error for name [%s]", degenerate)
+	}
+	_, err = SanitizeResourceName(longName, 8)
+	assert.NotNil(t, err)
+}
+
+//unityTestError has the JSON layout of the error body returned by the Unity REST API
+type unityTestError struct {
+	ErrorContent struct {
+		ErrorCode      int    `json:"errorCode"`
+		HTTPStatusCode int    `json:"httpStatusCode"`
+		Message        string `json:"message"`
+	} `json:"error"`
+}
+
+func (e *unityTestError) Error() string {
+	return e.ErrorContent.Message
+}
+
+func TestGetUnityError(t *testing.T) {
+	typed := &unityTestError{}
+	typed.ErrorContent.ErrorCode = 131149829
+	typed.ErrorContent.Message = "The requested resource does not exist."
+	assert.Equal(t, "131149829", GetUnityErrorCode(typed))
+	assert.Equal(t, "[Unity error code 131149829] The requested resource does not exist.", GetUnityError(typed))
+
+	text := errors.New(`{"errorCode":151036446,"messages":[{"en-US":"The system is busy."}]}`)
+	assert.Equal(t, "151036446", GetUnityErrorCode(text))
+	assert.Equal(t, text.Error(), GetUnityError(text))
+
+	plain := errors.New("connection refused")
+	assert.Equal(t, "", GetUnityErrorCode(plain))
+	assert.Equal(t, "connection refused", GetUnityError(plain))
+	assert.Equal(t, "<nil>", GetUnityError(nil))
+}
+
+func TestGetUnityHTTPStatusCode(t *testing.T) {
+	typed := &unityTestError{}
+	typed.ErrorContent.HTTPStatusCode = 401
+	typed.ErrorContent.Message = "Unauthorized"
+	assert.Equal(t, 401, GetUnityHTTPStatusCode(typed))
+	assert.Equal(t, 0, GetUnityHTTPStatusCode(errors.New("error: 401 Unauthorized")))
+	assert.Equal(t, 0, GetUnityHTTPStatusCode(nil))
+}
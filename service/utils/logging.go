@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,7 +20,7 @@ var once sync.Once
 
 const (
 	// Default log format will output [INFO]: 2006-01-02T15:04:05Z07:00 - Log message
-	defaultLogFormat       = "time=\"%time%\" level=%lvl% %arrayid% %runid% msg=\"%msg%\""
+	defaultLogFormat       = "time=\"%time%\" level=%lvl% %arrayid% %runid%%volumeid% msg=\"%msg%\""
 	defaultTimestampFormat = time.RFC3339
 )
 
@@ -67,6 +68,12 @@ func (f *Formatter) Format(entry *logrus.Entry) ([]byte, error) {
 	} else {
 		output = strings.Replace(output, "%arrayid%", "", 1)
 	}
+	x, b = fields[VOLUMEID]
+	if b {
+		output = strings.Replace(output, "%volumeid%", fmt.Sprintf(" volumeid=%v", x), 1)
+	} else {
+		output = strings.Replace(output, "%volumeid%", "", 1)
+	}
 
 	for k, val := range entry.Data {
 		switch v := val.(type) {
@@ -136,11 +143,44 @@ func GetLogger() *logrus.Logger {
 	return singletonLog
 }
 
+//SamplingFormatter emits one in Rate of the debug and trace log lines, so that the logs of mass operations do not
+//overwhelm the log pipeline. Lines of the other levels are always emitted, and emitted lines keep their runid
+type SamplingFormatter struct {
+	Formatter logrus.Formatter
+	Rate      uint64
+	count     uint64
+}
+
+// Format building log message of the sampled lines.
+func (f *SamplingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	if f.Rate > 1 && entry.Level >= logrus.DebugLevel {
+		if (atomic.AddUint64(&f.count, 1)-1)%f.Rate != 0 {
+			return []byte{}, nil
+		}
+	}
+	return f.Formatter.Format(entry)
+}
+
+//SetDebugLogSampling makes the logger emit one in rate of the debug and trace log lines. A rate of 1 or less emits all of them
+func SetDebugLogSampling(rate int) {
+	log := GetLogger()
+	formatter := log.Formatter
+	if sampling, ok := formatter.(*SamplingFormatter); ok {
+		formatter = sampling.Formatter
+	}
+	if rate <= 1 {
+		log.SetFormatter(formatter)
+		return
+	}
+	log.SetFormatter(&SamplingFormatter{Formatter: formatter, Rate: uint64(rate)})
+}
+
 const (
 	UnityLogger = "unitylog"
 	LogFields   = "fields"
 	RUNID       = "runid"
 	ARRAYID     = "arrayid"
+	VOLUMEID    = "volumeid"
 )
 
 func GetRunidLogger(ctx context.Context) *logrus.Entry {
@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"strings"
 	"testing"
@@ -25,3 +27,49 @@ func TestGetRunidLogger(t *testing.T) {
 	message, _ = entry.String()
 	assert.True(t, strings.Contains(message, `arrayid=arr0000 runid=1111 msg="Hi this is TestSetArrayIdContext"`), "Log message not found")
 }
+
+func TestSamplingFormatter(t *testing.T) {
+	var out bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&out)
+	log.SetLevel(logrus.DebugLevel)
+	log.SetFormatter(&SamplingFormatter{Formatter: &Formatter{}, Rate: 10})
+	entry := log.WithField(RUNID, "42")
+	for i := 0; i < 1000; i++ {
+		entry.Debugf("debug line %d", i)
+		if i%10 == 0 {
+			entry.Errorf("error line %d", i)
+			entry.Warnf("warning line %d", i)
+		}
+	}
+
+	debugLines, errorLines, warningLines := 0, 0, 0
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		assert.True(t, strings.Contains(line, "runid=42"), "Runid missing: %s", line)
+		switch {
+		case strings.Contains(line, "level=debug"):
+			debugLines++
+		case strings.Contains(line, "level=error"):
+			errorLines++
+		case strings.Contains(line, "level=warning"):
+			warningLines++
+		}
+	}
+	assert.Equal(t, 100, debugLines)
+	assert.Equal(t, 100, errorLines)
+	assert.Equal(t, 100, warningLines)
+
+	//Sampling of the shared logger can be changed and disabled
+	SetDebugLogSampling(5)
+	sampling, ok := GetLogger().Formatter.(*SamplingFormatter)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(5), sampling.Rate)
+	SetDebugLogSampling(20)
+	sampling = GetLogger().Formatter.(*SamplingFormatter)
+	assert.Equal(t, uint64(20), sampling.Rate)
+	_, ok = sampling.Formatter.(*SamplingFormatter)
+	assert.False(t, ok)
+	SetDebugLogSampling(0)
+	_, ok = GetLogger().Formatter.(*Formatter)
+	assert.True(t, ok)
+}
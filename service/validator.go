@@ -2,7 +2,9 @@ package service
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/dell/csi-unity/service/utils"
@@ -20,6 +22,85 @@ func validateVolumeCreateParam(req *csi.CreateVolumeRequest) error {
 	return nil
 }
 
+//createVolumeParameters are the storage class parameters read by CreateVolume
+var createVolumeParameters = []string{keyStoragePool, keyThinProvisioned, keyDescription, keyDataReductionEnabled,
+	keyTieringPolicy, keyHostIOLimitName, keyArrayId, keyProtocol, keyNasServer, keyHostIoSize, keyDisableMultipath,
+	keyPlacementGroup, keyAllowArrayFallback, keyArraySelector, keyMountPropagation, keySELinuxContext, keyNamespace,
+	keyAccessPolicy}
+
+//csiParameterPrefix prefixes the parameters added by the external provisioner, e.g. csi.storage.k8s.io/pvc/namespace
+const csiParameterPrefix = "csi.storage.k8s.io/"
+
+//validateParameterKeys rejects the parameters CreateVolume does not read, e.g. a mistyped tieringpolicy, so that they
+//are not silently ignored. The parameters added by the external provisioner are accepted
+func validateParameterKeys(ctx context.Context, params map[string]string) error {
+	rid, _ := utils.GetRunidAndLogger(ctx)
+	unknown := make([]string, 0)
+	for key := range params {
+		if !strings.HasPrefix(key, csiParameterPrefix) && !utils.ArrayContains(createVolumeParameters, key) {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Unknown parameters: %s. Supported parameters are %s", strings.Join(unknown, ", "), strings.Join(createVolumeParameters, ", ")))
+}
+
+//Policies applied to the storage class parameters implying both a block and an NFS volume
+const (
+	MixedProtocolReject = "reject"
+	MixedProtocolWarn   = "warn"
+)
+
+//fileProtocolParameters are the storage class parameters of NFS volumes only, blockProtocolParameters those of FC and
+//iSCSI volumes only
+var (
+	fileProtocolParameters  = []string{keyNasServer, keyHostIoSize, keyAccessPolicy}
+	blockProtocolParameters = []string{keyDisableMultipath}
+)
+
+//getProtocolParameters returns the parameters among the keys that are set
+func getProtocolParameters(params map[string]string, keys []string) []string {
+	set := make([]string, 0)
+	for _, key := range keys {
+		if strings.TrimSpace(params[key]) != "" {
+			set = append(set, key)
+		}
+	}
+	return set
+}
+
+//validateProtocolParameters returns an InvalidArgument error naming the conflict when the parameters imply both a block
+//and an NFS volume, or a protocol other than the protocol parameter
+func validateProtocolParameters(ctx context.Context, params map[string]string) error {
+	rid, _ := utils.GetRunidAndLogger(ctx)
+	fileParams := getProtocolParameters(params, fileProtocolParameters)
+	blockParams := getProtocolParameters(params, blockProtocolParameters)
+	protocol := strings.TrimSpace(params[keyProtocol])
+	switch {
+	case len(fileParams) > 0 && len(blockParams) > 0:
+		return status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Conflicting parameters: %s imply an NFS volume while %s imply an FC or iSCSI volume", strings.Join(fileParams, ", "), strings.Join(blockParams, ", ")))
+	case len(fileParams) > 0 && protocol != "" && !strings.EqualFold(protocol, NFS):
+		return status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Conflicting parameters: %s imply an NFS volume while %s is %s", strings.Join(fileParams, ", "), keyProtocol, protocol))
+	case len(blockParams) > 0 && strings.EqualFold(protocol, NFS):
+		return status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "Conflicting parameters: %s imply an FC or iSCSI volume while %s is %s", strings.Join(blockParams, ", "), keyProtocol, protocol))
+	}
+	return nil
+}
+
+//checkMixedProtocolParameters applies the configured policy to the storage class parameters implying both a block and
+//an NFS volume. The reject policy fails the request, the warn policy logs the conflict and keeps the protocol parameter
+func (s *service) checkMixedProtocolParameters(ctx context.Context, params map[string]string) error {
+	err := validateProtocolParameters(ctx, params)
+	if err == nil || s.opts.MixedProtocolPolicy != MixedProtocolWarn {
+		return err
+	}
+	utils.GetRunidLogger(ctx).Warnf("%s. Using protocol %s", status.Convert(err).Message(), params[keyProtocol])
+	return nil
+}
+
 func checkValidAccessTypes(vcs []*csi.VolumeCapability) bool {
 	for _, vc := range vcs {
 		if vc == nil {
@@ -187,6 +268,27 @@ func validateCreateVolumeFromSource(ctx context.Context, sourceVolResp *types.Vo
 	return nil
 }
 
+//volumeAllocationUnit is the granularity of the capacity allocated by Unity to the volumes
+const volumeAllocationUnit = 8 * 1024
+
+//validateCapacityLimit returns an error when the required bytes, or the capacity allocated for them once rounded up to
+//volumeAllocationUnit, exceed the limit bytes. There is no limit when the limit bytes are 0
+func validateCapacityLimit(required, limit int64) error {
+	if limit < 0 {
+		return fmt.Errorf("LimitBytes %d should not be negative", limit)
+	}
+	if limit == 0 {
+		return nil
+	}
+	if required > limit {
+		return fmt.Errorf("RequiredBytes %d exceeds LimitBytes %d", required, limit)
+	}
+	if allocated := (required + volumeAllocationUnit - 1) / volumeAllocationUnit * volumeAllocationUnit; allocated > limit {
+		return fmt.Errorf("RequiredBytes %d rounded up to the allocation unit of %d bytes is %d, which exceeds LimitBytes %d", required, volumeAllocationUnit, allocated, limit)
+	}
+	return nil
+}
+
 //ValidateCreateVolumeRequest - Validates all mandatory parameters in create volume request
 func ValidateCreateVolumeRequest(ctx context.Context, req *csi.CreateVolumeRequest) (protocol, storagePool string, size, tieringPolicy, hostIoSize int64, thin, dataReduction bool, err error) {
 
@@ -225,6 +327,10 @@ func ValidateCreateVolumeRequest(ctx context.Context, req *csi.CreateVolumeReque
 		return "", "", 0, 0, 0, false, false, status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "RequiredBytes should be greater then 0"))
 	}
 
+	if err = validateCapacityLimit(size, req.GetCapacityRange().GetLimitBytes()); err != nil {
+		return "", "", 0, 0, 0, false, false, status.Error(codes.OutOfRange, utils.GetMessageWithRunID(rid, "%v", err))
+	}
+
 	tieringPolicy, err = strconv.ParseInt(params[keyTieringPolicy], 0, 64)
 	if err != nil {
 		tieringPolicy = 0
@@ -323,6 +429,30 @@ func ValidateAndGetProtocol(ctx context.Context, protocol, scProtocol string) (s
 	return protocol, nil
 }
 
+//validateCreateVolumeAccessType validates that the access type of the requested volume capabilities is compatible
+//with the protocol given in the parameters
+func validateCreateVolumeAccessType(ctx context.Context, req *csi.CreateVolumeRequest) error {
+	rid, _ := utils.GetRunidAndLogger(ctx)
+	protocol := req.GetParameters()[keyProtocol]
+	if protocol == "" {
+		protocol = FC
+	}
+	protocol, err := ValidateAndGetProtocol(ctx, protocol, "")
+	if err != nil {
+		return err
+	}
+
+	for _, vc := range req.GetVolumeCapabilities() {
+		if vc.GetBlock() != nil && protocol == NFS {
+			return status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "%s. Block access type requested with protocol %s", errBlockNFS, protocol))
+		}
+		if mount := vc.GetMount(); mount != nil && protocol != NFS && strings.HasPrefix(strings.ToLower(mount.GetFsType()), "nfs") {
+			return status.Error(codes.InvalidArgument, utils.GetMessageWithRunID(rid, "%s. Mount access type with fsType %s requested with protocol %s", errNFSMountBlock, mount.GetFsType(), protocol))
+		}
+	}
+	return nil
+}
+
 //SingleAccessMode returns true if only a single access is allowed SINGLE_NODE_WRITER or SINGLE_NODE_READER_ONLY
 func SingleAccessMode(accMode *csi.VolumeCapability_AccessMode) bool {
 	switch accMode.GetMode() {
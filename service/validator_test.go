@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestValidateCapacityRange(t *testing.T) {
+	ctx := context.Background()
+	request := func(required, limit int64) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:          "vol1",
+			Parameters:    map[string]string{keyStoragePool: "pool_1", keyProtocol: FC},
+			CapacityRange: &csi.CapacityRange{RequiredBytes: required, LimitBytes: limit},
+			VolumeCapabilities: []*csi.VolumeCapability{{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+			}},
+		}
+	}
+
+	//Ranges whose rounded allocation fits in the limit, or without limit, are accepted
+	for _, capacity := range [][2]int64{{1 << 30, 0}, {1 << 30, 1 << 30}, {1 << 30, 2 << 30}, {1000, volumeAllocationUnit}} {
+		_, _, size, _, _, _, _, err := ValidateCreateVolumeRequest(ctx, request(capacity[0], capacity[1]))
+		assert.Nil(t, err, "Capacity range %v rejected", capacity)
+		assert.Equal(t, capacity[0], size)
+	}
+
+	//Required bytes larger than the limit bytes are rejected
+	_, _, _, _, _, _, _, err := ValidateCreateVolumeRequest(ctx, request(2<<30, 1<<30))
+	assert.Equal(t, codes.OutOfRange, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "RequiredBytes 2147483648 exceeds LimitBytes 1073741824"), "Unexpected error message: %v", err)
+
+	//Required bytes whose allocation, rounded up, exceeds the limit bytes are rejected
+	_, _, _, _, _, _, _, err = ValidateCreateVolumeRequest(ctx, request(1000, 4096))
+	assert.Equal(t, codes.OutOfRange, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "RequiredBytes 1000 rounded up to the allocation unit of 8192 bytes is 8192, which exceeds LimitBytes 4096"), "Unexpected error message: %v", err)
+
+	//Negative limit bytes are rejected
+	_, _, _, _, _, _, _, err = ValidateCreateVolumeRequest(ctx, request(1<<30, -1))
+	assert.Equal(t, codes.OutOfRange, status.Code(err))
+}
+
+func TestStrictParameters(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	request := func() *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name: "vol1",
+			Parameters: map[string]string{keyArrayId: "array1", keyStoragePool: "pool_1", keyProtocol: FC,
+				"tieringpolicy": "0", "thinprovisioned": "true", "csi.storage.k8s.io/pvc/name": "pvc1"},
+		}
+	}
+
+	//Strict mode rejects the unknown parameters, listing them
+	s := &service{arrays: new(sync.Map), opts: Opts{StrictParameters: true}}
+	_, err := s.CreateVolume(ctx, request())
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "Unknown parameters: thinprovisioned, tieringpolicy."), "Unexpected error message: %v", err)
+	assert.False(t, strings.Contains(err.Error(), "Unknown parameters: csi.storage.k8s.io"), "Unexpected error message: %v", err)
+
+	//Known and external provisioner parameters are accepted
+	assert.Nil(t, validateParameterKeys(ctx, map[string]string{keyArrayId: "array1", keyTieringPolicy: "0", "csi.storage.k8s.io/pvc/namespace": "ns1"}))
+
+	//Lenient mode ignores the unknown parameters
+	s.opts.StrictParameters = false
+	_, err = s.CreateVolume(ctx, request())
+	assert.NotNil(t, err)
+	assert.False(t, strings.Contains(err.Error(), "Unknown parameters"), "Unexpected error message: %v", err)
+}
+
+func TestMixedProtocolParameters(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	request := func(params map[string]string) *csi.CreateVolumeRequest {
+		params[keyArrayId] = "array1"
+		params[keyStoragePool] = "pool_1"
+		return &csi.CreateVolumeRequest{Name: "vol1", Parameters: params}
+	}
+
+	//Parameters implying both a block and an NFS volume are rejected, naming the conflict
+	s := &service{arrays: new(sync.Map), opts: Opts{MixedProtocolPolicy: MixedProtocolReject}}
+	_, err := s.CreateVolume(ctx, request(map[string]string{keyProtocol: FC, keyNasServer: "nas_1"}))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "nasServer imply an NFS volume while protocol is FC"), "Unexpected error message: %v", err)
+
+	_, err = s.CreateVolume(ctx, request(map[string]string{keyProtocol: NFS, keyDisableMultipath: "true"}))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "disableMultipath imply an FC or iSCSI volume while protocol is NFS"), "Unexpected error message: %v", err)
+
+	err = validateProtocolParameters(ctx, map[string]string{keyNasServer: "nas_1", keyAccessPolicy: "root", keyDisableMultipath: "true"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.True(t, strings.Contains(err.Error(), "nasServer, accessPolicy imply an NFS volume while disableMultipath imply"), "Unexpected error message: %v", err)
+
+	//Without a protocol parameter the default protocol conflicts with the NFS parameters
+	_, err = s.CreateVolume(ctx, request(map[string]string{keyNasServer: "nas_1"}))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	//Unambiguous requests go past the validation
+	for _, params := range []map[string]string{
+		{keyProtocol: NFS, keyNasServer: "nas_1", keyHostIoSize: "8192"},
+		{keyProtocol: ISCSI, keyDisableMultipath: "true"},
+		{keyProtocol: FC},
+	} {
+		_, err = s.CreateVolume(ctx, request(params))
+		assert.NotNil(t, err)
+		assert.False(t, strings.Contains(err.Error(), "Conflicting parameters"), "Unexpected error message: %v", err)
+	}
+
+	//The warn policy keeps the protocol parameter
+	s.opts.MixedProtocolPolicy = MixedProtocolWarn
+	_, err = s.CreateVolume(ctx, request(map[string]string{keyProtocol: FC, keyNasServer: "nas_1"}))
+	assert.NotNil(t, err)
+	assert.False(t, strings.Contains(err.Error(), "Conflicting parameters"), "Unexpected error message: %v", err)
+}
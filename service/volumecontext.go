@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/dell/csi-unity/service/utils"
+)
+
+//volumeContextKeys are the current keys of the volume context read by the node
+var volumeContextKeys = []string{keyProtocol, keyArrayId, "volumeId", keyNasServer, keyDisableMultipath, keyFsType,
+	keyFsGroup, keyFsGroupChangePolicy, keyMountPropagation, keySELinuxContext, keyAccessPolicy}
+
+//getLegacyKeyForm returns the key in lower case without separators, the form in which the legacy keys of the volume
+//context match the current keys, e.g. Protocol, ArrayID, array_id and nas-server
+func getLegacyKeyForm(key string) string {
+	return strings.NewReplacer("_", "", "-", "", ".", "").Replace(strings.ToLower(strings.TrimSpace(key)))
+}
+
+//normalizeVolumeContext returns the volume context with the legacy keys written by prior versions of the driver
+//renamed to the current keys, so that upgraded nodes can stage and publish the volumes they created. The current keys
+//take precedence over the legacy keys, and the legacy protocol values are converted to the current ones
+func normalizeVolumeContext(ctx context.Context, volumeContext map[string]string) map[string]string {
+	if len(volumeContext) == 0 {
+		return volumeContext
+	}
+	log := utils.GetRunidLogger(ctx)
+	currentKeys := make(map[string]string, len(volumeContextKeys))
+	for _, key := range volumeContextKeys {
+		currentKeys[getLegacyKeyForm(key)] = key
+	}
+
+	normalized := make(map[string]string, len(volumeContext))
+	for key, value := range volumeContext {
+		normalized[key] = value
+	}
+	for key, value := range volumeContext {
+		current, ok := currentKeys[getLegacyKeyForm(key)]
+		if !ok || current == key {
+			continue
+		}
+		if _, exists := volumeContext[current]; exists {
+			log.Debugf("Legacy volume context key %s is ignored as %s is set", key, current)
+			continue
+		}
+		log.Debugf("Legacy volume context key %s is read as %s", key, current)
+		normalized[current] = value
+		delete(normalized, key)
+	}
+	if protocol := getCanonicalProtocol(normalized[keyProtocol]); protocol != "" {
+		normalized[keyProtocol] = protocol
+	}
+	return normalized
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNormalizeVolumeContext(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+
+	//Legacy keys of prior versions are read as the current keys
+	legacy := map[string]string{"Protocol": "iscsi", "ArrayID": "array1", "nas_server": "nas_1", "disable-multipath": "true", "VolumeId": "sv_1", "custom": "value"}
+	normalized := normalizeVolumeContext(ctx, legacy)
+	assert.Equal(t, map[string]string{keyProtocol: ISCSI, keyArrayId: "array1", keyNasServer: "nas_1", keyDisableMultipath: "true", "volumeId": "sv_1", "custom": "value"}, normalized)
+	assert.Equal(t, "iscsi", legacy["Protocol"], "The volume context of the request is not modified")
+
+	//A v1.0 volume whose id does not carry the protocol stages with the protocol of the legacy key
+	protocol, err := ValidateAndGetProtocol(ctx, ProtocolUnknown, normalized[keyProtocol])
+	assert.Nil(t, err)
+	assert.Equal(t, ISCSI, protocol)
+	_, err = ValidateAndGetProtocol(ctx, ProtocolUnknown, legacy[keyProtocol])
+	assert.NotNil(t, err, "Legacy keys are not read without normalization")
+
+	//Current keys take precedence over legacy keys
+	normalized = normalizeVolumeContext(ctx, map[string]string{keyProtocol: NFS, "PROTOCOL": FC, "fstype": "xfs"})
+	assert.Equal(t, NFS, normalized[keyProtocol])
+	assert.Equal(t, FC, normalized["PROTOCOL"])
+	assert.Equal(t, "xfs", normalized[keyFsType])
+
+	//Current volume contexts are unchanged
+	current := map[string]string{keyProtocol: FC, keyArrayId: "array1", "volumeId": "sv_1"}
+	assert.Equal(t, current, normalizeVolumeContext(ctx, current))
+	assert.Nil(t, normalizeVolumeContext(ctx, nil))
+}
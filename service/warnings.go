@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/dell/csi-unity/service/utils"
+)
+
+const (
+	//Volume context key holding the JSON list of the non-fatal warnings of CreateVolume
+	keyWarnings = "csi-unity/warnings"
+
+	//Warning types reported in the volume context
+	warningCapacityRoundedUp      = "CapacityRoundedUp"
+	warningTieringPolicyDowngrade = "TieringPolicyDowngraded"
+	warningDescriptionTruncated   = "DescriptionTruncated"
+
+	//maxTieringPolicy is the highest tieringPolicy supported by the driver
+	maxTieringPolicy = 3
+	//maxDescriptionLength is the length to which the description of a volume is truncated, placement group tag included
+	maxDescriptionLength = 255
+)
+
+//volumeWarning is a non-fatal condition of CreateVolume reported in the volume context
+type volumeWarning struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+//volumeWarnings accumulates the warnings of a CreateVolume request
+type volumeWarnings []volumeWarning
+
+//add records a warning and logs it
+func (w *volumeWarnings) add(ctx context.Context, warningType, format string, args ...interface{}) {
+	log := utils.GetRunidLogger(ctx)
+	message := fmt.Sprintf(format, args...)
+	log.Warnf("%s: %s", warningType, message)
+	*w = append(*w, volumeWarning{Type: warningType, Message: message})
+}
+
+//setVolumeContext adds the warnings to the volume context of the response. Nothing is added when there are no warnings
+func (w volumeWarnings) setVolumeContext(ctx context.Context, volumeResp *csi.CreateVolumeResponse) {
+	if len(w) == 0 {
+		return
+	}
+	log := utils.GetRunidLogger(ctx)
+	value, err := json.Marshal(w)
+	if err != nil {
+		log.Errorf("Unable to add warnings to volume context. Error: %v", err)
+		return
+	}
+	if volumeResp.Volume.VolumeContext == nil {
+		volumeResp.Volume.VolumeContext = make(map[string]string)
+	}
+	volumeResp.Volume.VolumeContext[keyWarnings] = string(value)
+}
+
+//checkCapacityRoundedUp records a warning when the capacity of the volume is larger than requested
+func (w *volumeWarnings) checkCapacityRoundedUp(ctx context.Context, volumeResp *csi.CreateVolumeResponse, requested int64) {
+	if capacity := volumeResp.Volume.CapacityBytes; capacity > requested {
+		w.add(ctx, warningCapacityRoundedUp, "Capacity of %d bytes is larger than the requested %d bytes", capacity, requested)
+	}
+}
+
+//checkTieringPolicy returns the tiering policy to use, downgraded to the default with a warning when the requested
+//value is not supported
+func (w *volumeWarnings) checkTieringPolicy(ctx context.Context, value string, tieringPolicy int64) int64 {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return tieringPolicy
+	}
+	if requested, err := strconv.ParseInt(value, 0, 64); err == nil && requested >= 0 && requested <= maxTieringPolicy {
+		return requested
+	}
+	w.add(ctx, warningTieringPolicyDowngrade, "%s %s is not supported. Using %d", keyTieringPolicy, value, 0)
+	return 0
+}
+
+//truncateDescription returns the description truncated so that it fits with the suffix, with a warning when truncated
+func (w *volumeWarnings) truncateDescription(ctx context.Context, description, suffix string) string {
+	maxLength := maxDescriptionLength
+	if suffix != "" {
+		maxLength -= len(suffix) + 1
+	}
+	if maxLength < 0 {
+		maxLength = 0
+	}
+	if len(description) <= maxLength {
+		return description
+	}
+	w.add(ctx, warningDescriptionTruncated, "Description of %d characters is truncated to %d", len(description), maxLength)
+	return description[:maxLength]
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+//getVolumeContextWarnings returns the warnings in the volume context of the response
+func getVolumeContextWarnings(t *testing.T, volumeResp *csi.CreateVolumeResponse) []volumeWarning {
+	list := make([]volumeWarning, 0)
+	if value, ok := volumeResp.Volume.VolumeContext[keyWarnings]; ok {
+		assert.Nil(t, json.Unmarshal([]byte(value), &list))
+	}
+	return list
+}
+
+func TestCreateVolumeWarnings(t *testing.T) {
+	ctx, _, _ := GetRunidLog(context.Background())
+	newResponse := func(capacity int64) *csi.CreateVolumeResponse {
+		return &csi.CreateVolumeResponse{Volume: &csi.Volume{CapacityBytes: capacity, VolumeContext: map[string]string{keyProtocol: FC}}}
+	}
+
+	//No warnings leave the volume context unchanged
+	warnings := make(volumeWarnings, 0)
+	resp := newResponse(1024)
+	warnings.checkCapacityRoundedUp(ctx, resp, 1024)
+	assert.Equal(t, "desc", warnings.truncateDescription(ctx, "desc", ""))
+	warnings.setVolumeContext(ctx, resp)
+	_, ok := resp.Volume.VolumeContext[keyWarnings]
+	assert.False(t, ok)
+
+	//Capacity rounded up appends a warning
+	resp = newResponse(8192)
+	warnings.checkCapacityRoundedUp(ctx, resp, 1000)
+	warnings.setVolumeContext(ctx, resp)
+	list := getVolumeContextWarnings(t, resp)
+	assert.Equal(t, 1, len(list))
+	assert.Equal(t, warningCapacityRoundedUp, list[0].Type)
+	assert.Equal(t, FC, resp.Volume.VolumeContext[keyProtocol])
+
+	//Description truncation appends a warning, keeping room for the placement group tag
+	tag := "[csi-placement-group=db]"
+	desc := warnings.truncateDescription(ctx, strings.Repeat("a", 300), tag)
+	assert.Equal(t, maxDescriptionLength, len(addPlacementGroupTag(desc, "db")))
+	warnings.setVolumeContext(ctx, resp)
+	list = getVolumeContextWarnings(t, resp)
+	assert.Equal(t, 2, len(list))
+	assert.Equal(t, warningDescriptionTruncated, list[1].Type)
+
+	//Unsupported tiering policy is downgraded with a warning
+	warnings = make(volumeWarnings, 0)
+	assert.Equal(t, int64(2), warnings.checkTieringPolicy(ctx, "2", 2))
+	assert.Equal(t, int64(0), warnings.checkTieringPolicy(ctx, "", 0))
+	assert.Equal(t, 0, len(warnings))
+	assert.Equal(t, int64(0), warnings.checkTieringPolicy(ctx, "7", 7))
+	assert.Equal(t, int64(0), warnings.checkTieringPolicy(ctx, "high", 0))
+	assert.Equal(t, 2, len(warnings))
+	assert.Equal(t, warningTieringPolicyDowngrade, warnings[0].Type)
+}